@@ -1,14 +1,26 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/mattn/go-isatty"
+
+	"tmux-session-manager/pkg/config"
 	core "tmux-session-manager/pkg/manager"
 	"tmux-session-manager/pkg/spec"
 	"tmux-session-manager/pkg/templates"
@@ -22,11 +34,28 @@ var (
 	flagSpecPath    string
 	flagSpecSession string
 	flagSpecCwd     string
+	flagSpecEnv     specEnvFlag
 
 	flagProjectName string
+	flagOpen        string
+
+	flagRestore   string
+	flagRestoreAt string
+
+	flagScaffold string
+	flagForce    bool
+
+	flagValidate string
+
+	flagKillSession   string
+	flagRenameSession string
+	flagKillIdle      bool
+	flagSessionExists string
+	flagQuiet         bool
 
 	flagBootstrap            bool
 	flagBootstrapInitSession string
+	flagKeepInitWindow       bool
 
 	flagAllowShell           bool
 	flagAllowTmuxPassthrough bool
@@ -36,40 +65,119 @@ var (
 	flagLaunchMode   string
 	flagKeyBind      string
 
-	flagRoots string
-	flagDepth int
+	flagRoots      string
+	flagDepth      int
+	flagIgnoreDirs string
+	flagNoCache    bool
+
+	flagTemplate     string
+	flagDryRun       bool
+	flagPreflight    bool
+	flagStrictDryRun bool
+
+	flagOutputSessionName bool
+
+	flagAttach   bool
+	flagNoAttach bool
+
+	flagNoDefaultWindowCleanup bool
+	flagReplaceSession         bool
+
+	flagThemePreview bool
+
+	flagListActions bool
+	flagPrintSchema bool
+
+	flagListProjects bool
+	flagListSessions bool
+	flagOutput       string
+
+	flagSocket string
+
+	flagForeachProject  string
+	flagContinueOnError bool
+	flagTmuxL           string
+
+	flagFocusWindow string
+	flagFocusPane   string
 
-	flagTemplate string
-	flagDryRun   bool
+	flagPrintBindTable bool
+
+	flagDetachUI bool
 )
 
+// cfg is the runtime configuration: env (populated by the launcher from tmux options) resolved by
+// pkg/config, with explicitly passed flags layered on top (see resolveConfig).
+var cfg config.Config
+
+// debugLog is the TMUX_SESSION_MANAGER_DEBUG trace (nil when debugging is off); see openDebugLog.
+var debugLog *slog.Logger
+
 func init() {
 	flag.StringVar(&flagConfigPath, "config", "", "Path to global config file (optional)")
 	flag.BoolVar(&flagPreferProjectSpec, "prefer-project-spec", true, "Prefer project-local session spec over built-in templates")
 	flag.StringVar(&flagProjectSpecNames, "project-spec-names", ".tmux-session.yaml,.tmux-session.yml,.tmux-session.json", "Comma-separated project-local spec filenames to look for")
 
-	flag.StringVar(&flagSpecPath, "spec", "", "Apply a spec file directly (.yaml/.yml/.json); skips project discovery")
+	flag.StringVar(&flagSpecPath, "spec", "", "Apply a spec file directly (.yaml/.yml/.json; - reads it from stdin, needs --spec-session); skips project discovery")
 	flag.StringVar(&flagSpecSession, "spec-session", "", "Override tmux session name when applying --spec")
 	flag.StringVar(&flagSpecCwd, "spec-cwd", "", "Working directory for applying --spec (resolves relative paths)")
+	flag.Var(&flagSpecEnv, "spec-env", "Set a ${VAR} substitution value as KEY=VALUE when applying a spec (repeatable; overrides spec env)")
 
 	flag.StringVar(&flagProjectName, "project", "", "Apply a project by name by resolving <root>/<project>/.tmux-session.(yaml|yml|json) under --roots")
+	flag.StringVar(&flagOpen, "open", "", "Open a directory (need not be under --roots) as a session named after it: switch to it, or create it from the directory's project spec, else --template")
+	flag.StringVar(&flagRestore, "restore", "", "Apply the most recent edit-mode snapshot of this session from ~/.config/tmux-session-manager/snapshots, or $XDG_CONFIG_HOME (empty value lists snapshots)")
+	flag.StringVar(&flagRestoreAt, "restore-at", "", "With --restore: pick the snapshot taken at this timestamp (YYYYMMDD-HHMMSS, or a unique prefix)")
+
+	flag.StringVar(&flagScaffold, "scaffold", "", "Write a starter .tmux-session.yaml for a project (name under --roots, or a directory path like .)")
+	flag.BoolVar(&flagForce, "force", false, "Allow --scaffold to overwrite an existing project spec")
+	flag.StringVar(&flagValidate, "validate", "", "Lint a spec file (structure + policy under the current --allow-* settings) without tmux; exit 1 on errors")
+
+	flag.StringVar(&flagKillSession, "kill-session", "", "Kill this tmux session without opening the TUI, then exit (honors --socket)")
+	flag.StringVar(&flagRenameSession, "rename-session", "", "Rename a tmux session without opening the TUI: <from>=<to>, then exit (honors --socket)")
+	flag.StringVar(&flagSessionExists, "session-exists", "", "Exit 0 if this tmux session exists (exact name, then its sanitized form; printed on stdout), 1 if not; honors --socket")
+	flag.BoolVar(&flagQuiet, "quiet", false, "With --session-exists: print nothing, only set the exit status")
+	flag.BoolVar(&flagKillIdle, "kill-idle", false, "Kill detached sessions that are only idle shells in $HOME (asks first on a terminal; --dry-run lists them), then exit (honors --socket)")
 
 	flag.BoolVar(&flagBootstrap, "bootstrap", false, "When run outside tmux with --project/--spec, start/attach tmux and re-run inside it (opt-in)")
 	flag.StringVar(&flagBootstrapInitSession, "bootstrap-init-session", "", "INTERNAL: bootstrap init session name")
+	flag.BoolVar(&flagKeepInitWindow, "keep-init-window", false, "Keep the --bootstrap init session instead of killing it after switching (debugging)")
 
 	flag.BoolVar(&flagAllowShell, "allow-shell", false, "Allow specs/templates to execute shell commands (unsafe; opt-in)")
 	flag.BoolVar(&flagAllowTmuxPassthrough, "allow-tmux-passthrough", false, "Allow specs/templates to run raw tmux commands (advanced; opt-in)")
 
 	flag.StringVar(&flagInitialQuery, "query", "", "Initial query for the TUI selector")
 	flag.IntVar(&flagMaxResults, "max", 30, "Maximum results to display in the TUI (0 uses default)")
-	flag.StringVar(&flagLaunchMode, "launch-mode", "", "Launch mode hint for tmux launcher: window|popup")
-	flag.StringVar(&flagKeyBind, "print-bind", "", "Print a suggested tmux binding line and exit")
+	flag.StringVar(&flagLaunchMode, "launch-mode", "", "Launch mode: window|popup (popup re-runs the TUI in tmux display-popup, tmux >= 3.2)")
+	flag.BoolVar(&flagDetachUI, "detach-ui", false, "TUI only picks: it exits on enter, then the session/project is opened outside the UI (useful from popups); env TMUX_SESSION_MANAGER_DETACH_UI")
+	flag.StringVar(&flagKeyBind, "print-bind", "", "Print a suggested tmux binding line and exit (a display-popup binding with --launch-mode popup)")
+	flag.BoolVar(&flagPrintBindTable, "print-bind-table", false, "Print several common tmux binding choices and exit")
 
 	flag.StringVar(&flagRoots, "roots", "", "Comma-separated roots to scan for projects (default: ~/code,~/src,~/projects)")
-	flag.IntVar(&flagDepth, "depth", 2, "Project scan depth under roots")
-	flag.StringVar(&flagTemplate, "template", "", "Default template in TUI: auto|empty|node|python|go")
+	flag.IntVar(&flagDepth, "depth", 2, "Project scan depth under roots; only overrides env TMUX_SESSION_MANAGER_PROJECT_DEPTH when passed explicitly")
+	flag.StringVar(&flagIgnoreDirs, "ignore-dirs", "", "Comma-separated directory names the project scan skips (default: .git,node_modules,vendor,dist,build,target,.venv,__pycache__); env TMUX_SESSION_MANAGER_IGNORE_DIRS")
+	flag.BoolVar(&flagNoCache, "no-cache", false, "Scan project roots on startup instead of reusing the last scan (~/.cache/tmux-session-manager/projects.json)")
+	flag.StringVar(&flagTemplate, "template", "", "Default template in TUI: auto|empty|node|python|go|rust")
 
 	flag.BoolVar(&flagDryRun, "dry-run", false, "Dry-run: show planned operations and do not execute")
+	flag.BoolVar(&flagPreflight, "preflight", false, "With --spec/--project: check that window/pane directories exist and run programs are in PATH (read-only)")
+	flag.BoolVar(&flagStrictDryRun, "strict-dry-run", false, "Like --dry-run --preflight, but exit 3 if any preflight check fails and 4 if the plan needs shell/tmux passthrough")
+	flag.StringVar(&flagSocket, "socket", "", "tmux server to use (TUI and --spec/--project): socket path (tmux -S) or name (tmux -L); env TMUX_SESSION_MANAGER_SOCKET")
+	flag.StringVar(&flagForeachProject, "foreach-project", "", "Create detached sessions for several projects (comma-separated names or globs under roots, e.g. 'api,web-*') from their specs, or --template; never switches")
+	flag.BoolVar(&flagContinueOnError, "continue-on-error", false, "With --foreach-project: keep going after a project fails")
+	flag.StringVar(&flagTmuxL, "tmux-L", "", "tmux server to use by socket name, like tmux -L (same as --socket <name>)")
+	flag.BoolVar(&flagThemePreview, "theme-preview", false, "Print each TUI theme style with sample text (honors TMUX_SESSION_MANAGER_COLOR_*) and exit")
+	flag.StringVar(&flagFocusWindow, "focus-window", "", "After applying --spec/--project, select this window (name or index), overriding the spec's focus")
+	flag.StringVar(&flagFocusPane, "focus-pane", "", "After applying --spec/--project, select this pane index (in --focus-window, or the spec's focused window)")
+	flag.BoolVar(&flagListActions, "list-actions", false, "Print every supported spec action type with its fields and policy requirements, then exit")
+	flag.BoolVar(&flagPrintSchema, "print-schema", false, "Print a JSON Schema (draft-07) for spec files, for editor completion/validation, then exit")
+	flag.BoolVar(&flagListProjects, "list-projects", false, "Print the projects the TUI would list (name, path, has spec), then exit")
+	flag.BoolVar(&flagListSessions, "list-sessions", false, "Print tmux sessions (name, windows, attached), then exit")
+	flag.StringVar(&flagOutput, "output", "plain", "Output format for --list-projects/--list-sessions: plain (tab-separated lines) | json (one object per line)")
+	flag.BoolVar(&flagNoDefaultWindowCleanup, "no-default-window-cleanup", false, "Keep the session's default (base-index) window after applying --spec/--project instead of killing it")
+	flag.BoolVar(&flagReplaceSession, "replace-session", false, "With --spec/--project: if the session already exists, tear it down and rebuild it from the spec (asks first on a terminal)")
+	flag.BoolVar(&flagAttach, "attach", false, "With --spec/--project: switch/attach to the session after applying, even if the spec says attach: false")
+	flag.BoolVar(&flagNoAttach, "no-attach", false, "With --spec/--project: create the session without switching/attaching, even if the spec says attach: true")
+	flag.BoolVar(&flagOutputSessionName, "output-session-name", false, "After applying --spec/--project, print the final tmux session name to stdout")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "tmux-session-manager\n\n")
@@ -78,7 +186,10 @@ func init() {
 		fmt.Fprintf(os.Stderr, "Examples:\n")
 		fmt.Fprintf(os.Stderr, "  tmux-session-manager\n")
 		fmt.Fprintf(os.Stderr, "  tmux-session-manager --project vmlab\n")
-		fmt.Fprintf(os.Stderr, "  tmux-session-manager --spec /path/to/.tmux-session.yaml\n\n")
+		fmt.Fprintf(os.Stderr, "  tmux-session-manager --spec /path/to/.tmux-session.yaml\n")
+		fmt.Fprintf(os.Stderr, "  tmux-session-manager --restore vmlab --restore-at 20240101-120000\n")
+		fmt.Fprintf(os.Stderr, "  tmux-session-manager --scaffold . --template auto\n")
+		fmt.Fprintf(os.Stderr, "  tmux-session-manager --validate .tmux-session.yaml\n\n")
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		flag.PrintDefaults()
 	}
@@ -87,21 +198,171 @@ func init() {
 func main() {
 	flag.Parse()
 
+	if flagStrictDryRun {
+		flagDryRun = true
+		flagPreflight = true
+	}
+	if v := strings.TrimSpace(flagTmuxL); v != "" {
+		if strings.TrimSpace(flagSocket) != "" {
+			fmt.Fprintln(os.Stderr, "tmux-session-manager: use only one of --socket and --tmux-L")
+			os.Exit(1)
+		}
+		if strings.ContainsRune(v, '/') {
+			fmt.Fprintln(os.Stderr, "tmux-session-manager: --tmux-L takes a socket name; use --socket for a path")
+			os.Exit(1)
+		}
+	}
+
+	cfg = resolveConfig()
+	openDebugLog()
+
 	if strings.TrimSpace(flagBootstrapInitSession) != "" && strings.TrimSpace(os.Getenv("TMUX_SESSION_MANAGER_INIT_SESSION")) == "" {
 		_ = os.Setenv("TMUX_SESSION_MANAGER_INIT_SESSION", strings.TrimSpace(flagBootstrapInitSession))
 	}
 
 	if flagKeyBind != "" {
-		printSuggestedBind(flagKeyBind)
+		if err := validateTmuxKey(flagKeyBind); err != nil {
+			fmt.Fprintf(os.Stderr, "tmux-session-manager: warning: %v\n", err)
+		}
+		printSuggestedBind(os.Stdout, flagKeyBind, cfg.LaunchMode)
+		return
+	}
+	if flagPrintBindTable {
+		printBindTable(cfg.LaunchMode)
+		return
+	}
+
+	if flagThemePreview {
+		if err := core.PrintThemePreview(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "tmux-session-manager: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flagListActions {
+		if err := spec.PrintActionTypes(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "tmux-session-manager: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flagPrintSchema {
+		if _, err := os.Stdout.Write(spec.JSONSchema()); err != nil {
+			fmt.Fprintf(os.Stderr, "tmux-session-manager: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flagListProjects || flagListSessions {
+		if err := printLists(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "tmux-session-manager: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if strings.TrimSpace(flagValidate) != "" {
+		if err := validateSpecFile(os.Stdout, strings.TrimSpace(flagValidate)); err != nil {
+			fmt.Fprintf(os.Stderr, "tmux-session-manager: --validate: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flagWasSet("session-exists") {
+		os.Exit(sessionExistsStatus(os.Stdout, os.Stderr, flagSessionExists))
+	}
+
+	if strings.TrimSpace(flagKillSession) != "" || strings.TrimSpace(flagRenameSession) != "" || flagKillIdle {
+		if err := runSessionCommand(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "tmux-session-manager: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Scaffolding only writes a file; it never needs tmux (so it runs before bootstrap).
+	if strings.TrimSpace(flagScaffold) != "" {
+		dir, err := resolveScaffoldDir(strings.TrimSpace(flagScaffold))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tmux-session-manager: --scaffold: %v\n", err)
+			os.Exit(1)
+		}
+		tpl := strings.TrimSpace(flagTemplate)
+		if tpl == "" {
+			tpl = "auto"
+		}
+		res, err := core.ScaffoldProjectSpec(dir, core.ScaffoldOptions{
+			SpecNames: cfg.SpecFilenames,
+			Template:  tpl,
+			Force:     flagForce,
+			DryRun:    flagDryRun,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tmux-session-manager: %v\n", err)
+			os.Exit(1)
+		}
+		if flagDryRun {
+			fmt.Printf("# would write %s (template: %s)\n", res.Path, res.Template)
+			fmt.Print(res.Content)
+			return
+		}
+		verb := "wrote"
+		if res.Overwrote {
+			verb = "overwrote"
+		}
+		fmt.Printf("%s %s (template: %s)\n", verb, res.Path, res.Template)
 		return
 	}
 
+	// --restore resolves to a snapshot file and then applies it like --spec. It runs before
+	// bootstrap so a bad name lists the snapshots without starting tmux.
+	if flagWasSet("restore") || strings.TrimSpace(flagRestoreAt) != "" {
+		if strings.TrimSpace(flagSpecPath) != "" || strings.TrimSpace(flagProjectName) != "" {
+			fmt.Fprintln(os.Stderr, "tmux-session-manager: --restore can't be combined with --spec/--project")
+			os.Exit(1)
+		}
+		snap, err := resolveRestore(flagRestore, flagRestoreAt)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tmux-session-manager: --restore: %v\n", err)
+			os.Exit(1)
+		}
+		flagSpecPath = snap.Path
+		// Snapshot panes carry absolute roots; ${PROJECT_PATH} (session/window root) falls back
+		// to $HOME instead of the snapshots directory.
+		if strings.TrimSpace(flagSpecCwd) == "" {
+			if home, _ := os.UserHomeDir(); strings.TrimSpace(home) != "" {
+				flagSpecCwd = home
+			}
+		}
+		fmt.Fprintf(os.Stderr, "tmux-session-manager: restoring %s (%s)\n", snap.Name, snap.Timestamp)
+	}
+
+	if strings.TrimSpace(flagForeachProject) != "" {
+		if strings.TrimSpace(flagSpecPath) != "" || strings.TrimSpace(flagProjectName) != "" {
+			fmt.Fprintln(os.Stderr, "tmux-session-manager: --foreach-project can't be combined with --spec/--project")
+			os.Exit(1)
+		}
+		os.Exit(runForeachProject(flagForeachProject))
+	}
+
+	if strings.TrimSpace(flagOpen) != "" && (strings.TrimSpace(flagSpecPath) != "" || strings.TrimSpace(flagProjectName) != "") {
+		fmt.Fprintln(os.Stderr, "tmux-session-manager: --open can't be combined with --spec/--project")
+		os.Exit(1)
+	}
+
 	outsideTmux := strings.TrimSpace(os.Getenv("TMUX")) == ""
-	explicitIntent := strings.TrimSpace(flagProjectName) != "" || strings.TrimSpace(flagSpecPath) != ""
+	explicitIntent := strings.TrimSpace(flagProjectName) != "" || strings.TrimSpace(flagSpecPath) != "" || strings.TrimSpace(flagOpen) != ""
 	bootstrapped := strings.TrimSpace(os.Getenv("TMUX_SESSION_MANAGER_BOOTSTRAPPED")) != ""
 	bootstrapEnabled := flagBootstrap || parseEnvBool("TMUX_SESSION_MANAGER_BOOTSTRAP", false)
 
-	if outsideTmux && explicitIntent && !bootstrapped {
+	// An explicit --socket outside tmux doesn't need bootstrapping: the session is created on that
+	// server and attached directly. Neither does a dry-run, which only prints the plan (so CI can
+	// gate specs with --strict-dry-run).
+	if outsideTmux && explicitIntent && !bootstrapped && !flagDryRun && specSocket() == "" {
 		if bootstrapEnabled {
 			self, err := os.Executable()
 			if err == nil && strings.TrimSpace(self) != "" {
@@ -131,11 +392,20 @@ func main() {
 				cmd.Stdout = os.Stdout
 				cmd.Stderr = os.Stderr
 
-				if err := cmd.Run(); err == nil {
+				keepInit := keepInitWindow()
+				if keepInit {
+					fmt.Fprintf(os.Stderr, "tmux-session-manager: bootstrap init session %q will be kept (inspect with: tmux attach -t %s)\n", initSession, initSession)
+				}
+
+				err := cmd.Run()
+				if err == nil {
 					return
 				}
 
 				// Fall through to normal behavior if tmux isn't reachable or attach fails.
+				if keepInit {
+					fmt.Fprintf(os.Stderr, "tmux-session-manager: bootstrap via tmux failed: %v (init session %q)\n", err, initSession)
+				}
 			}
 		} else {
 			fmt.Fprintln(os.Stderr, "tmux-session-manager: not inside tmux. Re-run with --bootstrap (or set TMUX_SESSION_MANAGER_BOOTSTRAP=1).")
@@ -146,40 +416,9 @@ func main() {
 	if strings.TrimSpace(flagProjectName) != "" && strings.TrimSpace(flagSpecPath) == "" {
 		project := strings.TrimSpace(flagProjectName)
 
-		roots := splitAndTrim(os.Getenv("TMUX_SESSION_MANAGER_ROOTS"))
-		if len(roots) == 0 {
-			roots = splitAndTrim(flagRoots)
-		}
-		if len(roots) == 0 {
-			home, _ := os.UserHomeDir()
-			roots = []string{
-				filepath.Join(home, "code"),
-				filepath.Join(home, "src"),
-				filepath.Join(home, "projects"),
-			}
-		}
-
-		var resolvedSpec string
-		var resolvedCwd string
-		candidates := []string{".tmux-session.yaml", ".tmux-session.yml", ".tmux-session.json"}
-		for _, r := range roots {
-			r = expandHome(r)
-			cwd := filepath.Join(r, project)
-			for _, nm := range candidates {
-				p := filepath.Join(cwd, nm)
-				if st, err := os.Stat(p); err == nil && st != nil && !st.IsDir() {
-					resolvedSpec = p
-					resolvedCwd = cwd
-					break
-				}
-			}
-			if resolvedSpec != "" {
-				break
-			}
-		}
-
-		if resolvedSpec == "" {
-			fmt.Fprintf(os.Stderr, "tmux-session-manager: --project %q: no .tmux-session.{yaml,yml,json} found under roots\n", project)
+		resolvedSpec, resolvedCwd, ok := core.ResolveProjectSpec(cfg.ProjectRoots, cfg.SpecFilenames, project)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "tmux-session-manager: --project %q: no spec (%s) found under roots\n", project, strings.Join(cfg.SpecFilenames, ", "))
 			os.Exit(1)
 		}
 
@@ -187,66 +426,90 @@ func main() {
 		if strings.TrimSpace(flagSpecCwd) == "" {
 			flagSpecCwd = resolvedCwd
 		}
-		if strings.TrimSpace(flagSpecSession) == "" {
-			flagSpecSession = project
-		}
 	}
 
 	if strings.TrimSpace(flagSpecPath) != "" {
 		specPath := expandHome(flagSpecPath)
 
+		// --spec -: the spec comes from stdin, so there is no file to name the session after or to
+		// default --spec-cwd to (the current directory is used).
+		var stdinSpec *spec.Spec
+		if strings.TrimSpace(flagSpecPath) == "-" {
+			if strings.TrimSpace(flagSpecSession) == "" {
+				fmt.Fprintln(os.Stderr, "tmux-session-manager: --spec - requires --spec-session")
+				os.Exit(1)
+			}
+			s, err := spec.LoadReader(os.Stdin, "")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "tmux-session-manager: load spec from stdin: %v\n", err)
+				os.Exit(1)
+			}
+			stdinSpec, specPath = s, ""
+		}
+
 		specCwd := strings.TrimSpace(flagSpecCwd)
-		if specCwd == "" {
+		if specCwd == "" && stdinSpec != nil {
+			specCwd, _ = os.Getwd()
+		} else if specCwd == "" {
 			specCwd = filepath.Dir(specPath)
 		}
 		specCwd = expandHome(specCwd)
 
-		// Load spec directly from file path (do not rely on "project-local" lookup semantics here).
-		loadedSpec, loadErr := spec.LoadFile(specPath)
-		if loadErr != nil {
-			fmt.Fprintf(os.Stderr, "tmux-session-manager: load spec: %v\n", loadErr)
+		// Without --spec-session, Apply derives the name like the TUI does: spec session.name, else
+		// [session.prefix-]basename(--spec-cwd).
+		sessionName := strings.TrimSpace(flagSpecSession)
+		if sessionName == "" && strings.TrimSpace(filepath.Base(strings.TrimRight(specCwd, string(filepath.Separator)))) == "" {
+			fmt.Fprintf(os.Stderr, "tmux-session-manager: --spec requires --spec-session (or a non-empty --spec-cwd)\n")
 			os.Exit(1)
 		}
 
-		// Determine effective attach/switch behavior (defaults: true).
-		shouldAttach := true
-		if loadedSpec.Session.Attach != nil {
-			shouldAttach = *loadedSpec.Session.Attach
-		}
-		shouldSwitchClient := true
-		if loadedSpec.Session.SwitchClient != nil {
-			shouldSwitchClient = *loadedSpec.Session.SwitchClient
+		for _, w := range cfg.Safety.Warnings() {
+			fmt.Fprintf(os.Stderr, "tmux-session-manager: warning: %s\n", w)
 		}
 
-		sessionName := strings.TrimSpace(flagSpecSession)
-		if sessionName == "" {
-			sessionName = filepath.Base(strings.TrimRight(specCwd, string(filepath.Separator)))
-			sessionName = strings.TrimSpace(sessionName)
-		}
-		if sessionName == "" {
-			fmt.Fprintf(os.Stderr, "tmux-session-manager: --spec requires --spec-session (or a non-empty --spec-cwd)\n")
+		attach, err := attachOverride()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tmux-session-manager: %v\n", err)
 			os.Exit(1)
 		}
 
-		if strings.TrimSpace(os.Getenv("TMUX")) != "" {
-			if err := exec.Command("tmux", "has-session", "-t", sessionName).Run(); err != nil {
-				_ = exec.Command("tmux", "new-session", "-d", "-s", sessionName, "-c", specCwd).Run()
-			}
-		}
-
-		opt := core.ApplySpecOptions{
+		// Load spec directly from file path (do not rely on "project-local" lookup semantics here).
+		req := core.ApplyRequest{
+			SpecPath:    specPath,
+			Spec:        stdinSpec,
 			ProjectPath: specCwd,
 			SessionName: sessionName,
+			Env:         flagSpecEnv.Map(),
+
+			AllowShell:           cfg.Safety.AllowShell,
+			AllowTmuxPassthrough: cfg.Safety.AllowTmuxPassthrough,
+			AllowedShellPrefixes: cfg.Safety.AllowedShellPrefixes,
+			AllowedTmuxCommands:  cfg.Safety.TmuxAllowlist(),
+			StrictWaitForPrompt:  cfg.Safety.StrictWaitForPrompt,
+			CommandTimeout:       cfg.CommandTimeout,
+			WaitDefaults:         waitDefaults(),
+			MaxActionsCeiling:    cfg.Safety.MaxActionsCeiling,
+			MaxCommandLenCeiling: cfg.Safety.MaxCommandLenCeiling,
+
+			FocusWindow: flagFocusWindow,
+			FocusPane:   flagFocusPane,
+
+			Attach: attach,
+
+			Socket:            specSocket(),
+			KeepDefaultWindow: flagNoDefaultWindowCleanup,
+			ReplaceSession:    flagReplaceSession,
+			DryRun:            flagDryRun,
+			Preflight:         flagPreflight,
+			Log:               debugLog,
+		}
 
-			AllowShell:           parseEnvBool("TMUX_SESSION_MANAGER_ALLOW_SHELL", flagAllowShell),
-			AllowTmuxPassthrough: parseEnvBool("TMUX_SESSION_MANAGER_ALLOW_TMUX_PASSTHROUGH", flagAllowTmuxPassthrough),
-
-			IncludeEnsureSession: false,
-			DryRun:               flagDryRun,
-			Runner:               &templates.TmuxExecRunner{},
+		if flagReplaceSession && !flagDryRun && !confirmReplaceSession(req, os.Stdin, os.Stderr) {
+			fmt.Fprintln(os.Stderr, "tmux-session-manager: not replacing the running session")
+			os.Exit(1)
 		}
 
-		res, err := core.ApplySpecFile(specPath, opt)
+		res, err := core.Apply(context.Background(), req)
 		if err != nil {
 			msg := err.Error()
 			if strings.Contains(msg, "no server running on ") ||
@@ -258,182 +521,923 @@ func main() {
 			}
 
 			fmt.Fprintf(os.Stderr, "tmux-session-manager: %v\n", err)
+			if flagStrictDryRun {
+				os.Exit(strictDryRunExitCode(err, false, 0))
+			}
 			os.Exit(exitCodeFromErr(err))
 		}
-
-		if !flagDryRun {
-			specNames := map[string]struct{}{}
-			for _, w := range loadedSpec.Windows {
-				n := strings.TrimSpace(w.Name)
-				if n != "" {
-					specNames[n] = struct{}{}
-				}
-			}
-
-			baseIndex := 0
-			if out, e := exec.Command("tmux", "show-option", "-gqv", "base-index").Output(); e == nil {
-				if n, ne := strconv.Atoi(strings.TrimSpace(string(out))); ne == nil {
-					baseIndex = n
-				}
-			}
-
-			baseWinName, _ := exec.Command(
-				"tmux",
-				"display-message",
-				"-p",
-				"-t",
-				fmt.Sprintf("%s:%d", sessionName, baseIndex),
-				"#{window_name}",
-			).Output()
-
-			baseWinNameStr := strings.TrimSpace(string(baseWinName))
-			if baseWinNameStr != "" {
-				if _, isSpec := specNames[baseWinNameStr]; !isSpec {
-					_ = exec.Command("tmux", "kill-window", "-t", fmt.Sprintf("%s:%d", sessionName, baseIndex)).Run()
-				}
-
-				// (moved) runConnectSubcommand is now defined at package scope.
-			}
+		for _, w := range res.ExecWarnings {
+			fmt.Fprintf(os.Stderr, "tmux-session-manager: warning: %s\n", w)
 		}
+		sessionName = res.SessionName
 
 		// Dry-run prints the plan for inspection.
 		if flagDryRun {
+			for _, p := range res.Preflight {
+				fmt.Printf("PREFLIGHT: %s\n", p)
+			}
 			for _, ln := range res.DryRunLines {
 				fmt.Println(ln)
 			}
+			if flagOutputSessionName {
+				fmt.Println(res.SessionName)
+			}
+			if flagStrictDryRun {
+				if res.UnsafeUsed {
+					fmt.Fprintln(os.Stderr, "tmux-session-manager: plan uses unsafe actions (shell and/or tmux passthrough)")
+				}
+				if len(res.Preflight) > 0 {
+					fmt.Fprintf(os.Stderr, "tmux-session-manager: %d preflight check(s) failed\n", len(res.Preflight))
+				}
+				if code := strictDryRunExitCode(nil, res.UnsafeUsed, len(res.Preflight)); code != 0 {
+					os.Exit(code)
+				}
+			}
 			return
 		}
+		for _, p := range res.Preflight {
+			fmt.Fprintf(os.Stderr, "tmux-session-manager: preflight: %s\n", p)
+		}
 
-		if shouldAttach {
-			if strings.TrimSpace(os.Getenv("TMUX")) != "" {
-				if shouldSwitchClient {
-					if err := exec.Command("tmux", "switch-client", "-t", sessionName).Run(); err != nil {
-						fmt.Fprintf(os.Stderr, "tmux-session-manager: switch-client failed: %v\n", err)
-						os.Exit(1)
-					}
-				}
+		// Print before switching: when bootstrapped, killing the init session below may take this
+		// process (and its stdout) down with it.
+		if flagOutputSessionName {
+			fmt.Println(res.SessionName)
+		}
 
-				initSession := strings.TrimSpace(os.Getenv("TMUX_SESSION_MANAGER_INIT_SESSION"))
-				if initSession == "" {
+		if res.Attach {
+			// switch-client only works within one server. When the session lives on another server
+			// (--socket), attach a client to that server instead.
+			if res.CrossServerSocket != "" {
+				fmt.Fprintf(os.Stderr, "tmux-session-manager: session %q is on another tmux server (%s); switch-client can't cross servers, attaching a nested client (detach with prefix d)\n", sessionName, res.CrossServerSocket)
+				if err := attachOnSpecSocket(sessionName); err != nil {
+					fmt.Fprintf(os.Stderr, "tmux-session-manager: attach-session failed: %v\n", err)
+					os.Exit(1)
 				}
-				if initSession != "" && initSession != sessionName {
-					_ = exec.Command("tmux", "kill-session", "-t", initSession).Run()
+				return
+			}
+			if strings.TrimSpace(os.Getenv("TMUX")) == "" && specSocket() != "" {
+				if err := attachOnSpecSocket(sessionName); err != nil {
+					fmt.Fprintf(os.Stderr, "tmux-session-manager: attach-session failed: %v\n", err)
+					os.Exit(1)
 				}
-			} else {
 				return
 			}
+
+			if strings.TrimSpace(os.Getenv("TMUX")) != "" {
+				dropInitSession(sessionName)
+			}
 		}
 
 		return
 	}
 
-	// Resolve runtime defaults from env (populated by the launcher from tmux options),
-	// then let explicit CLI flags override.
-	//
-	// Launcher sets (examples):
-	//   TMUX_SESSION_MANAGER_ROOTS
-	//   TMUX_SESSION_MANAGER_PROJECT_DEPTH
-	//   TMUX_SESSION_MANAGER_SPEC_NAMES
-	//   TMUX_SESSION_MANAGER_PREFER_PROJECT_SPEC
-	//   TMUX_SESSION_MANAGER_DEFAULT_TEMPLATE
-	//   TMUX_SESSION_MANAGER_ALLOW_SHELL
-	//   TMUX_SESSION_MANAGER_ALLOW_TMUX_PASSTHROUGH
-	//   TMUX_SESSION_MANAGER_LAUNCH_MODE
-	//
-	envRoots := splitAndTrim(os.Getenv("TMUX_SESSION_MANAGER_ROOTS"))
-	if len(envRoots) == 0 {
-		// Fall back to flag (or defaults inside UI if still empty).
-		envRoots = splitAndTrim(flagRoots)
+	if strings.TrimSpace(flagOpen) != "" {
+		os.Exit(runOpen(flagOpen))
+	}
+
+	// --launch-mode popup from inside tmux: re-run this binary in a display-popup (the launcher
+	// does this itself and sets TMUX_SESSION_MANAGER_IN_POPUP, which also stops the recursion).
+	if cfg.LaunchMode == "popup" && relaunchInPopup() {
+		return
+	}
+
+	opts := uiOptions()
+
+	_ = flagConfigPath // reserved for a future global config loader
+
+	sel, err := core.RunTUISelect(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tmux-session-manager: %v\n", err)
+		os.Exit(exitCodeFromErr(err))
+	}
+
+	// --detach-ui: the UI has exited (and released the popup/terminal); open the pick now.
+	note, err := core.OpenSelection(sel, opts)
+	if note != "" {
+		fmt.Fprintf(os.Stderr, "tmux-session-manager: %s\n", note)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tmux-session-manager: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// relaunchInPopup runs this binary with the same arguments in `tmux display-popup -E` and
+// reports whether it did. It returns false (run the TUI in place, like window mode) outside tmux,
+// when already in a popup, or when the server's tmux predates display-popup (3.2).
+func relaunchInPopup() bool {
+	if strings.TrimSpace(os.Getenv("TMUX")) == "" || strings.TrimSpace(os.Getenv("TMUX_SESSION_MANAGER_IN_POPUP")) != "" {
+		return false
+	}
+	if !tmuxSupportsPopup() {
+		fmt.Fprintln(os.Stderr, "tmux-session-manager: display-popup needs tmux >= 3.2; using window mode")
+		return false
+	}
+	self, err := os.Executable()
+	if err != nil || strings.TrimSpace(self) == "" {
+		return false
 	}
 
-	envSpecNames := splitAndTrim(os.Getenv("TMUX_SESSION_MANAGER_SPEC_NAMES"))
-	if len(envSpecNames) == 0 {
-		envSpecNames = splitAndTrim(flagProjectSpecNames)
+	w := strings.TrimSpace(os.Getenv("TMUX_SESSION_MANAGER_POPUP_W"))
+	if w == "" {
+		w = "90%"
+	}
+	h := strings.TrimSpace(os.Getenv("TMUX_SESSION_MANAGER_POPUP_H"))
+	if h == "" {
+		h = "80%"
 	}
 
-	envPreferSpec := parseEnvBool("TMUX_SESSION_MANAGER_PREFER_PROJECT_SPEC", flagPreferProjectSpec)
-	envAllowShell := parseEnvBool("TMUX_SESSION_MANAGER_ALLOW_SHELL", flagAllowShell)
-	envAllowTmux := parseEnvBool("TMUX_SESSION_MANAGER_ALLOW_TMUX_PASSTHROUGH", flagAllowTmuxPassthrough)
+	// display-popup runs its command through the shell; the env prefix keeps this working on
+	// tmux 3.2, whose display-popup has no -e.
+	cmdStr := "TMUX_SESSION_MANAGER_IN_POPUP=1 exec " + shellQuote(self)
+	if len(os.Args) > 1 {
+		cmdStr += " " + shellJoin(os.Args[1:])
+	}
+	args := []string{"display-popup", "-E", "-w", w, "-h", h}
+	if wd, err := os.Getwd(); err == nil {
+		args = append(args, "-d", wd)
+	}
+	cmd := exec.Command("tmux", append(args, cmdStr)...)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			// The popup ran; its exit status is the TUI's (already reported inside the popup).
+			os.Exit(ee.ExitCode())
+		}
+		fmt.Fprintf(os.Stderr, "tmux-session-manager: display-popup: %v; using window mode\n", err)
+		return false
+	}
+	return true
+}
 
-	envLaunchMode := strings.TrimSpace(os.Getenv("TMUX_SESSION_MANAGER_LAUNCH_MODE"))
-	if envLaunchMode == "" {
-		envLaunchMode = strings.TrimSpace(flagLaunchMode)
+// tmuxSupportsPopup reports whether `tmux -V` is 3.2 or newer (display-popup -E -w/-h/-d).
+// Unparseable versions (e.g. "tmux master") are assumed new enough.
+func tmuxSupportsPopup() bool {
+	out, err := exec.Command("tmux", "-V").Output()
+	if err != nil {
+		return false
 	}
+	fields := strings.Fields(string(out))
+	if len(fields) < 2 {
+		return true
+	}
+	v := strings.TrimPrefix(fields[1], "next-")
+	major, minor := 0, 0
+	if n, _ := fmt.Sscanf(v, "%d.%d", &major, &minor); n == 0 {
+		return true
+	}
+	return major > 3 || (major == 3 && minor >= 2)
+}
+
+const (
+	bindLauncher = "~/.tmux/plugins/tmux-session-manager/scripts/tmux_session_manager.tmux"
+	bindBinary   = "~/.tmux/plugins/tmux-session-manager/bin/tmux-session-manager"
+)
 
-	envDefaultTemplate := strings.TrimSpace(os.Getenv("TMUX_SESSION_MANAGER_DEFAULT_TEMPLATE"))
-	if envDefaultTemplate == "" {
-		envDefaultTemplate = strings.TrimSpace(flagTemplate)
+// bindCommand is the tmux command a suggested binding runs: the plugin launcher via run-shell,
+// or for launch mode "popup" the binary in a display-popup started at the pane's directory
+// (TMUX_SESSION_MANAGER_IN_POPUP stops it from opening a second popup).
+func bindCommand(launchMode string) string {
+	if strings.EqualFold(strings.TrimSpace(launchMode), "popup") {
+		return "display-popup -E -w 80% -h 80% -d " + tmuxDoubleQuote("#{pane_current_path}") + " " +
+			tmuxDoubleQuote("TMUX_SESSION_MANAGER_IN_POPUP=1 exec "+bindBinary)
 	}
+	return "run-shell " + tmuxDoubleQuote(bindLauncher)
+}
 
-	envDepth := parseEnvInt("TMUX_SESSION_MANAGER_PROJECT_DEPTH", flagDepth)
+// tmuxDoubleQuote quotes s as a double-quoted tmux.conf string (escaping \, " and $, which tmux
+// would otherwise interpret).
+func tmuxDoubleQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`)
+	return `"` + r.Replace(s) + `"`
+}
 
-	// CLI flags (when set) override env.
-	// Roots: if user supplied --roots explicitly, use it. Otherwise use env.
-	finalRoots := envRoots
-	if strings.TrimSpace(flagRoots) != "" {
-		finalRoots = splitAndTrim(flagRoots)
+func printSuggestedBind(w io.Writer, key, launchMode string) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		key = "<key>"
 	}
+	fmt.Fprintf(w, "bind-key %s %s\n", shellEscapeForTmuxBind(key), bindCommand(launchMode))
+}
 
-	finalSpecNames := envSpecNames
-	if strings.TrimSpace(flagProjectSpecNames) != "" {
-		// Note: we always have a default value for this flag; treat env as default source,
-		// but allow explicit override when user passes a different value.
-		finalSpecNames = splitAndTrim(flagProjectSpecNames)
+// printBindTable prints popular binding choices as ready-to-paste tmux.conf lines.
+func printBindTable(launchMode string) {
+	rows := []struct {
+		flags, key, note string
+	}{
+		{"", "S", "prefix + S (plugin default)"},
+		{"", "C-s", "prefix + Ctrl-s"},
+		{"", "s", "prefix + s (replaces tmux's choose-tree)"},
+		{"", "o", "prefix + o (replaces select next pane)"},
+		{"-n ", "M-s", "Alt-s, no prefix"},
+		{"-n ", "F12", "F12, no prefix"},
 	}
+	cmd := bindCommand(launchMode)
+	for _, r := range rows {
+		fmt.Printf("# %s\nbind-key %s%s %s\n", r.note, r.flags, r.key, cmd)
+	}
+}
 
-	finalTemplate := envDefaultTemplate
-	if strings.TrimSpace(flagTemplate) != "" {
-		finalTemplate = strings.TrimSpace(flagTemplate)
+// tmuxKeyNames are tmux's named keys (matched case-insensitively, like tmux does).
+var tmuxKeyNames = map[string]bool{
+	"up": true, "down": true, "left": true, "right": true,
+	"home": true, "end": true, "ic": true, "insert": true, "dc": true, "delete": true,
+	"npage": true, "pagedown": true, "pgdn": true, "ppage": true, "pageup": true, "pgup": true,
+	"enter": true, "escape": true, "tab": true, "btab": true, "space": true, "bspace": true, "any": true,
+	"kp/": true, "kp*": true, "kp-": true, "kp+": true, "kp.": true, "kpenter": true,
+}
+
+// validateTmuxKey does light syntax checking of a tmux key token: optional C-/M-/S-/^ modifiers
+// followed by a single character, a named key, or F1-F24. Only obvious mistakes are reported.
+func validateTmuxKey(key string) error {
+	key = strings.TrimSpace(key)
+	base := key
+	for {
+		switch {
+		case len(base) > 2 && (strings.HasPrefix(base, "C-") || strings.HasPrefix(base, "M-") || strings.HasPrefix(base, "S-")):
+			base = base[2:]
+			continue
+		case len(base) > 1 && strings.HasPrefix(base, "^"):
+			base = base[1:]
+			continue
+		}
+		break
 	}
 
-	finalLaunchMode := envLaunchMode
-	if strings.TrimSpace(flagLaunchMode) != "" {
-		finalLaunchMode = strings.TrimSpace(flagLaunchMode)
+	if utf8.RuneCountInString(base) == 1 && !unicode.IsSpace([]rune(base)[0]) {
+		return nil
+	}
+	lb := strings.ToLower(base)
+	if tmuxKeyNames[lb] {
+		return nil
+	}
+	if len(lb) == 3 && lb[:2] == "kp" && lb[2] >= '0' && lb[2] <= '9' {
+		return nil
+	}
+	if strings.HasPrefix(lb, "f") {
+		if n, err := strconv.Atoi(lb[1:]); err == nil && n >= 1 && n <= 24 {
+			return nil
+		}
 	}
 
-	finalDepth := envDepth
-	if flagDepth != 0 {
-		finalDepth = flagDepth
+	hint := "expected a single character, a named key (Enter, Space, Up, ...), or F1-F24, with optional C-/M-/S- modifiers"
+	switch {
+	case strings.ContainsAny(key, "+"):
+		hint = "tmux writes modifiers as C-/M-/S- (e.g. C-s), not with +"
+	case strings.HasPrefix(strings.ToLower(key), "ctrl") || strings.HasPrefix(strings.ToLower(key), "alt"):
+		hint = "tmux writes Ctrl as C- and Alt as M- (e.g. C-s, M-s)"
 	}
+	return fmt.Errorf("%q does not look like a tmux key: %s", key, hint)
+}
 
-	opts := core.UIOptions{
+func shellEscapeForTmuxBind(s string) string {
+	// tmux bind-key takes key tokens, not arbitrary shell strings.
+	// For common single-key binds we just pass through; callers can supply "C-s", "M-o", etc.
+	return strings.TrimSpace(s)
+}
+
+// flagWasSet reports whether the named flag was passed on the command line (as opposed to holding
+// its default), so explicit flags can override env while defaults don't shadow it.
+func flagWasSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// waitDefaults is the configured wait_for_prompt timings (zero fields keep the built-ins).
+func waitDefaults() templates.WaitDefaults {
+	return templates.WaitDefaults{
+		TimeoutMS:  cfg.Defaults.WaitTimeoutMS,
+		MinQuietMS: cfg.Defaults.WaitMinQuietMS,
+		SettleMS:   cfg.Defaults.WaitSettleMS,
+	}
+}
+
+// uiOptions builds the TUI options from cfg and the TUI-only flags.
+func uiOptions() core.UIOptions {
+	var cacheTTL time.Duration // default TTL
+	if flagNoCache {
+		cacheTTL = -1
+	}
+	return core.UIOptions{
 		InitialQuery:    flagInitialQuery,
-		LaunchMode:      finalLaunchMode,
-		ProjectsPaths:   finalRoots,
+		LaunchMode:      cfg.LaunchMode,
+		ProjectsPaths:   cfg.ProjectRoots,
 		MaxResults:      flagMaxResults,
-		DefaultTemplate: finalTemplate,
+		DefaultTemplate: cfg.Defaults.DefaultTemplate,
+		PreviewLines:    cfg.PreviewLines,
+
+		ProjectSpecNames:  cfg.SpecFilenames,
+		PreferProjectSpec: cfg.PreferProjectLocalSpec,
+
+		AllowShell:           cfg.Safety.AllowShell,
+		AllowTmuxPassthrough: cfg.Safety.AllowTmuxPassthrough,
+		AllowedShellPrefixes: cfg.Safety.AllowedShellPrefixes,
+		AllowedTmuxCommands:  cfg.Safety.TmuxAllowlist(),
+		StrictWaitForPrompt:  cfg.Safety.StrictWaitForPrompt,
+		WaitDefaults:         waitDefaults(),
+		MaxActionsCeiling:    cfg.Safety.MaxActionsCeiling,
+		MaxCommandLenCeiling: cfg.Safety.MaxCommandLenCeiling,
+		ConfirmKill:          cfg.Safety.ConfirmKill,
+		DryRun:               flagDryRun,
+		DetachUI:             parseEnvBool("TMUX_SESSION_MANAGER_DETACH_UI", flagDetachUI),
+
+		ProjectScanDepth: cfg.ProjectScanDepth,
+		IgnoreDirNames:   cfg.IgnoreDirNames,
+		ProjectMarkers:   cfg.ProjectMarkers,
+		CommandTimeout:   cfg.CommandTimeout,
+		Socket:           specSocket(),
+		SessionSort:      cfg.SessionSort,
+		ProjectCacheTTL:  cacheTTL,
+		Log:              debugLog,
+	}
+}
 
-		ProjectSpecNames:  finalSpecNames,
-		PreferProjectSpec: envPreferSpec,
+// printLists writes --list-projects / --list-sessions output in the --output format. Plain lines
+// are tab-separated so they feed fzf (--delimiter '\t') or cut; json is one object per line.
+func printLists(w io.Writer) error {
+	format := strings.ToLower(strings.TrimSpace(flagOutput))
+	if format != "plain" && format != "json" {
+		return fmt.Errorf("--output: unknown format %q (want plain or json)", flagOutput)
+	}
+	enc := json.NewEncoder(w)
 
-		AllowShell:           envAllowShell,
-		AllowTmuxPassthrough: envAllowTmux,
-		DryRun:               flagDryRun,
+	if flagListProjects {
+		for _, p := range core.ListProjects(context.Background(), uiOptions()) {
+			if format == "json" {
+				if err := enc.Encode(p); err != nil {
+					return err
+				}
+				continue
+			}
+			hasSpec := "-"
+			if p.HasSpec {
+				hasSpec = "spec"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", p.Name, p.Path, hasSpec)
+		}
+	}
+	if flagListSessions {
+		sessions, err := core.ListSessions(uiOptions())
+		if err != nil {
+			return fmt.Errorf("list sessions: %w", err)
+		}
+		for _, s := range sessions {
+			if format == "json" {
+				if err := enc.Encode(s); err != nil {
+					return err
+				}
+				continue
+			}
+			attached := "-"
+			if s.Attached {
+				attached = "attached"
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\n", s.Name, s.Windows, attached)
+		}
+	}
+	return nil
+}
 
-		ProjectScanDepth: finalDepth,
+// validateSpecFile lints a spec for --validate: spec.LoadFile (which runs Validate), then
+// ValidatePolicy and the shell prefix allowlist under the current safety settings. Errors carry
+// the offending field path. Unsafe actions the current settings permit are printed as warnings,
+// since the spec fails wherever those opt-ins are off.
+func validateSpecFile(w io.Writer, path string) error {
+	s, err := spec.LoadFile(expandHome(path))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
 	}
 
-	_ = flagConfigPath // reserved for a future global config loader
+	pol := spec.DefaultPolicy()
+	pol.AllowShell = cfg.Safety.AllowShell
+	pol.AllowTmuxPassthrough = cfg.Safety.AllowTmuxPassthrough
+	pol.AllowedTmuxCommands = cfg.Safety.TmuxAllowlist()
+	if pol.AllowedTmuxCommands == nil {
+		pol.AllowedTmuxCommands = templates.DefaultPolicy().AllowedTmuxCommands
+	}
+	if err := s.ValidatePolicy(pol); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
 
-	if err := core.RunTUI(opts); err != nil {
-		fmt.Fprintf(os.Stderr, "tmux-session-manager: %v\n", err)
-		os.Exit(exitCodeFromErr(err))
+	var warnings []string
+	err = s.WalkActions(func(at string, a spec.Action) error {
+		switch a.Type {
+		case "shell":
+			if a.Shell != nil && !templates.ShellPrefixAllowed(cfg.Safety.AllowedShellPrefixes, a.Shell.Cmd) {
+				return fmt.Errorf("%s: shell command %q does not start with an allowed prefix (%s)", at, a.Shell.Cmd, strings.Join(cfg.Safety.AllowedShellPrefixes, ", "))
+			}
+			warnings = append(warnings, at+": shell action requires --allow-shell")
+		case "tmux":
+			if a.Tmux != nil && !pol.AllowedTmuxCommands[a.Tmux.Name] {
+				warnings = append(warnings, fmt.Sprintf("%s: tmux command %q requires --allow-tmux-passthrough", at, a.Tmux.Name))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
 	}
+
+	for _, msg := range warnings {
+		fmt.Fprintf(w, "warning: %s\n", msg)
+	}
+	fmt.Fprintf(w, "%s: ok\n", path)
+	return nil
 }
 
-func printSuggestedBind(key string) {
-	key = strings.TrimSpace(key)
-	if key == "" {
-		fmt.Println("bind-key <key> run-shell \"~/.tmux/plugins/tmux-session-manager/scripts/tmux_session_manager.tmux\"")
+// runSessionCommand performs --kill-session / --rename-session / --kill-idle on the --socket server
+// (or the current one). Names are checked with spec.ValidateTmuxName, and the session must exist.
+func runSessionCommand(w io.Writer) error {
+	opts := core.SessionOptions{Socket: specSocket(), Log: debugLog}
+	if strings.TrimSpace(os.Getenv("TMUX")) == "" && !core.ServerReachable(opts) {
+		return errors.New("no tmux server reachable (run inside tmux, or pass --socket)")
+	}
+
+	requireSession := func(flagName, name string) error {
+		if err := spec.ValidateTmuxName(name); err != nil {
+			return fmt.Errorf("%s: %w", flagName, err)
+		}
+		ok, err := core.SessionExists(name, opts)
+		if err != nil {
+			return fmt.Errorf("%s: %w", flagName, err)
+		}
+		if !ok {
+			return fmt.Errorf("%s: no session %q", flagName, name)
+		}
+		return nil
+	}
+
+	if name := strings.TrimSpace(flagKillSession); name != "" {
+		if err := requireSession("--kill-session", name); err != nil {
+			return err
+		}
+		if err := core.KillSession(name, opts); err != nil {
+			return fmt.Errorf("--kill-session: %w", err)
+		}
+		fmt.Fprintf(w, "killed %s\n", name)
+	}
+
+	if v := strings.TrimSpace(flagRenameSession); v != "" {
+		from, to, err := parseRenameArg(v)
+		if err != nil {
+			return fmt.Errorf("--rename-session: %w", err)
+		}
+		if err := requireSession("--rename-session", from); err != nil {
+			return err
+		}
+		if err := spec.ValidateTmuxName(to); err != nil {
+			return fmt.Errorf("--rename-session: %w", err)
+		}
+		if ok, _ := core.SessionExists(to, opts); ok {
+			return fmt.Errorf("--rename-session: session %q already exists", to)
+		}
+		if err := core.RenameSession(from, to, opts); err != nil {
+			return fmt.Errorf("--rename-session: %w", err)
+		}
+		fmt.Fprintf(w, "renamed %s -> %s\n", from, to)
+	}
+
+	if flagKillIdle {
+		if err := killIdleSessions(w, opts); err != nil {
+			return fmt.Errorf("--kill-idle: %w", err)
+		}
+	}
+	return nil
+}
+
+// sessionExistsStatus implements --session-exists: exit status 0 when the session exists (its name
+// is printed unless --quiet), 1 when it doesn't (or no server is running), 2 for an empty name.
+// The name is tried as given, then sanitized the way sessions are named (e.g. "My API" -> my_api).
+func sessionExistsStatus(stdout, stderr io.Writer, name string) int {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		fmt.Fprintln(stderr, "tmux-session-manager: --session-exists: session name is empty")
+		return 2
+	}
+	opts := core.SessionOptions{Socket: specSocket(), Log: debugLog}
+	for _, c := range sessionExistsCandidates(name) {
+		if ok, _ := core.SessionExists(c, opts); ok {
+			if !flagQuiet {
+				fmt.Fprintln(stdout, c)
+			}
+			return 0
+		}
+	}
+	if !flagQuiet {
+		fmt.Fprintf(stderr, "tmux-session-manager: no session %q\n", name)
+	}
+	return 1
+}
+
+// sessionExistsCandidates is name, then its sanitized form when that differs. A name with nothing
+// tmux-safe in it (e.g. "@@@") gets no fallback: the generic "session" would match an unrelated one.
+func sessionExistsCandidates(name string) []string {
+	candidates := []string{name}
+	if s := core.SessionNameSlug(name); s != "" && s != name {
+		candidates = append(candidates, s)
+	}
+	return candidates
+}
+
+// killIdleSessions implements --kill-idle: it lists core.IdleSessions and kills them, after a
+// y/N prompt when stdin is a terminal (without one, the flag itself is the confirmation).
+func killIdleSessions(w io.Writer, opts core.SessionOptions) error {
+	idle, err := core.IdleSessions(opts)
+	if err != nil {
+		return err
+	}
+	if len(idle) == 0 {
+		fmt.Fprintln(w, "no idle sessions")
+		return nil
+	}
+	if flagDryRun {
+		for _, name := range idle {
+			fmt.Fprintf(w, "would kill %s\n", name)
+		}
+		return nil
+	}
+	if isatty.IsTerminal(os.Stdin.Fd()) {
+		fmt.Fprintf(os.Stderr, "tmux-session-manager: kill %d idle sessions (%s)? [y/N] ", len(idle), strings.Join(idle, ", "))
+		var answer string
+		_, _ = fmt.Fscanln(os.Stdin, &answer)
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+		default:
+			fmt.Fprintln(w, "cancelled")
+			return nil
+		}
+	}
+
+	var failed []string
+	for _, name := range idle {
+		if err := core.KillSession(name, opts); err != nil {
+			failed = append(failed, err.Error())
+			continue
+		}
+		fmt.Fprintf(w, "killed %s\n", name)
+	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// parseRenameArg splits a --rename-session value "<from>=<to>".
+func parseRenameArg(v string) (string, string, error) {
+	from, to, ok := strings.Cut(v, "=")
+	from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+	if !ok || from == "" || to == "" {
+		return "", "", fmt.Errorf("want <from>=<to> (got %q)", v)
+	}
+	return from, to, nil
+}
+
+// attachOverride maps --attach / --no-attach to ApplyRequest.Attach: nil (neither set) keeps the
+// spec's session.attach.
+func attachOverride() (*bool, error) {
+	switch {
+	case flagAttach && flagNoAttach:
+		return nil, errors.New("--attach and --no-attach are mutually exclusive")
+	case flagAttach:
+		v := true
+		return &v, nil
+	case flagNoAttach:
+		v := false
+		return &v, nil
+	}
+	return nil, nil
+}
+
+// openDebugLog opens the debug trace file when cfg.Debug is set. tmux commands and TUI key events
+// are written there rather than to stderr, which the TUI's alternate screen would hide.
+func openDebugLog() {
+	if !cfg.Debug {
 		return
 	}
-	fmt.Printf("bind-key %s run-shell \"~/.tmux/plugins/tmux-session-manager/scripts/tmux_session_manager.tmux\"\n", shellEscapeForTmuxBind(key))
+	path, err := core.DebugLogPath()
+	if err == nil {
+		debugLog, err = core.OpenDebugLog(path)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tmux-session-manager: warning: %v\n", err)
+		return
+	}
+	debugLog.Debug("start", "args", strings.Join(os.Args[1:], " "), "tmux", os.Getenv("TMUX"))
 }
 
-func shellEscapeForTmuxBind(s string) string {
-	// tmux bind-key takes key tokens, not arbitrary shell strings.
-	// For common single-key binds we just pass through; callers can supply "C-s", "M-o", etc.
-	return strings.TrimSpace(s)
+// resolveConfig resolves pkg/config from env (populated by the launcher from tmux options), then
+// lets explicitly passed flags override it. Flag defaults never shadow env: several flags have
+// non-empty defaults (--depth, --project-spec-names), so only flags that were actually set count.
+func resolveConfig() config.Config {
+	c := config.Resolve()
+	if flagWasSet("roots") {
+		if roots := splitAndTrim(flagRoots); len(roots) > 0 {
+			c.ProjectRoots = make([]string, 0, len(roots))
+			for _, r := range roots {
+				c.ProjectRoots = append(c.ProjectRoots, expandHome(r))
+			}
+		}
+	}
+	if flagWasSet("depth") && flagDepth >= 0 {
+		c.ProjectScanDepth = flagDepth
+	}
+	if flagWasSet("ignore-dirs") {
+		c.IgnoreDirNames = splitAndTrim(flagIgnoreDirs)
+	}
+	if flagWasSet("project-spec-names") {
+		if names := splitAndTrim(flagProjectSpecNames); len(names) > 0 {
+			c.SpecFilenames = names
+		}
+	}
+	if flagWasSet("prefer-project-spec") {
+		c.PreferProjectLocalSpec = flagPreferProjectSpec
+	}
+	if flagWasSet("template") && strings.TrimSpace(flagTemplate) != "" {
+		c.Defaults.DefaultTemplate = strings.TrimSpace(flagTemplate)
+	}
+	if flagWasSet("launch-mode") && strings.TrimSpace(flagLaunchMode) != "" {
+		c.LaunchMode = strings.TrimSpace(flagLaunchMode)
+	}
+	if flagWasSet("allow-shell") {
+		c.Safety.AllowShell = flagAllowShell
+	}
+	if flagWasSet("allow-tmux-passthrough") {
+		c.Safety.AllowTmuxPassthrough = flagAllowTmuxPassthrough
+	}
+	return c
+}
+
+// keepInitWindow reports whether the bootstrap init session should survive the switch
+// (flag, or TMUX_SESSION_MANAGER_KEEP_INIT_WINDOW).
+func keepInitWindow() bool {
+	return parseEnvBool("TMUX_SESSION_MANAGER_KEEP_INIT_WINDOW", flagKeepInitWindow)
+}
+
+// runOpen implements --open: switch to the directory's session, creating it first from its project
+// spec or the template (like picking a project in the TUI). It returns the exit code.
+func runOpen(dir string) int {
+	opts := uiOptions()
+	sel, specPath, err := core.OpenDirSelection(dir, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tmux-session-manager: --open: %v\n", err)
+		return 1
+	}
+	source := "template " + sel.Template
+	if specPath != "" {
+		source = "spec " + specPath
+	}
+
+	// Like the TUI: a spec that runs shell / tmux passthrough is confirmed once before it runs.
+	unsafeSpec, ask := core.UntrustedSelectionSpec(sel, opts)
+
+	if flagDryRun {
+		exists, _ := core.SessionExists(sel.SessionName, core.SessionOptions{Socket: specSocket(), Log: debugLog})
+		if exists {
+			fmt.Printf("would switch to session %s\n", sel.SessionName)
+		} else {
+			fmt.Printf("would create session %s in %s (%s) and switch to it\n", sel.SessionName, sel.ProjectPath, source)
+		}
+		if ask {
+			fmt.Printf("(%s runs shell / tmux passthrough actions and isn't trusted yet: it would ask first)\n", unsafeSpec.SpecPath)
+		}
+		if flagOutputSessionName {
+			fmt.Println(sel.SessionName)
+		}
+		return 0
+	}
+
+	if specPath != "" {
+		for _, w := range cfg.Safety.Warnings() {
+			fmt.Fprintf(os.Stderr, "tmux-session-manager: warning: %s\n", w)
+		}
+	}
+	if ask {
+		if !confirmUnsafeSpec(unsafeSpec) {
+			return 1
+		}
+		if err := core.TrustSpec(unsafeSpec); err != nil {
+			// Not fatal: it runs this time and asks again next time.
+			fmt.Fprintf(os.Stderr, "tmux-session-manager: warning: %v\n", err)
+		}
+	}
+	// Print before switching: when bootstrapped, dropping the init session may take this process
+	// down with it.
+	if flagOutputSessionName {
+		fmt.Println(sel.SessionName)
+	}
+	note, err := core.OpenSelection(sel, opts)
+	if note != "" {
+		fmt.Fprintf(os.Stderr, "tmux-session-manager: %s\n", note)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tmux-session-manager: --open: %v\n", err)
+		return 1
+	}
+	dropInitSession(sel.SessionName)
+	return 0
+}
+
+// confirmUnsafeSpec asks on the terminal before the first run of a spec whose plan uses shell or
+// tmux passthrough (--open). Without a terminal it refuses: there's nobody to confirm.
+func confirmUnsafeSpec(u core.UnsafeSpec) bool {
+	if !isatty.IsTerminal(os.Stdin.Fd()) {
+		fmt.Fprintf(os.Stderr, "tmux-session-manager: --open: %s runs shell / tmux passthrough actions and isn't trusted yet; run --open from a terminal (or open it in the TUI) to confirm it once\n", u.SpecPath)
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "tmux-session-manager: %s runs shell / tmux passthrough actions. Run it and trust them from now on? [y/N] ", u.SpecPath)
+	var answer string
+	_, _ = fmt.Fscanln(os.Stdin, &answer)
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	default:
+		fmt.Fprintln(os.Stderr, "tmux-session-manager: not opening")
+		return false
+	}
+}
+
+// dropInitSession kills the --bootstrap init session once the client has moved to sessionName,
+// unless it is kept (keepInitWindow). Outside a bootstrap it does nothing.
+func dropInitSession(sessionName string) {
+	initSession := strings.TrimSpace(os.Getenv("TMUX_SESSION_MANAGER_INIT_SESSION"))
+	if initSession == "" || initSession == sessionName {
+		return
+	}
+	if keepInitWindow() {
+		fmt.Fprintf(os.Stderr, "tmux-session-manager: keeping bootstrap init session %q\n", initSession)
+		return
+	}
+	_ = exec.Command("tmux", "kill-session", "-t", initSession).Run()
+}
+
+// runForeachProject applies --foreach-project and prints one line per project plus a summary. It
+// returns the exit code: 1 if any pattern matched nothing or any project failed.
+func runForeachProject(patterns string) int {
+	projects, missing := core.ResolveProjects(cfg.ProjectRoots, cfg.SpecFilenames, strings.Split(patterns, ","))
+	for _, m := range missing {
+		fmt.Fprintf(os.Stderr, "tmux-session-manager: --foreach-project: no project matches %q under roots\n", m)
+	}
+	if len(missing) > 0 && !flagContinueOnError {
+		return 1
+	}
+	for _, w := range cfg.Safety.Warnings() {
+		fmt.Fprintf(os.Stderr, "tmux-session-manager: warning: %s\n", w)
+	}
+
+	results := core.ApplyProjects(context.Background(), core.BatchRequest{
+		Projects:        projects,
+		Template:        cfg.Defaults.DefaultTemplate,
+		ContinueOnError: flagContinueOnError,
+		Apply: core.ApplyRequest{
+			Env:                  flagSpecEnv.Map(),
+			AllowShell:           cfg.Safety.AllowShell,
+			AllowTmuxPassthrough: cfg.Safety.AllowTmuxPassthrough,
+			AllowedShellPrefixes: cfg.Safety.AllowedShellPrefixes,
+			AllowedTmuxCommands:  cfg.Safety.TmuxAllowlist(),
+			StrictWaitForPrompt:  cfg.Safety.StrictWaitForPrompt,
+			CommandTimeout:       cfg.CommandTimeout,
+			WaitDefaults:         waitDefaults(),
+			MaxActionsCeiling:    cfg.Safety.MaxActionsCeiling,
+			MaxCommandLenCeiling: cfg.Safety.MaxCommandLenCeiling,
+			Socket:               specSocket(),
+			KeepDefaultWindow:    flagNoDefaultWindowCleanup,
+			ReplaceSession:       flagReplaceSession,
+			DryRun:               flagDryRun,
+			Log:                  debugLog,
+		},
+	})
+
+	created, existing := 0, 0
+	for _, r := range results {
+		switch {
+		case r.Err != nil:
+			fmt.Printf("FAIL\t%s\t%s\t%v\n", r.Project.Name, r.SessionName, r.Err)
+		case r.Source == "exists":
+			existing++
+			fmt.Printf("exists\t%s\t%s\n", r.Project.Name, r.SessionName)
+		default:
+			created++
+			fmt.Printf("ok\t%s\t%s\t%s\n", r.Project.Name, r.SessionName, r.Source)
+		}
+	}
+	failed := core.BatchFailed(results)
+	skipped := len(projects) - len(results)
+	summary := fmt.Sprintf("%d created, %d already running, %d failed", created, existing, failed)
+	if flagDryRun {
+		summary = fmt.Sprintf("dry-run: %d would be created, %d already running, %d failed", created, existing, failed)
+	}
+	if skipped > 0 {
+		summary += fmt.Sprintf(", %d not attempted (use --continue-on-error)", skipped)
+	}
+	if len(missing) > 0 {
+		summary += fmt.Sprintf(", %d unmatched", len(missing))
+	}
+	fmt.Fprintf(os.Stderr, "tmux-session-manager: %s\n", summary)
+	if failed > 0 || len(missing) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// specSocket returns the tmux server selected with --socket / --tmux-L (flags, then env), for
+// --spec/--project applies and the TUI.
+func specSocket() string {
+	if v := strings.TrimSpace(flagSocket); v != "" {
+		return v
+	}
+	if v := strings.TrimSpace(flagTmuxL); v != "" {
+		return v
+	}
+	return strings.TrimSpace(os.Getenv("TMUX_SESSION_MANAGER_SOCKET"))
+}
+
+// tmuxCmd builds a tmux command against the --socket server (or tmux's default resolution).
+func tmuxCmd(args ...string) *exec.Cmd {
+	return exec.Command("tmux", templates.WithSocketArgs(specSocket(), args)...)
+}
+
+// attachOnSpecSocket attaches this terminal to sessionName on the --socket server.
+// $TMUX is dropped so tmux allows a nested client when we're already inside one.
+func attachOnSpecSocket(sessionName string) error {
+	cmd := tmuxCmd("attach-session", "-t", sessionName)
+	env := make([]string, 0, len(os.Environ()))
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "TMUX=") {
+			env = append(env, kv)
+		}
+	}
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// resolveScaffoldDir resolves the --scaffold argument to a project directory.
+// An existing directory path (e.g. "." or "~/code/app") wins; otherwise the value is treated as a
+// project name and looked up as <root>/<name> under the configured roots.
+func resolveScaffoldDir(arg string) (string, error) {
+	p := expandHome(arg)
+	if st, err := os.Stat(p); err == nil && st.IsDir() {
+		return p, nil
+	}
+	if strings.ContainsRune(arg, os.PathSeparator) {
+		return "", fmt.Errorf("not a directory: %s", arg)
+	}
+
+	roots := cfg.ProjectRoots
+	for _, r := range roots {
+		cand := filepath.Join(expandHome(r), arg)
+		if st, err := os.Stat(cand); err == nil && st.IsDir() {
+			return cand, nil
+		}
+	}
+	return "", fmt.Errorf("project %q not found under roots (%s)", arg, strings.Join(roots, ","))
+}
+
+// specEnvFlag collects repeatable --spec-env KEY=VALUE flags (later values win).
+type specEnvFlag []string
+
+var reSpecEnvKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (f *specEnvFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(*f, ",")
+}
+
+func (f *specEnvFlag) Set(v string) error {
+	k, _, ok := strings.Cut(v, "=")
+	k = strings.TrimSpace(k)
+	if !ok {
+		return fmt.Errorf("expected KEY=VALUE, got %q", v)
+	}
+	if !reSpecEnvKey.MatchString(k) {
+		return fmt.Errorf("invalid variable name %q (want [A-Za-z_][A-Za-z0-9_]*)", k)
+	}
+	*f = append(*f, v)
+	return nil
+}
+
+// Map returns the collected KEY=VALUE pairs as a map (nil when none were set).
+func (f specEnvFlag) Map() map[string]string {
+	if len(f) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(f))
+	for _, kv := range f {
+		k, v, _ := strings.Cut(kv, "=")
+		out[strings.TrimSpace(k)] = v
+	}
+	return out
 }
 
 func splitAndTrim(csv string) []string {
@@ -482,18 +1486,6 @@ func parseEnvBool(key string, def bool) bool {
 	}
 }
 
-func parseEnvInt(key string, def int) int {
-	v := strings.TrimSpace(os.Getenv(key))
-	if v == "" {
-		return def
-	}
-	n, err := strconv.Atoi(v)
-	if err != nil {
-		return def
-	}
-	return n
-}
-
 // shellJoin renders args into a shell-safe command string.
 // This is used for bootstrap re-exec via `SHELL -lc "<cmd>"`.
 func shellJoin(args []string) string {
@@ -517,9 +1509,106 @@ func shellQuote(s string) string {
 	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
 }
 
+// confirmReplaceSession asks before --replace-session tears down a running session. Without a
+// terminal on stdin (scripts, keybindings) the flag itself is the confirmation. The name comes from
+// a dry run of req itself, so it resolves exactly as the apply will; when that dry run fails the
+// question is still asked (about req.SessionName, if any).
+func confirmReplaceSession(req core.ApplyRequest, in *os.File, out io.Writer) bool {
+	if !isatty.IsTerminal(in.Fd()) {
+		return true
+	}
+	return askReplaceSession(req, in, out)
+}
+
+func askReplaceSession(req core.ApplyRequest, in io.Reader, out io.Writer) bool {
+	from := req.SpecPath
+	if from == "" {
+		from = "stdin"
+	}
+
+	dry := req
+	dry.DryRun, dry.Preflight = true, false
+	plan, err := core.Apply(context.Background(), dry)
+	switch {
+	case err != nil:
+		name := strings.TrimSpace(req.SessionName)
+		if name == "" {
+			name = "(unresolved)"
+		}
+		fmt.Fprintf(out, "tmux-session-manager: could not plan the replacement (%v)\n", err)
+		fmt.Fprintf(out, "tmux-session-manager: replace running session %q with a fresh build from %s anyway? [y/N] ", name, from)
+	default:
+		if exists, _ := core.SessionExists(plan.SessionName, core.SessionOptions{Socket: req.Socket}); !exists {
+			return true
+		}
+		fmt.Fprintf(out, "tmux-session-manager: replace running session %q with a fresh build from %s? [y/N] ", plan.SessionName, from)
+	}
+	var answer string
+	_, _ = fmt.Fscanln(in, &answer)
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
+// exitPreflightFailed is the --strict-dry-run exit code when the plan compiled but preflight checks
+// found problems (distinct from 1, which means the spec could not be loaded/compiled at all).
+const exitPreflightFailed = 3
+
+// exitUnsafeActions is the --strict-dry-run exit code when the plan needs shell or tmux
+// passthrough: either rejected because the policy doesn't allow it, or allowed and used.
+const exitUnsafeActions = 4
+
 func exitCodeFromErr(err error) int {
 	if err == nil {
 		return 0
 	}
 	return 1
 }
+
+// strictDryRunExitCode maps a --strict-dry-run outcome to its exit code. Unsafe usage outranks
+// failed preflight checks; other errors keep exitCodeFromErr's code.
+func strictDryRunExitCode(err error, unsafeUsed bool, preflightFailures int) int {
+	switch {
+	case errors.Is(err, spec.ErrDisabledByPolicy), err == nil && unsafeUsed:
+		return exitUnsafeActions
+	case err != nil:
+		return exitCodeFromErr(err)
+	case preflightFailures > 0:
+		return exitPreflightFailed
+	}
+	return 0
+}
+
+// resolveRestore finds the snapshot for --restore/--restore-at. When nothing (or more than one)
+// matches, the candidates are printed to stderr before the error is returned.
+func resolveRestore(name, at string) (core.Snapshot, error) {
+	if strings.TrimSpace(name) == "" {
+		all, err := core.ListSnapshots()
+		if err != nil {
+			return core.Snapshot{}, err
+		}
+		printSnapshots(all)
+		return core.Snapshot{}, errors.New("missing session name")
+	}
+	snap, candidates, err := core.ResolveSnapshot(name, at)
+	if err != nil {
+		printSnapshots(candidates)
+		return core.Snapshot{}, err
+	}
+	return snap, nil
+}
+
+func printSnapshots(snaps []core.Snapshot) {
+	if len(snaps) == 0 {
+		dir, _ := core.SnapshotDir()
+		fmt.Fprintf(os.Stderr, "no snapshots in %s (take one with `e` in the TUI)\n", dir)
+		return
+	}
+	fmt.Fprintln(os.Stderr, "available snapshots (name  timestamp):")
+	for _, s := range snaps {
+		fmt.Fprintf(os.Stderr, "  %s  %s\n", s.Name, s.Timestamp)
+	}
+}
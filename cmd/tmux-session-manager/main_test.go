@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"tmux-session-manager/pkg/config"
+	core "tmux-session-manager/pkg/manager"
+	"tmux-session-manager/pkg/spec"
+)
+
+func TestSessionExistsCandidates(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"api", "api"},
+		{"My API", "My API,my_api"},
+		{"dev-api", "dev-api,dev_api"},
+		{"@@@", "@@@"},
+		{"!!", "!!"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(sessionExistsCandidates(tt.name), ","); got != tt.want {
+			t.Errorf("sessionExistsCandidates(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStrictDryRunExitCode(t *testing.T) {
+	policyErr := fmt.Errorf("spec policy rejected: %w", spec.ErrDisabledByPolicy)
+	tests := []struct {
+		name      string
+		err       error
+		unsafe    bool
+		preflight int
+		want      int
+	}{
+		{"clean plan", nil, false, 0, 0},
+		{"unsafe used", nil, true, 0, exitUnsafeActions},
+		{"unsafe rejected by policy", policyErr, false, 0, exitUnsafeActions},
+		{"preflight failures", nil, false, 2, exitPreflightFailed},
+		{"unsafe outranks preflight", nil, true, 2, exitUnsafeActions},
+		{"other error", errors.New("load spec: boom"), false, 0, 1},
+	}
+	for _, tt := range tests {
+		if got := strictDryRunExitCode(tt.err, tt.unsafe, tt.preflight); got != tt.want {
+			t.Errorf("%s: strictDryRunExitCode = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+// parseFlags re-parses the command line as args against fresh flag state: every flag is back at its
+// default and only the ones in args count as set for flagWasSet. The real flag set and values are
+// restored when the test ends.
+func parseFlags(t *testing.T, args ...string) {
+	t.Helper()
+	saved := flag.CommandLine
+	prev := map[string]string{}
+	fs := flag.NewFlagSet("tmux-session-manager", flag.ContinueOnError)
+	saved.VisitAll(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Name, "test.") {
+			return
+		}
+		prev[f.Name] = f.Value.String()
+		_ = f.Value.Set(f.DefValue)
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	flag.CommandLine = fs
+	t.Cleanup(func() {
+		flag.CommandLine = saved
+		for name, v := range prev {
+			_ = saved.Lookup(name).Value.Set(v)
+		}
+	})
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// --depth only overrides TMUX_SESSION_MANAGER_PROJECT_DEPTH when it is passed, even with the
+// value of its default.
+func TestResolveConfigDepth(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string
+		args []string
+		want int
+	}{
+		{"env, flag untouched", "5", nil, 5},
+		{"flag set", "5", []string{"--depth", "1"}, 1},
+		{"flag set to its default", "5", []string{"--depth=2"}, 2},
+		{"neither", "", nil, config.Resolve().ProjectScanDepth},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TMUX_SESSION_MANAGER_PROJECT_DEPTH", tt.env)
+			parseFlags(t, tt.args...)
+			if got := resolveConfig().ProjectScanDepth; got != tt.want {
+				t.Errorf("ProjectScanDepth = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// Flags with non-empty defaults must not shadow the env when left untouched.
+func TestResolveConfigFlagDefaultsKeepEnv(t *testing.T) {
+	t.Setenv("TMUX_SESSION_MANAGER_SPEC_NAMES", "session.yaml")
+	t.Setenv("TMUX_SESSION_MANAGER_ROOTS", "/env/a,/env/b")
+	t.Setenv("TMUX_SESSION_MANAGER_DEFAULT_TEMPLATE", "go")
+	t.Setenv("TMUX_SESSION_MANAGER_LAUNCH_MODE", "window")
+
+	summary := func(c config.Config) string {
+		return strings.Join([]string{
+			strings.Join(c.SpecFilenames, ","),
+			strings.Join(c.ProjectRoots, ","),
+			c.Defaults.DefaultTemplate,
+			c.LaunchMode,
+		}, " ")
+	}
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"env, flags untouched", nil, "session.yaml /env/a,/env/b go window"},
+		{"flags set", []string{"--project-spec-names", "x.yaml", "--roots", "/flag", "--template", "node", "--launch-mode", "popup"}, "x.yaml /flag node popup"},
+		{"flags set to their defaults", []string{"--project-spec-names=.tmux-session.yaml,.tmux-session.yml,.tmux-session.json"}, ".tmux-session.yaml,.tmux-session.yml,.tmux-session.json /env/a,/env/b go window"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parseFlags(t, tt.args...)
+			if got := summary(resolveConfig()); got != tt.want {
+				t.Errorf("config = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// The launcher sets the depth through the environment (from a tmux option) and passes other flags;
+// that depth, including 0, must reach the TUI's project scan.
+func TestUIOptionsScanDepthFromEnv(t *testing.T) {
+	for _, env := range []string{"0", "4"} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv("TMUX_SESSION_MANAGER_PROJECT_DEPTH", env)
+			parseFlags(t, "--config", "/dev/null", "--launch-mode", "popup")
+			saved := cfg
+			t.Cleanup(func() { cfg = saved })
+			cfg = resolveConfig()
+			if got := fmt.Sprint(uiOptions().ProjectScanDepth); got != env {
+				t.Errorf("ProjectScanDepth = %s, want %s", got, env)
+			}
+		})
+	}
+}
+
+// --list-projects --output json is one object per line with exactly these fields.
+func TestPrintListsProjectsJSON(t *testing.T) {
+	root := t.TempDir()
+	for _, p := range []string{"api/go.mod", "web/package.json", "web/.tmux-session.yaml"} {
+		path := filepath.Join(root, p)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	parseFlags(t, "--list-projects", "--output", "json")
+	saved := cfg
+	t.Cleanup(func() { cfg = saved })
+	cfg = config.Resolve()
+	cfg.ProjectRoots = []string{root}
+	cfg.ProjectScanDepth = 2
+
+	var buf bytes.Buffer
+	if err := printLists(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []map[string]any{
+		{"name": "api", "path": filepath.Join(root, "api"), "has_spec": false},
+		{"name": "web", "path": filepath.Join(root, "web"), "has_spec": true, "spec_path": filepath.Join(root, "web", ".tmux-session.yaml")},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("output:\n%s", buf.String())
+	}
+	for i, ln := range lines {
+		var got map[string]any
+		if err := json.Unmarshal([]byte(ln), &got); err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("line %d = %v, want %v", i, got, want[i])
+		}
+	}
+}
+
+func TestSessionInfoJSON(t *testing.T) {
+	b, err := json.Marshal(core.SessionInfo{Name: "api", Windows: 3, Attached: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), `{"name":"api","windows":3,"attached":true}`; got != want {
+		t.Errorf("json = %s, want %s", got, want)
+	}
+}
+
+func TestValidateSpecFile(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		allowShell bool
+		prefixes   []string
+		out        string
+		err        string
+	}{
+		{"valid", "version: 1\nwindows:\n  - name: edit\n", false, nil, "ok", ""},
+		{"unknown action type", "version: 1\nactions:\n  - type: bogus\n", false, nil, "", "actions[0]"},
+		{"nothing to build", "version: 1\nwindowz: []\n", false, nil, "", "either windows[] or actions[]"},
+		{"shell rejected by policy", "version: 1\nactions:\n  - type: shell\n    shell: {cmd: make dev}\n", false, nil, "", "disabled by policy"},
+		{"shell allowed warns", "version: 1\nactions:\n  - type: shell\n    shell: {cmd: make dev}\n", true, nil, "warning: actions[0]: shell action requires --allow-shell", ""},
+		{"shell prefix rejected", "version: 1\nactions:\n  - type: shell\n    shell: {cmd: go test}\n", true, []string{"make "}, "", "allowed prefix"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "spec.yaml")
+			if err := os.WriteFile(path, []byte(tt.body), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			saved := cfg
+			t.Cleanup(func() { cfg = saved })
+			cfg = config.Resolve()
+			cfg.Safety.AllowShell = tt.allowShell
+			cfg.Safety.AllowedShellPrefixes = tt.prefixes
+
+			var buf bytes.Buffer
+			err := validateSpecFile(&buf, path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(buf.String(), tt.out) {
+				t.Errorf("output %q doesn't contain %q", buf.String(), tt.out)
+			}
+		})
+	}
+}
+
+// Without TMUX_SESSION_MANAGER_DEBUG no trace file is opened or created.
+func TestOpenDebugLogDisabled(t *testing.T) {
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	t.Setenv("TMUX_SESSION_MANAGER_DEBUG", "")
+	saved, savedLog := cfg, debugLog
+	t.Cleanup(func() { cfg, debugLog = saved, savedLog })
+	cfg, debugLog = config.Resolve(), nil
+
+	openDebugLog()
+	if debugLog != nil {
+		t.Error("debug logger opened with debugging off")
+	}
+	if ents, _ := os.ReadDir(cache); len(ents) != 0 {
+		t.Errorf("cache written with debugging off: %v", ents)
+	}
+}
+
+func TestParseRenameArg(t *testing.T) {
+	tests := []struct {
+		in       string
+		from, to string
+		wantErr  bool
+	}{
+		{"old=new", "old", "new", false},
+		{" old = new ", "old", "new", false},
+		{"a=b=c", "a", "b=c", false}, // ValidateTmuxName rejects "b=c" later
+		{"old", "", "", true},
+		{"=new", "", "", true},
+		{"old=", "", "", true},
+	}
+	for _, tt := range tests {
+		from, to, err := parseRenameArg(tt.in)
+		if (err != nil) != tt.wantErr || from != tt.from || to != tt.to {
+			t.Errorf("parseRenameArg(%q) = %q, %q, %v", tt.in, from, to, err)
+		}
+	}
+}
+
+// Names are validated before anything reaches tmux, and without a server the command says so.
+func TestRunSessionCommandValidates(t *testing.T) {
+	saved := [...]string{flagKillSession, flagRenameSession, flagSocket}
+	t.Cleanup(func() { flagKillSession, flagRenameSession, flagSocket = saved[0], saved[1], saved[2] })
+	flagSocket = filepath.Join(t.TempDir(), "none")
+
+	tests := []struct {
+		tmux         string
+		kill, rename string
+		want         string
+	}{
+		{"", "api", "", "no tmux server reachable"},
+		{"/tmp/fake,1,0", "a:b", "", "--kill-session: invalid"},
+		{"/tmp/fake,1,0", "", "a.b=c", "--rename-session: invalid"},
+		{"/tmp/fake,1,0", "", "nodelimiter", "--rename-session: want <from>=<to>"},
+	}
+	for _, tt := range tests {
+		t.Setenv("TMUX", tt.tmux)
+		flagKillSession, flagRenameSession = tt.kill, tt.rename
+		var out bytes.Buffer
+		if err := runSessionCommand(&out); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("kill %q rename %q: err = %v, want %q", tt.kill, tt.rename, err, tt.want)
+		}
+		if out.Len() != 0 {
+			t.Errorf("output on failure: %q", out.String())
+		}
+	}
+}
+
+func TestAttachOverride(t *testing.T) {
+	saved := [...]bool{flagAttach, flagNoAttach}
+	t.Cleanup(func() { flagAttach, flagNoAttach = saved[0], saved[1] })
+	tests := []struct {
+		attach, noAttach bool
+		want             string
+	}{
+		{false, false, "<nil>"}, // honor the spec
+		{true, false, "true"},
+		{false, true, "false"},
+		{true, true, "error"},
+	}
+	for _, tt := range tests {
+		flagAttach, flagNoAttach = tt.attach, tt.noAttach
+		v, err := attachOverride()
+		got := "<nil>"
+		switch {
+		case err != nil:
+			got = "error"
+		case v != nil:
+			got = fmt.Sprint(*v)
+		}
+		if got != tt.want {
+			t.Errorf("--attach=%v --no-attach=%v: %s, want %s", tt.attach, tt.noAttach, got, tt.want)
+		}
+	}
+}
+
+func TestPrintSuggestedBind(t *testing.T) {
+	const (
+		runShell = `run-shell "~/.tmux/plugins/tmux-session-manager/scripts/tmux_session_manager.tmux"`
+		popup    = `display-popup -E -w 80% -h 80% -d "#{pane_current_path}" "TMUX_SESSION_MANAGER_IN_POPUP=1 exec ~/.tmux/plugins/tmux-session-manager/bin/tmux-session-manager"`
+	)
+	tests := []struct {
+		key, mode, want string
+	}{
+		{"S", "window", "bind-key S " + runShell},
+		{"C-s", "", "bind-key C-s " + runShell},
+		{" M-o ", "window", "bind-key M-o " + runShell},
+		{"C-s", "popup", "bind-key C-s " + popup},
+		{"M-o", " Popup ", "bind-key M-o " + popup},
+		{"", "popup", "bind-key <key> " + popup},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		printSuggestedBind(&buf, tt.key, tt.mode)
+		if got := strings.TrimSuffix(buf.String(), "\n"); got != tt.want {
+			t.Errorf("printSuggestedBind(%q, %q) =\n%s\nwant\n%s", tt.key, tt.mode, got, tt.want)
+		}
+	}
+	if got := tmuxDoubleQuote(`a "b" $HOME \x`); got != `"a \"b\" \$HOME \\x"` {
+		t.Errorf("tmuxDoubleQuote = %s", got)
+	}
+}
+
+// The replace prompt plans with the real request (here: raised limit ceilings), and a plan that
+// fails still asks instead of replacing silently.
+func TestAskReplaceSession(t *testing.T) {
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "tmux"), []byte("#!/bin/sh\nexit 0\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+	t.Setenv("TMUX", "")
+
+	dir := t.TempDir()
+	specPath := filepath.Join(dir, ".tmux-session.yaml")
+	if err := os.WriteFile(specPath, []byte("version: 1\nlimits: {max_actions: 5000}\nwindows:\n  - name: edit\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	req := core.ApplyRequest{SpecPath: specPath, ProjectPath: dir, SessionName: "big", MaxActionsCeiling: 10000, ReplaceSession: true}
+
+	tests := []struct {
+		name    string
+		ceiling int
+		answer  string
+		want    bool
+		prompt  string
+	}{
+		{"planned, declined", 10000, "n\n", false, `replace running session "big" with a fresh build from ` + specPath + "? [y/N]"},
+		{"planned, accepted", 10000, "y\n", true, `replace running session "big"`},
+		{"plan fails, declined", 0, "\n", false, "could not plan the replacement"},
+		{"plan fails, accepted", 0, "yes\n", true, `replace running session "big" with a fresh build from ` + specPath + " anyway?"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := req
+			r.MaxActionsCeiling = tt.ceiling
+			var out bytes.Buffer
+			if got := askReplaceSession(r, strings.NewReader(tt.answer), &out); got != tt.want {
+				t.Errorf("askReplaceSession = %v, want %v", got, tt.want)
+			}
+			if !strings.Contains(out.String(), tt.prompt) {
+				t.Errorf("prompt %q lacks %q", out.String(), tt.prompt)
+			}
+		})
+	}
+}
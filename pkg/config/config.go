@@ -1,11 +1,15 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
+
+	"tmux-session-manager/pkg/spec"
+	"tmux-session-manager/pkg/templates"
 )
 
 // Config contains runtime configuration resolved by the cmd/launcher layer and environment.
@@ -19,6 +23,10 @@ type Config struct {
 
 	IgnoreDirNames []string
 
+	// ProjectMarkers are extra file/directory names that make a directory a project (e.g. WORKSPACE,
+	// pom.xml, .projectile), in addition to the built-in markers.
+	ProjectMarkers []string
+
 	SpecFilenames []string
 
 	PreferProjectLocalSpec bool
@@ -30,6 +38,14 @@ type Config struct {
 	Debug bool
 
 	CommandTimeout time.Duration
+
+	// PreviewLines is the TUI preview height at startup (0 = default 12; +/- resize it live).
+	PreviewLines int
+
+	// SessionSort is the TUI sessions order at startup: "name" (default), "activity" (most
+	// recently active first), "windows" (most windows first), or "mru" (most recently switched
+	// to from the TUI, persisted across runs). `o` cycles it live.
+	SessionSort string
 }
 
 // Safety governs what kinds of actions are allowed when applying specs/templates.
@@ -41,6 +57,21 @@ type Safety struct {
 	DeniedTmuxCommands  []string
 
 	AllowedShellPrefixes []string
+
+	// ConfirmKill controls how destructive TUI operations (killing a session) are confirmed:
+	//   - "y" (default): a single y/n keypress
+	//   - "name": type the session name and press enter
+	//   - "yes": type the word "yes" and press enter
+	ConfirmKill string
+
+	// StrictWaitForPrompt fails applies when wait_for_prompt cannot use capture-pane, instead of
+	// falling back to a fixed settle delay with a warning (default false).
+	StrictWaitForPrompt bool
+
+	// MaxActionsCeiling / MaxCommandLenCeiling bound what a spec's `limits:` block may request.
+	// 0 means the engine defaults (2000 actions / 32768 chars).
+	MaxActionsCeiling    int
+	MaxCommandLenCeiling int
 }
 
 // Defaults are values applied when a spec omits fields.
@@ -49,6 +80,12 @@ type Defaults struct {
 	EditorCmd       string
 	ShellCmd        string
 	SessionPrefix   string
+
+	// WaitTimeoutMS / WaitMinQuietMS / WaitSettleMS replace the built-in wait_for_prompt timings
+	// (15000 / 500 / 250 ms) for actions that leave them unset. 0 keeps the built-in value.
+	WaitTimeoutMS  int
+	WaitMinQuietMS int
+	WaitSettleMS   int
 }
 
 type EnvKeys struct {
@@ -56,6 +93,7 @@ type EnvKeys struct {
 	Roots         string
 	Depth         string
 	IgnoreDirs    string
+	Markers       string
 	SpecNames     string
 	PreferSpec    string
 	Debug         string
@@ -65,11 +103,22 @@ type EnvKeys struct {
 	DefaultTpl    string
 	SessionPrefix string
 
+	WaitTimeoutMS  string
+	WaitMinQuietMS string
+	WaitSettleMS   string
+
 	AllowShell           string
 	AllowTmuxPassthrough string
 	AllowedTmuxCommands  string
 	DeniedTmuxCommands   string
 	AllowedShellPrefixes string
+	ConfirmKill          string
+	StrictWaitForPrompt  string
+	MaxActionsCeiling    string
+	MaxCommandLenCeiling string
+
+	PreviewLines string
+	SessionSort  string
 }
 
 func DefaultEnvKeys() EnvKeys {
@@ -78,6 +127,7 @@ func DefaultEnvKeys() EnvKeys {
 		Roots:         "TMUX_SESSION_MANAGER_ROOTS",
 		Depth:         "TMUX_SESSION_MANAGER_PROJECT_DEPTH",
 		IgnoreDirs:    "TMUX_SESSION_MANAGER_IGNORE_DIRS",
+		Markers:       "TMUX_SESSION_MANAGER_PROJECT_MARKERS",
 		SpecNames:     "TMUX_SESSION_MANAGER_SPEC_NAMES",
 		PreferSpec:    "TMUX_SESSION_MANAGER_PREFER_PROJECT_SPEC",
 		Debug:         "TMUX_SESSION_MANAGER_DEBUG",
@@ -87,11 +137,22 @@ func DefaultEnvKeys() EnvKeys {
 		DefaultTpl:    "TMUX_SESSION_MANAGER_DEFAULT_TEMPLATE",
 		SessionPrefix: "TMUX_SESSION_MANAGER_SESSION_PREFIX",
 
+		WaitTimeoutMS:  "TMUX_SESSION_MANAGER_WAIT_TIMEOUT_MS",
+		WaitMinQuietMS: "TMUX_SESSION_MANAGER_WAIT_MIN_QUIET_MS",
+		WaitSettleMS:   "TMUX_SESSION_MANAGER_WAIT_SETTLE_MS",
+
 		AllowShell:           "TMUX_SESSION_MANAGER_ALLOW_SHELL",
 		AllowTmuxPassthrough: "TMUX_SESSION_MANAGER_ALLOW_TMUX_PASSTHROUGH",
 		AllowedTmuxCommands:  "TMUX_SESSION_MANAGER_ALLOWED_TMUX_COMMANDS",
 		DeniedTmuxCommands:   "TMUX_SESSION_MANAGER_DENIED_TMUX_COMMANDS",
 		AllowedShellPrefixes: "TMUX_SESSION_MANAGER_ALLOWED_SHELL_PREFIXES",
+		ConfirmKill:          "TMUX_SESSION_MANAGER_CONFIRM_KILL",
+		StrictWaitForPrompt:  "TMUX_SESSION_MANAGER_STRICT_WAIT_FOR_PROMPT",
+		MaxActionsCeiling:    "TMUX_SESSION_MANAGER_MAX_ACTIONS_CEILING",
+		MaxCommandLenCeiling: "TMUX_SESSION_MANAGER_MAX_COMMAND_LEN_CEILING",
+
+		PreviewLines: "TMUX_SESSION_MANAGER_PREVIEW_LINES",
+		SessionSort:  "TMUX_SESSION_MANAGER_SESSION_SORT",
 	}
 }
 
@@ -122,6 +183,9 @@ func ResolveWithEnv(keys EnvKeys) Config {
 	if v := strings.TrimSpace(os.Getenv(keys.IgnoreDirs)); v != "" {
 		cfg.IgnoreDirNames = splitCommaList(v)
 	}
+	if v := strings.TrimSpace(os.Getenv(keys.Markers)); v != "" {
+		cfg.ProjectMarkers = splitCommaList(v)
+	}
 
 	// Spec filenames
 	if v := strings.TrimSpace(os.Getenv(keys.SpecNames)); v != "" {
@@ -158,6 +222,21 @@ func ResolveWithEnv(keys EnvKeys) Config {
 	if v := strings.TrimSpace(os.Getenv(keys.SessionPrefix)); v != "" {
 		cfg.Defaults.SessionPrefix = strings.TrimSpace(v)
 	}
+	if v := strings.TrimSpace(os.Getenv(keys.WaitTimeoutMS)); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.Defaults.WaitTimeoutMS = n
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv(keys.WaitMinQuietMS)); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.Defaults.WaitMinQuietMS = n
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv(keys.WaitSettleMS)); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.Defaults.WaitSettleMS = n
+		}
+	}
 
 	// Safety toggles
 	if v := strings.TrimSpace(os.Getenv(keys.AllowShell)); v != "" {
@@ -175,6 +254,30 @@ func ResolveWithEnv(keys EnvKeys) Config {
 	if v := strings.TrimSpace(os.Getenv(keys.AllowedShellPrefixes)); v != "" {
 		cfg.Safety.AllowedShellPrefixes = splitCommaListPreserveSpaces(v)
 	}
+	if v := strings.TrimSpace(os.Getenv(keys.ConfirmKill)); v != "" {
+		cfg.Safety.ConfirmKill = NormalizeConfirmKill(v)
+	}
+	if v := strings.TrimSpace(os.Getenv(keys.StrictWaitForPrompt)); v != "" {
+		cfg.Safety.StrictWaitForPrompt = parseBool(v, cfg.Safety.StrictWaitForPrompt)
+	}
+	if v := strings.TrimSpace(os.Getenv(keys.MaxActionsCeiling)); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.Safety.MaxActionsCeiling = n
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv(keys.MaxCommandLenCeiling)); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.Safety.MaxCommandLenCeiling = n
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv(keys.PreviewLines)); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.PreviewLines = n
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv(keys.SessionSort)); v != "" {
+		cfg.SessionSort = NormalizeSessionSort(v)
+	}
 
 	cfg = cfg.withDerivedDefaults()
 	return cfg
@@ -216,6 +319,9 @@ func (c Config) ApplyTmuxOptionEnvOverlay(optionEnv map[string]string) Config {
 	if v := get("TMUX_SESSION_MANAGER_IGNORE_DIRS"); v != "" {
 		out.IgnoreDirNames = splitCommaList(v)
 	}
+	if v := get("TMUX_SESSION_MANAGER_PROJECT_MARKERS"); v != "" {
+		out.ProjectMarkers = splitCommaList(v)
+	}
 	if v := get("TMUX_SESSION_MANAGER_SPEC_NAMES"); v != "" {
 		out.SpecFilenames = splitCommaList(v)
 	}
@@ -238,6 +344,30 @@ func (c Config) ApplyTmuxOptionEnvOverlay(optionEnv map[string]string) Config {
 	if v := get("TMUX_SESSION_MANAGER_ALLOWED_SHELL_PREFIXES"); v != "" {
 		out.Safety.AllowedShellPrefixes = splitCommaListPreserveSpaces(v)
 	}
+	if v := get("TMUX_SESSION_MANAGER_CONFIRM_KILL"); v != "" {
+		out.Safety.ConfirmKill = NormalizeConfirmKill(v)
+	}
+	if v := get("TMUX_SESSION_MANAGER_STRICT_WAIT_FOR_PROMPT"); v != "" {
+		out.Safety.StrictWaitForPrompt = parseBool(v, out.Safety.StrictWaitForPrompt)
+	}
+	if v := get("TMUX_SESSION_MANAGER_MAX_ACTIONS_CEILING"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			out.Safety.MaxActionsCeiling = n
+		}
+	}
+	if v := get("TMUX_SESSION_MANAGER_MAX_COMMAND_LEN_CEILING"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			out.Safety.MaxCommandLenCeiling = n
+		}
+	}
+	if v := get("TMUX_SESSION_MANAGER_PREVIEW_LINES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			out.PreviewLines = n
+		}
+	}
+	if v := get("TMUX_SESSION_MANAGER_SESSION_SORT"); v != "" {
+		out.SessionSort = NormalizeSessionSort(v)
+	}
 
 	if v := get("TMUX_SESSION_MANAGER_DEFAULT_TEMPLATE"); v != "" {
 		out.Defaults.DefaultTemplate = v
@@ -251,6 +381,21 @@ func (c Config) ApplyTmuxOptionEnvOverlay(optionEnv map[string]string) Config {
 	if v := get("TMUX_SESSION_MANAGER_SESSION_PREFIX"); v != "" {
 		out.Defaults.SessionPrefix = v
 	}
+	if v := get("TMUX_SESSION_MANAGER_WAIT_TIMEOUT_MS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			out.Defaults.WaitTimeoutMS = n
+		}
+	}
+	if v := get("TMUX_SESSION_MANAGER_WAIT_MIN_QUIET_MS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			out.Defaults.WaitMinQuietMS = n
+		}
+	}
+	if v := get("TMUX_SESSION_MANAGER_WAIT_SETTLE_MS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			out.Defaults.WaitSettleMS = n
+		}
+	}
 
 	if v := get("TMUX_SESSION_MANAGER_DEBUG"); v != "" {
 		out.Debug = parseBool(v, out.Debug)
@@ -300,6 +445,7 @@ func defaultConfig() Config {
 			AllowedTmuxCommands:  defaultAllowedTmuxCommands(),
 			DeniedTmuxCommands:   defaultDeniedTmuxCommands(),
 			AllowedShellPrefixes: nil,
+			ConfirmKill:          "y",
 		},
 		Defaults: Defaults{
 			DefaultTemplate: "auto",
@@ -309,6 +455,7 @@ func defaultConfig() Config {
 		},
 		Debug:          false,
 		CommandTimeout: 0,
+		SessionSort:    "name",
 	}
 }
 
@@ -340,6 +487,9 @@ func (c Config) withDerivedDefaults() Config {
 		out.Safety.DeniedTmuxCommands = defaultDeniedTmuxCommands()
 	}
 
+	out.Safety.ConfirmKill = NormalizeConfirmKill(out.Safety.ConfirmKill)
+	out.SessionSort = NormalizeSessionSort(out.SessionSort)
+
 	// Ensure spec filenames include the canonical defaults if user set an empty list accidentally.
 	if len(out.SpecFilenames) == 0 {
 		out.SpecFilenames = []string{".tmux-session.yaml", ".tmux-session.yml", ".tmux-session.json"}
@@ -364,6 +514,34 @@ func normalizeLaunchMode(v string, def string) string {
 	}
 }
 
+// NormalizeConfirmKill maps a confirmation setting to one of "y", "name", "yes".
+// Unknown values fall back to "y" (single keypress).
+func NormalizeConfirmKill(v string) string {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "name", "session", "session-name", "typed":
+		return "name"
+	case "yes", "word":
+		return "yes"
+	default:
+		return "y"
+	}
+}
+
+// NormalizeSessionSort maps a sessions order to one of "name", "activity", "windows", "mru".
+// Unknown values fall back to "name".
+func NormalizeSessionSort(v string) string {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "activity", "recent":
+		return "activity"
+	case "windows":
+		return "windows"
+	case "mru":
+		return "mru"
+	default:
+		return "name"
+	}
+}
+
 // IsTmuxCommandAllowed determines whether a tmux subcommand is allowed under the current Safety config.
 // This is intended for enforcement by the spec executor.
 //
@@ -382,6 +560,9 @@ func (s Safety) IsTmuxCommandAllowed(cmd string) bool {
 	}
 	lc := strings.ToLower(cmd)
 
+	if _, denied := spec.HardDeniedTmuxCommand(lc); denied {
+		return false
+	}
 	for _, d := range s.DeniedTmuxCommands {
 		if strings.ToLower(strings.TrimSpace(d)) == lc {
 			return false
@@ -395,33 +576,50 @@ func (s Safety) IsTmuxCommandAllowed(cmd string) bool {
 	return false
 }
 
+// TmuxAllowlist is AllowedTmuxCommands minus DeniedTmuxCommands, as the spec and engine policies
+// take it (nil when nothing is configured, i.e. their default allowlist). Always-denied names are
+// kept so the engine can warn about them; they never run.
+func (s Safety) TmuxAllowlist() map[string]bool {
+	if len(s.AllowedTmuxCommands) == 0 {
+		return nil
+	}
+	denied := map[string]bool{}
+	for _, d := range s.DeniedTmuxCommands {
+		denied[strings.ToLower(strings.TrimSpace(d))] = true
+	}
+	out := map[string]bool{}
+	for _, a := range s.AllowedTmuxCommands {
+		if a = strings.ToLower(strings.TrimSpace(a)); a != "" && !denied[a] {
+			out[a] = true
+		}
+	}
+	return out
+}
+
+// Warnings reports Safety settings that cannot take effect: allowlisted tmux commands that are
+// always denied (see spec.HardDeniedTmuxCommand), whatever DeniedTmuxCommands says.
+func (s Safety) Warnings() []string {
+	var out []string
+	for _, n := range spec.DeniedInAllowlist(s.AllowedTmuxCommands) {
+		out = append(out, fmt.Sprintf("allowed tmux commands: %q is always denied (runs arbitrary commands); ignoring", n))
+	}
+	return out
+}
+
 // IsShellCommandAllowed returns true if shell execution is permitted.
 // If AllowedShellPrefixes is non-empty, the command must start with one of them (after trimming leading spaces).
 func (s Safety) IsShellCommandAllowed(cmd string) bool {
 	if !s.AllowShell {
 		return false
 	}
-	cmd = strings.TrimLeft(cmd, " \t")
-	if cmd == "" {
+	if strings.TrimLeft(cmd, " \t") == "" {
 		return false
 	}
-	if len(s.AllowedShellPrefixes) == 0 {
-		return true
-	}
-	for _, p := range s.AllowedShellPrefixes {
-		p = strings.TrimLeft(p, " \t")
-		if p == "" {
-			continue
-		}
-		if strings.HasPrefix(cmd, p) {
-			return true
-		}
-	}
-	return false
+	return templates.ShellPrefixAllowed(s.AllowedShellPrefixes, cmd)
 }
 
 func defaultAllowedTmuxCommands() []string {
-	// Conservative "session construction" interface.
+	// Conservative "session construction" interface, the same as the engine's default allowlist.
 	// Notably excludes: run-shell, pipe-pane, set-hook, source-file, display-popup, etc.
 	return []string{
 		"new-session",
@@ -429,7 +627,6 @@ func defaultAllowedTmuxCommands() []string {
 		"rename-session",
 		"switch-client",
 		"select-session",
-		"attach-session",
 
 		"new-window",
 		"kill-window",
@@ -437,24 +634,19 @@ func defaultAllowedTmuxCommands() []string {
 		"select-window",
 		"move-window",
 		"swap-window",
+		"list-windows",
+		"list-sessions",
 
 		"split-window",
 		"kill-pane",
 		"select-pane",
-		"swap-pane",
 		"resize-pane",
-		"break-pane",
-		"join-pane",
-
 		"select-layout",
 
 		"send-keys",
-		"set-buffer",
 		"display-message",
-
 		"set-option",
-		"set-window-option",
-		"set-hook",
+		"set-buffer",
 	}
 }
 
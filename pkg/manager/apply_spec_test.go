@@ -0,0 +1,523 @@
+package manager
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tmux-session-manager/pkg/templates"
+)
+
+// waitArgs returns the "timeout quiet settle" fields of the plan's wait_for_prompt sentinel.
+func waitArgs(t *testing.T, cmds []PlanCommand) string {
+	t.Helper()
+	for _, c := range cmds {
+		if len(c.Args) > 4 && c.Args[0] == "__wait_for_prompt__" {
+			return strings.Join(c.Args[2:5], " ")
+		}
+	}
+	t.Fatalf("no wait_for_prompt in plan %v", cmds)
+	return ""
+}
+
+func TestApplySpecFileWaitDefaults(t *testing.T) {
+	dir := t.TempDir()
+	writeSpec(t, dir, "version: 1\nactions:\n  - type: wait_for_prompt\n    wait_for_prompt: {settle_ms: 40}\n")
+	path := filepath.Join(dir, ".tmux-session.yaml")
+
+	tests := []struct {
+		name     string
+		defaults templates.WaitDefaults
+		want     string
+	}{
+		{"built-ins", templates.WaitDefaults{}, "15000 500 40"},
+		{"configured", templates.WaitDefaults{TimeoutMS: 3000, MinQuietMS: 100, SettleMS: 900}, "3000 100 40"},
+		{"partly configured", templates.WaitDefaults{MinQuietMS: 250}, "15000 250 40"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := ApplySpecFile(path, ApplySpecOptions{SessionName: "w", DryRun: true, WaitDefaults: tt.defaults})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := waitArgs(t, res.Commands); got != tt.want {
+				t.Errorf("wait args = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// The UI compiles specs with specEngine, which must carry the configured wait settings too.
+func TestSpecEngineUsesOptions(t *testing.T) {
+	opts := UIOptions{
+		AllowShell:           true,
+		AllowedShellPrefixes: []string{"make "},
+		StrictWaitForPrompt:  true,
+		WaitDefaults:         templates.WaitDefaults{TimeoutMS: 1234},
+	}
+	eng := specEngine(opts)
+	if !eng.Policy.AllowShell || eng.Policy.AllowTmuxPassthrough || len(eng.Policy.AllowedShellPrefixes) != 1 {
+		t.Errorf("policy = %+v", eng.Policy)
+	}
+	if !eng.StrictWaitForPrompt || eng.WaitDefaults.TimeoutMS != 1234 {
+		t.Errorf("strict = %v, wait defaults = %+v", eng.StrictWaitForPrompt, eng.WaitDefaults)
+	}
+	if pol := specPolicy(opts); !pol.AllowShell || pol.AllowTmuxPassthrough {
+		t.Errorf("spec policy = %+v", pol)
+	}
+}
+
+func TestApplySpecFileLimitCeilings(t *testing.T) {
+	dir := t.TempDir()
+	writeSpec(t, dir, "version: 1\nlimits: {max_actions: 5000, max_command_len: 40000}\nwindows:\n  - name: edit\n")
+	path := filepath.Join(dir, ".tmux-session.yaml")
+	// The env var must no longer matter; only the options do.
+	t.Setenv("TMUX_SESSION_MANAGER_MAX_ACTIONS_CEILING", "10000")
+
+	tests := []struct {
+		name          string
+		maxActions    int
+		maxCommandLen int
+		wantErr       string
+	}{
+		{"engine defaults", 0, 0, "limits.max_actions 5000 exceeds ceiling 2000"},
+		{"actions raised only", 6000, 0, "limits.max_command_len 40000 exceeds ceiling 32768"},
+		{"both raised", 6000, 50000, ""},
+		{"lowered", 100, 50000, "exceeds ceiling 100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ApplySpecFile(path, ApplySpecOptions{
+				SessionName:          "l",
+				DryRun:               true,
+				MaxActionsCeiling:    tt.maxActions,
+				MaxCommandLenCeiling: tt.maxCommandLen,
+			})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplySpecFileTmuxAllowlist(t *testing.T) {
+	dir := t.TempDir()
+	writeSpec(t, dir, "version: 1\nactions:\n  - type: tmux\n    tmux: {name: set-environment, args: [FOO, bar]}\n")
+	path := filepath.Join(dir, ".tmux-session.yaml")
+
+	tests := []struct {
+		name    string
+		allowed map[string]bool
+		wantErr bool
+		warn    string
+	}{
+		{"default allowlist", nil, true, ""},
+		{"configured", map[string]bool{"set-environment": true}, false, ""},
+		{"configured without it", map[string]bool{"display-message": true}, true, ""},
+		{"always-denied entry warns", map[string]bool{"set-environment": true, "run-shell": true}, false, `"run-shell"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := ApplySpecFile(path, ApplySpecOptions{
+				SessionName:          "a",
+				DryRun:               true,
+				AllowTmuxPassthrough: true,
+				AllowedTmuxCommands:  tt.allowed,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error: %v", err, tt.wantErr)
+			}
+			if tt.warn != "" && !strings.Contains(strings.Join(res.Warnings, "\n"), tt.warn) {
+				t.Errorf("warnings %q don't mention %s", res.Warnings, tt.warn)
+			}
+		})
+	}
+}
+
+// planLines joins each plan command's args, one command per line.
+func planLines(cmds []PlanCommand) string {
+	var b strings.Builder
+	for _, c := range cmds {
+		b.WriteString(strings.Join(c.Args, " "))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func TestApplySpecFileExportEnv(t *testing.T) {
+	const actions = "actions:\n  - type: banner\n    banner: {message: \"db=${DATABASE_URL}\"}\n"
+	tests := []struct {
+		name        string
+		body        string
+		env         map[string]string
+		exported    string
+		substituted string
+	}{
+		{"not exported by default", "version: 1\nenv: {DATABASE_URL: spec, not-an-identifier: x}\n" + actions, nil, "", "db=spec"},
+		{"export_env", "version: 1\nexport_env: true\nenv: {DATABASE_URL: spec}\n" + actions, nil, "DATABASE_URL spec", "db=spec"},
+		{"--spec-env reaches the export", "version: 1\nexport_env: true\nenv: {DATABASE_URL: spec}\n" + actions, map[string]string{"DATABASE_URL": "cli"}, "DATABASE_URL cli", "db=cli"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeSpec(t, dir, tt.body)
+			res, err := ApplySpecFile(filepath.Join(dir, ".tmux-session.yaml"), ApplySpecOptions{SessionName: "e", DryRun: true, Env: tt.env})
+			if err != nil {
+				t.Fatal(err)
+			}
+			plan := planLines(res.Commands)
+			if got := strings.Contains(plan, "set-environment -t e "); got != (tt.exported != "") {
+				t.Errorf("set-environment in plan = %v, want %v:\n%s", got, tt.exported != "", plan)
+			}
+			if tt.exported != "" && !strings.Contains(plan, "set-environment -t e "+tt.exported+"\n") {
+				t.Errorf("plan doesn't export %q:\n%s", tt.exported, plan)
+			}
+			if !strings.Contains(plan, tt.substituted) {
+				t.Errorf("plan doesn't substitute %q:\n%s", tt.substituted, plan)
+			}
+		})
+	}
+}
+
+// Exported names must be identifiers; substitution-only ones need not be.
+func TestApplySpecFileExportEnvNames(t *testing.T) {
+	dir := t.TempDir()
+	writeSpec(t, dir, "version: 1\nexport_env: true\nenv: {not-an-identifier: x}\nwindows:\n  - name: edit\n")
+	_, err := ApplySpecFile(filepath.Join(dir, ".tmux-session.yaml"), ApplySpecOptions{SessionName: "e", DryRun: true})
+	if err == nil || !strings.Contains(err.Error(), "invalid variable name") {
+		t.Errorf("err = %v, want invalid variable name", err)
+	}
+}
+
+// A pane_plan split size of "NN%" is a percent (-p), a bare "NN" an absolute length (-l), and an
+// empty size neither (tmux splits in half).
+func TestApplySpecFilePanePlanSize(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    string
+		wantErr bool
+	}{
+		{"30", "-l 30", false},
+		{"30%", "-p 30", false},
+		{"", "", false},
+		{"0", "", true},
+		{"100%", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.size, func(t *testing.T) {
+			dir := t.TempDir()
+			writeSpec(t, dir, "version: 1\nwindows:\n  - name: edit\n    pane_plan:\n      - pane: {name: side}\n      - split: {direction: h, size: \""+tt.size+"\"}\n      - pane: {name: main}\n")
+			res, err := ApplySpecFile(filepath.Join(dir, ".tmux-session.yaml"), ApplySpecOptions{SessionName: "p", DryRun: true})
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "size") {
+					t.Errorf("err = %v, want a size error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var split string
+			for _, line := range strings.Split(planLines(res.Commands), "\n") {
+				if strings.HasPrefix(line, "split-window ") {
+					split = line
+				}
+			}
+			if split == "" {
+				t.Fatalf("no split-window in plan:\n%s", planLines(res.Commands))
+			}
+			hasP, hasL := strings.Contains(split, " -p "), strings.Contains(split, " -l ")
+			switch {
+			case tt.want == "" && (hasP || hasL):
+				t.Errorf("split = %q, want no size", split)
+			case tt.want != "" && !strings.Contains(split, " "+tt.want):
+				t.Errorf("split = %q, want %s", split, tt.want)
+			case hasP && hasL:
+				t.Errorf("split = %q has both -p and -l", split)
+			}
+		})
+	}
+}
+
+// Splits that leave a pane too small on a typical terminal are a warning, not an error.
+func TestApplySpecFilePanePlanOverSplit(t *testing.T) {
+	tests := []struct {
+		name   string
+		splits string
+		warn   string
+	}{
+		{"reasonable", "      - split: {direction: h, size: \"30%\"}\n      - pane: {}\n      - split: {direction: v, size: \"50%\"}\n      - pane: {}\n", ""},
+		{"over-split rows", strings.Repeat("      - split: {direction: v, size: \"90%\"}\n      - pane: {}\n", 5), "rows tall"},
+		{"narrow columns", "      - split: {direction: h, size: \"10\"}\n      - pane: {}\n", "columns wide"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeSpec(t, dir, "version: 1\nwindows:\n  - name: edit\n    pane_plan:\n      - pane: {}\n"+tt.splits)
+			res, err := ApplySpecFile(filepath.Join(dir, ".tmux-session.yaml"), ApplySpecOptions{SessionName: "p", DryRun: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			warns := strings.Join(res.Warnings, "\n")
+			if tt.warn == "" && strings.Contains(warns, "pane_plan") {
+				t.Errorf("unexpected warning:\n%s", warns)
+			}
+			if tt.warn != "" && !strings.Contains(warns, tt.warn) {
+				t.Errorf("warnings %q don't mention %q", res.Warnings, tt.warn)
+			}
+		})
+	}
+}
+
+// Each named pane gets one select-pane -T with its name; unnamed panes get none.
+func TestApplySpecFilePaneTitles(t *testing.T) {
+	dir := t.TempDir()
+	writeSpec(t, dir, `version: 1
+windows:
+  - name: edit
+    panes:
+      - name: editor
+      - {}
+      - name: logs
+  - name: plan
+    pane_plan:
+      - pane: {name: left}
+      - split: {direction: h}
+      - pane: {}
+      - split: {direction: v}
+      - pane: {name: bottom}
+`)
+	res, err := ApplySpecFile(filepath.Join(dir, ".tmux-session.yaml"), ApplySpecOptions{SessionName: "t", DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, c := range res.Commands {
+		if len(c.Args) > 0 && c.Args[0] == "select-pane" {
+			for i, a := range c.Args {
+				if a == "-T" && i+1 < len(c.Args) {
+					titles = append(titles, c.Args[i+1])
+				}
+			}
+		}
+	}
+	if got := strings.Join(titles, ","); got != "editor,logs,left,bottom" {
+		t.Errorf("pane titles = %s, want editor,logs,left,bottom\n%s", got, planLines(res.Commands))
+	}
+}
+
+// Pre hooks compile before the first window, post hooks after the last window and focus_window,
+// and both go through the same policy as other actions.
+func TestApplySpecFileHooksOrder(t *testing.T) {
+	dir := t.TempDir()
+	writeSpec(t, dir, `version: 1
+session: {focus_window: edit}
+hooks:
+  pre:
+    - type: banner
+      banner: {message: pre-hook}
+  post:
+    - type: banner
+      banner: {message: post-hook}
+windows:
+  - name: edit
+    panes:
+      - actions: [{type: run, run: {program: vim}}]
+  - name: logs
+`)
+	res, err := ApplySpecFile(filepath.Join(dir, ".tmux-session.yaml"), ApplySpecOptions{SessionName: "h", DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := planLines(res.Commands)
+	order := []string{"pre-hook", "-n edit", "vim", "-n logs", "select-window -t h:edit\n", "post-hook"}
+	rest := plan
+	for _, needle := range order {
+		i := strings.Index(rest, needle)
+		if i < 0 {
+			t.Fatalf("%q missing or out of order (want %q):\n%s", needle, order, plan)
+		}
+		rest = rest[i+len(needle):]
+	}
+
+	writeSpec(t, dir, "version: 1\nhooks:\n  post:\n    - type: shell\n      shell: {cmd: notify-send done}\nwindows:\n  - name: edit\n")
+	if _, err := ApplySpecFile(filepath.Join(dir, ".tmux-session.yaml"), ApplySpecOptions{SessionName: "h", DryRun: true}); err == nil || !strings.Contains(err.Error(), "hooks.post") {
+		t.Errorf("shell post hook without --allow-shell: err = %v", err)
+	}
+}
+
+// run and send_keys honor enter: false, and prefill types its command last without Enter.
+func TestApplySpecFileNoEnter(t *testing.T) {
+	dir := t.TempDir()
+	writeSpec(t, dir, `version: 1
+windows:
+  - name: edit
+    panes:
+      - prefill: make deploy
+        actions:
+          - {type: run, run: {program: make, args: [build]}}
+          - {type: run, run: {program: make, args: [test], enter: false}}
+          - {type: send_keys, send_keys: {keys: [git status], enter: true}}
+          - {type: send_keys, send_keys: {keys: [git push]}}
+`)
+	res, err := ApplySpecFile(filepath.Join(dir, ".tmux-session.yaml"), ApplySpecOptions{SessionName: "k", DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent []string
+	for _, c := range res.Commands {
+		if len(c.Args) > 0 && c.Args[0] == "send-keys" {
+			sent = append(sent, strings.Join(c.Args[3:], " "))
+		}
+	}
+	want := []string{"make build C-m", "make test", "git status C-m", "git push", "make deploy"}
+	if strings.Join(sent, "|") != strings.Join(want, "|") {
+		t.Errorf("send-keys = %q, want %q", sent, want)
+	}
+}
+
+// session.group compiles ensure_session into new-session -t <group>, shown in the dry run.
+func TestApplySpecFileSessionGroup(t *testing.T) {
+	tests := []struct {
+		group   string
+		want    string
+		wantErr bool
+	}{
+		{"work", "new-session -d -s view -t work -c ", false},
+		{"", "new-session -d -s view -c ", false},
+		{"view", "new-session -d -s view -c ", false}, // the group's own base session
+		{"my work", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.group, func(t *testing.T) {
+			dir := t.TempDir()
+			writeSpec(t, dir, "version: 1\nsession: {group: \""+tt.group+"\"}\nwindows:\n  - name: edit\n")
+			res, err := ApplySpecFile(filepath.Join(dir, ".tmux-session.yaml"), ApplySpecOptions{SessionName: "view", DryRun: true, IncludeEnsureSession: true})
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "group") {
+					t.Errorf("err = %v, want a group error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plan := planLines(res.Commands); !strings.HasPrefix(plan, tt.want) {
+				t.Fatalf("plan doesn't start with %q:\n%s", tt.want, plan)
+			}
+			if !strings.Contains(strings.Join(res.DryRunLines, "\n"), "tmux "+tt.want) {
+				t.Errorf("dry run doesn't show %q:\n%s", tt.want, strings.Join(res.DryRunLines, "\n"))
+			}
+		})
+	}
+}
+
+// focusRunner records executed commands and reports active as the session's active window.
+type focusRunner struct {
+	active string
+	runs   []string
+}
+
+func (r *focusRunner) Run(args []string) error {
+	r.runs = append(r.runs, strings.Join(args, " "))
+	return nil
+}
+
+func (r *focusRunner) RunOutput(args []string) (string, error) {
+	r.runs = append(r.runs, strings.Join(args, " "))
+	if args[0] == "display-message" {
+		return r.active + "\n", nil
+	}
+	return "", nil
+}
+
+// After executing, ApplySpecFile reads back the active window and warns when tmux landed
+// somewhere other than session.focus_window (or the FocusWindow override).
+func TestApplySpecFileVerifiesFocusWindow(t *testing.T) {
+	dir := t.TempDir()
+	writeSpec(t, dir, "version: 1\nsession: {focus_window: edit}\nwindows:\n  - name: edit\n  - name: editor\n")
+	path := filepath.Join(dir, ".tmux-session.yaml")
+
+	tests := []struct {
+		name     string
+		override string
+		active   string
+		selected string
+		warn     bool
+	}{
+		{"name matches", "", "1:edit", "select-window -t f:edit", false},
+		{"index matches", "2", "2:editor", "select-window -t f:2", false},
+		{"prefix landed elsewhere", "", "2:editor", "select-window -t f:edit", true},
+		{"unreadable", "", "", "select-window -t f:edit", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &focusRunner{active: tt.active}
+			res, err := ApplySpecFile(path, ApplySpecOptions{SessionName: "f", FocusWindow: tt.override, Runner: r})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(strings.Join(r.runs, "\n"), tt.selected) {
+				t.Errorf("no %q in\n%s", tt.selected, strings.Join(r.runs, "\n"))
+			}
+			if res.ActiveWindow != tt.active {
+				t.Errorf("ActiveWindow = %q, want %q", res.ActiveWindow, tt.active)
+			}
+			var warned bool
+			for _, w := range res.Warnings {
+				warned = warned || strings.HasPrefix(w, "focus window ")
+			}
+			if warned != tt.warn {
+				t.Errorf("warnings = %q, want focus warning %v", res.Warnings, tt.warn)
+			}
+		})
+	}
+}
+
+// ${VAR} in an action takes the pane's env over its window's over the spec's; window and pane env
+// keys must be variable names.
+func TestApplySpecFileScopedEnv(t *testing.T) {
+	dir := t.TempDir()
+	writeSpec(t, dir, `version: 1
+env: {A: top, B: top, C: top}
+windows:
+  - name: test
+    env: {A: win, B: win}
+    panes:
+      - actions: [{type: run, run: {program: echo, args: ["${A}-${B}-${C}"]}}]
+      - env: {A: pane}
+        actions: [{type: run, run: {program: echo, args: ["${A}-${B}-${C}"]}}]
+  - name: plain
+    panes:
+      - actions: [{type: run, run: {program: echo, args: ["${A}-${B}-${C}"]}}]
+`)
+	res, err := ApplySpecFile(filepath.Join(dir, ".tmux-session.yaml"), ApplySpecOptions{SessionName: "e", DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var echoed []string
+	for _, line := range strings.Split(planLines(res.Commands), "\n") {
+		if _, arg, ok := strings.Cut(line, " echo "); ok && strings.HasPrefix(line, "send-keys ") {
+			echoed = append(echoed, strings.TrimSuffix(arg, " C-m"))
+		}
+	}
+	if got := strings.Join(echoed, " "); got != "'win-win-top' 'pane-win-top' 'top-top-top'" {
+		t.Errorf("echoed %s, want 'win-win-top' 'pane-win-top' 'top-top-top'", got)
+	}
+
+	for _, body := range []string{
+		"version: 1\nwindows:\n  - name: test\n    env: {NODE-ENV: test}\n",
+		"version: 1\nwindows:\n  - name: test\n    panes:\n      - env: {1X: y}\n",
+	} {
+		writeSpec(t, dir, body)
+		if _, err := ApplySpecFile(filepath.Join(dir, ".tmux-session.yaml"), ApplySpecOptions{SessionName: "e", DryRun: true}); err == nil || !strings.Contains(err.Error(), "invalid variable name") {
+			t.Errorf("%q: err = %v, want invalid variable name", body, err)
+		}
+	}
+}
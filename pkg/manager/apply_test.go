@@ -0,0 +1,215 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tmux-session-manager/pkg/spec"
+	"tmux-session-manager/pkg/templates"
+)
+
+func TestResolveApplySessionName(t *testing.T) {
+	const path = "/home/u/code/api"
+	withSession := func(name, prefix string) *spec.Spec {
+		return &spec.Spec{Session: spec.Session{Name: name, Prefix: prefix}}
+	}
+	tests := []struct {
+		name     string
+		s        *spec.Spec
+		explicit string
+		project  string
+		want     string
+	}{
+		{"project name", withSession("", ""), "", "api", "api"},
+		{"no spec", nil, "", "My API", "my_api"},
+		{"prefix", withSession("", "dev"), "", "api", "dev-api"},
+		{"prefix sanitized", withSession("", "Dev Env"), "", "api", "dev_env-api"},
+		{"punctuation prefix ignored", withSession("", "!!"), "", "api", "api"},
+		{"spec name beats prefix", withSession("backend", "dev"), "", "api", "backend"},
+		{"explicit name wins", withSession("backend", "dev"), "Mine", "api", "mine"},
+		{"dashes folded", withSession("", ""), "", "my-svc", "my_svc"},
+		{"dashed spec name folded", withSession("my-svc", ""), "", "api", "my_svc"},
+		{"punctuation-only name hashed", withSession("@@@", ""), "", "api", "session_" + templates.HashPath(path)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveApplySessionName(tt.s, tt.explicit, tt.project, path); got != tt.want {
+				t.Errorf("resolveApplySessionName = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// The apply path and the compiled plan must agree on the prefixed name.
+func TestApplySpecFilePrefixedSessionName(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "api")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeSpec(t, dir, "version: 1\nsession: {prefix: dev}\nwindows:\n  - name: edit\n")
+	path := filepath.Join(dir, ".tmux-session.yaml")
+
+	res, err := ApplySpecFile(path, ApplySpecOptions{DryRun: true, IncludeEnsureSession: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.SessionName != "dev-api" {
+		t.Errorf("SessionName = %q, want dev-api", res.SessionName)
+	}
+	for _, c := range res.Commands {
+		if len(c.Args) > 0 && c.Args[0] == "new-session" {
+			for i, a := range c.Args {
+				if a == "-s" && c.Args[i+1] != "dev-api" {
+					t.Errorf("plan creates %q: %v", c.Args[i+1], c.Args)
+				}
+			}
+		}
+	}
+
+	res, err = ApplySpecFile(path, ApplySpecOptions{DryRun: true, SessionName: "explicit"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.SessionName != "explicit" {
+		t.Errorf("explicit SessionName = %q", res.SessionName)
+	}
+}
+
+// Two projects whose names sanitize to nothing must not collapse onto one session. (A
+// punctuation-only session.name is already rejected when the spec loads.)
+func TestApplySpecFilePunctuationOnlyName(t *testing.T) {
+	seen := map[string]bool{}
+	for _, project := range []string{"a", "b"} {
+		dir := filepath.Join(t.TempDir(), project, "+++")
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writeSpec(t, dir, "version: 1\nwindows:\n  - name: edit\n")
+		res, err := ApplySpecFile(filepath.Join(dir, ".tmux-session.yaml"), ApplySpecOptions{DryRun: true, IncludeEnsureSession: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "session_" + templates.HashPath(dir); res.SessionName != want {
+			t.Errorf("%s: SessionName = %q, want %q", project, res.SessionName, want)
+		}
+		if seen[res.SessionName] {
+			t.Errorf("%s: SessionName %q collides", project, res.SessionName)
+		}
+		seen[res.SessionName] = true
+		for _, c := range res.Commands {
+			for i, a := range c.Args {
+				if a == "-t" && i+1 < len(c.Args) && !strings.HasPrefix(c.Args[i+1], res.SessionName) {
+					t.Errorf("%s: plan targets %q: %v", project, c.Args[i+1], c.Args)
+				}
+			}
+		}
+	}
+}
+
+// The --attach / --no-attach override beats the spec's session.attach, which beats the default
+// (attach), and the dry-run plan shows the outcome.
+func TestApplyAttachPrecedence(t *testing.T) {
+	t.Setenv("TMUX", "")
+	t.Setenv("TMUX_TMPDIR", t.TempDir())
+	yes, no := true, false
+	tests := []struct {
+		name       string
+		spec, flag *bool
+		want       bool
+	}{
+		{"default", nil, nil, true},
+		{"spec off", &no, nil, false},
+		{"spec on", &yes, nil, true},
+		{"flag on beats spec off", &no, &yes, true},
+		{"flag off beats spec on", &yes, &no, false},
+		{"flag off, no spec value", nil, &no, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &spec.Spec{Version: 1, Session: spec.Session{Attach: tt.spec}, Windows: []spec.Window{{Name: "edit"}}}
+			rep, err := Apply(context.Background(), ApplyRequest{Spec: s, ProjectPath: t.TempDir(), SessionName: "att", Attach: tt.flag, DryRun: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rep.Attach != tt.want {
+				t.Errorf("Attach = %v, want %v", rep.Attach, tt.want)
+			}
+			last := rep.DryRunLines[len(rep.DryRunLines)-1]
+			if got := strings.HasPrefix(last, "tmux attach-session -t att"); got != tt.want {
+				t.Errorf("last dry-run line = %q, want attach: %v", last, tt.want)
+			}
+		})
+	}
+}
+
+// applyRunner is a tmux stand-in for Apply: no session exists yet, new-session prints
+// newSession, the live base-index is baseIndex, and every window is named "zsh".
+type applyRunner struct {
+	newSession string
+	baseIndex  string
+	runs       []string
+}
+
+func (r *applyRunner) Run(args []string) error {
+	r.runs = append(r.runs, strings.Join(args, " "))
+	if args[0] == "has-session" {
+		return errors.New("can't find session")
+	}
+	return nil
+}
+
+func (r *applyRunner) RunOutput(args []string) (string, error) {
+	r.runs = append(r.runs, strings.Join(args, " "))
+	switch args[0] {
+	case "new-session":
+		return r.newSession, nil
+	case "show-option":
+		return r.baseIndex, nil
+	case "display-message":
+		return "zsh", nil
+	}
+	return "", nil
+}
+
+// The default window is cleaned up where tmux created it, whatever session.base_index the spec
+// sets afterwards: by window id, or else at the live base-index.
+func TestApplyCleansDefaultWindow(t *testing.T) {
+	t.Setenv("TMUX", "")
+	tests := []struct {
+		name       string
+		specIndex  int
+		newSession string
+		live       string
+		want       string
+	}{
+		{"window id, spec base 1", 1, "@7\n", "0", "kill-window -t @7"},
+		{"window id, spec base 0", 0, "@7\n", "1", "kill-window -t @7"},
+		{"live base 0, spec base 1", 1, "", "0", "kill-window -t att:0"},
+		{"live base 1, spec base 0", 0, "", "1", "kill-window -t att:1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idx := tt.specIndex
+			no := false
+			s := &spec.Spec{Version: 1, Session: spec.Session{BaseIndex: &idx, Attach: &no}, Windows: []spec.Window{{Name: "edit"}}}
+			r := &applyRunner{newSession: tt.newSession, baseIndex: tt.live}
+			rep, err := Apply(context.Background(), ApplyRequest{Spec: s, ProjectPath: t.TempDir(), SessionName: "att", Runner: r})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var kills []string
+			for _, run := range r.runs {
+				if strings.HasPrefix(run, "kill-window") {
+					kills = append(kills, run)
+				}
+			}
+			if len(kills) != 1 || kills[0] != tt.want || rep.CleanedWindow != "zsh" {
+				t.Errorf("kills = %q, cleaned %q; want %q\n%s", kills, rep.CleanedWindow, tt.want, strings.Join(r.runs, "\n"))
+			}
+		})
+	}
+}
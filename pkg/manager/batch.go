@@ -0,0 +1,202 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"tmux-session-manager/pkg/spec"
+)
+
+// ResolveProjectSpec finds project under roots the way --project does: the first root with a
+// <root>/<project>/<spec name> file. It returns the spec path and the project directory.
+func ResolveProjectSpec(roots, specNames []string, project string) (specPath, dir string, ok bool) {
+	project = strings.TrimSpace(project)
+	if project == "" {
+		return "", "", false
+	}
+	for _, r := range roots {
+		d := filepath.Join(expandHome(r), project)
+		if p := findProjectSpec(d, specNames); p != "" {
+			return p, d, true
+		}
+	}
+	return "", "", false
+}
+
+// ResolveProjects resolves --foreach-project patterns: project names, or globs (e.g. "api-*")
+// matched against the directories directly under each root. A name resolves to its first root that
+// has a spec for it, else the first root that has the directory; the first root also wins when a
+// glob matches the same name in several roots. Patterns that match nothing are returned in missing.
+func ResolveProjects(roots, specNames, patterns []string) (projects []ProjectInfo, missing []string) {
+	seen := map[string]bool{}
+	add := func(name, dir string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		info := ProjectInfo{Name: name, Path: dir}
+		if p := findProjectSpec(dir, specNames); p != "" {
+			info.HasSpec = true
+			info.SpecPath = p
+		}
+		projects = append(projects, info)
+	}
+
+	for _, pat := range patterns {
+		pat = strings.TrimSpace(pat)
+		if pat == "" {
+			continue
+		}
+		found := false
+		if strings.ContainsAny(pat, "*?[") {
+			for _, r := range roots {
+				matches, err := filepath.Glob(filepath.Join(expandHome(r), pat))
+				if err != nil {
+					break // malformed pattern: the same for every root
+				}
+				for _, m := range matches {
+					if st, err := os.Stat(m); err == nil && st.IsDir() {
+						add(filepath.Base(m), m)
+						found = true
+					}
+				}
+			}
+		} else if _, dir, ok := ResolveProjectSpec(roots, specNames, pat); ok {
+			add(pat, dir)
+			found = true
+		} else {
+			for _, r := range roots {
+				d := filepath.Join(expandHome(r), pat)
+				if st, err := os.Stat(d); err == nil && st.IsDir() {
+					add(pat, d)
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			missing = append(missing, pat)
+		}
+	}
+	return projects, missing
+}
+
+// BatchRequest pre-creates sessions for several projects (ApplyProjects).
+type BatchRequest struct {
+	Projects []ProjectInfo
+
+	// Template is the built-in template for projects without a spec: "auto" (default; detect from
+	// project markers), "empty", "node", "python", "go", "rust".
+	Template string
+
+	// ContinueOnError keeps going after a failed project; otherwise the rest are not attempted.
+	ContinueOnError bool
+
+	// Apply carries the shared settings (policy, socket, timeouts, DryRun, ReplaceSession). Its
+	// per-project fields (SpecPath, Spec, ProjectPath, ProjectName, SessionName) are ignored, and
+	// nothing is attached or switched to.
+	Apply ApplyRequest
+}
+
+// BatchResult is the outcome for one project of ApplyProjects.
+type BatchResult struct {
+	Project     ProjectInfo
+	SessionName string
+
+	// Source is how the session was built: "spec", "template <name>", or "exists" when it was
+	// already running (left untouched unless Apply.ReplaceSession).
+	Source string
+
+	Err error
+}
+
+// ApplyProjects creates a detached session for each project, from its spec or else from the
+// template, without attaching or switching. Running sessions are skipped. Results are in project
+// order; with ContinueOnError false they stop after the first failure.
+func ApplyProjects(ctx context.Context, req BatchRequest) []BatchResult {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	tuiTmux.Socket = req.Apply.Socket // template sessions go through the TUI helpers
+	tuiTmux.Log = req.Apply.Log
+
+	out := make([]BatchResult, 0, len(req.Projects))
+	for _, p := range req.Projects {
+		if err := ctx.Err(); err != nil {
+			break
+		}
+		res := applyBatchProject(ctx, p, req)
+		out = append(out, res)
+		if res.Err != nil && !req.ContinueOnError {
+			break
+		}
+	}
+	return out
+}
+
+func applyBatchProject(ctx context.Context, p ProjectInfo, req BatchRequest) BatchResult {
+	res := BatchResult{Project: p}
+
+	var s *spec.Spec
+	if p.SpecPath != "" {
+		loaded, err := spec.LoadFile(p.SpecPath)
+		if err != nil {
+			res.Err = fmt.Errorf("load spec: %w", err)
+			return res
+		}
+		s = loaded
+	}
+	res.SessionName = resolveApplySessionName(s, "", p.Name, p.Path)
+
+	exists, _ := SessionExists(res.SessionName, SessionOptions{Socket: req.Apply.Socket, Log: req.Apply.Log})
+	if exists && !(s != nil && req.Apply.ReplaceSession) {
+		res.Source = "exists"
+		return res
+	}
+
+	if s != nil {
+		ar := req.Apply
+		ar.SpecPath, ar.Spec = p.SpecPath, s
+		ar.ProjectPath, ar.ProjectName, ar.SessionName = p.Path, p.Name, ""
+		noAttach := false
+		ar.Attach = &noAttach
+		rep, err := Apply(ctx, ar)
+		res.Source = "spec"
+		if rep.SessionName != "" {
+			res.SessionName = rep.SessionName
+		}
+		res.Err = err
+		return res
+	}
+
+	tpl := detectTemplate(p.Path)
+	if t := strings.ToLower(strings.TrimSpace(req.Template)); t != "" && t != "auto" {
+		tpl = parseTemplate(t)
+	}
+	res.Source = "template " + tpl.String()
+	if req.Apply.DryRun {
+		return res
+	}
+	if err := tmuxNewSessionDetached(res.SessionName, p.Path); err != nil {
+		res.Err = fmt.Errorf("create session: %w", err)
+		return res
+	}
+	if err := applyTemplate(res.SessionName, p.Path, tpl); err != nil {
+		res.Err = fmt.Errorf("template: %w", err)
+	}
+	return res
+}
+
+// BatchFailed counts the failed results.
+func BatchFailed(results []BatchResult) int {
+	n := 0
+	for _, r := range results {
+		if r.Err != nil {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,121 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveProjects(t *testing.T) {
+	specName := ".tmux-session.yaml"
+	a, b := t.TempDir(), t.TempDir()
+	mkProject(t, a, "api", "go.mod")
+	mkProject(t, b, "api", specName)
+	mkProject(t, a, "web-app", "package.json")
+	mkProject(t, b, "web-admin", specName)
+	mkProject(t, b, "web-app", specName)
+	if err := os.WriteFile(filepath.Join(a, "web-notes.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// summary renders each project as name@root[+spec].
+	summary := func(ps []ProjectInfo) string {
+		var out []string
+		for _, p := range ps {
+			root := "a"
+			if filepath.Dir(p.Path) == b {
+				root = "b"
+			}
+			s := p.Name + "@" + root
+			if p.HasSpec {
+				s += "+spec"
+			}
+			out = append(out, s)
+		}
+		return strings.Join(out, ",")
+	}
+	tests := []struct {
+		name     string
+		patterns []string
+		want     string
+		missing  string
+	}{
+		{"name prefers the root with a spec", []string{"api"}, "api@b+spec", ""},
+		{"glob over roots, first root wins, files skipped", []string{"web-*"}, "web-app@a,web-admin@b+spec", ""},
+		{"duplicates and blanks dropped", []string{" api ", "", "api", "web-a*"}, "api@b+spec,web-app@a,web-admin@b+spec", ""},
+		{"missing names and globs", []string{"nope", "x-*", "api"}, "api@b+spec", "nope,x-*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, missing := ResolveProjects([]string{a, b}, []string{specName}, tt.patterns)
+			if s := summary(got); s != tt.want {
+				t.Errorf("projects = %s, want %s", s, tt.want)
+			}
+			if m := strings.Join(missing, ","); m != tt.missing {
+				t.Errorf("missing = %s, want %s", m, tt.missing)
+			}
+		})
+	}
+}
+
+// ApplyProjects reports one result per attempted project: running sessions as "exists", specs and
+// templates by source, failures with their error; without ContinueOnError it stops at the first.
+func TestApplyProjects(t *testing.T) {
+	// A tmux on PATH where only session "running" exists.
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "tmux"), []byte("#!/bin/sh\n[ \"$1 $3\" = \"has-session =running\" ]\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+	fakeTuiTmux(t, "exit 1\n")
+	t.Setenv("TMUX", "")
+
+	root := t.TempDir()
+	mkProject(t, root, "running", "go.mod")
+	mkProject(t, root, "good", ".tmux-session.yaml")
+	writeSpec(t, filepath.Join(root, "good"), "version: 1\nwindows:\n  - name: edit\n")
+	mkProject(t, root, "bad", ".tmux-session.yaml")
+	writeSpec(t, filepath.Join(root, "bad"), "version: 1\nwindows: [\n")
+	mkProject(t, root, "plain", "go.mod")
+	projects, missing := ResolveProjects([]string{root}, []string{".tmux-session.yaml"}, []string{"running", "good", "bad", "plain"})
+	if len(missing) != 0 {
+		t.Fatalf("missing %v", missing)
+	}
+
+	render := func(rs []BatchResult) string {
+		var out []string
+		for _, r := range rs {
+			if r.Err != nil {
+				out = append(out, r.Project.Name+":FAIL")
+				continue
+			}
+			out = append(out, fmt.Sprintf("%s:%s:%s", r.Project.Name, r.SessionName, r.Source))
+		}
+		return strings.Join(out, ",")
+	}
+	for _, tt := range []struct {
+		cont   bool
+		want   string
+		failed int
+	}{
+		{false, "running:running:exists,good:good:spec,bad:FAIL", 1},
+		{true, "running:running:exists,good:good:spec,bad:FAIL,plain:plain:template go", 1},
+	} {
+		req := BatchRequest{Projects: projects, ContinueOnError: tt.cont, Apply: ApplyRequest{DryRun: true}}
+		rs := ApplyProjects(context.Background(), req)
+		if got := render(rs); got != tt.want {
+			t.Errorf("continue %v: results = %s, want %s", tt.cont, got, tt.want)
+		}
+		if n := BatchFailed(rs); n != tt.failed {
+			t.Errorf("continue %v: BatchFailed = %d, want %d", tt.cont, n, tt.failed)
+		}
+	}
+
+	rs := ApplyProjects(context.Background(), BatchRequest{Projects: projects[3:], Template: "rust", Apply: ApplyRequest{DryRun: true}})
+	if got := render(rs); got != "plain:plain:template rust" {
+		t.Errorf("--template rust: results = %s", got)
+	}
+}
@@ -0,0 +1,86 @@
+package manager
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// debugLogMaxBytes caps debug.log; past it the file is moved to debug.log.1 (replacing the previous
+// one) and a fresh file is started.
+const debugLogMaxBytes = 4 << 20
+
+// DebugLogPath is ~/.cache/tmux-session-manager/debug.log ($XDG_CACHE_HOME when set).
+func DebugLogPath() (string, error) {
+	return cacheFilePath("debug.log")
+}
+
+// OpenDebugLog opens the TMUX_SESSION_MANAGER_DEBUG trace at path: logfmt lines (time, level, msg,
+// key=value attributes, and this process's pid) at debug level. Set it as UIOptions.Log /
+// ApplyRequest.Log / SessionOptions.Log; a nil logger disables tracing everywhere.
+func OpenDebugLog(path string) (*slog.Logger, error) {
+	w, err := openCappedFile(path, debugLogMaxBytes)
+	if err != nil {
+		return nil, fmt.Errorf("debug log: %w", err)
+	}
+	h := slog.NewTextHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug})
+	return slog.New(h).With("pid", os.Getpid()), nil
+}
+
+// logDebug writes a trace line to l; a nil l (tracing off) does nothing.
+func logDebug(l *slog.Logger, msg string, args ...any) {
+	if l != nil {
+		l.Debug(msg, args...)
+	}
+}
+
+// cappedFile is an append-only file that rotates to <path>.1 once a write would take it past max.
+// Several processes may append to the same file (the launcher's TUI, then an apply); the size is
+// tracked per process, so the cap is approximate.
+type cappedFile struct {
+	mu   sync.Mutex
+	path string
+	max  int64
+	f    *os.File
+	size int64
+}
+
+func openCappedFile(path string, max int64) (*cappedFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	c := &cappedFile{path: path, max: max}
+	if err := c.open(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *cappedFile) open() error {
+	f, err := os.OpenFile(c.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	c.f, c.size = f, 0
+	if st, err := f.Stat(); err == nil {
+		c.size = st.Size()
+	}
+	return nil
+}
+
+func (c *cappedFile) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size > 0 && c.size+int64(len(p)) > c.max {
+		c.f.Close()
+		_ = os.Rename(c.path, c.path+".1")
+		if err := c.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := c.f.Write(p)
+	c.size += int64(n)
+	return n, err
+}
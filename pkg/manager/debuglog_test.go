@@ -0,0 +1,70 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenDebugLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache", "debug.log")
+	l, err := OpenDebugLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logDebug(l, "tmux", "args", "list-sessions -F x", "exit", 0)
+	logDebug(l, "key", "key", "ctrl+d")
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("%d lines, want 2:\n%s", len(lines), b)
+	}
+	pid := fmt.Sprintf("pid=%d", os.Getpid())
+	for i, want := range []string{"msg=tmux " + pid + ` args="list-sessions -F x" exit=0`, "msg=key " + pid + " key=ctrl+d"} {
+		if !strings.HasPrefix(lines[i], "time=") || !strings.Contains(lines[i], "level=DEBUG "+want) {
+			t.Errorf("line %d = %q, want time=... level=DEBUG %s", i, lines[i], want)
+		}
+	}
+}
+
+// A nil logger is tracing off: logDebug does nothing, and Tmux runs without one.
+func TestDebugLogDisabled(t *testing.T) {
+	logDebug(nil, "tmux", "args", "x")
+
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "tmux")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\necho ok\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	out, err := (&Tmux{Bin: bin}).Output("list-sessions")
+	if err != nil || strings.TrimSpace(out) != "ok" {
+		t.Fatalf("Output = %q, %v", out, err)
+	}
+	if ents, _ := os.ReadDir(dir); len(ents) != 1 {
+		t.Errorf("files written with tracing off: %v", ents)
+	}
+}
+
+func TestCappedFileRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "debug.log")
+	c, err := openCappedFile(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"aaaaaa\n", "bbbbbb\n", "cc\n"} {
+		if _, err := c.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cur, _ := os.ReadFile(path)
+	old, _ := os.ReadFile(path + ".1")
+	if string(cur) != "bbbbbb\ncc\n" || string(old) != "aaaaaa\n" {
+		t.Errorf("debug.log = %q, debug.log.1 = %q", cur, old)
+	}
+}
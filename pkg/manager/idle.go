@@ -0,0 +1,96 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"tmux-session-manager/pkg/templates"
+)
+
+// idleShells are the pane commands that count as a bare shell for sessionIsIdle.
+var idleShells = map[string]bool{
+	"sh": true, "bash": true, "zsh": true, "fish": true, "dash": true, "ksh": true, "mksh": true,
+	"tcsh": true, "csh": true, "nu": true, "elvish": true, "xonsh": true,
+}
+
+// idlePane is one pane as sessionIsIdle sees it: #{pane_current_command} and #{pane_current_path}.
+type idlePane struct {
+	Command string
+	Path    string
+}
+
+// parseIdlePanes parses `list-panes -F "#{pane_current_command}|#{pane_current_path}"` output.
+func parseIdlePanes(out string) []idlePane {
+	var panes []idlePane
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		cmd, path, _ := strings.Cut(line, "|")
+		panes = append(panes, idlePane{Command: strings.TrimSpace(cmd), Path: strings.TrimSpace(path)})
+	}
+	return panes
+}
+
+// panesIdle reports whether every pane is a bare shell (login shells show as "-zsh") sitting in
+// home. No panes, or a pane whose path tmux can't report, is not idle.
+func panesIdle(panes []idlePane, home string) bool {
+	if len(panes) == 0 || strings.TrimSpace(home) == "" {
+		return false
+	}
+	home = filepath.Clean(home)
+	for _, p := range panes {
+		if !idleShells[filepath.Base(strings.TrimPrefix(p.Command, "-"))] {
+			return false
+		}
+		if p.Path == "" || filepath.Clean(p.Path) != home {
+			return false
+		}
+	}
+	return true
+}
+
+// sessionIsIdle reports whether every pane of session name (all windows) is a bare shell at home.
+func sessionIsIdle(r templates.Runner, name, home string) (bool, error) {
+	out, err := r.RunOutput([]string{"list-panes", "-s", "-t", "=" + name, "-F", "#{pane_current_command}|#{pane_current_path}"})
+	if err != nil {
+		return false, fmt.Errorf("list panes of %q: %w", name, err)
+	}
+	return panesIdle(parseIdlePanes(out), home), nil
+}
+
+// IdleSessions returns the detached sessions that hold nothing but idle shells in the home
+// directory (see sessionIsIdle), in tmux's order. Attached sessions, including the caller's own,
+// are never returned.
+func IdleSessions(opts SessionOptions) ([]string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	r := opts.runner()
+	out, err := r.RunOutput([]string{"list-sessions", "-F", "#{session_name}|#{session_attached}"})
+	if err != nil {
+		return nil, fmt.Errorf("list sessions: %w", err)
+	}
+
+	var idle []string
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimRight(line, "\r")
+		i := strings.LastIndex(line, "|")
+		if i < 0 {
+			continue
+		}
+		name, attached := line[:i], line[i+1:]
+		if name == "" || (attached != "" && attached != "0") {
+			continue
+		}
+		// A session that went away meanwhile (list-panes fails) isn't reported.
+		if yes, err := sessionIsIdle(r, name, home); err == nil && yes {
+			idle = append(idle, name)
+		}
+	}
+	return idle, nil
+}
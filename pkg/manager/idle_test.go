@@ -0,0 +1,78 @@
+package manager
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// listingRunner answers list-sessions with sessions and list-panes -t =<name> with panes[name]
+// (an error when the session has no listing).
+type listingRunner struct {
+	sessions string
+	panes    map[string]string
+}
+
+func (r listingRunner) Run(args []string) error { return nil }
+
+func (r listingRunner) RunOutput(args []string) (string, error) {
+	switch args[0] {
+	case "list-sessions":
+		return r.sessions, nil
+	case "list-panes":
+		for i, a := range args {
+			if a == "-t" && i+1 < len(args) {
+				if out, ok := r.panes[strings.TrimPrefix(args[i+1], "=")]; ok {
+					return out, nil
+				}
+			}
+		}
+		return "", errors.New("can't find session")
+	}
+	return "", errors.New("unexpected " + args[0])
+}
+
+func TestPanesIdle(t *testing.T) {
+	const home = "/home/u"
+	tests := []struct {
+		name    string
+		listing string
+		want    bool
+	}{
+		{"one shell at home", "zsh|/home/u\n", true},
+		{"login shells, trailing slash", "-bash|/home/u/\nfish|/home/u\n", true},
+		{"shell elsewhere", "zsh|/home/u\nzsh|/home/u/code\n", false},
+		{"program running", "zsh|/home/u\nnvim|/home/u\n", false},
+		{"unknown path", "zsh|\n", false},
+		{"no panes", "", false},
+	}
+	for _, tt := range tests {
+		if got := panesIdle(parseIdlePanes(tt.listing), home); got != tt.want {
+			t.Errorf("%s: panesIdle = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIdleSessions(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip(err)
+	}
+	r := listingRunner{
+		sessions: "idle|0\nbusy|0\nattached|1\nweird|name|0\ngone|0\n",
+		panes: map[string]string{
+			"idle":       "zsh|" + home + "\n-zsh|" + home + "\n",
+			"busy":       "zsh|" + home + "\nnvim|" + home + "\n",
+			"attached":   "zsh|" + home + "\n",
+			"weird|name": "bash|" + home + "\n",
+		},
+	}
+	got, err := IdleSessions(SessionOptions{Runner: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.Join(got, ","); s != "idle,weird|name" {
+		t.Errorf("IdleSessions = %s, want idle,weird|name", s)
+	}
+}
@@ -0,0 +1,77 @@
+package manager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ProjectInfo is a discovered project as listed by --list-projects.
+type ProjectInfo struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	HasSpec  bool   `json:"has_spec"`
+	SpecPath string `json:"spec_path,omitempty"`
+}
+
+// SessionInfo is a tmux session as listed by --list-sessions.
+type SessionInfo struct {
+	Name     string `json:"name"`
+	Windows  int    `json:"windows"`
+	Attached bool   `json:"attached"`
+}
+
+// ListProjects scans for projects exactly like the TUI's projects tab (roots, depth and ignored
+// directories from opts) and reports whether each has a project-local spec (opts.ProjectSpecNames).
+func ListProjects(ctx context.Context, opts UIOptions) []ProjectInfo {
+	roots, depth := projectScanRoots(opts)
+	items := scanProjects(ctx, roots, depth, ignoreDirSet(opts.IgnoreDirNames), markerSet(opts.ProjectMarkers))
+
+	out := make([]ProjectInfo, 0, len(items))
+	for _, it := range items {
+		info := ProjectInfo{Name: it.Name, Path: it.Path}
+		if p := findProjectSpec(it.Path, opts.ProjectSpecNames); p != "" {
+			info.HasSpec = true
+			info.SpecPath = p
+		}
+		out = append(out, info)
+	}
+	return out
+}
+
+// ListSessions lists tmux sessions like the TUI's sessions tab (on the server selected by
+// opts.Socket), sorted by name. No reachable tmux server yields an empty list and no error.
+func ListSessions(opts UIOptions) ([]SessionInfo, error) {
+	tuiTmux.Socket = uiSocket(opts)
+	items, err := tmuxListSessions()
+	if err != nil {
+		if !tuiTmux.ServerReachable() {
+			return nil, nil
+		}
+		return nil, err
+	}
+	out := make([]SessionInfo, 0, len(items))
+	for _, it := range items {
+		out = append(out, SessionInfo{Name: it.Name, Windows: it.Windows, Attached: it.Attached})
+	}
+	return out, nil
+}
+
+// findProjectSpec returns the first project-local spec file in dir (stat only; not parsed).
+func findProjectSpec(dir string, names []string) string {
+	if len(names) == 0 {
+		names = []string{".tmux-session.yaml", ".tmux-session.yml", ".tmux-session.json"}
+	}
+	for _, n := range names {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			continue
+		}
+		p := filepath.Join(dir, n)
+		if st, err := os.Stat(p); err == nil && !st.IsDir() {
+			return p
+		}
+	}
+	return ""
+}
@@ -0,0 +1,149 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxMRUEntries caps the recent-sessions file; older entries are dropped.
+const maxMRUEntries = 50
+
+// mruLockWait is how long recordMRU waits for another writer's lock, and mruLockStale is the age
+// after which a left-over lock file (crashed writer) is taken over.
+const (
+	mruLockWait  = 500 * time.Millisecond
+	mruLockStale = 5 * time.Second
+)
+
+// mruFile is the on-disk form of the recent-sessions list (mruPath), most recent first.
+type mruFile struct {
+	Sessions []mruEntry `json:"sessions"`
+}
+
+type mruEntry struct {
+	Name string    `json:"name"`
+	At   time.Time `json:"at"`
+}
+
+// mruPath is ~/.cache/tmux-session-manager/mru.json ($XDG_CACHE_HOME when set).
+func mruPath() (string, error) {
+	return cacheFilePath("mru.json")
+}
+
+// loadMRU returns the recorded session names at path, most recent first. A missing or unreadable
+// file is an empty history.
+func loadMRU(path string) []string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var f mruFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil
+	}
+	out := make([]string, 0, len(f.Sessions))
+	for _, e := range f.Sessions {
+		if e.Name != "" {
+			out = append(out, e.Name)
+		}
+	}
+	return out
+}
+
+// mruRanks maps each recorded session name to its recency (0 = most recent).
+func mruRanks(names []string) map[string]int {
+	ranks := make(map[string]int, len(names))
+	for i, n := range names {
+		if _, ok := ranks[n]; !ok {
+			ranks[n] = i
+		}
+	}
+	return ranks
+}
+
+// updateMRU moves name to the front of entries (deduplicated) and caps the list at limit.
+func updateMRU(entries []mruEntry, name string, now time.Time, limit int) []mruEntry {
+	out := make([]mruEntry, 0, len(entries)+1)
+	out = append(out, mruEntry{Name: name, At: now})
+	for _, e := range entries {
+		if e.Name == "" || e.Name == name {
+			continue
+		}
+		out = append(out, e)
+	}
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// recordMRU marks name as the most recently used session in the file at path. Writers serialize on
+// a lock file next to it and replace the file via a temp file, so concurrent TUIs neither lose
+// each other's updates (within mruLockWait) nor leave a partial file.
+func recordMRU(path, name string, now time.Time) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("mru: %w", err)
+	}
+	unlock, err := lockMRU(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var f mruFile
+	if b, err := os.ReadFile(path); err == nil {
+		_ = json.Unmarshal(b, &f) // a corrupt file starts a fresh history
+	}
+	f.Sessions = updateMRU(f.Sessions, name, now, maxMRUEntries)
+
+	b, err := json.Marshal(f)
+	if err != nil {
+		return fmt.Errorf("mru: %w", err)
+	}
+	if err := writeFileAtomic(path, b); err != nil {
+		return fmt.Errorf("mru: %w", err)
+	}
+	return nil
+}
+
+// lockMRU takes the lock file at path (created exclusively), waiting up to mruLockWait for another
+// writer and taking over locks older than mruLockStale.
+func lockMRU(path string) (unlock func(), err error) {
+	deadline := time.Now().Add(mruLockWait)
+	for {
+		lf, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			lf.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("mru: %w", err)
+		}
+		if info, serr := os.Stat(path); serr == nil && time.Since(info.ModTime()) > mruLockStale {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("mru: locked by another writer")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// noteSessionUsed records a switch to name in the recent-sessions file (best-effort).
+func noteSessionUsed(name string) {
+	path, err := mruPath()
+	if err != nil {
+		return
+	}
+	_ = recordMRU(path, name, time.Now())
+}
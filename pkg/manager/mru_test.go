@@ -0,0 +1,61 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordMRU(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache", "mru.json")
+	now := time.Unix(1700000000, 0)
+	for i, name := range []string{"api", "web", "db", "api"} {
+		if err := recordMRU(path, name, now.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := strings.Join(loadMRU(path), ","); got != "api,db,web" {
+		t.Errorf("history = %s, want api,db,web", got)
+	}
+	if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("lock file left behind: %v", err)
+	}
+
+	for i := 0; i < maxMRUEntries+5; i++ {
+		if err := recordMRU(path, fmt.Sprintf("s%d", i), now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := loadMRU(path)
+	if len(names) != maxMRUEntries || names[0] != fmt.Sprintf("s%d", maxMRUEntries+4) {
+		t.Errorf("capped history has %d entries, first %q", len(names), names[0])
+	}
+}
+
+// With a recorded history, mru sorting puts recent sessions first and the rest after, by name.
+func TestSortSessionsMRU(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	path, err := mruPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	for i, name := range []string{"web", "api", "gone"} {
+		if err := recordMRU(path, name, now.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	items := []sessionItem{{Name: "zeta"}, {Name: "web"}, {Name: "alpha"}, {Name: "api"}}
+	sortSessions(items, "mru")
+	names := make([]string, len(items))
+	for i, it := range items {
+		names[i] = it.Name
+	}
+	if got := strings.Join(names, ","); got != "api,web,alpha,zeta" {
+		t.Errorf("mru order = %s, want api,web,alpha,zeta", got)
+	}
+}
@@ -0,0 +1,115 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"tmux-session-manager/pkg/spec"
+	"tmux-session-manager/pkg/templates"
+)
+
+// preflight performs read-only checks that catch specs which would compile fine but fail once
+// running: working directories (tmux -c) that do not exist, and `run` programs that are not
+// executable / not found in PATH. It returns one human-readable problem per finding.
+func preflight(s *spec.Spec, ctx templates.Context, compiled templates.Compiled) []string {
+	var problems []string
+	seen := map[string]bool{}
+
+	dirs := []string{ctx.ProjectPath}
+	for _, c := range compiled.Commands {
+		if len(c.Args) == 0 {
+			continue
+		}
+		switch c.Args[0] {
+		case "new-session", "new-window", "split-window":
+		default:
+			continue
+		}
+		for i := 1; i+1 < len(c.Args); i++ {
+			if c.Args[i] == "-c" {
+				dirs = append(dirs, c.Args[i+1])
+			}
+		}
+	}
+	for _, d := range dirs {
+		d = strings.TrimSpace(d)
+		if d == "" || seen["dir:"+d] {
+			continue
+		}
+		seen["dir:"+d] = true
+		st, err := os.Stat(d)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("directory %s does not exist", d))
+		case !st.IsDir():
+			problems = append(problems, fmt.Sprintf("directory %s is not a directory", d))
+		}
+	}
+
+	for _, a := range specActions(s) {
+		if a.Type != "run" || a.Run == nil || templates.WhenUnmet(ctx, a.When) != "" {
+			continue
+		}
+		prog := expandHome(strings.TrimSpace(templates.Expand(ctx, a.Run.Program)))
+		if prog == "" || seen["prog:"+prog] {
+			continue
+		}
+		seen["prog:"+prog] = true
+		if err := checkProgram(prog, ctx.ProjectPath); err != nil {
+			problems = append(problems, fmt.Sprintf("run program %s: %v", prog, err))
+		}
+	}
+	return problems
+}
+
+// checkProgram resolves prog like a shell would: names are looked up in PATH, paths (relative to
+// the project) must be executable files.
+func checkProgram(prog, projectPath string) error {
+	if !strings.ContainsRune(prog, '/') {
+		if _, err := exec.LookPath(prog); err != nil {
+			return errors.New("not found in PATH")
+		}
+		return nil
+	}
+	if !filepath.IsAbs(prog) {
+		prog = filepath.Join(projectPath, prog)
+	}
+	st, err := os.Stat(prog)
+	if err != nil {
+		return errors.New("does not exist")
+	}
+	if st.IsDir() || st.Mode()&0o111 == 0 {
+		return errors.New("is not executable")
+	}
+	return nil
+}
+
+// specActions lists every action in s: top-level, hooks, window actions/on_create, and pane actions
+// (for_each_dir entries contribute their window template's).
+func specActions(s *spec.Spec) []spec.Action {
+	out := append([]spec.Action(nil), s.Actions...)
+	if s.Hooks != nil {
+		out = append(out, s.Hooks.Pre...)
+		out = append(out, s.Hooks.Post...)
+	}
+	for _, w := range s.Windows {
+		if w.ForEachDir != nil {
+			w = w.ForEachDir.WindowTemplate
+		}
+		out = append(out, w.Actions...)
+		for _, p := range w.Panes {
+			out = append(out, p.Actions...)
+		}
+		for _, st := range w.PanePlan {
+			if st.Pane != nil {
+				out = append(out, st.Pane.Actions...)
+			}
+		}
+		out = append(out, w.OnCreate...)
+	}
+	return out
+}
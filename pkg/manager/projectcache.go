@@ -0,0 +1,183 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"tmux-session-manager/pkg/paths"
+)
+
+// defaultProjectCacheTTL is how long a cached project scan is served when UIOptions.ProjectCacheTTL
+// is unset.
+const defaultProjectCacheTTL = 10 * time.Minute
+
+// projectCache is the on-disk form of the last project scan (projectCachePath). Key identifies the
+// scan settings; RootMtimes invalidate it when a root gains or loses entries. Deeper changes are
+// only picked up after the TTL (or with R in the TUI).
+type projectCache struct {
+	Key        string           `json:"key"`
+	ScannedAt  time.Time        `json:"scanned_at"`
+	RootMtimes map[string]int64 `json:"root_mtimes"`
+	Projects   []cachedProject  `json:"projects"`
+}
+
+type cachedProject struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// projectCachePath is ~/.cache/tmux-session-manager/projects.json ($XDG_CACHE_HOME when set).
+func projectCachePath() (string, error) {
+	return cacheFilePath("projects.json")
+}
+
+// cacheFilePath is ~/.cache/tmux-session-manager/<name> ($XDG_CACHE_HOME when set).
+func cacheFilePath(name string) (string, error) {
+	p, err := paths.CachePath(name)
+	if err != nil {
+		return "", fmt.Errorf("cache: %w", err)
+	}
+	return p, nil
+}
+
+// writeFileAtomic replaces path with data (mode 0600) through a temp file in the same directory,
+// so a concurrent reader sees the old or the new file, never a partial one. The directory must
+// exist.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// projectCacheKey identifies a scan: the exact roots (expanded), depth, ignore set and extra
+// markers.
+func projectCacheKey(roots []string, depth int, ignore, markers map[string]bool) string {
+	sorted := func(set map[string]bool) string {
+		names := make([]string, 0, len(set))
+		for n := range set {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return strings.Join(names, "\x00")
+	}
+	expanded := make([]string, 0, len(roots))
+	for _, r := range roots {
+		expanded = append(expanded, expandHome(r))
+	}
+	return strings.Join(expanded, "\x00") + "\x01" + strconv.Itoa(depth) + "\x01" + sorted(ignore) + "\x01" + sorted(markers)
+}
+
+// rootMtimes records each existing root's mtime (UnixNano); missing roots are left out.
+func rootMtimes(roots []string) map[string]int64 {
+	out := make(map[string]int64, len(roots))
+	for _, r := range roots {
+		r = expandHome(r)
+		if info, err := os.Stat(r); err == nil && info.IsDir() {
+			out[r] = info.ModTime().UnixNano()
+		}
+	}
+	return out
+}
+
+// loadProjectCache returns the cached scan at path for key, if it is younger than ttl and no
+// root's mtime changed since.
+func loadProjectCache(path, key string, roots []string, ttl time.Duration, now time.Time) ([]projectItem, bool) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var c projectCache
+	if err := json.Unmarshal(b, &c); err != nil || c.Key != key {
+		return nil, false
+	}
+	if ttl <= 0 || now.Sub(c.ScannedAt) > ttl || now.Before(c.ScannedAt) {
+		return nil, false
+	}
+	cur := rootMtimes(roots)
+	if len(cur) != len(c.RootMtimes) {
+		return nil, false
+	}
+	for r, mt := range cur {
+		if c.RootMtimes[r] != mt {
+			return nil, false
+		}
+	}
+
+	out := make([]projectItem, 0, len(c.Projects))
+	for _, p := range c.Projects {
+		out = append(out, newProjectItem(p.Name, p.Path))
+	}
+	return out, true
+}
+
+// saveProjectCache writes a scan to path (atomically, see writeFileAtomic).
+func saveProjectCache(path, key string, roots []string, items []projectItem, now time.Time) error {
+	c := projectCache{
+		Key:        key,
+		ScannedAt:  now,
+		RootMtimes: rootMtimes(roots),
+		Projects:   make([]cachedProject, 0, len(items)),
+	}
+	for _, it := range items {
+		c.Projects = append(c.Projects, cachedProject{Name: it.Name, Path: it.Path})
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("project cache: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("project cache: %w", err)
+	}
+	if err := writeFileAtomic(path, b); err != nil {
+		return fmt.Errorf("project cache: %w", err)
+	}
+	return nil
+}
+
+// scanProjectsCached serves the project scan from the cache when useCache and it is valid, and
+// otherwise scans and refreshes the cache. ttl < 0 disables the cache entirely.
+func scanProjectsCached(ctx context.Context, roots []string, depth int, ignore, markers map[string]bool, ttl time.Duration, useCache bool) []projectItem {
+	if ttl < 0 {
+		return scanProjects(ctx, roots, depth, ignore, markers)
+	}
+	if ttl == 0 {
+		ttl = defaultProjectCacheTTL
+	}
+	path, err := projectCachePath()
+	if err != nil {
+		return scanProjects(ctx, roots, depth, ignore, markers)
+	}
+	key := projectCacheKey(roots, depth, ignore, markers)
+	if useCache {
+		if items, ok := loadProjectCache(path, key, roots, ttl, time.Now()); ok {
+			return items
+		}
+	}
+	items := scanProjects(ctx, roots, depth, ignore, markers)
+	if ctx.Err() == nil {
+		_ = saveProjectCache(path, key, roots, items, time.Now()) // best-effort
+	}
+	return items
+}
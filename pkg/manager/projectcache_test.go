@@ -0,0 +1,45 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProjectCache(t *testing.T) {
+	root := t.TempDir()
+	roots := []string{root}
+	path := filepath.Join(t.TempDir(), "projects.json")
+	key := projectCacheKey(roots, 2, map[string]bool{"node_modules": true}, nil)
+	items := []projectItem{newProjectItem("api", filepath.Join(root, "api"))}
+	now := time.Unix(1700000000, 0)
+	ttl := 10 * time.Minute
+
+	if err := saveProjectCache(path, key, roots, items, now); err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := loadProjectCache(path, key, roots, ttl, now.Add(time.Minute))
+	if !ok || len(got) != 1 || got[0].Name != "api" || got[0].Path != items[0].Path {
+		t.Fatalf("hit: %v, %v", got, ok)
+	}
+	if _, ok := loadProjectCache(path, projectCacheKey(roots, 3, nil, nil), roots, ttl, now); ok {
+		t.Error("served for a different depth/ignore key")
+	}
+	if _, ok := loadProjectCache(path, key, roots, ttl, now.Add(ttl+time.Second)); ok {
+		t.Error("served after the TTL expired")
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := info.ModTime().Add(time.Second)
+	if err := os.Chtimes(root, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loadProjectCache(path, key, roots, ttl, now.Add(time.Minute)); ok {
+		t.Error("served after the root's mtime changed")
+	}
+}
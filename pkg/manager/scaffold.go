@@ -0,0 +1,232 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"tmux-session-manager/pkg/spec"
+)
+
+// ErrSpecExists is returned by ScaffoldProjectSpec when the project already has a spec and
+// ScaffoldOptions.Force is not set.
+var ErrSpecExists = errors.New("spec already exists")
+
+// ScaffoldOptions controls starter spec generation.
+type ScaffoldOptions struct {
+	// SpecNames are the project-local spec filenames to consider. The first name is used when
+	// creating a new spec. If empty, defaults to the pkg/spec defaults.
+	SpecNames []string
+
+	// Template selects the built-in template used to seed commands:
+	// "auto" (default; detect from project markers), "empty", "node", "python", "go", "rust".
+	Template string
+
+	// Force allows overwriting an existing spec.
+	Force bool
+
+	// DryRun renders the spec without writing it.
+	DryRun bool
+}
+
+// ScaffoldResult describes a generated starter spec.
+type ScaffoldResult struct {
+	Path      string
+	Template  string
+	Content   string
+	Overwrote bool
+}
+
+// ScaffoldProjectSpec writes a starter project-local spec for projectDir.
+//
+// The spec is seeded from the same command table as the built-in templates (editor window +
+// a server/repl/test window based on the detected ecosystem), so a scaffolded spec behaves like
+// the template the TUI would have applied, but is now editable and versionable.
+//
+// An existing spec is never overwritten unless opt.Force is set.
+func ScaffoldProjectSpec(projectDir string, opt ScaffoldOptions) (ScaffoldResult, error) {
+	projectDir = strings.TrimSpace(expandHome(projectDir))
+	if projectDir == "" {
+		return ScaffoldResult{}, errors.New("scaffold: empty project dir")
+	}
+	if abs, err := filepath.Abs(projectDir); err == nil {
+		projectDir = abs
+	}
+	st, err := os.Stat(projectDir)
+	if err != nil {
+		return ScaffoldResult{}, fmt.Errorf("scaffold: %w", err)
+	}
+	if !st.IsDir() {
+		return ScaffoldResult{}, fmt.Errorf("scaffold: not a directory: %s", projectDir)
+	}
+
+	names := opt.SpecNames
+	if len(names) == 0 {
+		names = []string{".tmux-session.yaml", ".tmux-session.yml", ".tmux-session.json"}
+	}
+
+	// Find an existing spec (any candidate name).
+	existing := ""
+	for _, n := range names {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			continue
+		}
+		p := filepath.Join(projectDir, n)
+		if st, err := os.Stat(p); err == nil && !st.IsDir() {
+			existing = p
+			break
+		}
+	}
+	if existing != "" && !opt.Force {
+		return ScaffoldResult{}, fmt.Errorf("scaffold: %w: %s (use --force to overwrite)", ErrSpecExists, existing)
+	}
+
+	outPath := existing
+	if outPath == "" {
+		first := ""
+		for _, n := range names {
+			if strings.TrimSpace(n) != "" {
+				first = strings.TrimSpace(n)
+				break
+			}
+		}
+		if first == "" {
+			first = ".tmux-session.yaml"
+		}
+		outPath = filepath.Join(projectDir, first)
+	}
+	if ext := strings.ToLower(filepath.Ext(outPath)); ext != ".yaml" && ext != ".yml" {
+		return ScaffoldResult{}, fmt.Errorf("scaffold: only YAML specs can be generated (got %s)", filepath.Base(outPath))
+	}
+
+	tpl := tplEmpty
+	switch strings.ToLower(strings.TrimSpace(opt.Template)) {
+	case "", "auto":
+		tpl = detectTemplate(projectDir)
+	default:
+		tpl = parseTemplate(opt.Template)
+	}
+
+	projectName := filepath.Base(projectDir)
+	content := scaffoldSpecYAML(tpl, projectName, projectDir)
+
+	// Never write something we can't load back.
+	var check spec.Spec
+	if err := yaml.Unmarshal([]byte(content), &check); err != nil {
+		return ScaffoldResult{}, fmt.Errorf("scaffold: generated spec does not parse: %w", err)
+	}
+	if err := check.Validate(); err != nil {
+		return ScaffoldResult{}, fmt.Errorf("scaffold: generated spec is invalid: %w", err)
+	}
+
+	res := ScaffoldResult{
+		Path:      outPath,
+		Template:  tpl.String(),
+		Content:   content,
+		Overwrote: existing != "",
+	}
+	if opt.DryRun {
+		return res, nil
+	}
+	if err := os.WriteFile(outPath, []byte(content), 0o644); err != nil {
+		return ScaffoldResult{}, fmt.Errorf("scaffold: write: %w", err)
+	}
+	return res, nil
+}
+
+// detectTemplate picks a built-in template from project language markers.
+func detectTemplate(dir string) templateKind {
+	switch {
+	case fileExists(filepath.Join(dir, "go.mod")) || fileExists(filepath.Join(dir, "go.work")):
+		return tplGo
+	case fileExists(filepath.Join(dir, "package.json")):
+		return tplNode
+	case fileExists(filepath.Join(dir, "pyproject.toml")) || fileExists(filepath.Join(dir, "requirements.txt")):
+		return tplPython
+	case fileExists(filepath.Join(dir, "Cargo.toml")):
+		return tplRust
+	default:
+		return tplEmpty
+	}
+}
+
+// scaffoldSpecYAML renders a starter spec equivalent to the built-in template tpl.
+//
+// Layout:
+//   - "editor" window: editor pane + a shell pane side-by-side (pane_plan)
+//   - template window (server/repl/run) running the template command, if any
+//
+// Commands are emitted as structured `run` actions (safe; no shell required).
+func scaffoldSpecYAML(tpl templateKind, projectName, projectDir string) string {
+	var b strings.Builder
+	b.WriteString("# tmux-session-manager project spec (scaffolded; template: " + tpl.String() + ")\n")
+	b.WriteString("#\n")
+	b.WriteString("# This file is yours to edit. Windows are created in order; pane_plan splits are\n")
+	b.WriteString("# interpreted left-to-right. run actions type the command into the pane (safe).\n")
+	b.WriteString("version: 1\n")
+	b.WriteString("name: \"" + escapeYAMLString(projectName) + "\"\n")
+	b.WriteString("\n")
+	b.WriteString("session:\n")
+	b.WriteString("  root: \"${PROJECT_PATH}\"\n")
+	b.WriteString("  focus_window: \"editor\"\n")
+	b.WriteString("\n")
+	b.WriteString("windows:\n")
+
+	editor := strings.Fields(strings.TrimSpace(os.Getenv("TMUX_SESSION_MANAGER_EDITOR_CMD")))
+	if len(editor) == 0 {
+		editor = []string{"nvim", "."}
+	}
+
+	b.WriteString("  - name: \"editor\"\n")
+	b.WriteString("    pane_plan:\n")
+	b.WriteString("      - pane:\n")
+	b.WriteString("          name: \"editor\"\n")
+	b.WriteString("          actions:\n")
+	writeScaffoldRun(&b, "            ", editor)
+	b.WriteString("      - split:\n")
+	b.WriteString("          direction: \"h\"\n")
+	b.WriteString("          size: \"40%\"\n")
+	b.WriteString("      - pane:\n")
+	b.WriteString("          name: \"shell\"\n")
+
+	if winName, cmd := templateWindowCommand(tpl, projectDir); winName != "" {
+		b.WriteString("\n")
+		b.WriteString("  - name: \"" + escapeYAMLString(winName) + "\"\n")
+		b.WriteString("    panes:\n")
+		b.WriteString("      - name: \"" + escapeYAMLString(winName) + "\"\n")
+		if argv := strings.Fields(cmd); len(argv) > 0 {
+			b.WriteString("        actions:\n")
+			writeScaffoldRun(&b, "          ", argv)
+		}
+	}
+
+	return b.String()
+}
+
+func writeScaffoldRun(b *strings.Builder, indent string, argv []string) {
+	b.WriteString(indent + "- type: run\n")
+	b.WriteString(indent + "  run:\n")
+	b.WriteString(indent + "    program: \"" + escapeYAMLString(argv[0]) + "\"\n")
+	if len(argv) > 1 {
+		quoted := make([]string, 0, len(argv)-1)
+		for _, a := range argv[1:] {
+			quoted = append(quoted, "\""+escapeYAMLString(a)+"\"")
+		}
+		b.WriteString(indent + "    args: [" + strings.Join(quoted, ", ") + "]\n")
+	}
+}
+
+func escapeYAMLString(s string) string {
+	// Minimal escape for double-quoted YAML scalars.
+	// Replace backslash and double quote; normalize newlines to spaces.
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	s = strings.ReplaceAll(s, "\"", "\\\"")
+	s = strings.ReplaceAll(s, "\r", "")
+	s = strings.ReplaceAll(s, "\n", " ")
+	return s
+}
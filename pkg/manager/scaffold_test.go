@@ -0,0 +1,142 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tmux-session-manager/pkg/spec"
+	"tmux-session-manager/pkg/templates"
+)
+
+// Every template scaffolds a spec that loads back through spec.LoadFile, with the template's
+// window and command as structured run actions.
+func TestScaffoldSpecYAMLLoads(t *testing.T) {
+	t.Setenv("TMUX_SESSION_MANAGER_EDITOR_CMD", "")
+	dir := t.TempDir()
+	for tpl := templateKind(0); tpl < templateKindCount; tpl++ {
+		t.Run(tpl.String(), func(t *testing.T) {
+			name := `my "app" \ ` + tpl.String()
+			path := filepath.Join(dir, tpl.String()+".yaml")
+			if err := os.WriteFile(path, []byte(scaffoldSpecYAML(tpl, name, dir)), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			s, err := spec.LoadFile(path)
+			if err != nil {
+				t.Fatalf("LoadFile: %v", err)
+			}
+			if s.Name != name {
+				t.Errorf("name = %q, want %q", s.Name, name)
+			}
+			if len(s.Windows) == 0 || s.Windows[0].Name != "editor" || len(s.Windows[0].PanePlan) != 3 {
+				t.Fatalf("editor window = %+v", s.Windows)
+			}
+			if run := s.Windows[0].PanePlan[0].Pane.Actions[0].Run; run == nil || run.Program != "nvim" {
+				t.Errorf("editor run = %+v", run)
+			}
+
+			winName, cmd := templateWindowCommand(tpl, dir)
+			if winName == "" {
+				if len(s.Windows) != 1 {
+					t.Errorf("%d windows, want only editor", len(s.Windows))
+				}
+				return
+			}
+			if len(s.Windows) != 2 || s.Windows[1].Name != winName {
+				t.Fatalf("windows = %+v, want editor and %s", s.Windows, winName)
+			}
+			panes := s.Windows[1].Panes
+			if cmd == "" {
+				if len(panes) != 1 || len(panes[0].Actions) != 0 {
+					t.Errorf("%s panes = %+v, want one pane with no actions", winName, panes)
+				}
+				return
+			}
+			if len(panes) != 1 || len(panes[0].Actions) != 1 || panes[0].Actions[0].Run == nil {
+				t.Fatalf("%s panes = %+v", winName, panes)
+			}
+			run := panes[0].Actions[0].Run
+			if got := strings.Join(append([]string{run.Program}, run.Args...), " "); got != cmd {
+				t.Errorf("%s command = %q, want %q", winName, got, cmd)
+			}
+		})
+	}
+}
+
+// Cargo.toml marks a Rust project, and its template runs cargo (cargo watch when installed).
+func TestRustTemplate(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "Cargo.toml"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isProjectDir(dir, ents, nil) {
+		t.Error("Cargo.toml dir not a project")
+	}
+	if got := detectTemplate(dir); got != tplRust {
+		t.Errorf("detectTemplate = %s, want rust", got)
+	}
+	for _, s := range []string{"rust", "RS"} {
+		if got := parseTemplate(s); got != tplRust {
+			t.Errorf("parseTemplate(%q) = %s", s, got)
+		}
+	}
+
+	bin := t.TempDir()
+	t.Setenv("PATH", bin)
+	plan := renderHardcodedTemplatePlan("crate", dir, tplRust)
+	if !strings.Contains(plan, "-n run") || !strings.Contains(plan, "'cargo run'") {
+		t.Errorf("plan without cargo-watch:\n%s", plan)
+	}
+	if err := os.WriteFile(filepath.Join(bin, "cargo-watch"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if plan := renderHardcodedTemplatePlan("crate", dir, tplRust); !strings.Contains(plan, "'cargo watch -x run'") {
+		t.Errorf("plan with cargo-watch:\n%s", plan)
+	}
+}
+
+// applyTemplate runs exactly the commands the dry-run plan shows.
+func TestApplyTemplateMatchesPlan(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"package.json", "pnpm-lock.yaml"} {
+		if err := os.WriteFile(filepath.Join(dir, f), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	log := filepath.Join(t.TempDir(), "tmux.log")
+	fakeTuiTmux(t, `printf '%s\037' "$@" >> '`+log+"'\necho >> '"+log+"'\n")
+
+	if err := applyTemplate("web", dir, tplNode); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ran []string
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		ran = append(ran, strings.Join(strings.Split(strings.TrimSuffix(line, "\x1f"), "\x1f"), " "))
+	}
+
+	compiled, err := compileTemplate(templates.NewEngine(), "web", dir, tplNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var planned []string
+	for _, c := range compiled.Commands {
+		if len(c.Args) > 0 {
+			planned = append(planned, strings.Join(c.Args, " "))
+		}
+	}
+	if strings.Join(ran, "\n") != strings.Join(planned, "\n") {
+		t.Errorf("executed:\n%s\nplanned:\n%s", strings.Join(ran, "\n"), strings.Join(planned, "\n"))
+	}
+	if !strings.Contains(strings.Join(planned, "\n"), "send-keys -t web:server pnpm dev") {
+		t.Errorf("node plan doesn't start the dev server:\n%s", strings.Join(planned, "\n"))
+	}
+}
@@ -0,0 +1,120 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"tmux-session-manager/pkg/spec"
+)
+
+// SelectionKind identifies what a Selection opens.
+type SelectionKind string
+
+const (
+	SelectSession    SelectionKind = "session"     // switch to an existing session
+	SelectNewSession SelectionKind = "new_session" // create an empty session named from the query, then switch
+	SelectProject    SelectionKind = "project"     // open a project: switch, or create from spec/template first
+)
+
+// Selection is what the user picked in DetachUI mode. The zero value means nothing was picked
+// (the UI was quit).
+type Selection struct {
+	Kind        SelectionKind
+	SessionName string
+
+	// ProjectName / ProjectPath / Template are set for SelectProject.
+	ProjectName string
+	ProjectPath string
+	Template    string
+}
+
+// Empty reports whether nothing was picked.
+func (s Selection) Empty() bool {
+	return s.Kind == ""
+}
+
+// OpenSelection performs a Selection returned by RunTUISelect, the way the UI would have from
+// inside its loop. opts should be the UIOptions the UI ran with (spec names, policy gates).
+// The returned note reports non-fatal spec/template problems.
+func OpenSelection(sel Selection, opts UIOptions) (note string, err error) {
+	opts.Socket = uiSocket(opts)
+	tuiTmux.Socket = opts.Socket // also when called without RunTUISelect (--open)
+	tuiTmux.Log = opts.Log
+	name := strings.TrimSpace(sel.SessionName)
+	if name == "" && !sel.Empty() {
+		return "", errors.New("selection has no session name")
+	}
+
+	switch sel.Kind {
+	case "":
+		return "", nil
+	case SelectSession:
+		if err := tmuxSwitchClient(name); err != nil {
+			return "", fmt.Errorf("switch failed: %w", err)
+		}
+		return "", nil
+	case SelectNewSession:
+		if exists, _ := tmuxHasSession(name); !exists {
+			if err := tmuxNewSessionDetached(name, ""); err != nil {
+				return "", fmt.Errorf("new failed: %w", err)
+			}
+		}
+		if err := tmuxSwitchClient(name); err != nil {
+			return "", fmt.Errorf("switch failed: %w", err)
+		}
+		return "", nil
+	case SelectProject:
+		prj := newProjectItem(sel.ProjectName, sel.ProjectPath)
+		return openProjectSession(opts, prj, name, parseTemplate(sel.Template))
+	default:
+		return "", fmt.Errorf("unknown selection kind %q", sel.Kind)
+	}
+}
+
+// OpenDirSelection is --open: the SelectProject Selection for dir, which need not be under a
+// project root. The session is named the way the TUI names a project (the spec's session name when
+// PreferProjectSpec finds one, else the directory's basename). specPath is the project spec
+// OpenSelection will build the session from, or "" when it uses sel.Template instead.
+func OpenDirSelection(dir string, opts UIOptions) (sel Selection, specPath string, err error) {
+	dir = expandHome(strings.TrimSpace(dir))
+	if dir == "" {
+		return Selection{}, "", errors.New("empty directory")
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return Selection{}, "", err
+	}
+	st, err := os.Stat(abs)
+	if err != nil {
+		return Selection{}, "", err
+	}
+	if !st.IsDir() {
+		return Selection{}, "", fmt.Errorf("%s is not a directory", abs)
+	}
+
+	prj := newProjectItem(filepath.Base(abs), abs)
+	var s *spec.Spec
+	if opts.PreferProjectSpec {
+		// A spec that fails to load still counts: opening reports the error and falls back to the
+		// template, like the TUI.
+		loaded, p, ok, _ := spec.LoadProjectLocalWithNames(abs, opts.ProjectSpecNames)
+		if ok {
+			s, specPath = loaded, p
+		}
+	}
+	// "auto" (or unset) detects the template from the directory's markers, like --foreach-project.
+	tpl := strings.ToLower(strings.TrimSpace(opts.DefaultTemplate))
+	if tpl == "" || tpl == "auto" {
+		tpl = detectTemplate(abs).String()
+	}
+	return Selection{
+		Kind:        SelectProject,
+		SessionName: resolveApplySessionName(s, "", prj.Name, prj.Path),
+		ProjectName: prj.Name,
+		ProjectPath: prj.Path,
+		Template:    tpl,
+	}, specPath, nil
+}
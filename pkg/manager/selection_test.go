@@ -0,0 +1,75 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenDirSelection(t *testing.T) {
+	root := t.TempDir()
+	mk := func(name string, files map[string]string) string {
+		t.Helper()
+		dir := filepath.Join(root, name)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for f, body := range files {
+			if err := os.WriteFile(filepath.Join(dir, f), []byte(body), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return dir
+	}
+	plain := mk("My Svc", nil)
+	goDir := mk("gop", map[string]string{"go.mod": "module x\n"})
+	named := mk("named", map[string]string{".tmux-session.yaml": "version: 1\nsession: {name: custom}\nwindows:\n  - name: edit\n"})
+	prefixed := mk("api", map[string]string{".tmux-session.yaml": "version: 1\nsession: {prefix: dev}\nwindows:\n  - name: edit\n"})
+	names := []string{".tmux-session.yaml"}
+
+	tests := []struct {
+		name     string
+		dir      string
+		opts     UIOptions
+		session  string
+		spec     bool
+		template string
+	}{
+		{"basename sanitized, template", plain, UIOptions{PreferProjectSpec: true, ProjectSpecNames: names}, "my_svc", false, "empty"},
+		{"auto template detected", goDir, UIOptions{DefaultTemplate: "auto"}, "gop", false, "go"},
+		{"explicit template wins", goDir, UIOptions{DefaultTemplate: "python"}, "gop", false, "python"},
+		{"spec names the session", named, UIOptions{PreferProjectSpec: true, ProjectSpecNames: names}, "custom", true, "empty"},
+		{"spec prefix", prefixed, UIOptions{PreferProjectSpec: true, ProjectSpecNames: names}, "dev-api", true, "empty"},
+		{"spec ignored without PreferProjectSpec", named, UIOptions{ProjectSpecNames: names, DefaultTemplate: "node"}, "named", false, "node"},
+		{"trailing slash", named + "/", UIOptions{PreferProjectSpec: true, ProjectSpecNames: names}, "custom", true, "empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel, specPath, err := OpenDirSelection(tt.dir, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sel.Kind != SelectProject || sel.SessionName != tt.session || sel.Template != tt.template {
+				t.Errorf("sel = %+v, want session %q template %q", sel, tt.session, tt.template)
+			}
+			if (specPath != "") != tt.spec {
+				t.Errorf("specPath = %q, want spec: %v", specPath, tt.spec)
+			}
+			if !filepath.IsAbs(sel.ProjectPath) {
+				t.Errorf("ProjectPath %q is not absolute", sel.ProjectPath)
+			}
+		})
+	}
+}
+
+func TestOpenDirSelectionRejects(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"", "  ", filepath.Join(t.TempDir(), "missing"), file} {
+		if _, _, err := OpenDirSelection(dir, UIOptions{}); err == nil {
+			t.Errorf("OpenDirSelection(%q): no error", dir)
+		}
+	}
+}
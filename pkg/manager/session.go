@@ -0,0 +1,139 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"tmux-session-manager/pkg/templates"
+)
+
+// SessionOptions controls the session helpers (SessionExists, SwitchOrCreate, KillSession, ...).
+type SessionOptions struct {
+	// Socket selects the tmux server (socket path or name; see templates.TmuxSocketArgs).
+	Socket string
+
+	// DryRun skips mutating commands (new-session, switch-client). Read-only queries still run so
+	// the plan reflects reality. Planned commands are written to DryRunOut.
+	DryRun    bool
+	DryRunOut io.Writer
+
+	// Runner executes tmux commands. Defaults to templates.TmuxExecRunner{Socket: Socket}.
+	Runner templates.Runner
+
+	// Log receives a debug line per tmux command of the default runner (see OpenDebugLog).
+	Log *slog.Logger
+}
+
+// ErrNoClient is returned by SwitchOrCreate when there is no tmux client it can switch: the caller
+// is outside tmux, or the session lives on a different server (--socket). The session exists by
+// then; attaching a new client is up to the caller since it needs a terminal.
+var ErrNoClient = errors.New("no tmux client to switch")
+
+func (o SessionOptions) runner() templates.Runner {
+	if o.Runner != nil {
+		return o.Runner
+	}
+	return &templates.TmuxExecRunner{Socket: o.Socket, Log: o.Log}
+}
+
+// SessionExists reports whether a session named name exists. A missing server counts as
+// "does not exist" (no error), and the check never starts a server.
+func SessionExists(name string, opts SessionOptions) (bool, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return false, errors.New("session name is empty")
+	}
+	// has-session exits 1 both for "no such session" and "no server"; either way it doesn't exist.
+	if err := opts.runner().Run([]string{"has-session", "-t", "=" + name}); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+// ServerReachable reports whether the tmux server selected by opts answers (list-sessions). It
+// never starts a server.
+func ServerReachable(opts SessionOptions) bool {
+	_, err := opts.runner().RunOutput([]string{"list-sessions", "-F", "#{session_name}"})
+	return err == nil
+}
+
+// KillSession kills session name (exact match). It doesn't move clients attached to it first;
+// tmux switches or detaches them.
+func KillSession(name string, opts SessionOptions) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("session name is empty")
+	}
+	if err := runOrPlan(opts.runner(), []string{"kill-session", "-t", "=" + name}, opts); err != nil {
+		return fmt.Errorf("kill session %q: %w", name, err)
+	}
+	return nil
+}
+
+// RenameSession renames session from (exact match) to to. to is used as-is; validate it first if
+// it comes from user input.
+func RenameSession(from, to string, opts SessionOptions) error {
+	from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+	if from == "" || to == "" {
+		return errors.New("session name is empty")
+	}
+	if err := runOrPlan(opts.runner(), []string{"rename-session", "-t", "=" + from, to}, opts); err != nil {
+		return fmt.Errorf("rename session %q: %w", from, err)
+	}
+	return nil
+}
+
+// SwitchOrCreate switches the current tmux client to session name, creating it detached in dir
+// first when it does not exist (dir may be empty to use tmux's default).
+//
+// name is used as-is; pass it through SanitizeSessionName first if it comes from user input or a
+// project directory. Returns ErrNoClient (wrapped) when the session is ready but no client can be
+// switched to it.
+func SwitchOrCreate(name, dir string, opts SessionOptions) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("session name is empty")
+	}
+	r := opts.runner()
+
+	exists, err := SessionExists(name, opts)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		args := []string{"new-session", "-d", "-s", name}
+		if strings.TrimSpace(dir) != "" {
+			args = append(args, "-c", expandHome(strings.TrimSpace(dir)))
+		}
+		if err := runOrPlan(r, args, opts); err != nil {
+			return fmt.Errorf("create session %q: %w", name, err)
+		}
+	}
+
+	if strings.TrimSpace(os.Getenv("TMUX")) == "" {
+		return fmt.Errorf("%w: not inside tmux (attach with: tmux attach -t %s)", ErrNoClient, name)
+	}
+	if target := crossServerSocket(r, opts.Socket); target != "" {
+		return fmt.Errorf("%w: session %q is on another tmux server (%s)", ErrNoClient, name, target)
+	}
+	if err := runOrPlan(r, []string{"switch-client", "-t", name}, opts); err != nil {
+		return fmt.Errorf("switch-client: %w", err)
+	}
+	return nil
+}
+
+// runOrPlan runs a mutating tmux command, or writes it to DryRunOut in dry-run mode.
+func runOrPlan(r templates.Runner, args []string, opts SessionOptions) error {
+	if !opts.DryRun {
+		return r.Run(args)
+	}
+	if opts.DryRunOut != nil {
+		_, err := fmt.Fprintln(opts.DryRunOut, "tmux "+shellJoin(args))
+		return err
+	}
+	return nil
+}
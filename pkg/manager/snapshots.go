@@ -0,0 +1,203 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"tmux-session-manager/pkg/paths"
+	"tmux-session-manager/pkg/spec"
+)
+
+// snapshotTimestampLayout is the <ts> part of snapshot file names (<name>.<ts>.tmux-session.yaml).
+const snapshotTimestampLayout = "20060102-150405"
+
+const snapshotFileSuffix = ".tmux-session.yaml"
+
+// Snapshot is a session snapshot written by the TUI edit-mode `e` key.
+type Snapshot struct {
+	Name      string // sanitized session name from the file name
+	Timestamp string // as written in the file name (YYYYMMDD-HHMMSS)
+	Path      string
+}
+
+// Time parses Timestamp in local time; the zero time if it doesn't parse.
+func (s Snapshot) Time() time.Time {
+	t, err := time.ParseInLocation(snapshotTimestampLayout, s.Timestamp, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// SnapshotDir is where snapshots are written: ~/.config/tmux-session-manager/snapshots
+// ($XDG_CONFIG_HOME when set).
+func SnapshotDir() (string, error) {
+	p, err := paths.ConfigPath("snapshots")
+	if err != nil {
+		return "", fmt.Errorf("snapshot: %w", err)
+	}
+	return p, nil
+}
+
+// ListSnapshots returns the snapshots in SnapshotDir, oldest first (by name, then timestamp).
+// A missing directory yields no snapshots and no error.
+func ListSnapshots() ([]Snapshot, error) {
+	dir, err := SnapshotDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("snapshot: read dir: %w", err)
+	}
+
+	var out []Snapshot
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), snapshotFileSuffix) {
+			continue
+		}
+		base := strings.TrimSuffix(e.Name(), snapshotFileSuffix)
+		i := strings.LastIndex(base, ".")
+		if i <= 0 || i == len(base)-1 {
+			continue
+		}
+		out = append(out, Snapshot{
+			Name:      base[:i],
+			Timestamp: base[i+1:],
+			Path:      filepath.Join(dir, e.Name()),
+		})
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].Timestamp < out[j].Timestamp
+	})
+	return out, nil
+}
+
+// ResolveSnapshot picks the snapshot to restore for name: the most recent one, or the one taken
+// at `at` (a YYYYMMDD-HHMMSS timestamp, or a unique prefix of one) when set. name is matched
+// after sanitizing, like the snapshot file names. On error the available snapshots are returned
+// too, so callers can list them.
+func ResolveSnapshot(name, at string) (Snapshot, []Snapshot, error) {
+	all, err := ListSnapshots()
+	if err != nil {
+		return Snapshot{}, nil, err
+	}
+
+	key := spec.SessionNameSlug(name)
+	if key == "" {
+		return Snapshot{}, all, errors.New("snapshot: empty name")
+	}
+
+	var matches []Snapshot
+	for _, s := range all {
+		if s.Name == key {
+			matches = append(matches, s)
+		}
+	}
+	if len(matches) == 0 {
+		// Fall back to a unique name prefix so `--restore api` finds `api_server`.
+		names := map[string]bool{}
+		for _, s := range all {
+			if strings.HasPrefix(s.Name, key) {
+				names[s.Name] = true
+			}
+		}
+		switch len(names) {
+		case 0:
+			return Snapshot{}, all, fmt.Errorf("snapshot: no snapshots for %q", name)
+		case 1:
+			for _, s := range all {
+				if names[s.Name] {
+					matches = append(matches, s)
+				}
+			}
+		default:
+			var amb []Snapshot
+			for _, s := range all {
+				if names[s.Name] {
+					amb = append(amb, s)
+				}
+			}
+			return Snapshot{}, amb, fmt.Errorf("snapshot: %q is ambiguous (%d sessions match)", name, len(names))
+		}
+	}
+
+	at = strings.TrimSpace(at)
+	if at == "" {
+		return matches[len(matches)-1], matches, nil
+	}
+
+	var picked []Snapshot
+	for _, s := range matches {
+		if strings.HasPrefix(s.Timestamp, at) {
+			picked = append(picked, s)
+		}
+	}
+	switch len(picked) {
+	case 0:
+		return Snapshot{}, matches, fmt.Errorf("snapshot: no snapshot of %q at %q", matches[0].Name, at)
+	case 1:
+		return picked[0], matches, nil
+	default:
+		return Snapshot{}, picked, fmt.Errorf("snapshot: %q matches %d snapshots of %q", at, len(picked), matches[0].Name)
+	}
+}
+
+// snapshotShells are pane_current_command values that mean "idle prompt": nothing to re-run.
+var snapshotShells = map[string]bool{
+	"sh": true, "bash": true, "zsh": true, "fish": true, "dash": true, "ksh": true,
+	"tcsh": true, "csh": true, "nu": true, "elvish": true, "xonsh": true,
+	"tmux": true, "login": true,
+}
+
+// snapshotPaneArgv returns the argv to re-run for a pane whose foreground command is cmd, or nil
+// when the pane sits at a shell prompt. children are the argv lines of the pane shell's child
+// processes (see processArgsByParent); the first one supplies the full command line (e.g.
+// `npm run dev` where tmux only reports `node`), falling back to cmd alone.
+func snapshotPaneArgv(cmd string, children []string) []string {
+	cmd = strings.TrimPrefix(strings.TrimSpace(cmd), "-")
+	if cmd == "" || snapshotShells[cmd] {
+		return nil
+	}
+	if sh := strings.TrimSpace(os.Getenv("SHELL")); sh != "" && filepath.Base(sh) == cmd {
+		return nil
+	}
+	for _, c := range children {
+		// Best-effort: ps joins argv with spaces, so quoted arguments containing spaces split.
+		if argv := strings.Fields(c); len(argv) > 0 {
+			return argv
+		}
+	}
+	return []string{cmd}
+}
+
+// processArgsByParent maps a parent pid to its children's command lines (`ps -A -o ppid=,args=`,
+// which works on Linux and macOS). It returns nil when ps isn't usable.
+func processArgsByParent() map[string][]string {
+	out, err := exec.Command("ps", "-A", "-o", "ppid=", "-o", "args=").Output()
+	if err != nil {
+		return nil
+	}
+	m := map[string][]string{}
+	for _, ln := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(ln)
+		if len(fields) < 2 {
+			continue
+		}
+		rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(ln), fields[0]))
+		m[fields[0]] = append(m[fields[0]], rest)
+	}
+	return m
+}
@@ -0,0 +1,79 @@
+package manager
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Theme holds the TUI styles. Colors can be overridden via env (populated by the launcher from
+// @tmux_session_manager_color_* options); values are lipgloss colors: ANSI indexes ("12"),
+// 256-color indexes ("208"), or hex ("#ff8800").
+type Theme struct {
+	Title     lipgloss.Style // header title
+	Dim       lipgloss.Style // hints, metadata, preview chrome
+	Highlight lipgloss.Style // focused input / prompts
+	Warn      lipgloss.Style // destructive confirmations
+	Selected  lipgloss.Style // selected list row
+	Item      lipgloss.Style // unselected list row
+}
+
+// themeEntry describes one themed style for overrides and --theme-preview.
+type themeEntry struct {
+	Name   string
+	EnvKey string
+	Style  *lipgloss.Style
+}
+
+// DefaultTheme returns the built-in color scheme.
+func DefaultTheme() Theme {
+	return Theme{
+		Title:     lipgloss.NewStyle().Bold(true),
+		Dim:       lipgloss.NewStyle().Foreground(lipgloss.Color("8")),
+		Highlight: lipgloss.NewStyle().Foreground(lipgloss.Color("12")).Bold(true),
+		Warn:      lipgloss.NewStyle().Foreground(lipgloss.Color("9")).Bold(true),
+		Selected:  lipgloss.NewStyle().Foreground(lipgloss.Color("15")).Bold(true),
+		Item:      lipgloss.NewStyle().Foreground(lipgloss.Color("7")),
+	}
+}
+
+func (t *Theme) entries() []themeEntry {
+	return []themeEntry{
+		{Name: "title", EnvKey: "TMUX_SESSION_MANAGER_COLOR_TITLE", Style: &t.Title},
+		{Name: "dim", EnvKey: "TMUX_SESSION_MANAGER_COLOR_DIM", Style: &t.Dim},
+		{Name: "highlight", EnvKey: "TMUX_SESSION_MANAGER_COLOR_HIGHLIGHT", Style: &t.Highlight},
+		{Name: "warn", EnvKey: "TMUX_SESSION_MANAGER_COLOR_WARN", Style: &t.Warn},
+		{Name: "selected", EnvKey: "TMUX_SESSION_MANAGER_COLOR_SELECTED", Style: &t.Selected},
+		{Name: "item", EnvKey: "TMUX_SESSION_MANAGER_COLOR_ITEM", Style: &t.Item},
+	}
+}
+
+// ThemeFromEnv returns DefaultTheme with any TMUX_SESSION_MANAGER_COLOR_* foreground overrides applied.
+func ThemeFromEnv() Theme {
+	t := DefaultTheme()
+	for _, e := range t.entries() {
+		if v := strings.TrimSpace(os.Getenv(e.EnvKey)); v != "" {
+			*e.Style = e.Style.Foreground(lipgloss.Color(v))
+		}
+	}
+	return t
+}
+
+// PrintThemePreview writes each themed style with its name, override key, and sample text.
+// It renders inline (no alt-screen) so colors can be tuned by re-running it.
+func PrintThemePreview(w io.Writer) error {
+	t := ThemeFromEnv()
+	for _, e := range t.entries() {
+		cur := strings.TrimSpace(os.Getenv(e.EnvKey))
+		if cur == "" {
+			cur = "default"
+		}
+		if _, err := fmt.Fprintf(w, "%-10s %s  %s\n", e.Name, e.Style.Render("The quick brown fox · tmux-session-manager"), t.Dim.Render(e.EnvKey+"="+cur)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
 	"strings"
 	"time"
+
+	"tmux-session-manager/pkg/templates"
 )
 
 // Tmux is a small wrapper around invoking `tmux`.
@@ -24,6 +27,13 @@ type Tmux struct {
 
 	// Debug, when true, prints executed tmux commands and outputs to stderr.
 	Debug bool
+
+	// Socket selects the tmux server (socket path or name; see templates.TmuxSocketArgs). Empty
+	// leaves it to tmux ($TMUX, then the default socket).
+	Socket string
+
+	// Log, when set, receives a debug line per invocation (see OpenDebugLog).
+	Log *slog.Logger
 }
 
 // NewTmux returns a Tmux wrapper with sensible defaults.
@@ -47,8 +57,10 @@ func (t *Tmux) InTmux() bool {
 }
 
 // ServerReachable checks if a tmux server is reachable from this process context.
+// It only queries (list-sessions): nothing is displayed and no server is started, so it is safe
+// to call from a bare shell.
 func (t *Tmux) ServerReachable() bool {
-	_, err := t.Output("display-message", "-d", "1", "tmux-session-manager: ping")
+	_, err := t.Output("list-sessions", "-F", "#{session_name}")
 	return err == nil
 }
 
@@ -197,6 +209,7 @@ func (t *Tmux) runBytes(capture bool, args ...string) ([]byte, []byte, error) {
 		return nil, nil, errors.New("tmux: missing args")
 	}
 
+	args = templates.WithSocketArgs(t.Socket, args)
 	cmd := exec.Command(t.bin(), args...)
 	cmd.Env = append(os.Environ(), t.ExtraEnv...)
 
@@ -216,6 +229,13 @@ func (t *Tmux) runBytes(capture bool, args ...string) ([]byte, []byte, error) {
 		fmt.Fprintf(os.Stderr, "tmuxwrap: exec: %s %s\n", t.bin(), shellJoin(args))
 	}
 
+	if t.Log != nil {
+		start := time.Now()
+		defer func() {
+			t.Log.Debug("tmux", "runner", "tmuxwrap", "args", shellJoin(args), "dur", time.Since(start), "exit", cmd.ProcessState.String(), "stderr", strings.TrimSpace(stderrBuf.String()))
+		}()
+	}
+
 	if t.Timeout > 0 {
 		err := runWithTimeout(cmd, t.Timeout)
 		if err != nil {
@@ -312,6 +332,9 @@ func runWithTimeout(cmd *exec.Cmd, timeout time.Duration) error {
 	}
 	ch := make(chan result, 1)
 
+	// A killed process can leave children holding the output pipes; don't let Wait block on them.
+	cmd.WaitDelay = 500 * time.Millisecond
+
 	if err := cmd.Start(); err != nil {
 		return err
 	}
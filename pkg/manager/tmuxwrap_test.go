@@ -0,0 +1,59 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A wedged tmux (here one that sleeps) makes the TUI's helpers fail with a timeout instead of
+// blocking the selector.
+func TestTmuxTimeout(t *testing.T) {
+	fakeTuiTmux(t, "sleep 5\n")
+	tuiTmux.Timeout = 100 * time.Millisecond
+
+	start := time.Now()
+	_, err := tmuxCurrentSessionName()
+	if err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Errorf("err = %v, want a timeout", err)
+	}
+	// The killed shell's sleep child still holds the output pipe; WaitDelay bounds the wait.
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("call took %s", d)
+	}
+}
+
+// The TUI's tmux helpers all go through tuiTmux, so its socket reaches every call.
+func TestTuiTmuxSocket(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "tmux.log")
+	fakeTuiTmux(t, `echo "$*" >> '`+log+"'\n")
+
+	tests := []struct {
+		opts UIOptions
+		env  string
+		want string
+	}{
+		{UIOptions{}, "", "has-session -t =api"},
+		{UIOptions{}, "work", "-L work has-session -t =api"},
+		{UIOptions{Socket: "/tmp/tsm.sock"}, "work", "-S /tmp/tsm.sock has-session -t =api"},
+	}
+	for _, tt := range tests {
+		t.Setenv("TMUX_SESSION_MANAGER_SOCKET", tt.env)
+		tuiTmux.Socket = uiSocket(tt.opts)
+		if err := os.Remove(log); err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		if exists, err := tmuxHasSession("api"); err != nil || !exists {
+			t.Fatalf("tmuxHasSession = %v, %v", exists, err)
+		}
+		b, err := os.ReadFile(log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSpace(string(b)); got != tt.want {
+			t.Errorf("socket %q/env %q: ran %q, want %q", tt.opts.Socket, tt.env, got, tt.want)
+		}
+	}
+}
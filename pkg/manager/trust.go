@@ -0,0 +1,182 @@
+package manager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"tmux-session-manager/pkg/paths"
+	"tmux-session-manager/pkg/spec"
+	"tmux-session-manager/pkg/templates"
+)
+
+// UnsafeSpec is a project spec whose compiled plan needs shell or tmux passthrough: its path and
+// Sum, a hash of exactly those commands. Trust is recorded per (path, Sum), so editing the shell /
+// passthrough commands (in the spec or an `extends:` base) asks again.
+type UnsafeSpec struct {
+	SpecPath string
+	Sum      string
+}
+
+// trustFile is the on-disk form of the unsafe-spec allowlist (trustedSpecsPath): the project specs
+// the user confirmed running with shell / tmux passthrough actions, keyed by
+// templates.HashPath(spec path). Path is kept for whoever reads the file.
+type trustFile struct {
+	Specs map[string]trustEntry `json:"specs"`
+}
+
+type trustEntry struct {
+	Path string    `json:"path"`
+	Sum  string    `json:"sum"`
+	At   time.Time `json:"at"`
+}
+
+// trustedSpecsPath is ~/.config/tmux-session-manager/trusted-specs.json ($XDG_CONFIG_HOME when
+// set). It lives with the config rather than the cache: clearing caches shouldn't grant or revoke
+// anything, and deleting it makes every unsafe spec ask again.
+func trustedSpecsPath() (string, error) {
+	p, err := paths.ConfigPath("trusted-specs.json")
+	if err != nil {
+		return "", fmt.Errorf("trust: %w", err)
+	}
+	return p, nil
+}
+
+// loadTrustedSpecs returns the allowlist at path. A missing or unreadable file trusts nothing.
+func loadTrustedSpecs(path string) map[string]trustEntry {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var f trustFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil
+	}
+	return f.Specs
+}
+
+// specTrusted reports whether u (same path and same unsafe commands) is on the allowlist at path.
+func specTrusted(path string, u UnsafeSpec) bool {
+	e, ok := loadTrustedSpecs(path)[templates.HashPath(u.SpecPath)]
+	return ok && u.Sum != "" && e.Sum == u.Sum
+}
+
+// trustSpec adds u to the allowlist at path (replacing an older entry for the same spec path),
+// creating the file (0600) if needed.
+func trustSpec(path string, u UnsafeSpec, now time.Time) error {
+	specs := loadTrustedSpecs(path)
+	if specs == nil {
+		specs = map[string]trustEntry{}
+	}
+	specs[templates.HashPath(u.SpecPath)] = trustEntry{Path: u.SpecPath, Sum: u.Sum, At: now}
+
+	b, err := json.MarshalIndent(trustFile{Specs: specs}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("trust: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("trust: %w", err)
+	}
+	if err := writeFileAtomic(path, b); err != nil {
+		return fmt.Errorf("trust: %w", err)
+	}
+	return nil
+}
+
+// needsUnsafeConfirm is the gate before building a session from a project spec: a spec whose
+// compiled plan uses shell or tmux passthrough (unsafeUsed) asks once, until it's on the allowlist
+// at trustPath with the same unsafe commands. Without an allowlist path (no home directory) it asks
+// every time.
+func needsUnsafeConfirm(trustPath string, u UnsafeSpec, unsafeUsed bool) bool {
+	if !unsafeUsed {
+		return false
+	}
+	return trustPath == "" || !specTrusted(trustPath, u)
+}
+
+// unsafePlanSum hashes the unsafe commands of a compiled plan (their tmux args, in order).
+func unsafePlanSum(c templates.Compiled) string {
+	h := sha256.New()
+	for _, cmd := range c.Commands {
+		if cmd.Unsafe {
+			h.Write([]byte(strings.Join(cmd.Args, "\x00")))
+			h.Write([]byte{'\n'})
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// projectSpecUnsafe compiles prj's project spec (without running it) for sessionName and reports
+// whether the plan needs shell or tmux passthrough under opts' policy. No spec, or one that fails
+// to load/validate/compile, is not unsafe: openProjectSession / Apply report those problems.
+func projectSpecUnsafe(opts UIOptions, prj projectItem, sessionName string) (UnsafeSpec, bool) {
+	if !opts.PreferProjectSpec || (!opts.AllowShell && !opts.AllowTmuxPassthrough) {
+		return UnsafeSpec{}, false
+	}
+	s, specPath, ok, err := spec.LoadProjectLocalWithNames(prj.Path, opts.ProjectSpecNames)
+	if err != nil || !ok {
+		return UnsafeSpec{}, false
+	}
+	if s.ValidatePolicy(specPolicy(opts)) != nil {
+		return UnsafeSpec{}, false
+	}
+	eng := specEngine(opts)
+
+	ctx := templates.Context{
+		ProjectName: prj.Name,
+		ProjectPath: prj.Path,
+		SessionName: sessionName,
+		WorkingDir:  prj.Path,
+		Env:         s.Env,
+		TmuxSocket:  opts.Socket,
+	}
+	ts, err := templates.FromSpec(ctx, *s, opts.AllowShell, opts.AllowTmuxPassthrough, opts.AllowedTmuxCommands, false)
+	if err != nil {
+		return UnsafeSpec{}, false
+	}
+	compiled, err := eng.Compile(ctx, ts)
+	if err != nil || !compiled.UnsafeUsed {
+		return UnsafeSpec{}, false
+	}
+	return UnsafeSpec{SpecPath: specPath, Sum: unsafePlanSum(compiled)}, true
+}
+
+// untrustedProjectSpec is the gate for building sessionName from prj's spec: the spec to confirm,
+// and true, when its plan is unsafe and not trusted yet.
+func untrustedProjectSpec(opts UIOptions, prj projectItem, sessionName string) (UnsafeSpec, bool) {
+	u, unsafe := projectSpecUnsafe(opts, prj, sessionName)
+	if !unsafe {
+		return UnsafeSpec{}, false
+	}
+	trustPath, _ := trustedSpecsPath()
+	return u, needsUnsafeConfirm(trustPath, u, unsafe)
+}
+
+// UntrustedSelectionSpec is the first-use gate for OpenSelection outside the TUI (--open): when
+// sel would create its session from a project spec that runs shell or tmux passthrough and that
+// isn't on the allowlist yet, it returns that spec and true. Confirm with the user, then TrustSpec.
+func UntrustedSelectionSpec(sel Selection, opts UIOptions) (UnsafeSpec, bool) {
+	if sel.Kind != SelectProject {
+		return UnsafeSpec{}, false
+	}
+	opts.Socket = uiSocket(opts)
+	if exists, _ := SessionExists(sel.SessionName, SessionOptions{Socket: opts.Socket, Log: opts.Log}); exists {
+		return UnsafeSpec{}, false // only switches; nothing runs
+	}
+	return untrustedProjectSpec(opts, newProjectItem(sel.ProjectName, sel.ProjectPath), sel.SessionName)
+}
+
+// TrustSpec adds u to the allowlist (trustedSpecsPath), so it isn't asked about again until its
+// unsafe commands change.
+func TrustSpec(u UnsafeSpec) error {
+	path, err := trustedSpecsPath()
+	if err != nil {
+		return err
+	}
+	return trustSpec(path, u, time.Now())
+}
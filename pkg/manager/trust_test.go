@@ -0,0 +1,137 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTrustSpecPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cfg", "trusted-specs.json")
+	a := UnsafeSpec{SpecPath: "/p/a/.tmux-session.yaml", Sum: "sum-a"}
+	b := UnsafeSpec{SpecPath: "/p/b/.tmux-session.yaml", Sum: "sum-b"}
+
+	if specTrusted(path, a) {
+		t.Fatal("trusted before the file exists")
+	}
+	for _, u := range []UnsafeSpec{a, b} {
+		if err := trustSpec(path, u, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !specTrusted(path, a) || !specTrusted(path, b) {
+		t.Fatal("trusted specs not found after reload")
+	}
+	if st, err := os.Stat(path); err != nil || st.Mode().Perm() != 0o600 {
+		t.Fatalf("allowlist mode: %v, %v", st, err)
+	}
+
+	// Same path, different unsafe commands: no longer trusted; re-trusting replaces the entry.
+	edited := UnsafeSpec{SpecPath: a.SpecPath, Sum: "sum-a2"}
+	if specTrusted(path, edited) {
+		t.Error("edited spec still trusted")
+	}
+	if err := trustSpec(path, edited, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if !specTrusted(path, edited) || specTrusted(path, a) {
+		t.Error("re-trusting did not replace the old entry")
+	}
+	if n := len(loadTrustedSpecs(path)); n != 2 {
+		t.Errorf("entries = %d, want 2", n)
+	}
+}
+
+func TestTrustSpecCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trusted-specs.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	u := UnsafeSpec{SpecPath: "/p/a.yaml", Sum: "s"}
+	if specTrusted(path, u) {
+		t.Fatal("corrupt file trusts")
+	}
+	if err := trustSpec(path, u, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if !specTrusted(path, u) {
+		t.Error("trust after a corrupt file was not recorded")
+	}
+}
+
+func TestNeedsUnsafeConfirm(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trusted-specs.json")
+	trusted := UnsafeSpec{SpecPath: "/p/a.yaml", Sum: "s1"}
+	if err := trustSpec(path, trusted, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		trustPath string
+		u         UnsafeSpec
+		unsafe    bool
+		want      bool
+	}{
+		{"safe plan", path, UnsafeSpec{SpecPath: "/p/new.yaml"}, false, false},
+		{"safe plan without allowlist", "", UnsafeSpec{}, false, false},
+		{"unsafe, untrusted", path, UnsafeSpec{SpecPath: "/p/new.yaml", Sum: "s"}, true, true},
+		{"unsafe, trusted", path, trusted, true, false},
+		{"unsafe, commands changed", path, UnsafeSpec{SpecPath: trusted.SpecPath, Sum: "s2"}, true, true},
+		{"unsafe, no sum", path, UnsafeSpec{SpecPath: trusted.SpecPath}, true, true},
+		{"unsafe, no allowlist path", "", trusted, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := needsUnsafeConfirm(tt.trustPath, tt.u, tt.unsafe); got != tt.want {
+				t.Errorf("needsUnsafeConfirm = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func writeSpec(t *testing.T, dir, body string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, ".tmux-session.yaml"), []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProjectSpecUnsafe(t *testing.T) {
+	const shellSpec = "version: 1\nactions:\n  - type: shell\n    shell: {cmd: \"make dev\"}\n"
+	dir := t.TempDir()
+	writeSpec(t, dir, shellSpec)
+	prj := newProjectItem("svc", dir)
+	opts := UIOptions{PreferProjectSpec: true, AllowShell: true, ProjectSpecNames: []string{".tmux-session.yaml"}}
+
+	u, unsafe := projectSpecUnsafe(opts, prj, "svc")
+	if !unsafe || u.SpecPath != filepath.Join(dir, ".tmux-session.yaml") || u.Sum == "" {
+		t.Fatalf("projectSpecUnsafe = %+v, %v", u, unsafe)
+	}
+	if again, _ := projectSpecUnsafe(opts, prj, "svc"); again.Sum != u.Sum {
+		t.Error("sum is not stable")
+	}
+
+	writeSpec(t, dir, "version: 1\nactions:\n  - type: shell\n    shell: {cmd: \"make dev; rm -rf /tmp/x\"}\n")
+	if edited, _ := projectSpecUnsafe(opts, prj, "svc"); edited.Sum == u.Sum {
+		t.Error("editing the shell command kept the sum")
+	}
+
+	off := opts
+	off.AllowShell = false
+	if _, unsafe := projectSpecUnsafe(off, prj, "svc"); unsafe {
+		t.Error("unsafe with shell disallowed (nothing would run)")
+	}
+	noSpec := opts
+	noSpec.PreferProjectSpec = false
+	if _, unsafe := projectSpecUnsafe(noSpec, prj, "svc"); unsafe {
+		t.Error("unsafe with PreferProjectSpec off")
+	}
+
+	safeDir := t.TempDir()
+	writeSpec(t, safeDir, "version: 1\nwindows:\n  - name: edit\n")
+	if _, unsafe := projectSpecUnsafe(opts, newProjectItem("safe", safeDir), "safe"); unsafe {
+		t.Error("safe spec reported unsafe")
+	}
+}
@@ -1,34 +1,60 @@
 package manager
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
-	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
 
+	"tmux-session-manager/pkg/config"
 	"tmux-session-manager/pkg/spec"
 	"tmux-session-manager/pkg/templates"
 )
 
 // RunTUI launches the Bubble Tea UI.
 func RunTUI(opts UIOptions) error {
+	_, err := RunTUISelect(opts)
+	return err
+}
+
+// RunTUISelect runs the UI and returns what was picked. With opts.DetachUI the UI only records
+// the pick and exits; the caller then performs it with OpenSelection, outside the UI loop. Without
+// DetachUI the UI acts on the pick itself and the returned Selection is empty.
+func RunTUISelect(opts UIOptions) (Selection, error) {
 	// Improve rendering reliability when launched inside tmux popups/wrappers.
 	if os.Getenv("TMUX_SESSION_MANAGER_IN_POPUP") != "" {
 		_ = os.Setenv("TERM", "xterm-256color")
 	}
 
-	m := newModel(opts)
-	p := tea.NewProgram(m, tea.WithAltScreen())
-	_, err := p.Run()
-	return err
+	tuiTmux.Timeout = defaultTUITmuxTimeout
+	if opts.CommandTimeout > 0 {
+		tuiTmux.Timeout = opts.CommandTimeout
+	}
+	tuiTmux.Socket = uiSocket(opts)
+	tuiTmux.Log = opts.Log
+
+	p := tea.NewProgram(newModel(opts), tea.WithAltScreen())
+	final, err := p.Run()
+	if err != nil {
+		return Selection{}, err
+	}
+	if fm, ok := final.(model); ok {
+		return fm.selection, nil
+	}
+	return Selection{}, nil
 }
 
 // UIOptions controls the selector behavior for tmux-session-manager.
@@ -51,6 +77,19 @@ type UIOptions struct {
 	// If 0, defaults to the built-in scanner default.
 	ProjectScanDepth int
 
+	// IgnoreDirNames are directory names the project scan never descends into (hidden directories
+	// are always skipped). If empty, defaults to node_modules and vendor.
+	IgnoreDirNames []string
+
+	// ProjectMarkers are extra names (files or directories) that make a directory a project, on top
+	// of the built-in markers (.git, go.mod, package.json, ...).
+	ProjectMarkers []string
+
+	// ProjectCacheTTL is how long the last project scan (~/.cache/tmux-session-manager/projects.json)
+	// is reused at startup, unless a root's mtime changed: 0 = defaultProjectCacheTTL, < 0 = no cache.
+	// R always rescans.
+	ProjectCacheTTL time.Duration
+
 	// ProjectSpecNames are filenames to look for inside a project directory.
 	// If empty, defaults to pkg/spec defaults:
 	//   - .tmux-session.yaml
@@ -68,7 +107,7 @@ type UIOptions struct {
 	// DefaultTemplate is one of: "auto", "node", "python", "go", "empty"
 	DefaultTemplate string
 
-	// PreviewLines caps the preview height when enabled (0 means auto).
+	// PreviewLines caps the preview height when enabled (0 means 12). It can be resized live with +/-.
 	PreviewLines int
 
 	// DryRun prevents executing tmux mutations and only previews the plan.
@@ -79,6 +118,48 @@ type UIOptions struct {
 
 	// AllowTmuxPassthrough enables validated raw tmux passthrough actions in specs (advanced; opt-in).
 	AllowTmuxPassthrough bool
+
+	// AllowedShellPrefixes restricts shell actions in specs when AllowShell is on (empty = any).
+	AllowedShellPrefixes []string
+
+	// AllowedTmuxCommands replaces the tmux passthrough allowlist (nil = the default one).
+	AllowedTmuxCommands map[string]bool
+
+	// StrictWaitForPrompt and WaitDefaults apply to the specs the UI builds (see
+	// ApplySpecOptions); the caller resolves them from config.
+	StrictWaitForPrompt bool
+	WaitDefaults        templates.WaitDefaults
+
+	// MaxActionsCeiling / MaxCommandLenCeiling bound spec `limits:` (see ApplySpecOptions).
+	MaxActionsCeiling    int
+	MaxCommandLenCeiling int
+
+	// CommandTimeout bounds each tmux command run while applying a spec (0 = no timeout), and the
+	// TUI's own tmux commands (0 = defaultTUITmuxTimeout).
+	CommandTimeout time.Duration
+
+	// Socket selects the tmux server the UI lists and acts on (socket path or name; see
+	// templates.TmuxSocketArgs). Empty falls back to TMUX_SESSION_MANAGER_SOCKET, then to tmux's
+	// own resolution ($TMUX, then the default socket).
+	Socket string
+
+	// Log, when set, receives the UI's tmux commands and key events as debug lines (see
+	// OpenDebugLog); the terminal belongs to the UI, so nothing is traced to stderr.
+	Log *slog.Logger
+
+	// DetachUI makes enter (and w) only pick: the UI exits and RunTUISelect returns the Selection
+	// for the caller to open after the UI is gone (useful from popups). Other keys (d, r, W, ...)
+	// still act inside the UI.
+	DetachUI bool
+
+	// ConfirmKill controls how killing a session is confirmed: "y" (default; single keypress),
+	// "name" (type the session name), or "yes" (type the word yes). Typed modes confirm on enter.
+	ConfirmKill string
+
+	// SessionSort orders the sessions list: "name" (default), "activity" (most recently active
+	// first), "windows" (most windows first), or "mru" (most recently switched to from this tool,
+	// see mruPath). `o` cycles it live.
+	SessionSort string
 }
 
 type listMode int
@@ -88,9 +169,15 @@ const (
 	modeProjects
 )
 
+func (l listMode) String() string {
+	if l == modeProjects {
+		return "projects"
+	}
+	return "sessions"
+}
+
 // snapshot defaults / paths
 const (
-	defaultSnapshotDirName  = "tmux-session-manager"
 	defaultSnapshotFileMode = 0o600
 )
 
@@ -101,8 +188,17 @@ const (
 	tplNode
 	tplPython
 	tplGo
+	tplRust
+
+	// templateKindCount is the number of template kinds; keep it last.
+	templateKindCount
 )
 
+// nextTemplate is the template after t in the `t` cycle, wrapping back to the first.
+func nextTemplate(t templateKind) templateKind {
+	return (t + 1) % templateKindCount
+}
+
 func (t templateKind) String() string {
 	switch t {
 	case tplNode:
@@ -111,6 +207,8 @@ func (t templateKind) String() string {
 		return "python"
 	case tplGo:
 		return "go"
+	case tplRust:
+		return "rust"
 	default:
 		return "empty"
 	}
@@ -128,24 +226,74 @@ type model struct {
 	sessions []sessionItem
 	projects []projectItem
 
+	// noServer is set when no tmux server is reachable, so the sessions list shows that instead
+	// of "(no sessions)" or a list-sessions error.
+	noServer bool
+
 	filteredSessions []sessionItem
 	filteredProjects []projectItem
 
 	selected int
 	scroll   int
 
+	// filterQuery is the (lowercased) query the filtered slices currently reflect. When the
+	// query only grows (the common "typing" case), recomputeFilter narrows the previous result
+	// set instead of rescanning the full dataset. filterValid is cleared whenever the backing
+	// datasets are reloaded.
+	filterQuery string
+	filterValid bool
+
 	// help/preview
 	showHelp    bool
 	showPreview bool
 
+	// previewFocus routes j/k and paging keys to the preview (shift+tab / ctrl+w). previewScroll is
+	// its offset in lines, valid only while the preview still shows previewScrollKey.
+	previewFocus     bool
+	previewScroll    int
+	previewScrollKey string
+
 	// confirm / prompts
-	confirmKill bool
-	renameMode  bool
-	newMode     bool
+	confirmKill  bool
+	confirmValue string // typed confirmation buffer (ConfirmKill "name"/"yes")
+	renameMode   bool
+	newMode      bool
+
+	// killFallback is set when the session being killed is the one this client is attached to:
+	// the client switches there first. confirmKillAttached is the extra y/n step for that case.
+	killFallback        string
+	confirmKillAttached bool
+
+	// marked sessions (space in sessions mode); `d` kills all of them when any are marked, with
+	// killBatch holding the targets while the confirmation is up.
+	marked    map[string]bool
+	killBatch []string
+
+	// killBatchKind names the batch in the confirmation: "marked" (`d`) or "idle" (`I`).
+	killBatchKind string
+
+	// killName is the single session `d` asked about (no marks), fixed when the prompt opens so a
+	// refresh that moves the selection can't change what gets killed.
+	killName string
+
+	// confirmScaffold is the existing spec `S` would overwrite, while that confirmation is up.
+	confirmScaffold string
 
 	renameValue string
 	newValue    string
 
+	// selection is the pick recorded in DetachUI mode (see RunTUISelect).
+	selection Selection
+
+	// confirmReplace is the running session W offered to rebuild from its project's spec (y/n);
+	// replaceProject is that project, fixed when the prompt opens.
+	confirmReplace string
+	replaceProject projectItem
+
+	// confirmUnsafe is the pending first-use confirmation before a project spec's shell / tmux
+	// passthrough actions run (y/n; yes adds it to the allowlist, see trustedSpecsPath).
+	confirmUnsafe *unsafePrompt
+
 	// template selection (only used when creating from project)
 	template templateKind
 
@@ -156,9 +304,19 @@ type model struct {
 	lastRefresh  time.Time
 	refreshAfter time.Duration
 
+	// Async refresh (see startRefresh): results tagged with an older generation are dropped, and a
+	// new refresh cancels the project scan still in flight.
+	sessionsGen    int
+	projectsGen    int
+	projectsCancel context.CancelFunc
+	scanning       bool
+	initCmd        tea.Cmd
+
 	status      string
 	statusUntil time.Time
 
+	theme Theme
+
 	width  int
 	height int
 
@@ -171,17 +329,39 @@ type sessionItem struct {
 	Attached  bool
 	CreatedAt string
 	RawLine   string
+
+	// LastActivity is #{session_activity} (unix seconds; 0 if unknown).
+	LastActivity int64
+
+	// nameRunes is the precomputed lowercase Name used for filtering; ord is the item's index in
+	// the unfiltered list, which breaks score ties.
+	nameRunes []rune
+	ord       int
 }
 
 type projectItem struct {
 	Name string
 	Path string
+
+	// sessionName is projectSessionName resolved when the projects were loaded, so rendering
+	// doesn't read specs ("" for items built elsewhere; see rowSessionName).
+	sessionName string
+
+	// hay is the precomputed lowercase search haystack ("name path"); ord as for sessionItem.
+	hay []rune
+	ord int
+}
+
+func newProjectItem(name, path string) projectItem {
+	return projectItem{Name: name, Path: path, hay: []rune(strings.ToLower(name + " " + path))}
 }
 
 func newModel(opts UIOptions) model {
-	// Derive safety toggles from env (default: safe).
-	opts.AllowShell = parseEnvBool("TMUX_SESSION_MANAGER_ALLOW_SHELL", opts.AllowShell)
-	opts.AllowTmuxPassthrough = parseEnvBool("TMUX_SESSION_MANAGER_ALLOW_TMUX_PASSTHROUGH", opts.AllowTmuxPassthrough)
+	// Safety toggles, confirmation and sort order come from opts only (config.Resolve already
+	// layered flags over env over the config file); the zero values are the safe defaults.
+	opts.ConfirmKill = config.NormalizeConfirmKill(opts.ConfirmKill)
+	opts.SessionSort = config.NormalizeSessionSort(opts.SessionSort)
+	opts.Socket = uiSocket(opts)
 
 	ti := textinput.New()
 	ti.Prompt = "/ "
@@ -200,17 +380,19 @@ func newModel(opts UIOptions) model {
 		template:     parseTemplate(opts.DefaultTemplate),
 		ggTimeout:    650 * time.Millisecond,
 		refreshAfter: 2 * time.Second,
+		theme:        ThemeFromEnv(),
 	}
 
 	if m.opts.MaxResults <= 0 {
 		m.opts.MaxResults = 20
 	}
-	if m.opts.PreviewLines <= 0 {
-		m.opts.PreviewLines = 12
+	if m.opts.PreviewLines < minPreviewLines {
+		m.opts.PreviewLines = defaultPreviewLines
 	}
 
-	m.refreshSessions()
-	m.refreshProjects()
+	// Sessions and projects load in the background so the UI opens immediately, even with
+	// hundreds of repos under the roots.
+	m.initCmd = m.startRefresh(true)
 	m.recomputeFilter()
 	return m
 }
@@ -223,14 +405,15 @@ func parseTemplate(s string) templateKind {
 		return tplPython
 	case "go", "golang":
 		return tplGo
+	case "rust", "rs":
+		return tplRust
 	default:
 		return tplEmpty
 	}
 }
 
 func (m model) Init() tea.Cmd {
-	// No async at first pass: keep simple and deterministic.
-	return nil
+	return m.initCmd
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -239,10 +422,34 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.width = x.Width
 		m.height = x.Height
 		m.input.Width = clampInt(m.width-6, 10, 80)
+		m.opts.PreviewLines = clampInt(m.opts.PreviewLines, minPreviewLines, m.maxPreviewLines())
+
+		return m, nil
 
+	case sessionsLoadedMsg:
+		if x.gen != m.sessionsGen {
+			return m, nil
+		}
+		m.applySessions(x.items, x.err, x.noServer)
+		m.recomputeFilter()
+		return m, nil
+
+	case projectsLoadedMsg:
+		if x.gen != m.projectsGen {
+			return m, nil
+		}
+		m.scanning = false
+		if m.projectsCancel != nil {
+			m.projectsCancel() // the scan is done; release its context
+			m.projectsCancel = nil
+		}
+		m.projects = x.items
+		m.filterValid = false
+		m.recomputeFilter()
 		return m, nil
 
 	case tea.KeyMsg:
+		logDebug(m.opts.Log, "key", "key", x.String(), "mode", m.mode, "selected", m.selected, "search", m.input.Focused(), "preview_focus", m.previewFocus)
 
 		// Allow ESC to exit modes / blur, consistent with vim mental model.
 		if m.renameMode || m.newMode {
@@ -251,6 +458,18 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if m.confirmKill {
 			return m.handleConfirmKeys(x)
 		}
+		if m.confirmKillAttached {
+			return m.handleKillAttachedKeys(x)
+		}
+		if m.confirmReplace != "" {
+			return m.handleReplaceKeys(x)
+		}
+		if m.confirmScaffold != "" {
+			return m.handleScaffoldKeys(x)
+		}
+		if m.confirmUnsafe != nil {
+			return m.handleUnsafeKeys(x)
+		}
 		return m.handleGlobalKeys(x)
 	}
 
@@ -293,10 +512,8 @@ func (m model) handlePromptKeys(k tea.KeyMsg) (tea.Model, tea.Cmd) {
 			}
 			m.renameMode = false
 			m.renameValue = ""
-			m.refreshSessions()
-			m.recomputeFilter()
 			m.setStatus("renamed "+cur+" -> "+name, 1800*time.Millisecond)
-			return m, nil
+			return m, m.refreshSessionsCmd()
 		}
 
 		if m.newMode {
@@ -316,8 +533,6 @@ func (m model) handlePromptKeys(k tea.KeyMsg) (tea.Model, tea.Cmd) {
 			}
 			m.newMode = false
 			m.newValue = ""
-			m.refreshSessions()
-			m.recomputeFilter()
 			m.setStatus("created "+name, 1800*time.Millisecond)
 			return m, tea.Quit
 		}
@@ -349,34 +564,364 @@ func (m model) handlePromptKeys(k tea.KeyMsg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// confirmKillPhrase returns the text that must be typed to confirm killing name,
+// or "" when a single y/n keypress is enough.
+func (m model) confirmKillPhrase(name string) string {
+	switch m.opts.ConfirmKill {
+	case "name":
+		return name
+	case "yes":
+		return "yes"
+	default:
+		return ""
+	}
+}
+
 func (m model) handleConfirmKeys(k tea.KeyMsg) (tea.Model, tea.Cmd) {
-	switch k.String() {
-	case "y", "Y":
-		name := m.currentSessionName()
-		if name == "" {
+	name := m.killName
+	phrase := m.confirmKillPhrase(name)
+	if len(m.killBatch) > 0 && phrase != "" {
+		// Typing several names isn't practical: a batch is confirmed with "yes".
+		phrase = "yes"
+	}
+
+	if phrase != "" {
+		// Typed confirmation: accumulate runes until enter; only an exact match kills.
+		switch k.Type {
+		case tea.KeyEsc, tea.KeyCtrlC:
 			m.confirmKill = false
-			m.setStatus("kill: no session selected", 1500*time.Millisecond)
+			m.confirmValue = ""
+			m.killFallback = ""
+			m.killBatch, m.killName = nil, ""
+			m.setStatus("cancelled", 1200*time.Millisecond)
 			return m, nil
-		}
-		if err := tmuxKillSession(name); err != nil {
-			m.confirmKill = false
-			m.setStatus("kill failed: "+err.Error(), 2500*time.Millisecond)
+		case tea.KeyEnter:
+			if m.confirmValue != phrase {
+				m.confirmValue = ""
+				m.setStatus("kill: confirmation did not match (type "+phrase+")", 2000*time.Millisecond)
+				return m, nil
+			}
+			return m.killConfirmed(name)
+		case tea.KeyBackspace:
+			if r := []rune(m.confirmValue); len(r) > 0 {
+				m.confirmValue = string(r[:len(r)-1])
+			}
+			return m, nil
+		case tea.KeyRunes, tea.KeySpace:
+			m.confirmValue += string(k.Runes)
 			return m, nil
 		}
-		m.confirmKill = false
-		m.refreshSessions()
-		m.recomputeFilter()
-		m.selected = clampInt(m.selected, 0, m.currentListLen()-1)
-		m.setStatus("killed "+name, 1800*time.Millisecond)
 		return m, nil
+	}
+
+	switch k.String() {
+	case "y", "Y":
+		return m.killConfirmed(name)
 	case "n", "N", "esc", "q":
 		m.confirmKill = false
+		m.killFallback = ""
+		m.killBatch, m.killName = nil, ""
 		m.setStatus("cancelled", 1200*time.Millisecond)
 		return m, nil
 	}
 	return m, nil
 }
 
+func (m model) handleKillAttachedKeys(k tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch k.String() {
+	case "y", "Y":
+		m.confirmKillAttached = false
+		return m.killSessions(m.killTargets())
+	case "n", "N", "esc", "q":
+		m.confirmKillAttached = false
+		m.killFallback = ""
+		m.killBatch, m.killName = nil, ""
+		m.setStatus("cancelled", 1200*time.Millisecond)
+		return m, nil
+	}
+	return m, nil
+}
+
+func (m model) handleScaffoldKeys(k tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch k.String() {
+	case "y", "Y":
+		m.confirmScaffold = ""
+		return m.scaffoldProjectSpec(true)
+	case "n", "N", "esc", "q":
+		m.confirmScaffold = ""
+		m.setStatus("cancelled", 1200*time.Millisecond)
+		return m, nil
+	}
+	return m, nil
+}
+
+// scaffoldProjectSpec writes a starter spec for the selected project from the current template
+// (like --scaffold). An existing spec is only overwritten with force, after a confirmation.
+func (m model) scaffoldProjectSpec(force bool) (tea.Model, tea.Cmd) {
+	prj := m.currentProject()
+	if prj.Path == "" {
+		m.setStatus("scaffold: no project selected", 1200*time.Millisecond)
+		return m, nil
+	}
+	res, err := ScaffoldProjectSpec(prj.Path, ScaffoldOptions{
+		SpecNames: m.opts.ProjectSpecNames,
+		Template:  m.template.String(),
+		Force:     force,
+		DryRun:    m.opts.DryRun,
+	})
+	if errors.Is(err, ErrSpecExists) {
+		_, existing, _, _ := spec.LoadProjectLocalWithNames(prj.Path, m.opts.ProjectSpecNames)
+		if existing == "" {
+			existing = prj.Name
+		}
+		m.confirmScaffold = existing
+		return m, nil
+	}
+	if err != nil {
+		m.setStatus(err.Error(), 2500*time.Millisecond)
+		return m, nil
+	}
+	if m.opts.DryRun {
+		m.setStatus("dry-run: would write "+res.Path+" (template: "+res.Template+")", 2500*time.Millisecond)
+		return m, nil
+	}
+	verb := "wrote "
+	if res.Overwrote {
+		verb = "overwrote "
+	}
+	m.renameProjectRows(prj)
+	m.setStatus(verb+res.Path+" (template: "+res.Template+")", 2500*time.Millisecond)
+	return m, nil
+}
+
+// renameProjectRows re-resolves the cached session name of prj's rows after its spec changed.
+func (m *model) renameProjectRows(prj projectItem) {
+	name := m.projectSessionName(prj)
+	for _, list := range [][]projectItem{m.projects, m.filteredProjects} {
+		for i := range list {
+			if list[i].Path == prj.Path {
+				list[i].sessionName = name
+			}
+		}
+	}
+}
+
+// unsafePrompt is what the unsafe-spec confirmation acts on, fixed when it opens: a refresh that
+// moves the selection meanwhile doesn't change which spec is trusted or which project opens.
+type unsafePrompt struct {
+	spec    UnsafeSpec
+	project projectItem
+	session string
+
+	// replace rebuilds the running session (W) instead of opening the project.
+	replace bool
+}
+
+func (m model) handleUnsafeKeys(k tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch k.String() {
+	case "y", "Y":
+		p := *m.confirmUnsafe
+		m.confirmUnsafe = nil
+		trustPath, err := trustedSpecsPath()
+		if err == nil {
+			err = trustSpec(trustPath, p.spec, time.Now())
+		}
+		if err != nil {
+			// Not fatal: the spec still runs this time and asks again next time.
+			logDebug(m.opts.Log, "trust failed", "spec", p.spec.SpecPath, "err", err)
+		}
+		if p.replace {
+			return m.rebuildSession(p.project, p.session)
+		}
+		return m.openProject(p.project, p.session)
+	case "n", "N", "esc", "q":
+		m.confirmUnsafe = nil
+		m.setStatus("cancelled", 1200*time.Millisecond)
+		return m, nil
+	}
+	return m, nil
+}
+
+func (m model) handleReplaceKeys(k tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch k.String() {
+	case "y", "Y":
+		return m.replaceConfirmed()
+	case "n", "N", "esc", "q":
+		m.confirmReplace = ""
+		m.replaceProject = projectItem{}
+		m.setStatus("cancelled", 1200*time.Millisecond)
+		return m, nil
+	}
+	return m, nil
+}
+
+// replaceConfirmed rebuilds the running session W asked about, after the unsafe-spec confirmation
+// when its spec runs shell / tmux passthrough and isn't trusted yet.
+func (m model) replaceConfirmed() (tea.Model, tea.Cmd) {
+	name, prj := m.confirmReplace, m.replaceProject
+	m.confirmReplace, m.replaceProject = "", projectItem{}
+	if prj.Path == "" {
+		m.setStatus("no project selected", 1200*time.Millisecond)
+		return m, nil
+	}
+	if !m.opts.DryRun {
+		if u, ask := untrustedProjectSpec(m.opts, prj, name); ask {
+			m.confirmUnsafe = &unsafePrompt{spec: u, project: prj, session: name, replace: true}
+			return m, nil
+		}
+	}
+	return m.rebuildSession(prj, name)
+}
+
+// rebuildSession tears down session name and rebuilds it from prj's project spec (see
+// ApplyRequest.ReplaceSession).
+func (m model) rebuildSession(prj projectItem, name string) (tea.Model, tea.Cmd) {
+	_, specPath, ok, err := spec.LoadProjectLocalWithNames(prj.Path, m.opts.ProjectSpecNames)
+	if err != nil {
+		m.setStatus("replace: spec load failed: "+err.Error(), 2500*time.Millisecond)
+		return m, nil
+	}
+	if !ok {
+		m.setStatus("replace: "+prj.Name+" has no project spec to rebuild from", 2500*time.Millisecond)
+		return m, nil
+	}
+
+	res, err := Apply(context.Background(), ApplyRequest{
+		SpecPath:             specPath,
+		ProjectPath:          prj.Path,
+		ProjectName:          prj.Name,
+		SessionName:          name,
+		AllowShell:           m.opts.AllowShell,
+		AllowTmuxPassthrough: m.opts.AllowTmuxPassthrough,
+		AllowedShellPrefixes: m.opts.AllowedShellPrefixes,
+		AllowedTmuxCommands:  m.opts.AllowedTmuxCommands,
+		StrictWaitForPrompt:  m.opts.StrictWaitForPrompt,
+		WaitDefaults:         m.opts.WaitDefaults,
+		MaxActionsCeiling:    m.opts.MaxActionsCeiling,
+		MaxCommandLenCeiling: m.opts.MaxCommandLenCeiling,
+		CommandTimeout:       m.opts.CommandTimeout,
+		Socket:               m.opts.Socket,
+		ReplaceSession:       true,
+		DryRun:               m.opts.DryRun,
+		Log:                  m.opts.Log,
+	})
+	if err != nil {
+		m.setStatus("replace failed: "+err.Error(), 3000*time.Millisecond)
+		return m, nil
+	}
+	if m.opts.DryRun {
+		m.setStatus("dry-run: would rebuild session "+res.SessionName+" from spec", 2500*time.Millisecond)
+		return m, nil
+	}
+	return m, tea.Quit
+}
+
+func (m model) killConfirmed(name string) (tea.Model, tea.Cmd) {
+	m.confirmKill = false
+	m.confirmValue = ""
+	if name == "" && len(m.killBatch) == 0 {
+		m.setStatus("kill: no session selected", 1500*time.Millisecond)
+		return m, nil
+	}
+	if m.killFallback != "" {
+		// Killing the attached session: ask once more, naming where the client goes.
+		m.confirmKillAttached = true
+		return m, nil
+	}
+	return m.killSessions(m.killTargets())
+}
+
+// killTargets is what a confirmed kill acts on: the batch, or the single session, both fixed
+// when the prompt opened.
+func (m model) killTargets() []string {
+	if len(m.killBatch) > 0 {
+		return m.killBatch
+	}
+	if m.killName != "" {
+		return []string{m.killName}
+	}
+	return nil
+}
+
+// killSessions kills names and clears the marks. When the attached session is among them
+// (killFallback set), the client is switched to killFallback first so it isn't detached, and the
+// TUI quits: it usually ran inside the killed session.
+func (m model) killSessions(names []string) (tea.Model, tea.Cmd) {
+	fallback := m.killFallback
+	m.killFallback = ""
+	m.killBatch, m.killName = nil, ""
+	if fallback != "" {
+		if err := tmuxSwitchClient(fallback); err != nil {
+			m.setStatus("kill: switch to "+fallback+" failed: "+err.Error(), 2500*time.Millisecond)
+			return m, nil
+		}
+	}
+	m.marked = nil
+
+	var failed []string
+	killed := make(map[string]bool, len(names))
+	for _, name := range names {
+		if err := tmuxKillSession(name); err != nil {
+			failed = append(failed, name+": "+err.Error())
+			continue
+		}
+		killed[name] = true
+	}
+	if fallback != "" {
+		return m, tea.Quit
+	}
+	// Drop the killed sessions right away; the background reload then brings the list current.
+	kept := make([]sessionItem, 0, len(m.sessions))
+	for _, it := range m.sessions {
+		if !killed[it.Name] {
+			kept = append(kept, it)
+		}
+	}
+	m.sessions = kept
+	m.filterValid = false
+	m.recomputeFilter()
+	m.selected = clampInt(m.selected, 0, m.currentListLen()-1)
+	refresh := m.refreshSessionsCmd()
+	switch {
+	case len(failed) > 0 && len(names) == 1:
+		m.setStatus("kill failed: "+strings.TrimPrefix(failed[0], names[0]+": "), 2500*time.Millisecond)
+	case len(failed) > 0:
+		m.setStatus(fmt.Sprintf("kill: %d of %d failed: %s", len(failed), len(names), strings.Join(failed, "; ")), 3000*time.Millisecond)
+	case len(names) == 1:
+		m.setStatus("killed "+names[0], 1800*time.Millisecond)
+	default:
+		m.setStatus(fmt.Sprintf("killed %d sessions", len(names)), 1800*time.Millisecond)
+	}
+	return m, refresh
+}
+
+// killFallbackSession picks the session to switch the client to before killing targets, which
+// include the session it is attached to: the first other session in list order, or "" when
+// nothing would be left.
+func killFallbackSession(items []sessionItem, targets []string) string {
+	skip := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		skip[t] = true
+	}
+	for _, it := range items {
+		if it.Name != "" && !skip[it.Name] {
+			return it.Name
+		}
+	}
+	return ""
+}
+
+// markedSessions returns the marked sessions that still exist, in list order.
+func markedSessions(items []sessionItem, marked map[string]bool) []string {
+	var out []string
+	for _, it := range items {
+		if marked[it.Name] {
+			out = append(out, it.Name)
+		}
+	}
+	return out
+}
+
 func (m model) handleGlobalKeys(k tea.KeyMsg) (tea.Model, tea.Cmd) {
 
 	// When search is focused, still allow keybindings that should work "globally"
@@ -443,6 +988,7 @@ func (m model) handleGlobalKeys(k tea.KeyMsg) (tea.Model, tea.Cmd) {
 
 		case "p":
 			m.showPreview = !m.showPreview
+			m.previewFocus = m.previewFocus && m.showPreview
 			return m, nil
 
 		default:
@@ -462,6 +1008,12 @@ func (m model) handleGlobalKeys(k tea.KeyMsg) (tea.Model, tea.Cmd) {
 			m.pendingG = false
 		}
 	}
+	if m.previewFocus && m.showPreview {
+		if done := m.handlePreviewKeys(k); done {
+			return m, nil
+		}
+	}
+
 	switch k.String() {
 	case "q":
 		m.quitting = true
@@ -473,6 +1025,21 @@ func (m model) handleGlobalKeys(k tea.KeyMsg) (tea.Model, tea.Cmd) {
 
 	case "p":
 		m.showPreview = !m.showPreview
+		m.previewFocus = m.previewFocus && m.showPreview
+		return m, nil
+
+	case "shift+tab", "ctrl+w":
+		m.showPreview = true
+		m.previewFocus = true
+		m.setStatus("focus: preview (j/k scroll · ctrl+f/b page · g/G top/bottom · esc back)", 1500*time.Millisecond)
+		return m, nil
+
+	case "+", "=":
+		m.resizePreview(+2)
+		return m, nil
+
+	case "-", "_":
+		m.resizePreview(-2)
 		return m, nil
 
 	case "/":
@@ -528,10 +1095,11 @@ func (m model) handleGlobalKeys(k tea.KeyMsg) (tea.Model, tea.Cmd) {
 		m.move(-1)
 		return m, nil
 
-	case "ctrl-d":
+	case "ctrl+d", "ctrl-d":
+		// Bubble Tea names ctrl chords "ctrl+d"; the hyphen form never arrives but is kept as an alias.
 		m.pageDown()
 		return m, nil
-	case "ctrl-u":
+	case "ctrl+u", "ctrl-u":
 		m.pageUp()
 		return m, nil
 
@@ -552,6 +1120,46 @@ func (m model) handleGlobalKeys(k tea.KeyMsg) (tea.Model, tea.Cmd) {
 	case "enter":
 		return m.accept()
 
+	case "o":
+		if m.mode != modeSessions {
+			m.setStatus("sort: sessions mode only", 1500*time.Millisecond)
+			return m, nil
+		}
+		next := sessionSorts[0]
+		for i, s := range sessionSorts {
+			if s == m.opts.SessionSort {
+				next = sessionSorts[(i+1)%len(sessionSorts)]
+			}
+		}
+		m.opts.SessionSort = next
+		sortSessions(m.sessions, next)
+		m.filterValid = false
+		m.recomputeFilter()
+		m.selected = 0
+		m.scroll = 0
+		m.setStatus("sort: "+next, 1200*time.Millisecond)
+		return m, nil
+
+	case " ":
+		if m.mode != modeSessions {
+			m.setStatus("mark: sessions mode only", 1500*time.Millisecond)
+			return m, nil
+		}
+		name := m.currentSessionName()
+		if name == "" {
+			return m, nil
+		}
+		if m.marked == nil {
+			m.marked = map[string]bool{}
+		}
+		if m.marked[name] {
+			delete(m.marked, name)
+		} else {
+			m.marked[name] = true
+		}
+		m.setStatus(fmt.Sprintf("%d marked (d kills them)", len(m.marked)), 1200*time.Millisecond)
+		return m, nil
+
 	case "r":
 		if m.mode != modeSessions {
 			m.setStatus("rename: sessions mode only", 1500*time.Millisecond)
@@ -566,6 +1174,34 @@ func (m model) handleGlobalKeys(k tea.KeyMsg) (tea.Model, tea.Cmd) {
 		m.renameValue = ""
 		return m, nil
 
+	case "P":
+		if m.mode != modeSessions {
+			m.setStatus("project root: sessions mode only", 1500*time.Millisecond)
+			return m, nil
+		}
+		name := m.currentSessionName()
+		if name == "" {
+			m.setStatus("project root: no session selected", 1500*time.Millisecond)
+			return m, nil
+		}
+		cwd, err := tuiTmux.Output("display-message", "-p", "-t", name+":", "#{pane_current_path}")
+		if err != nil || strings.TrimSpace(cwd) == "" {
+			m.setStatus("project root: can't read the pane's directory", 2000*time.Millisecond)
+			return m, nil
+		}
+		cwd = strings.TrimSpace(cwd)
+		root, ok := findProjectRoot(cwd, markerSet(m.opts.ProjectMarkers))
+		if !ok {
+			m.setStatus("project root: no project above "+cwd, 2500*time.Millisecond)
+			return m, nil
+		}
+		if err := tuiTmuxRun("new-window", "-t", name+":", "-c", root); err != nil {
+			m.setStatus("project root: new-window failed: "+err.Error(), 2500*time.Millisecond)
+			return m, nil
+		}
+		m.setStatus("new window in "+name+" at "+root+" (enter switches)", 3000*time.Millisecond)
+		return m, m.refreshSessionsCmd()
+
 	case "n":
 		if m.mode != modeSessions {
 			m.setStatus("new: sessions mode only", 1500*time.Millisecond)
@@ -580,25 +1216,70 @@ func (m model) handleGlobalKeys(k tea.KeyMsg) (tea.Model, tea.Cmd) {
 			m.setStatus("kill: sessions mode only", 1500*time.Millisecond)
 			return m, nil
 		}
-		name := m.currentSessionName()
-		if name == "" {
-			m.setStatus("kill: no session selected", 1500*time.Millisecond)
-			return m, nil
+		m.killBatch = markedSessions(m.sessions, m.marked)
+		m.killBatchKind = "marked"
+		m.killName = ""
+		if len(m.killBatch) == 0 {
+			m.killName = m.currentSessionName()
+			if m.killName == "" {
+				m.setStatus("kill: no session selected", 1500*time.Millisecond)
+				return m, nil
+			}
+		}
+		targets := m.killTargets()
+		// Killing the session this client is attached to would detach it: switch elsewhere
+		// first, or refuse when there's nowhere to go.
+		m.killFallback = ""
+		if cur, err := tmuxCurrentSessionName(); err == nil && slices.Contains(targets, cur) {
+			m.killFallback = killFallbackSession(m.sessions, targets)
+			if m.killFallback == "" {
+				m.killBatch, m.killName = nil, ""
+				m.setStatus("kill: "+cur+" is the attached session and there is no other session to switch to", 2500*time.Millisecond)
+				return m, nil
+			}
 		}
 		// Avoid killing current session without explicit confirm.
 		m.confirmKill = true
+		m.confirmValue = ""
+		return m, nil
+
+	case "I":
+		// Offer to kill the detached sessions that are only idle shells at $HOME (IdleSessions).
+		if m.mode != modeSessions {
+			m.setStatus("kill idle: sessions mode only", 1500*time.Millisecond)
+			return m, nil
+		}
+		idle, err := IdleSessions(SessionOptions{Runner: tuiRunner{}})
+		if err != nil {
+			m.setStatus("kill idle: "+err.Error(), 2500*time.Millisecond)
+			return m, nil
+		}
+		if len(idle) == 0 {
+			m.setStatus("no idle sessions", 1500*time.Millisecond)
+			return m, nil
+		}
+		if m.opts.DryRun {
+			m.setStatus(fmt.Sprintf("dry-run: would kill %d idle sessions: %s", len(idle), strings.Join(idle, ", ")), 3000*time.Millisecond)
+			return m, nil
+		}
+		m.killBatch = idle
+		m.killBatchKind = "idle"
+		m.killName = ""
+		m.killFallback = "" // idle sessions are detached, so never this client's
+		m.confirmKill = true
+		m.confirmValue = ""
 		return m, nil
 
 	case "e":
 		// Edit mode:
-		// - snapshot current session to ~/.config/tmux-session-manager/snapshots/<name>.<ts>.tmux-session.yaml
+		// - snapshot current session to SnapshotDir()/<name>.<ts>.tmux-session.yaml
 		// - create new session rooted at current pane path
 		// - open editor there
 		return m.editNewSessionInCurrentDir()
 
 	case "t":
 		// cycle template (only meaningful for project-driven create)
-		m.template = (m.template + 1) % 4
+		m.template = nextTemplate(m.template)
 		m.setStatus("template: "+m.template.String(), 1200*time.Millisecond)
 		return m, nil
 
@@ -611,12 +1292,42 @@ func (m model) handleGlobalKeys(k tea.KeyMsg) (tea.Model, tea.Cmd) {
 		}
 		return m.projectAccept()
 
-	case "R":
-		m.refreshSessions()
-		m.refreshProjects()
-		m.recomputeFilter()
-		m.setStatus("refreshed", 1000*time.Millisecond)
+	case "W":
+		// In projects mode: like w, but rebuild a running session from the spec (confirmed).
+		if m.mode != modeProjects {
+			m.setStatus("W: switch to projects mode (tab)", 1500*time.Millisecond)
+			return m, nil
+		}
+		prj := m.currentProject()
+		if prj.Path == "" {
+			m.setStatus("no project selected", 1200*time.Millisecond)
+			return m, nil
+		}
+		name := m.projectSessionName(prj)
+		if exists, _ := tmuxHasSession(name); !exists {
+			return m.projectAccept()
+		}
+		m.confirmReplace, m.replaceProject = name, prj
 		return m, nil
+
+	case "S":
+		// In projects mode: write a starter spec into the project from the current template.
+		if m.mode != modeProjects {
+			m.setStatus("S: switch to projects mode (tab)", 1500*time.Millisecond)
+			return m, nil
+		}
+		return m.scaffoldProjectSpec(false)
+
+	case "E":
+		// In projects mode: open the project's spec in $EDITOR (or start a new one).
+		if m.mode != modeProjects {
+			m.setStatus("E: switch to projects mode (tab)", 1500*time.Millisecond)
+			return m, nil
+		}
+		return m.openProjectSpecInEditor()
+
+	case "R":
+		return m, m.startRefresh(false)
 	}
 
 	return m, nil
@@ -636,11 +1347,15 @@ func (m model) accept() (tea.Model, tea.Cmd) {
 				return m, nil
 			}
 
-			newName := sanitizeSessionName(q)
+			newName := spec.SessionNameSlug(q)
 			if newName == "" {
 				m.setStatus("new: invalid name", 1500*time.Millisecond)
 				return m, nil
 			}
+			if m.opts.DetachUI {
+				m.selection = Selection{Kind: SelectNewSession, SessionName: newName}
+				return m, tea.Quit
+			}
 
 			// Create if missing, then switch.
 			exists, _ := tmuxHasSession(newName)
@@ -658,6 +1373,11 @@ func (m model) accept() (tea.Model, tea.Cmd) {
 			return m, tea.Quit
 		}
 
+		if m.opts.DetachUI {
+			m.selection = Selection{Kind: SelectSession, SessionName: name}
+			return m, tea.Quit
+		}
+
 		// Switch client to selected session.
 		if err := tmuxSwitchClient(name); err != nil {
 			m.setStatus("switch failed: "+err.Error(), 2500*time.Millisecond)
@@ -679,93 +1399,130 @@ func (m model) projectAccept() (tea.Model, tea.Cmd) {
 		m.setStatus("no project selected", 1200*time.Millisecond)
 		return m, nil
 	}
-	sessionName := sanitizeSessionName(prj.Name)
-	if sessionName == "" {
-		sessionName = "project"
+	sessionName := m.projectSessionName(prj)
+
+	// A new session from a spec that runs shell / tmux passthrough asks once first (trust.go).
+	if !m.opts.DryRun {
+		if exists, _ := tmuxHasSession(sessionName); !exists {
+			if u, ask := untrustedProjectSpec(m.opts, prj, sessionName); ask {
+				m.confirmUnsafe = &unsafePrompt{spec: u, project: prj, session: sessionName}
+				return m, nil
+			}
+		}
+	}
+	return m.openProject(prj, sessionName)
+}
+
+// openProject is projectAccept past the unsafe-spec confirmation: record the pick (DetachUI),
+// report it (dry-run), or open the session and quit.
+func (m model) openProject(prj projectItem, sessionName string) (tea.Model, tea.Cmd) {
+	if m.opts.DetachUI && !m.opts.DryRun {
+		// Selection only: main applies after the UI has exited (see RunTUISelect).
+		m.selection = Selection{
+			Kind:        SelectProject,
+			SessionName: sessionName,
+			ProjectName: prj.Name,
+			ProjectPath: prj.Path,
+			Template:    m.template.String(),
+		}
+		return m, tea.Quit
 	}
 
 	// If session exists, switch to it; otherwise create using spec (if enabled/present) or template.
 	exists, _ := tmuxHasSession(sessionName)
-	if !exists {
-		if m.opts.DryRun {
-			// In dry-run, do not mutate tmux. Just surface intent in preview/status.
-			if m.opts.PreferProjectSpec {
-				s, _, ok, err := spec.LoadProjectLocalWithNames(prj.Path, m.opts.ProjectSpecNames)
-				if err != nil {
-					m.setStatus("dry-run: spec load failed: "+err.Error(), 3000*time.Millisecond)
+	if !exists && m.opts.DryRun {
+		// In dry-run, do not mutate tmux. Just surface intent in preview/status.
+		if m.opts.PreferProjectSpec {
+			s, _, ok, err := spec.LoadProjectLocalWithNames(prj.Path, m.opts.ProjectSpecNames)
+			if err != nil {
+				m.setStatus("dry-run: spec load failed: "+err.Error(), 3000*time.Millisecond)
+				return m, nil
+			}
+			if ok {
+				if verr := s.ValidatePolicy(specPolicy(m.opts)); verr != nil {
+					m.setStatus("dry-run: spec invalid: "+verr.Error(), 3000*time.Millisecond)
 					return m, nil
 				}
-				if ok {
-					pol := spec.DefaultPolicy()
-					pol.AllowShell = m.opts.AllowShell
-					pol.AllowTmuxPassthrough = m.opts.AllowTmuxPassthrough
-					if verr := s.ValidatePolicy(pol); verr != nil {
-						m.setStatus("dry-run: spec invalid: "+verr.Error(), 3000*time.Millisecond)
-						return m, nil
-					}
 
-					eng := templates.NewEngine()
-					eng.Policy.AllowShell = m.opts.AllowShell
-					eng.Policy.AllowTmuxPassthrough = m.opts.AllowTmuxPassthrough
+				eng := specEngine(m.opts)
 
-					ctx := templates.Context{
-						ProjectName: prj.Name,
-						ProjectPath: prj.Path,
-						SessionName: sessionName,
-						WorkingDir:  prj.Path,
-						Env:         s.Env,
-					}
-
-					ts, terr := templates.FromSpec(
-						ctx,
-						*s,
-						m.opts.AllowShell,
-						m.opts.AllowTmuxPassthrough,
-						false, // includeEnsureSession (TUI creates session before applying spec)
-					)
-					if terr != nil {
-						m.setStatus("dry-run: spec compile failed: "+terr.Error(), 3000*time.Millisecond)
-						return m, nil
-					}
+				ctx := templates.Context{
+					ProjectName: prj.Name,
+					ProjectPath: prj.Path,
+					SessionName: sessionName,
+					WorkingDir:  prj.Path,
+					Env:         s.Env,
+					TmuxSocket:  m.opts.Socket,
+				}
 
-					compiled, cerr := eng.Compile(ctx, ts)
-					if cerr != nil {
-						m.setStatus("dry-run: spec compile failed: "+cerr.Error(), 3000*time.Millisecond)
-						return m, nil
-					}
+				ts, terr := templates.FromSpec(
+					ctx,
+					*s,
+					m.opts.AllowShell,
+					m.opts.AllowTmuxPassthrough,
+					m.opts.AllowedTmuxCommands,
+					false, // includeEnsureSession (TUI creates session before applying spec)
+				)
+				if terr != nil {
+					m.setStatus("dry-run: spec compile failed: "+terr.Error(), 3000*time.Millisecond)
+					return m, nil
+				}
 
-					_ = compiled // preview uses compile output; no execution in dry-run
-					m.setStatus("dry-run: would create session "+sessionName+" from spec", 2500*time.Millisecond)
+				compiled, cerr := eng.Compile(ctx, ts)
+				if cerr != nil {
+					m.setStatus("dry-run: spec compile failed: "+cerr.Error(), 3000*time.Millisecond)
 					return m, nil
 				}
-			}
 
-			m.setStatus("dry-run: would create session "+sessionName+" using template "+m.template.String(), 2500*time.Millisecond)
-			return m, nil
+				_ = compiled // preview uses compile output; no execution in dry-run
+				m.setStatus("dry-run: would create session "+sessionName+" from spec", 2500*time.Millisecond)
+				return m, nil
+			}
 		}
 
+		m.setStatus("dry-run: would create session "+sessionName+" using template "+m.template.String(), 2500*time.Millisecond)
+		return m, nil
+	}
+
+	if m.opts.DryRun {
+		m.setStatus("dry-run: would switch to "+sessionName, 2000*time.Millisecond)
+		return m, nil
+	}
+
+	note, err := openProjectSession(m.opts, prj, sessionName, m.template)
+	if err != nil {
+		m.setStatus(err.Error(), 2500*time.Millisecond)
+		return m, nil
+	}
+	if note != "" {
+		m.setStatus(note, 2500*time.Millisecond)
+	} else {
+		m.setStatus("switched to "+sessionName, 1000*time.Millisecond)
+	}
+	return m, tea.Quit
+}
+
+// openProjectSession switches to a project's session, creating it first when needed from the
+// project spec (if enabled and present) or the built-in template tpl. Spec/template problems are not
+// fatal (the session still opens) and come back as note.
+func openProjectSession(opts UIOptions, prj projectItem, sessionName string, tpl templateKind) (note string, err error) {
+	if exists, _ := tmuxHasSession(sessionName); !exists {
 		if err := tmuxNewSessionDetached(sessionName, prj.Path); err != nil {
-			m.setStatus("create failed: "+err.Error(), 2500*time.Millisecond)
-			return m, nil
+			return "", fmt.Errorf("create failed: %w", err)
 		}
 
 		// Prefer project-local spec iff enabled.
 		usedSpec := false
-		if m.opts.PreferProjectSpec {
-			s, _, ok, err := spec.LoadProjectLocalWithNames(prj.Path, m.opts.ProjectSpecNames)
+		if opts.PreferProjectSpec {
+			s, _, ok, err := spec.LoadProjectLocalWithNames(prj.Path, opts.ProjectSpecNames)
 			if err != nil {
-				m.setStatus("spec load failed: "+err.Error(), 2500*time.Millisecond)
+				note = "spec load failed: " + err.Error()
 			} else if ok {
-				pol := spec.DefaultPolicy()
-				pol.AllowShell = m.opts.AllowShell
-				pol.AllowTmuxPassthrough = m.opts.AllowTmuxPassthrough
-				if verr := s.ValidatePolicy(pol); verr != nil {
-					m.setStatus("spec invalid: "+verr.Error(), 2500*time.Millisecond)
+				if verr := s.ValidatePolicy(specPolicy(opts)); verr != nil {
+					note = "spec invalid: " + verr.Error()
 				} else {
-					eng := templates.NewEngine()
-					eng.Policy.AllowShell = m.opts.AllowShell
-					eng.Policy.AllowTmuxPassthrough = m.opts.AllowTmuxPassthrough
-					eng.Runner = &templates.TmuxExecRunner{} // executes `tmux <args...>`
+					eng := specEngine(opts)
+					eng.Runner = &templates.TmuxExecRunner{Timeout: opts.CommandTimeout, Socket: opts.Socket, Log: opts.Log} // executes `tmux <args...>`
 
 					ctx := templates.Context{
 						ProjectName: prj.Name,
@@ -773,26 +1530,32 @@ func (m model) projectAccept() (tea.Model, tea.Cmd) {
 						SessionName: sessionName,
 						WorkingDir:  prj.Path,
 						Env:         s.Env,
+						TmuxSocket:  opts.Socket,
 					}
 
 					ts, terr := templates.FromSpec(
 						ctx,
 						*s,
-						m.opts.AllowShell,
-						m.opts.AllowTmuxPassthrough,
+						opts.AllowShell,
+						opts.AllowTmuxPassthrough,
+						opts.AllowedTmuxCommands,
 						false, // includeEnsureSession (TUI creates session before applying spec)
 					)
 					if terr != nil {
-						m.setStatus("spec apply failed: "+terr.Error(), 2500*time.Millisecond)
+						note = "spec apply failed: " + terr.Error()
 					} else {
 						compiled, cerr := eng.Compile(ctx, ts)
 						if cerr != nil {
-							m.setStatus("spec apply failed: "+cerr.Error(), 2500*time.Millisecond)
+							note = "spec apply failed: " + cerr.Error()
 						} else {
-							if _, eerr := eng.Execute(compiled, false); eerr != nil {
-								m.setStatus("spec apply failed: "+eerr.Error(), 2500*time.Millisecond)
+							lines, eerr := eng.Execute(compiled, false)
+							if eerr != nil {
+								note = "spec apply failed: " + eerr.Error()
 							} else {
 								usedSpec = true
+								if ws := execWarnings(lines, len(templates.DryRunLines(compiled))); len(ws) > 0 {
+									note = "warning: " + ws[0]
+								}
 							}
 						}
 					}
@@ -802,46 +1565,98 @@ func (m model) projectAccept() (tea.Model, tea.Cmd) {
 
 		// Fallback to built-in template if we did not use a spec.
 		if !usedSpec {
-			if err := applyTemplate(sessionName, prj.Path, m.template); err != nil {
-				m.setStatus("template failed: "+err.Error(), 2500*time.Millisecond)
+			if err := applyTemplate(sessionName, prj.Path, tpl); err != nil {
+				note = "template failed: " + err.Error()
 				// Still allow switching.
 			}
 		}
 	}
 
-	if m.opts.DryRun {
-		m.setStatus("dry-run: would switch to "+sessionName, 2000*time.Millisecond)
-		return m, nil
+	if err := tmuxSwitchClient(sessionName); err != nil {
+		return note, fmt.Errorf("switch failed: %w", err)
 	}
+	return note, nil
+}
 
-	if err := tmuxSwitchClient(sessionName); err != nil {
-		m.setStatus("switch failed: "+err.Error(), 2500*time.Millisecond)
-		return m, nil
+// byScore sorts a filtered list by descending match score, then by position in the unfiltered
+// list (ords), swapping the list and its scores together. The order only depends on the query, so
+// narrowing a previous result gives the same list as a full rescan.
+type byScore struct {
+	n      int
+	scores []int
+	ords   func(i int) int
+	swap   func(i, j int)
+}
+
+func (b byScore) Len() int { return b.n }
+func (b byScore) Less(i, j int) bool {
+	if b.scores[i] != b.scores[j] {
+		return b.scores[i] > b.scores[j]
 	}
-	m.setStatus("switched to "+sessionName, 1000*time.Millisecond)
-	return m, tea.Quit
+	return b.ords(i) < b.ords(j)
+}
+func (b byScore) Swap(i, j int) {
+	b.scores[i], b.scores[j] = b.scores[j], b.scores[i]
+	b.swap(i, j)
 }
 
 func (m *model) recomputeFilter() {
-	q := strings.TrimSpace(m.input.Value())
+	q := strings.ToLower(strings.TrimSpace(m.input.Value()))
+
+	// Ordered-subsequence matching is monotonic: anything matching "abc" also matches "ab".
+	// So when the query only grew, narrow the current results in place instead of rescanning
+	// the full (possibly huge) dataset. The backing arrays of the filtered slices are reused
+	// to avoid reallocating on every keystroke.
+	narrow := m.filterValid && m.filterQuery != "" && strings.HasPrefix(q, m.filterQuery)
+
 	if q == "" {
-		m.filteredSessions = append([]sessionItem(nil), m.sessions...)
-		m.filteredProjects = append([]projectItem(nil), m.projects...)
+		m.filteredSessions = append(m.filteredSessions[:0], m.sessions...)
+		m.filteredProjects = append(m.filteredProjects[:0], m.projects...)
 	} else {
-		m.filteredSessions = m.filteredSessions[:0]
-		for _, s := range m.sessions {
-			if fuzzyContains(strings.ToLower(s.Name), strings.ToLower(q)) {
-				m.filteredSessions = append(m.filteredSessions, s)
+		srcSessions := m.sessions
+		srcProjects := m.projects
+		if narrow {
+			srcSessions = m.filteredSessions
+			srcProjects = m.filteredProjects
+		} else {
+			for i := range m.sessions {
+				m.sessions[i].ord = i
+			}
+			for i := range m.projects {
+				m.projects[i].ord = i
+			}
+		}
+		needle := []rune(q)
+
+		// Best match first; ties keep the underlying (tmux / scan) order.
+		dstSessions := m.filteredSessions[:0]
+		var sessionScores []int
+		for _, s := range srcSessions {
+			if sc, ok := fuzzyScore(s.nameRunes, needle); ok {
+				dstSessions = append(dstSessions, s)
+				sessionScores = append(sessionScores, sc)
 			}
 		}
-		m.filteredProjects = m.filteredProjects[:0]
-		for _, p := range m.projects {
-			hay := strings.ToLower(p.Name + " " + p.Path)
-			if fuzzyContains(hay, strings.ToLower(q)) {
-				m.filteredProjects = append(m.filteredProjects, p)
+		sort.Sort(byScore{len(dstSessions), sessionScores, func(i int) int { return dstSessions[i].ord }, func(i, j int) {
+			dstSessions[i], dstSessions[j] = dstSessions[j], dstSessions[i]
+		}})
+		m.filteredSessions = dstSessions
+
+		dstProjects := m.filteredProjects[:0]
+		var projectScores []int
+		for _, p := range srcProjects {
+			if sc, ok := fuzzyScore(p.hay, needle); ok {
+				dstProjects = append(dstProjects, p)
+				projectScores = append(projectScores, sc)
 			}
 		}
+		sort.Sort(byScore{len(dstProjects), projectScores, func(i int) int { return dstProjects[i].ord }, func(i, j int) {
+			dstProjects[i], dstProjects[j] = dstProjects[j], dstProjects[i]
+		}})
+		m.filteredProjects = dstProjects
 	}
+	m.filterQuery = q
+	m.filterValid = true
 
 	// Clamp selection/scroll.
 	max := m.currentListLen()
@@ -854,19 +1669,133 @@ func (m *model) recomputeFilter() {
 	m.scroll = clampInt(m.scroll, 0, max-1)
 }
 
-func (m *model) refreshSessions() {
-	items, err := tmuxListSessions()
+// sessionsLoadedMsg / projectsLoadedMsg carry async refresh results; gen is the refresh generation
+// that produced them.
+type sessionsLoadedMsg struct {
+	gen      int
+	items    []sessionItem
+	err      error
+	noServer bool
+}
+
+type projectsLoadedMsg struct {
+	gen   int
+	items []projectItem
+}
+
+// startRefresh reloads sessions and projects in the background, superseding any refresh still in
+// flight (its results are dropped and its project scan is cancelled). useCache serves projects from
+// the scan cache when it is still valid (see UIOptions.ProjectCacheTTL).
+func (m *model) startRefresh(useCache bool) tea.Cmd {
+	sessions := m.refreshSessionsCmd()
+	m.projectsGen++
+	if m.projectsCancel != nil {
+		m.projectsCancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.projectsCancel = cancel
+	m.scanning = true
+
+	projectsGen := m.projectsGen
+	roots, depth := projectScanRoots(m.opts)
+	ignore := ignoreDirSet(m.opts.IgnoreDirNames)
+	markers := markerSet(m.opts.ProjectMarkers)
+	ttl := m.opts.ProjectCacheTTL
+	preferSpec, specNames := m.opts.PreferProjectSpec, m.opts.ProjectSpecNames
+	return tea.Batch(
+		sessions,
+		func() tea.Msg {
+			items := scanProjectsCached(ctx, roots, depth, ignore, markers, ttl, useCache)
+			for i := range items {
+				items[i].sessionName = resolveProjectSessionName(items[i], preferSpec, specNames)
+			}
+			return projectsLoadedMsg{gen: projectsGen, items: items}
+		},
+	)
+}
+
+// refreshSessionsCmd reloads only the sessions in the background (after kill/rename/new window),
+// superseding a session load still in flight.
+func (m *model) refreshSessionsCmd() tea.Cmd {
+	m.sessionsGen++
+	gen := m.sessionsGen
+	return func() tea.Msg {
+		items, err := tmuxListSessions()
+		return sessionsLoadedMsg{gen: gen, items: items, err: err, noServer: err != nil && !tuiTmux.ServerReachable()}
+	}
+}
+
+func (m *model) applySessions(items []sessionItem, err error, noServer bool) {
 	if err != nil {
 		m.sessions = nil
+		m.filterValid = false
+		if noServer {
+			m.noServer = true
+			return
+		}
 		m.setStatus("tmux list-sessions failed: "+err.Error(), 3000*time.Millisecond)
 		return
 	}
+	m.noServer = false
 	m.sessions = items
+	sortSessions(m.sessions, m.opts.SessionSort)
+	m.filterValid = false
+}
+
+// sessionSorts is the order `o` cycles through (see config.NormalizeSessionSort).
+var sessionSorts = []string{"name", "activity", "windows", "mru"}
+
+// sessionLess is the sessions comparator for a sort mode: name ascending, activity most recent
+// first, windows most first, or mru by rank in mru (recorded sessions first, most recent first).
+// Ties fall back to name so the order is deterministic.
+func sessionLess(mode string, mru map[string]int) func(a, b sessionItem) bool {
+	switch mode {
+	case "mru":
+		return func(a, b sessionItem) bool {
+			ra, aok := mru[a.Name]
+			rb, bok := mru[b.Name]
+			if aok != bok {
+				return aok
+			}
+			if aok && ra != rb {
+				return ra < rb
+			}
+			return a.Name < b.Name
+		}
+	case "activity":
+		return func(a, b sessionItem) bool {
+			if a.LastActivity != b.LastActivity {
+				return a.LastActivity > b.LastActivity
+			}
+			return a.Name < b.Name
+		}
+	case "windows":
+		return func(a, b sessionItem) bool {
+			if a.Windows != b.Windows {
+				return a.Windows > b.Windows
+			}
+			return a.Name < b.Name
+		}
+	default:
+		return func(a, b sessionItem) bool { return a.Name < b.Name }
+	}
+}
+
+func sortSessions(items []sessionItem, mode string) {
+	var mru map[string]int
+	if mode == "mru" {
+		if path, err := mruPath(); err == nil {
+			mru = mruRanks(loadMRU(path))
+		}
+	}
+	less := sessionLess(mode, mru)
+	sort.SliceStable(items, func(i, j int) bool { return less(items[i], items[j]) })
 }
 
-func (m *model) refreshProjects() {
-	paths := m.opts.ProjectsPaths
-	depth := m.opts.ProjectScanDepth
+// projectScanRoots returns the roots and depth to scan, with defaults applied.
+func projectScanRoots(opts UIOptions) ([]string, int) {
+	paths := opts.ProjectsPaths
+	depth := opts.ProjectScanDepth
 
 	// Default roots if still empty.
 	if len(paths) == 0 {
@@ -882,9 +1811,7 @@ func (m *model) refreshProjects() {
 	if depth <= 0 {
 		depth = 2
 	}
-
-	projects := scanProjects(paths, depth)
-	m.projects = projects
+	return paths, depth
 }
 
 func (m *model) move(delta int) {
@@ -908,45 +1835,143 @@ func (m *model) move(delta int) {
 	}
 }
 
-func (m *model) gotoTop() {
-	if m.currentListLen() <= 0 {
-		m.selected = 0
-		m.scroll = 0
-		return
-	}
-	m.selected = 0
-	m.scroll = 0
+func (m *model) gotoTop() {
+	if m.currentListLen() <= 0 {
+		m.selected = 0
+		m.scroll = 0
+		return
+	}
+	m.selected = 0
+	m.scroll = 0
+}
+
+func (m *model) gotoBottom() {
+	n := m.currentListLen()
+	if n <= 0 {
+		m.selected = 0
+		m.scroll = 0
+		return
+	}
+	m.selected = n - 1
+	visible := m.visibleListHeight()
+	if visible <= 0 {
+		visible = 10
+	}
+	m.scroll = clampInt(n-visible, 0, n-1)
+}
+
+func (m *model) pageDown() {
+	visible := m.visibleListHeight()
+	if visible <= 0 {
+		visible = 10
+	}
+	m.move(visible / 2)
+}
+
+func (m *model) pageUp() {
+	visible := m.visibleListHeight()
+	if visible <= 0 {
+		visible = 10
+	}
+	m.move(-(visible / 2))
+}
+
+const (
+	defaultPreviewLines = 12
+	minPreviewLines     = 3
+)
+
+// maxPreviewLines is the tallest preview that still leaves the minimum list height (4 rows) plus
+// header/footer/help on screen. Before the first size message there is no terminal bound.
+func (m model) maxPreviewLines() int {
+	if m.height <= 0 {
+		return 200
+	}
+	help := 0
+	if m.showHelp {
+		help = 8
+	}
+	return maxIntTUI(minPreviewLines, m.height-3-2-help-4-2)
+}
+
+// handlePreviewKeys handles the keys that act on the focused preview; false lets the key through
+// to the list bindings (enter, q, ...).
+func (m *model) handlePreviewKeys(k tea.KeyMsg) bool {
+	page := m.opts.PreviewLines
+	switch k.String() {
+	case "j", "down":
+		m.scrollPreview(1)
+	case "k", "up":
+		m.scrollPreview(-1)
+	case "ctrl+d":
+		m.scrollPreview(page / 2)
+	case "ctrl+u":
+		m.scrollPreview(-page / 2)
+	case "ctrl+f", "pgdown":
+		m.scrollPreview(page)
+	case "ctrl+b", "pgup":
+		m.scrollPreview(-page)
+	case "g":
+		m.scrollPreviewTo(0)
+	case "G":
+		m.scrollPreviewTo(math.MaxInt32)
+	case "esc", "shift+tab", "ctrl+w":
+		m.previewFocus = false
+		m.setStatus("focus: list", 800*time.Millisecond)
+	case "/":
+		m.previewFocus = false
+		return false
+	default:
+		return false
+	}
+	return true
+}
+
+// previewTailLines is how much of the active pane the preview captures while focused, so there
+// is a longer tail to scroll through.
+const previewTailLines = 200
+
+// previewKey identifies what the preview shows: a scroll offset only applies to the item it was
+// made on, so moving the selection starts the next preview at the top.
+func (m model) previewKey() string {
+	if m.mode == modeProjects {
+		return "project:" + m.currentProject().Path
+	}
+	return "session:" + m.currentSessionName()
+}
+
+// clampPreviewScroll bounds a preview offset: 0 at the top, and at the bottom the last page of
+// height lines stays full (0 when all total lines fit).
+func clampPreviewScroll(offset, total, height int) int {
+	return clampInt(offset, 0, maxIntTUI(0, total-height))
 }
 
-func (m *model) gotoBottom() {
-	n := m.currentListLen()
-	if n <= 0 {
-		m.selected = 0
-		m.scroll = 0
-		return
-	}
-	m.selected = n - 1
-	visible := m.visibleListHeight()
-	if visible <= 0 {
-		visible = 10
+// previewOffset is the scroll offset for the current preview (unclamped; View clamps it).
+func (m model) previewOffset() int {
+	if m.previewScrollKey != m.previewKey() {
+		return 0
 	}
-	m.scroll = clampInt(n-visible, 0, n-1)
+	return m.previewScroll
 }
 
-func (m *model) pageDown() {
-	visible := m.visibleListHeight()
-	if visible <= 0 {
-		visible = 10
-	}
-	m.move(visible / 2)
+func (m *model) scrollPreview(delta int) {
+	m.scrollPreviewTo(m.previewOffset() + delta)
 }
 
-func (m *model) pageUp() {
-	visible := m.visibleListHeight()
-	if visible <= 0 {
-		visible = 10
-	}
-	m.move(-(visible / 2))
+// scrollPreviewTo sets the preview offset, clamped to the current preview text.
+func (m *model) scrollPreviewTo(offset int) {
+	total := len(strings.Split(m.previewText(), "\n"))
+	m.previewScroll = clampPreviewScroll(offset, total, m.opts.PreviewLines)
+	m.previewScrollKey = m.previewKey()
+}
+
+// resizePreview grows/shrinks the preview by delta lines (showing it if hidden); the list height
+// follows via visibleListHeight.
+func (m *model) resizePreview(delta int) {
+	m.showPreview = true
+	m.opts.PreviewLines = clampInt(m.opts.PreviewLines+delta, minPreviewLines, m.maxPreviewLines())
+	m.scroll = clampInt(m.scroll, 0, maxIntTUI(0, m.currentListLen()-1))
+	m.setStatus(fmt.Sprintf("preview: %d lines", m.opts.PreviewLines), 800*time.Millisecond)
 }
 
 func (m model) visibleListHeight() int {
@@ -967,6 +1992,10 @@ func (m model) visibleListHeight() int {
 		preview = m.opts.PreviewLines + 2
 	}
 	list := h - header - footer - help - preview
+	if m.mode == modeProjects {
+		// Each project renders a name line and a path line.
+		list /= 2
+	}
 	list = clampInt(list, 4, m.opts.MaxResults)
 	return list
 }
@@ -990,6 +2019,40 @@ func (m model) currentSessionName() string {
 	return m.filteredSessions[m.selected].Name
 }
 
+// projectSessionName is the session a project opens as: the same naming as --project/--spec
+// (spec session.name or prefix when the project has a spec, else the directory name). It reads
+// the project's spec; render paths use rowSessionName.
+func (m model) projectSessionName(p projectItem) string {
+	return resolveProjectSessionName(p, m.opts.PreferProjectSpec, m.opts.ProjectSpecNames)
+}
+
+// rowSessionName is p's session name as resolved when the projects were loaded, falling back to
+// projectSessionName for items that weren't.
+func (m model) rowSessionName(p projectItem) string {
+	if p.sessionName != "" {
+		return p.sessionName
+	}
+	return m.projectSessionName(p)
+}
+
+func resolveProjectSessionName(p projectItem, preferSpec bool, specNames []string) string {
+	var s *spec.Spec
+	if preferSpec {
+		s, _, _, _ = spec.LoadProjectLocalWithNames(p.Path, specNames)
+	}
+	return resolveApplySessionName(s, "", p.Name, p.Path)
+}
+
+// hasLoadedSession reports whether name is in the sessions from the last load.
+func (m model) hasLoadedSession(name string) bool {
+	for _, s := range m.sessions {
+		if s.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (m model) currentProject() projectItem {
 	if m.mode != modeProjects {
 		return projectItem{}
@@ -1013,10 +2076,10 @@ func (m model) View() string {
 	var b strings.Builder
 
 	// Styles
-	titleStyle := lipgloss.NewStyle().Bold(true)
-	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
-	hlStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("12")).Bold(true)
-	warnStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("9")).Bold(true)
+	titleStyle := m.theme.Title
+	dimStyle := m.theme.Dim
+	hlStyle := m.theme.Highlight
+	warnStyle := m.theme.Warn
 
 	modeLabel := "sessions"
 	if m.mode == modeProjects {
@@ -1044,12 +2107,42 @@ func (m model) View() string {
 	if m.newMode {
 		fmt.Fprintf(&b, "%s %s\n", hlStyle.Render("new>"), m.newValue)
 	}
-	if m.confirmKill {
-		name := m.currentSessionName()
+	if m.confirmKill && len(m.killBatch) > 0 {
+		n := strconv.Itoa(len(m.killBatch))
+		if m.confirmKillPhrase("") != "" {
+			fmt.Fprintf(&b, "%s %s\n", warnStyle.Render("kill?"), "Type \"yes\" and press enter to kill "+n+" "+m.killBatchKind+" sessions (esc cancels): "+m.confirmValue)
+		} else {
+			fmt.Fprintf(&b, "%s %s\n", warnStyle.Render("kill?"), "Kill "+n+" "+m.killBatchKind+" sessions: "+strings.Join(m.killBatch, ", ")+" (y/n)")
+		}
+	} else if m.confirmKill {
+		name := m.killName
 		if name == "" {
 			name = "<none>"
 		}
-		fmt.Fprintf(&b, "%s %s\n", warnStyle.Render("kill?"), "Kill session "+name+" (y/n)")
+		if phrase := m.confirmKillPhrase(name); phrase != "" {
+			fmt.Fprintf(&b, "%s %s\n", warnStyle.Render("kill?"), "Type "+strconv.Quote(phrase)+" and press enter to kill session "+name+" (esc cancels): "+m.confirmValue)
+		} else {
+			fmt.Fprintf(&b, "%s %s\n", warnStyle.Render("kill?"), "Kill session "+name+" (y/n)")
+		}
+	}
+
+	if m.confirmKillAttached {
+		what := m.killName
+		if len(m.killBatch) > 0 {
+			what = strconv.Itoa(len(m.killBatch)) + " marked sessions"
+		}
+		fmt.Fprintf(&b, "%s %s\n", warnStyle.Render("kill?"), "This client's session is being killed: switch to "+m.killFallback+" and kill "+what+" (y/n)")
+	}
+
+	if m.confirmScaffold != "" {
+		fmt.Fprintf(&b, "%s %s\n", warnStyle.Render("overwrite?"), "Replace "+m.confirmScaffold+" with a starter spec (template: "+m.template.String()+") (y/n)")
+	}
+
+	if m.confirmReplace != "" {
+		fmt.Fprintf(&b, "%s %s\n", warnStyle.Render("replace?"), "Tear down session "+m.confirmReplace+" and rebuild it from the project spec (y/n)")
+	}
+	if m.confirmUnsafe != nil {
+		fmt.Fprintf(&b, "%s %s\n", warnStyle.Render("unsafe?"), m.confirmUnsafe.spec.SpecPath+" runs shell / tmux passthrough actions: run it and trust them from now on (y/n)")
 	}
 
 	// List
@@ -1060,19 +2153,22 @@ func (m model) View() string {
 
 	switch m.mode {
 	case modeSessions:
-		if len(m.filteredSessions) == 0 {
+		if m.noServer {
+			fmt.Fprintf(&b, "%s\n", dimStyle.Render("(no tmux server running; tab to pick a project)"))
+		} else if len(m.filteredSessions) == 0 {
 			fmt.Fprintf(&b, "%s\n", dimStyle.Render("(no sessions)"))
 		} else {
 			end := minIntTUI(len(m.filteredSessions), m.scroll+listH)
 			for i := m.scroll; i < end; i++ {
 				s := m.filteredSessions[i]
 				prefix := "  "
-				lineStyle := lipgloss.NewStyle()
+				lineStyle := m.theme.Item
 				if i == m.selected {
 					prefix = "> "
-					lineStyle = lineStyle.Bold(true).Foreground(lipgloss.Color("15"))
-				} else {
-					lineStyle = lineStyle.Foreground(lipgloss.Color("7"))
+					lineStyle = m.theme.Selected
+				}
+				if m.marked[s.Name] {
+					prefix = prefix[:1] + "*"
 				}
 
 				meta := ""
@@ -1083,51 +2179,71 @@ func (m model) View() string {
 					meta = fmt.Sprintf(" [%dw]%s", s.Windows, meta)
 				}
 
-				fmt.Fprintf(&b, "%s%s\n", prefix, lineStyle.Render(s.Name+meta))
+				fmt.Fprintf(&b, "%s%s\n", prefix, lineStyle.Render(fitWidth(s.Name+meta, m.width-2)))
 			}
 		}
 
 	case modeProjects:
 		if len(m.filteredProjects) == 0 {
-			fmt.Fprintf(&b, "%s\n", dimStyle.Render("(no projects found)"))
+			if m.scanning {
+				fmt.Fprintf(&b, "%s\n", dimStyle.Render("(scanning projects…)"))
+			} else {
+				fmt.Fprintf(&b, "%s\n", dimStyle.Render("(no projects found)"))
+			}
 		} else {
 			end := minIntTUI(len(m.filteredProjects), m.scroll+listH)
 			for i := m.scroll; i < end; i++ {
 				p := m.filteredProjects[i]
 				prefix := "  "
-				lineStyle := lipgloss.NewStyle()
+				lineStyle := m.theme.Item
 				if i == m.selected {
 					prefix = "> "
-					lineStyle = lineStyle.Bold(true).Foreground(lipgloss.Color("15"))
-				} else {
-					lineStyle = lineStyle.Foreground(lipgloss.Color("7"))
+					lineStyle = m.theme.Selected
 				}
 
-				sessionName := sanitizeSessionName(p.Name)
-				if sessionName == "" {
-					sessionName = "project"
+				sessionName := m.rowSessionName(p)
+				// Truncate the plain text first (styles add escape codes, not cells); the name keeps
+				// priority over the session/template hint.
+				name := fitWidth(p.Name, m.width-2)
+				meta := "  → " + sessionName + "  [" + m.template.String() + "]"
+				if m.width > 0 {
+					// fitWidth treats 0 as unlimited; a name filling the line leaves no room at all.
+					if rest := m.width - 2 - ansi.StringWidth(name); rest > 0 {
+						meta = fitWidth(" "+meta, rest)
+					} else {
+						meta = ""
+					}
+				} else {
+					meta = " " + meta
 				}
-				meta := dimStyle.Render("  → " + sessionName + "  [" + m.template.String() + "]")
-				fmt.Fprintf(&b, "%s%s\n", prefix, lineStyle.Render(p.Name)+" "+meta)
-				fmt.Fprintf(&b, "%s%s\n", "  ", dimStyle.Render(p.Path))
+				fmt.Fprintf(&b, "%s%s\n", prefix, lineStyle.Render(name)+dimStyle.Render(meta))
+				fmt.Fprintf(&b, "%s%s\n", "  ", dimStyle.Render(fitWidth(p.Path, m.width-2)))
 			}
 		}
 	}
 
 	// Preview
 	if m.showPreview {
-		fmt.Fprintf(&b, "\n%s\n", dimStyle.Render("preview"))
-		fmt.Fprintf(&b, "%s\n", dimStyle.Render(strings.Repeat("-", clampInt(m.width, 30, 120))))
 		prev := m.previewText()
 		if prev == "" {
 			prev = "(no preview)"
 		}
 		lines := strings.Split(prev, "\n")
+		off := clampPreviewScroll(m.previewOffset(), len(lines), m.opts.PreviewLines)
+		end := minIntTUI(len(lines), off+m.opts.PreviewLines)
+
+		title := "preview"
 		if len(lines) > m.opts.PreviewLines {
-			lines = lines[:m.opts.PreviewLines]
+			title += fmt.Sprintf(" %d-%d/%d", off+1, end, len(lines))
+		}
+		if m.previewFocus {
+			fmt.Fprintf(&b, "\n%s\n", hlStyle.Render(title+" (focused · esc back)"))
+		} else {
+			fmt.Fprintf(&b, "\n%s\n", dimStyle.Render(title))
 		}
-		for _, ln := range lines {
-			fmt.Fprintf(&b, "%s\n", dimStyle.Render(ln))
+		fmt.Fprintf(&b, "%s\n", dimStyle.Render(strings.Repeat("-", separatorWidth(m.width))))
+		for _, ln := range lines[off:end] {
+			fmt.Fprintf(&b, "%s\n", dimStyle.Render(fitWidth(ln, m.width)))
 		}
 	}
 
@@ -1135,20 +2251,60 @@ func (m model) View() string {
 	if m.showHelp {
 		fmt.Fprintf(&b, "\n%s\n", hlStyle.Render("help"))
 		fmt.Fprintf(&b, "%s\n", dimStyle.Render("j/k move · gg/G top/bottom · ctrl-u/d page · / search · tab toggle mode"))
-		fmt.Fprintf(&b, "%s\n", dimStyle.Render("enter switch/attach/create · d kill (confirm) · r rename · n new session · w create from project · e edit (snapshot+new)"))
-		fmt.Fprintf(&b, "%s\n", dimStyle.Render("t cycle template (node/python/go/empty) · p preview · q quit"))
+		fmt.Fprintf(&b, "%s\n", dimStyle.Render("enter switch/attach/create · o sort · space mark · d kill (confirm; marked if any) · I kill idle · r rename · n new session · P new window at the project root · w create from project · W rebuild from spec · e edit (snapshot+new)"))
+		fmt.Fprintf(&b, "%s\n", dimStyle.Render("E edit project spec in $EDITOR · S write a starter spec from the template (projects mode)"))
+		fmt.Fprintf(&b, "%s\n", dimStyle.Render("t cycle template (node/python/go/rust/empty) · p preview · +/- preview height · shift+tab/ctrl+w focus preview (j/k, ctrl+f/b scroll) · R reload/rescan · q quit"))
 	}
 
 	// Footer / status
 	if m.status != "" && time.Now().Before(m.statusUntil) {
 		fmt.Fprintf(&b, "\n%s\n", dimStyle.Render(m.status))
 	} else {
-		fmt.Fprintf(&b, "\n%s\n", dimStyle.Render("R refresh · template: "+m.template.String()))
+		footer := "R refresh · o sort: " + m.opts.SessionSort + " · template: " + m.template.String()
+		if m.scanning {
+			footer = "scanning… · " + footer
+		}
+		fmt.Fprintf(&b, "\n%s\n", dimStyle.Render(footer))
 	}
 
 	return b.String()
 }
 
+// fitWidth truncates s to w terminal cells (wide CJK/emoji glyphs count as 2), ending in "…" when
+// cut, so rows never wrap. w <= 0 means the size isn't known yet and s is returned unchanged.
+func fitWidth(s string, w int) string {
+	if w <= 0 {
+		return s
+	}
+	return ansi.Truncate(s, w, "…")
+}
+
+// separatorWidth is the preview rule width: the terminal width capped at 120 (30 before the first
+// size message), never wider than the terminal.
+func separatorWidth(termWidth int) int {
+	if termWidth <= 0 {
+		return 30
+	}
+	return minIntTUI(termWidth, 120)
+}
+
+// sessionPreview is the live preview of session name: its windows and the active pane's tail.
+func (m model) sessionPreview(name string) string {
+	out, err := tmuxCaptureSessionSummary(name)
+	if err != nil {
+		return "preview error: " + err.Error()
+	}
+
+	tailLines := clampInt(m.opts.PreviewLines, 5, 40)
+	if m.previewFocus {
+		tailLines = previewTailLines
+	}
+	if tail, terr := tmuxCaptureSessionActivePaneTail(name, tailLines); terr == nil && strings.TrimSpace(tail) != "" {
+		return out + "\n\npane tail:\n" + strings.TrimRight(tail, "\n")
+	}
+	return out
+}
+
 func (m model) previewText() string {
 	switch m.mode {
 	case modeSessions:
@@ -1159,7 +2315,7 @@ func (m model) previewText() string {
 			if q == "" {
 				return ""
 			}
-			sn := sanitizeSessionName(q)
+			sn := spec.SessionNameSlug(q)
 			if sn == "" {
 				return "new session:\n  (invalid name)"
 			}
@@ -1170,15 +2326,7 @@ func (m model) previewText() string {
 			return "new session:\n  " + sn
 		}
 
-		out, err := tmuxCaptureSessionSummary(name)
-		if err != nil {
-			return "preview error: " + err.Error()
-		}
-
-		if tail, terr := tmuxCaptureSessionActivePaneTail(name, clampInt(m.opts.PreviewLines, 5, 40)); terr == nil && strings.TrimSpace(tail) != "" {
-			return out + "\n\npane tail:\n" + strings.TrimRight(tail, "\n")
-		}
-		return out
+		return m.sessionPreview(name)
 
 	case modeProjects:
 		p := m.currentProject()
@@ -1186,6 +2334,14 @@ func (m model) previewText() string {
 			return ""
 		}
 
+		// enter switches to a running session instead of applying anything: show it live. The
+		// last sessions load says whether it runs (no tmux call per render).
+		if sn := m.rowSessionName(p); sn != "" {
+			if m.hasLoadedSession(sn) {
+				return "session " + sn + " is running (enter switches to it)\n\n" + m.sessionPreview(sn)
+			}
+		}
+
 		// Show "execution path" preview:
 		// - spec presence (yaml/json) (only if PreferProjectSpec is enabled)
 		// - safety mode (actions-only vs shell enabled vs tmux passthrough)
@@ -1204,7 +2360,7 @@ func (m model) previewText() string {
 			b.WriteString(" - disabled (PreferProjectSpec=false)\n")
 			b.WriteString(" - using built-in template: " + m.template.String() + "\n")
 			b.WriteString("\nplanned operations:\n")
-			b.WriteString(renderHardcodedTemplatePlan(sanitizeSessionName(p.Name), p.Path, m.template))
+			b.WriteString(renderHardcodedTemplatePlan(spec.SessionNameSlug(p.Name), p.Path, m.template))
 			return b.String()
 		}
 
@@ -1217,7 +2373,7 @@ func (m model) previewText() string {
 			b.WriteString(" - none (fallback: built-in template)\n")
 			b.WriteString(" - template: " + m.template.String() + "\n")
 			b.WriteString("\nplanned operations:\n")
-			b.WriteString(renderHardcodedTemplatePlan(sanitizeSessionName(p.Name), p.Path, m.template))
+			b.WriteString(renderHardcodedTemplatePlan(spec.SessionNameSlug(p.Name), p.Path, m.template))
 			return b.String()
 		}
 
@@ -1230,22 +2386,14 @@ func (m model) previewText() string {
 			b.WriteString(" - safety override: tmux passthrough ENABLED (TMUX_SESSION_MANAGER_ALLOW_TMUX_PASSTHROUGH=1)\n")
 		}
 
-		sessionName := sanitizeSessionName(p.Name)
-		if sessionName == "" {
-			sessionName = "project"
-		}
+		sessionName := resolveApplySessionName(s, "", p.Name, p.Path)
 
-		pol := spec.DefaultPolicy()
-		pol.AllowShell = m.opts.AllowShell
-		pol.AllowTmuxPassthrough = m.opts.AllowTmuxPassthrough
-		if verr := s.ValidatePolicy(pol); verr != nil {
+		if verr := s.ValidatePolicy(specPolicy(m.opts)); verr != nil {
 			b.WriteString("\n(spec invalid: " + verr.Error() + ")\n")
 			return b.String()
 		}
 
-		eng := templates.NewEngine()
-		eng.Policy.AllowShell = m.opts.AllowShell
-		eng.Policy.AllowTmuxPassthrough = m.opts.AllowTmuxPassthrough
+		eng := specEngine(m.opts)
 
 		ctx := templates.Context{
 			ProjectName: p.Name,
@@ -1253,6 +2401,7 @@ func (m model) previewText() string {
 			SessionName: sessionName,
 			WorkingDir:  p.Path,
 			Env:         s.Env,
+			TmuxSocket:  m.opts.Socket,
 		}
 
 		ts, terr := templates.FromSpec(
@@ -1260,6 +2409,7 @@ func (m model) previewText() string {
 			*s,
 			m.opts.AllowShell,
 			m.opts.AllowTmuxPassthrough,
+			m.opts.AllowedTmuxCommands,
 			false, // includeEnsureSession (preview assumes TUI creates session first)
 		)
 		if terr != nil {
@@ -1286,11 +2436,67 @@ func (m model) previewText() string {
 
 // ---------- tmux helpers ----------
 
+// defaultTUITmuxTimeout bounds the TUI's tmux commands when UIOptions.CommandTimeout is unset.
+// They are all quick queries or one-shot commands, so a longer wait means a wedged server.
+const defaultTUITmuxTimeout = 5 * time.Second
+
+// tuiTmux runs the TUI's tmux commands. RunTUISelect sets its Timeout from
+// UIOptions.CommandTimeout, so a wedged server surfaces as a status error instead of a hang.
+var tuiTmux = &Tmux{Bin: "tmux", Timeout: defaultTUITmuxTimeout}
+
+// uiSocket is the tmux server the UI targets: opts.Socket, else TMUX_SESSION_MANAGER_SOCKET (the
+// same fallback as --socket). Every TUI tmux helper goes through tuiTmux, which RunTUISelect points
+// at it, so listing, switching and spec applies all agree on the server.
+func uiSocket(opts UIOptions) string {
+	if v := strings.TrimSpace(opts.Socket); v != "" {
+		return v
+	}
+	return strings.TrimSpace(os.Getenv("TMUX_SESSION_MANAGER_SOCKET"))
+}
+
+// specPolicy is the policy project specs are validated against under opts' safety settings.
+func specPolicy(opts UIOptions) spec.Policy {
+	pol := spec.DefaultPolicy()
+	pol.AllowShell = opts.AllowShell
+	pol.AllowTmuxPassthrough = opts.AllowTmuxPassthrough
+	pol.AllowedTmuxCommands = opts.AllowedTmuxCommands
+	return pol
+}
+
+// specEngine is the engine the UI compiles (and, given a Runner, builds) project specs with, so the
+// dry-run, preview, unsafe gate and the build itself all see the same policy and wait settings.
+func specEngine(opts UIOptions) *templates.Engine {
+	eng := templates.NewEngine()
+	eng.Policy.AllowShell = opts.AllowShell
+	eng.Policy.AllowTmuxPassthrough = opts.AllowTmuxPassthrough
+	eng.Policy.AllowedShellPrefixes = opts.AllowedShellPrefixes
+	if len(opts.AllowedTmuxCommands) > 0 {
+		eng.Policy.AllowedTmuxCommands = opts.AllowedTmuxCommands
+	}
+	setLimitCeilings(&eng.Policy, opts.MaxActionsCeiling, opts.MaxCommandLenCeiling)
+	eng.WaitDefaults = opts.WaitDefaults
+	eng.StrictWaitForPrompt = opts.StrictWaitForPrompt
+	return eng
+}
+
+// tuiRunner adapts tuiTmux to templates.Runner for the shared session helpers (KillSession, ...).
+type tuiRunner struct{}
+
+func (tuiRunner) Run(args []string) error { return tuiTmuxRun(args...) }
+
+func (tuiRunner) RunOutput(args []string) (string, error) { return tuiTmux.Output(args...) }
+
+// tuiTmuxRun runs `tmux <args...>` through tuiTmux, capturing its output (it would otherwise
+// draw over the UI).
+func tuiTmuxRun(args ...string) error {
+	_, err := tuiTmux.OutputBytes(args...)
+	return err
+}
+
 func tmuxListSessions() ([]sessionItem, error) {
 	// Use a stable format to parse:
-	// name|windows|attached
-	cmd := exec.Command("tmux", "list-sessions", "-F", "#{session_name}|#{session_windows}|#{?session_attached,1,0}")
-	out, err := cmd.Output()
+	// name|windows|attached|activity
+	out, err := tuiTmux.OutputBytes("list-sessions", "-F", "#{session_name}|#{session_windows}|#{?session_attached,1,0}|#{session_activity}")
 	if err != nil {
 		return nil, err
 	}
@@ -1312,7 +2518,11 @@ func tmuxListSessions() ([]sessionItem, error) {
 		if len(parts) > 2 {
 			it.Attached = strings.TrimSpace(parts[2]) == "1"
 		}
+		if len(parts) > 3 {
+			it.LastActivity, _ = strconv.ParseInt(strings.TrimSpace(parts[3]), 10, 64)
+		}
 		if it.Name != "" {
+			it.nameRunes = []rune(strings.ToLower(it.Name))
 			items = append(items, it)
 		}
 	}
@@ -1325,16 +2535,17 @@ func tmuxHasSession(name string) (bool, error) {
 	if strings.TrimSpace(name) == "" {
 		return false, nil
 	}
-	err := exec.Command("tmux", "has-session", "-t", name).Run()
-	if err == nil {
-		return true, nil
-	}
-	// tmux returns exit 1 when not found.
-	return false, nil
+	return SessionExists(name, SessionOptions{Runner: tuiRunner{}})
 }
 
+// tmuxSwitchClient switches the client to name and records it in the recent-sessions file (the
+// "mru" sessions order).
 func tmuxSwitchClient(name string) error {
-	return exec.Command("tmux", "switch-client", "-t", name).Run()
+	if err := tuiTmuxRun("switch-client", "-t", name); err != nil {
+		return err
+	}
+	noteSessionUsed(name)
+	return nil
 }
 
 func tmuxNewSessionDetached(name string, dir string) error {
@@ -1342,7 +2553,66 @@ func tmuxNewSessionDetached(name string, dir string) error {
 	if strings.TrimSpace(dir) != "" {
 		args = append(args, "-c", dir)
 	}
-	return exec.Command("tmux", args...).Run()
+	return tuiTmuxRun(args...)
+}
+
+// ---------- spec editing ----------
+
+// openProjectSpecInEditor opens the selected project's spec in a new tmux window running $EDITOR.
+//
+// The spec path is resolved via spec.LoadProjectLocalWithNames, so a spec that fails to parse or
+// validate is still opened (that's usually why you want to edit it). If the project has no spec
+// yet, the editor is opened on the first configured spec filename so saving creates it.
+func (m model) openProjectSpecInEditor() (tea.Model, tea.Cmd) {
+	prj := m.currentProject()
+	if prj.Path == "" {
+		m.setStatus("no project selected", 1200*time.Millisecond)
+		return m, nil
+	}
+
+	_, specPath, ok, _ := spec.LoadProjectLocalWithNames(prj.Path, m.opts.ProjectSpecNames)
+	isNew := false
+	if !ok || specPath == "" {
+		name := ".tmux-session.yaml"
+		for _, n := range m.opts.ProjectSpecNames {
+			if strings.TrimSpace(n) != "" {
+				name = strings.TrimSpace(n)
+				break
+			}
+		}
+		specPath = filepath.Join(prj.Path, name)
+		isNew = true
+	}
+
+	if m.opts.DryRun {
+		m.setStatus("dry-run: would open "+specPath+" in editor", 2000*time.Millisecond)
+		return m, nil
+	}
+
+	if err := tmuxOpenEditorWindow(prj.Path, specPath); err != nil {
+		m.setStatus("edit spec failed: "+err.Error(), 2500*time.Millisecond)
+		return m, nil
+	}
+	if isNew {
+		m.setStatus("editing new spec "+specPath, 1500*time.Millisecond)
+	} else {
+		m.setStatus("editing "+specPath, 1500*time.Millisecond)
+	}
+	return m, tea.Quit
+}
+
+// tmuxOpenEditorWindow opens path in $VISUAL/$EDITOR (fallback: vi) in a new window of the
+// current session, rooted at dir.
+func tmuxOpenEditorWindow(dir, path string) error {
+	editor := strings.TrimSpace(os.Getenv("VISUAL"))
+	if editor == "" {
+		editor = strings.TrimSpace(os.Getenv("EDITOR"))
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	cmdLine := editor + " " + shellQuoteSimple(path)
+	return tuiTmuxRun("new-window", "-n", "spec", "-c", dir, "--", "bash", "-lc", cmdLine)
 }
 
 // ---------- edit mode: snapshot current session + new session in current dir ----------
@@ -1366,7 +2636,7 @@ func (m model) editNewSessionInCurrentDir() (tea.Model, tea.Cmd) {
 
 	// Create a new session name derived from dir basename.
 	base := filepath.Base(strings.TrimRight(curDir, string(filepath.Separator)))
-	newName := sanitizeSessionName(base)
+	newName := spec.SessionNameSlug(base)
 	if newName == "" {
 		newName = "edit"
 	}
@@ -1384,7 +2654,7 @@ func (m model) editNewSessionInCurrentDir() (tea.Model, tea.Cmd) {
 	if editor == "" {
 		editor = "nvim ."
 	}
-	_ = exec.Command("tmux", "send-keys", "-t", newName+":", editor, "Enter").Run()
+	_ = tuiTmuxRun("send-keys", "-t", newName+":", editor, "Enter")
 
 	if err := tmuxSwitchClient(newName); err != nil {
 		m.setStatus("edit: switch failed: "+err.Error(), 2500*time.Millisecond)
@@ -1403,23 +2673,23 @@ func (m model) editNewSessionInCurrentDir() (tea.Model, tea.Cmd) {
 }
 
 func tmuxCurrentPanePath() (string, error) {
-	out, err := exec.Command("tmux", "display-message", "-p", "-F", "#{pane_current_path}").Output()
+	out, err := tuiTmux.Output("display-message", "-p", "-F", "#{pane_current_path}")
 	if err != nil {
 		return "", err
 	}
-	return strings.TrimSpace(string(out)), nil
+	return strings.TrimSpace(out), nil
 }
 
 func tmuxCurrentSessionName() (string, error) {
-	out, err := exec.Command("tmux", "display-message", "-p", "-F", "#{session_name}").Output()
+	out, err := tuiTmux.Output("display-message", "-p", "-F", "#{session_name}")
 	if err != nil {
 		return "", err
 	}
-	return strings.TrimSpace(string(out)), nil
+	return strings.TrimSpace(out), nil
 }
 
 func makeUniqueSessionName(base string, maxTries int) string {
-	base = sanitizeSessionName(base)
+	base = spec.SessionNameSlug(base)
 	if base == "" {
 		base = "session"
 	}
@@ -1445,64 +2715,75 @@ func snapshotSessionToSpecFile(sessionName string) (string, error) {
 		return "", errors.New("snapshot: empty session name")
 	}
 
-	home, _ := os.UserHomeDir()
-	if strings.TrimSpace(home) == "" {
-		return "", errors.New("snapshot: no home dir")
+	dir, err := SnapshotDir()
+	if err != nil {
+		return "", err
 	}
-
-	dir := filepath.Join(home, ".config", "tmux-session-manager", "snapshots")
 	if err := os.MkdirAll(dir, 0o700); err != nil {
 		return "", fmt.Errorf("snapshot: mkdir: %w", err)
 	}
 
-	ts := time.Now().Format("20060102-150405")
-	fileName := fmt.Sprintf("%s.%s.tmux-session.yaml", sanitizeSessionName(sessionName), ts)
+	ts := time.Now().Format(snapshotTimestampLayout)
+	fileName := spec.SessionNameSlug(sessionName) + "." + ts + snapshotFileSuffix
 	outPath := filepath.Join(dir, fileName)
 
-	specText, err := tmuxSnapshotAsSpecYAML(sessionName)
+	snap, err := tmuxSnapshotSpec(sessionName)
 	if err != nil {
 		return "", err
 	}
+	specText, err := spec.MarshalYAML(snap)
+	if err != nil {
+		return "", fmt.Errorf("snapshot: %w", err)
+	}
 
-	if err := os.WriteFile(outPath, []byte(specText), defaultSnapshotFileMode); err != nil {
+	if err := os.WriteFile(outPath, specText, defaultSnapshotFileMode); err != nil {
 		return "", fmt.Errorf("snapshot: write: %w", err)
 	}
 
 	return outPath, nil
 }
 
-// tmuxSnapshotAsSpecYAML builds a tmux-session-manager spec that rehydrates the session shape
+// tmuxSnapshotSpec builds a tmux-session-manager spec that rehydrates the session shape
 // (windows, layouts, pane cwd, and current command).
-func tmuxSnapshotAsSpecYAML(sessionName string) (string, error) {
+func tmuxSnapshotSpec(sessionName string) (*spec.Spec, error) {
 	sessionName = strings.TrimSpace(sessionName)
 	if sessionName == "" {
-		return "", errors.New("snapshot: empty session name")
+		return nil, errors.New("snapshot: empty session name")
 	}
 
 	// Get windows (index, name, layout).
 	// Use "index|name|layout".
-	wOut, err := exec.Command(
-		"tmux",
+	wOut, err := tuiTmux.OutputBytes(
 		"list-windows",
 		"-t", sessionName,
 		"-F", "#{window_index}|#{window_name}|#{window_layout}",
-	).Output()
+	)
 	if err != nil {
-		return "", fmt.Errorf("snapshot: list-windows: %w", err)
+		return nil, fmt.Errorf("snapshot: list-windows: %w", err)
 	}
 
 	lines := strings.Split(strings.TrimSpace(string(wOut)), "\n")
 
-	// Start YAML
-	var b strings.Builder
-	b.WriteString("version: 1\n")
-	b.WriteString("session:\n")
-	b.WriteString("  name: \"" + escapeYAMLString(sessionName) + "\"\n")
-	b.WriteString("  root: \"${PROJECT_PATH}\"\n")
-	b.WriteString("  attach: true\n")
-	b.WriteString("  switch_client: true\n")
-	b.WriteString("\n")
-	b.WriteString("windows:\n")
+	// Optional (off by default): record copy-mode / scroll position so the snapshot restores the view.
+	captureMode := parseEnvBool("TMUX_SESSION_MANAGER_SNAPSHOT_PANE_MODE", false)
+
+	// On by default: record each pane's foreground command so the restore re-runs it.
+	captureCommands := parseEnvBool("TMUX_SESSION_MANAGER_SNAPSHOT_COMMANDS", true)
+	var children map[string][]string
+	if captureCommands {
+		children = processArgsByParent()
+	}
+
+	on := true
+	out := &spec.Spec{
+		Version: spec.CurrentVersion,
+		Session: spec.Session{
+			Name:         sessionName,
+			Root:         "${PROJECT_PATH}",
+			Attach:       &on,
+			SwitchClient: &on,
+		},
+	}
 
 	for _, ln := range lines {
 		ln = strings.TrimSpace(ln)
@@ -1517,67 +2798,72 @@ func tmuxSnapshotAsSpecYAML(sessionName string) (string, error) {
 		wName := strings.TrimSpace(parts[1])
 		wLayout := strings.TrimSpace(parts[2])
 
-		// panes: pane_index|pane_title|pane_current_path|pane_current_command
-		pOut, pErr := exec.Command(
-			"tmux",
+		// panes: pane_index|pane_title|pane_current_path|pane_current_command|pane_pid|pane_in_mode|scroll_position
+		// (title/path can't contain '|' reliably anyway; mode fields are only meaningful when captureMode).
+		pOut, pErr := tuiTmux.OutputBytes(
 			"list-panes",
 			"-t", sessionName+":"+wIdx,
-			"-F", "#{pane_index}|#{pane_title}|#{pane_current_path}|#{pane_current_command}",
-		).Output()
+			"-F", "#{pane_index}|#{pane_title}|#{pane_current_path}|#{pane_current_command}|#{pane_pid}|#{pane_in_mode}|#{scroll_position}",
+		)
 		if pErr != nil {
 			// Keep going; emit window without panes.
 			pOut = []byte{}
 		}
 		pLines := strings.Split(strings.TrimSpace(string(pOut)), "\n")
 
-		b.WriteString("  - name: \"" + escapeYAMLString(wName) + "\"\n")
-		b.WriteString("    root: \"${PROJECT_PATH}\"\n")
-		if wLayout != "" {
-			b.WriteString("    layout: \"" + escapeYAMLString(wLayout) + "\"\n")
+		w := spec.Window{
+			Name:   wName,
+			Root:   "${PROJECT_PATH}",
+			Layout: wLayout,
 		}
-		b.WriteString("    panes:\n")
 
 		for _, pl := range pLines {
 			pl = strings.TrimSpace(pl)
 			if pl == "" {
 				continue
 			}
-			pp := strings.SplitN(pl, "|", 4)
+			pp := strings.SplitN(pl, "|", 7)
 			if len(pp) < 4 {
 				continue
 			}
-			pTitle := strings.TrimSpace(pp[1])
-			pCwd := strings.TrimSpace(pp[2])
+			pane := spec.Pane{
+				Name: strings.TrimSpace(pp[1]),
+				Root: strings.TrimSpace(pp[2]),
+			}
 			pCmd := strings.TrimSpace(pp[3])
 
-			b.WriteString("      - name: \"" + escapeYAMLString(pTitle) + "\"\n")
-			if pCwd != "" {
-				b.WriteString("        root: \"" + escapeYAMLString(pCwd) + "\"\n")
+			if captureCommands && len(pp) >= 5 {
+				// Emitted as a `run` action (not `command:`, which is a shell snippet) so the restore
+				// types it with the safe send-keys path and needs no --allow-shell.
+				if argv := snapshotPaneArgv(pCmd, children[strings.TrimSpace(pp[4])]); len(argv) > 0 {
+					pane.Actions = append(pane.Actions, spec.Action{
+						Type: "run",
+						Run:  &spec.RunAction{Program: argv[0], Args: argv[1:]},
+					})
+				}
 			}
 
-			_ = pCmd
+			if captureMode && len(pp) >= 7 && strings.TrimSpace(pp[5]) == "1" {
+				// pane_in_mode is also set for other modes (e.g. choose-tree); scroll_position is
+				// only set in copy-mode, so treat a parsable value as the copy-mode signal.
+				if pos, perr := strconv.Atoi(strings.TrimSpace(pp[6])); perr == nil && pos >= 0 {
+					pane.Restore = &spec.PaneRestore{CopyMode: true, ScrollPosition: pos}
+				}
+			}
+			w.Panes = append(w.Panes, pane)
 		}
+		out.Windows = append(out.Windows, w)
 	}
 
-	return b.String(), nil
-}
-
-func escapeYAMLString(s string) string {
-	// Minimal escape for double-quoted YAML scalars.
-	// Replace backslash and double quote; normalize newlines to spaces.
-	s = strings.ReplaceAll(s, "\\", "\\\\")
-	s = strings.ReplaceAll(s, "\"", "\\\"")
-	s = strings.ReplaceAll(s, "\r", "")
-	s = strings.ReplaceAll(s, "\n", " ")
-	return s
+	return out, nil
 }
 
 func tmuxKillSession(name string) error {
-	return exec.Command("tmux", "kill-session", "-t", name).Run()
+	return KillSession(name, SessionOptions{Runner: tuiRunner{}})
 }
 
 func tmuxRenameSession(from, to string) error {
-	return exec.Command("tmux", "rename-session", "-t", from, to).Run()
+	return RenameSession(from, to, SessionOptions{Runner: tuiRunner{}})
 }
 
 func tmuxCaptureSessionSummary(name string) (string, error) {
@@ -1586,7 +2872,7 @@ func tmuxCaptureSessionSummary(name string) (string, error) {
 	// - active window/pane current path
 	var b strings.Builder
 
-	wOut, err := exec.Command("tmux", "list-windows", "-t", name, "-F", "#{window_index}:#{window_name} #{?window_active,*, } [#{window_panes} panes] (#{window_layout})").Output()
+	wOut, err := tuiTmux.OutputBytes("list-windows", "-t", name, "-F", "#{window_index}:#{window_name} #{?window_active,*, } [#{window_panes} panes] (#{window_layout})")
 	if err != nil {
 		return "", err
 	}
@@ -1594,7 +2880,7 @@ func tmuxCaptureSessionSummary(name string) (string, error) {
 	b.WriteString(strings.TrimRight(string(wOut), "\n"))
 	b.WriteString("\n")
 
-	pOut, err := exec.Command("tmux", "display-message", "-p", "-t", name, "active: #{session_name}:#{window_index}.#{pane_index}  path=#{pane_current_path}  cmd=#{pane_current_command}").Output()
+	pOut, err := tuiTmux.OutputBytes("display-message", "-p", "-t", name, "active: #{session_name}:#{window_index}.#{pane_index}  path=#{pane_current_path}  cmd=#{pane_current_command}")
 	if err == nil {
 		b.WriteString("\n")
 		b.WriteString(strings.TrimRight(string(pOut), "\n"))
@@ -1614,7 +2900,7 @@ func tmuxCaptureSessionActivePaneTail(sessionName string, lines int) (string, er
 
 	// Capture last N lines from the active pane in the session.
 	// Targeting "-t <sessionName>" will resolve to the session's current window/pane.
-	out, err := exec.Command("tmux", "capture-pane", "-p", "-t", sessionName, "-S", fmt.Sprintf("-%d", lines)).Output()
+	out, err := tuiTmux.OutputBytes("capture-pane", "-p", "-t", sessionName, "-S", fmt.Sprintf("-%d", lines))
 	if err != nil {
 		return "", err
 	}
@@ -1623,54 +2909,71 @@ func tmuxCaptureSessionActivePaneTail(sessionName string, lines int) (string, er
 
 // ---------- templates ----------
 
+// applyTemplate builds the built-in template tpl in the (already created) session sessionName by
+// executing templateSpec through the engine, the same commands renderHardcodedTemplatePlan shows.
 func applyTemplate(sessionName, projectDir string, tpl templateKind) error {
-	// Minimal, useful first pass:
-	// - Ensure we have 2 windows: "editor" and "server"/"repl"
-	// - Start in projectDir
-	//
-	// This intentionally avoids external tools and relies on common defaults.
-	switch tpl {
-	case tplNode:
-		return applyNodeTemplate(sessionName, projectDir)
-	case tplPython:
-		return applyPythonTemplate(sessionName, projectDir)
-	case tplGo:
-		return applyGoTemplate(sessionName, projectDir)
-	default:
-		return nil
+	eng := templates.NewEngine()
+	eng.Runner = tuiRunner{}
+	compiled, err := compileTemplate(eng, sessionName, projectDir, tpl)
+	if err != nil {
+		return err
 	}
+	_, err = eng.Execute(compiled, false)
+	return err
 }
 
-func applyNodeTemplate(sessionName, dir string) error {
-	_ = exec.Command("tmux", "rename-window", "-t", sessionName+":", "editor").Run()
-	_ = exec.Command("tmux", "split-window", "-t", sessionName+":editor", "-h", "-c", dir).Run()
-
-	// Create server window
-	_ = exec.Command("tmux", "new-window", "-t", sessionName, "-n", "server", "-c", dir).Run()
-
-	cmd := detectNodeDevCommand(dir)
+// templateSpec is the built-in template tpl as an engine spec: rename the first window to
+// "editor" and split it, then open the template's window (templateWindowCommand) and type its
+// command. The empty template has no actions.
+func templateSpec(tpl templateKind, dir string) templates.Spec {
+	ts := templates.Spec{Version: 1, Name: tpl.String()}
+	winName, cmd := templateWindowCommand(tpl, dir)
+	if winName == "" {
+		return ts
+	}
+	ts.Actions = []templates.Action{
+		{Kind: templates.ActionRenameWindow, From: "^", Name: "editor"}, // ^ = lowest index, whatever base-index is
+		{Kind: templates.ActionSplitWindow, Window: "editor", Direction: "h"},
+		{Kind: templates.ActionNewWindow, Name: winName},
+	}
 	if cmd != "" {
-		_ = exec.Command("tmux", "send-keys", "-t", sessionName+":server", cmd, "Enter").Run()
+		ts.Actions = append(ts.Actions, templates.Action{Kind: templates.ActionSendKeys, Window: winName, Command: cmd, Enter: true})
 	}
-	return nil
+	return ts
 }
 
-func applyPythonTemplate(sessionName, dir string) error {
-	_ = exec.Command("tmux", "rename-window", "-t", sessionName+":", "editor").Run()
-	_ = exec.Command("tmux", "split-window", "-t", sessionName+":editor", "-h", "-c", dir).Run()
-
-	_ = exec.Command("tmux", "new-window", "-t", sessionName, "-n", "repl", "-c", dir).Run()
-	_ = exec.Command("tmux", "send-keys", "-t", sessionName+":repl", "python", "Enter").Run()
-	return nil
+// compileTemplate compiles templateSpec for sessionName, rooted at dir. The empty template
+// compiles to no commands.
+func compileTemplate(eng *templates.Engine, sessionName, dir string, tpl templateKind) (templates.Compiled, error) {
+	ts := templateSpec(tpl, dir)
+	if len(ts.Actions) == 0 {
+		return templates.Compiled{}, nil
+	}
+	ctx := templates.Context{
+		ProjectName: filepath.Base(dir),
+		ProjectPath: dir,
+		SessionName: sessionName,
+		WorkingDir:  dir,
+	}
+	return eng.Compile(ctx, ts)
 }
 
-func applyGoTemplate(sessionName, dir string) error {
-	_ = exec.Command("tmux", "rename-window", "-t", sessionName+":", "editor").Run()
-	_ = exec.Command("tmux", "split-window", "-t", sessionName+":editor", "-h", "-c", dir).Run()
-
-	_ = exec.Command("tmux", "new-window", "-t", sessionName, "-n", "run", "-c", dir).Run()
-	_ = exec.Command("tmux", "send-keys", "-t", sessionName+":run", "go test ./...", "Enter").Run()
-	return nil
+// templateWindowCommand returns the second window (name + command) for a built-in template.
+// It is the single source of truth for template commands: applyTemplate, the preview plan, and
+// scaffolded specs all derive from it.
+func templateWindowCommand(tpl templateKind, dir string) (string, string) {
+	switch tpl {
+	case tplNode:
+		return "server", detectNodeDevCommand(dir)
+	case tplPython:
+		return "repl", "python"
+	case tplGo:
+		return "run", "go test ./..."
+	case tplRust:
+		return "run", detectRustDevCommand()
+	default:
+		return "", ""
+	}
 }
 
 // detectNodeDevCommand picks a common dev command based on lockfiles.
@@ -1691,11 +2994,44 @@ func detectNodeDevCommand(projectDir string) string {
 	return ""
 }
 
+// detectRustDevCommand prefers `cargo watch -x run` when cargo-watch is installed, else `cargo run`.
+func detectRustDevCommand() string {
+	if _, err := exec.LookPath("cargo-watch"); err == nil {
+		return "cargo watch -x run"
+	}
+	return "cargo run"
+}
+
 // ---------- misc helpers ----------
 
 // ---------- projects scanning / preview ----------
 
-func scanProjects(roots []string, depth int) []projectItem {
+// ignoreDirSet builds the scan's skip set from UIOptions.IgnoreDirNames.
+func ignoreDirSet(names []string) map[string]bool {
+	if len(names) == 0 {
+		names = []string{"node_modules", "vendor"}
+	}
+	out := make(map[string]bool, len(names))
+	for _, n := range names {
+		if n = strings.TrimSpace(n); n != "" {
+			out[n] = true
+		}
+	}
+	return out
+}
+
+// markerSet builds the extra project markers from UIOptions.ProjectMarkers.
+func markerSet(names []string) map[string]bool {
+	out := make(map[string]bool, len(names))
+	for _, n := range names {
+		if n = strings.TrimSpace(n); n != "" {
+			out[n] = true
+		}
+	}
+	return out
+}
+
+func scanProjects(ctx context.Context, roots []string, depth int, ignore, markers map[string]bool) []projectItem {
 	seen := map[string]bool{}
 	var out []projectItem
 
@@ -1705,15 +3041,15 @@ func scanProjects(roots []string, depth int) []projectItem {
 		if err != nil || !info.IsDir() {
 			continue
 		}
-		walkProjects(root, root, depth, &out, seen)
+		walkProjects(ctx, root, root, depth, ignore, markers, &out, seen)
 	}
 
 	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
 	return out
 }
 
-func walkProjects(root, dir string, depth int, out *[]projectItem, seen map[string]bool) {
-	if depth < 0 {
+func walkProjects(ctx context.Context, root, dir string, depth int, ignore, markers map[string]bool, out *[]projectItem, seen map[string]bool) {
+	if depth < 0 || ctx.Err() != nil {
 		return
 	}
 	ents, err := os.ReadDir(dir)
@@ -1722,11 +3058,11 @@ func walkProjects(root, dir string, depth int, out *[]projectItem, seen map[stri
 	}
 
 	// A directory is considered a project if it contains one of these markers.
-	if dir != root && isProjectDir(dir, ents) {
+	if dir != root && isProjectDir(dir, ents, markers) {
 		name := filepath.Base(dir)
 		if !seen[dir] {
 			seen[dir] = true
-			*out = append(*out, projectItem{Name: name, Path: dir})
+			*out = append(*out, newProjectItem(name, dir))
 		}
 		// Do not descend further once we identify a project directory.
 		return
@@ -1737,14 +3073,16 @@ func walkProjects(root, dir string, depth int, out *[]projectItem, seen map[stri
 			continue
 		}
 		n := e.Name()
-		if strings.HasPrefix(n, ".") || n == "node_modules" || n == "vendor" || n == ".git" {
+		if strings.HasPrefix(n, ".") || ignore[n] {
 			continue
 		}
-		walkProjects(root, filepath.Join(dir, n), depth-1, out, seen)
+		walkProjects(ctx, root, filepath.Join(dir, n), depth-1, ignore, markers, out, seen)
 	}
 }
 
-func isProjectDir(dir string, ents []os.DirEntry) bool {
+// isProjectDir reports whether dir (with entries ents) is a project: a built-in marker or one of
+// the extra markers is present.
+func isProjectDir(dir string, ents []os.DirEntry, markers map[string]bool) bool {
 	has := func(name string) bool {
 		for _, e := range ents {
 			if e.Name() == name {
@@ -1754,7 +3092,7 @@ func isProjectDir(dir string, ents []os.DirEntry) bool {
 		return false
 	}
 	// Common markers
-	if has(".git") {
+	if looksLikeGitRepo(ents) {
 		return true
 	}
 	if has("go.mod") || has("go.work") {
@@ -1766,6 +3104,9 @@ func isProjectDir(dir string, ents []os.DirEntry) bool {
 	if has("package.json") {
 		return true
 	}
+	if has("Cargo.toml") {
+		return true
+	}
 
 	// tmux-session-manager project spec markers
 	// If a repo has a project-local session spec, treat it as a project even if it doesn't
@@ -1774,9 +3115,54 @@ func isProjectDir(dir string, ents []os.DirEntry) bool {
 		return true
 	}
 
+	for _, e := range ents {
+		if markers[e.Name()] {
+			return true
+		}
+	}
+
 	return false
 }
 
+// findProjectRoot walks up from start to the nearest directory isProjectDir accepts (start
+// itself included).
+func findProjectRoot(start string, markers map[string]bool) (string, bool) {
+	dir := filepath.Clean(expandHome(strings.TrimSpace(start)))
+	if !filepath.IsAbs(dir) {
+		return "", false
+	}
+	for {
+		if ents, err := os.ReadDir(dir); err == nil && isProjectDir(dir, ents, markers) {
+			return dir, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// looksLikeGitRepo reports whether a directory with entries ents is a git checkout: a .git
+// directory, a .git file (worktrees and submodules point elsewhere with `gitdir: ...`), or a bare
+// repository (HEAD file plus objects/ and refs/ directories).
+func looksLikeGitRepo(ents []os.DirEntry) bool {
+	var head, objects, refs bool
+	for _, e := range ents {
+		switch e.Name() {
+		case ".git":
+			return true
+		case "HEAD":
+			head = !e.IsDir()
+		case "objects":
+			objects = e.IsDir()
+		case "refs":
+			refs = e.IsDir()
+		}
+	}
+	return head && objects && refs
+}
+
 func projectPreview(dir string) string {
 	var b strings.Builder
 	b.WriteString("path: " + dir + "\n")
@@ -1822,57 +3208,62 @@ func projectPreview(dir string) string {
 
 // ---------- misc helpers ----------
 
-func fuzzyContains(hay, needle string) bool {
-	// Simple ordered-subsequence match. Fast and "good enough" for first pass.
-	if needle == "" {
-		return true
-	}
-	i := 0
-	for _, r := range hay {
-		if i >= len(needle) {
-			break
+// fuzzyScore matches needle as an ordered subsequence of hay (both are expected to be lowercased
+// already; items keep theirs precomputed so a keystroke doesn't allocate per item), and scores the
+// match: contiguous runs, a match at the very start, and matches right after a separator
+// (space / - _ .) rank higher. ok is false when there is no match.
+func fuzzyScore(h, n []rune) (score int, ok bool) {
+	if len(n) == 0 {
+		return 0, true
+	}
+	best := -1
+	for start := range h {
+		if h[start] != n[0] {
+			continue
 		}
-		if byte(r) == needle[i] {
-			i++
+		sc := fuzzyScoreFrom(h, n, start)
+		if sc < 0 {
+			// Later starts have even less haystack left; they cannot match either.
+			break
 		}
+		best = maxIntTUI(best, sc)
 	}
-	return i == len(needle)
+	return best, best >= 0
 }
 
-func sanitizeSessionName(name string) string {
-	// tmux session names are fairly permissive, but spaces and punctuation cause friction.
-	// Keep it simple and consistent.
-	name = strings.TrimSpace(name)
-	if name == "" {
-		return ""
-	}
-	var b strings.Builder
-	lastUnderscore := false
-	for _, r := range name {
+// fuzzyScoreFrom greedily matches n in h beginning at h[start] (== n[0]); -1 if it runs out.
+func fuzzyScoreFrom(h, n []rune, start int) int {
+	score := 0
+	prev := -2
+	j := 0
+	for i := start; i < len(h) && j < len(n); i++ {
+		if h[i] != n[j] {
+			continue
+		}
+		score++
 		switch {
-		case r >= 'a' && r <= 'z':
-			b.WriteRune(r)
-			lastUnderscore = false
-		case r >= 'A' && r <= 'Z':
-			b.WriteRune(r + ('a' - 'A'))
-			lastUnderscore = false
-		case r >= '0' && r <= '9':
-			b.WriteRune(r)
-			lastUnderscore = false
-		case r == '-' || r == '_':
-			b.WriteRune('_')
-			lastUnderscore = true
-		default:
-			if !lastUnderscore {
-				b.WriteRune('_')
-				lastUnderscore = true
-			}
+		case i == 0:
+			score += 8
+		case i == prev+1:
+			score += 4
+		case isFuzzySeparator(h[i-1]):
+			score += 3
 		}
+		prev = i
+		j++
 	}
-	out := strings.Trim(b.String(), "_")
-	// Avoid tmux weirdness with empty/dot names
-	out = strings.Trim(out, ".")
-	return out
+	if j < len(n) {
+		return -1
+	}
+	return score
+}
+
+func isFuzzySeparator(r rune) bool {
+	switch r {
+	case ' ', '/', '-', '_', '.':
+		return true
+	}
+	return false
 }
 
 func atoiSafe(s string) int {
@@ -1939,6 +3330,13 @@ func minIntTUI(a, b int) int {
 	return b
 }
 
+func maxIntTUI(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
 func parseEnvBool(key string, def bool) bool {
 	v := strings.TrimSpace(os.Getenv(key))
 	if v == "" {
@@ -1954,30 +3352,18 @@ func parseEnvBool(key string, def bool) bool {
 	}
 }
 
+// renderHardcodedTemplatePlan previews the built-in template: the dry-run lines of the commands
+// applyTemplate executes. The session is created by the caller, so it isn't shown.
 func renderHardcodedTemplatePlan(sessionName, projectDir string, tpl templateKind) string {
-	// Session is created by caller, so show template operations only.
 	if sessionName == "" {
 		sessionName = "project"
 	}
-	var b strings.Builder
-	switch tpl {
-	case tplNode:
-		b.WriteString(" - rename-window -t " + sessionName + ":0 editor\n")
-		b.WriteString(" - split-window -t " + sessionName + ":0 -h -c " + projectDir + "\n")
-		b.WriteString(" - new-window -t " + sessionName + " -n server -c " + projectDir + "\n")
-		b.WriteString(" - send-keys -t " + sessionName + ":server.0 <detect dev cmd> Enter\n")
-	case tplPython:
-		b.WriteString(" - rename-window -t " + sessionName + ":0 editor\n")
-		b.WriteString(" - split-window -t " + sessionName + ":0 -h -c " + projectDir + "\n")
-		b.WriteString(" - new-window -t " + sessionName + " -n repl -c " + projectDir + "\n")
-		b.WriteString(" - send-keys -t " + sessionName + ":repl.0 python Enter\n")
-	case tplGo:
-		b.WriteString(" - rename-window -t " + sessionName + ":0 editor\n")
-		b.WriteString(" - split-window -t " + sessionName + ":0 -h -c " + projectDir + "\n")
-		b.WriteString(" - new-window -t " + sessionName + " -n run -c " + projectDir + "\n")
-		b.WriteString(" - send-keys -t " + sessionName + ":run.0 go test ./... Enter\n")
-	default:
-		b.WriteString(" - (empty template)\n")
+	compiled, err := compileTemplate(templates.NewEngine(), sessionName, projectDir, tpl)
+	if err != nil {
+		return " - (template compile error: " + err.Error() + ")"
 	}
-	return strings.TrimRight(b.String(), "\n")
+	if len(compiled.Commands) == 0 {
+		return " - (empty template)"
+	}
+	return strings.Join(templates.DryRunLines(compiled), "\n")
 }
@@ -0,0 +1,883 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+
+	"tmux-session-manager/pkg/spec"
+)
+
+// testModel is a TUI model over a fixed session list, with tmux pointed at an empty socket
+// directory so nothing reaches a real server.
+func testModel(t *testing.T, sessions ...string) model {
+	t.Helper()
+	t.Setenv("TMUX", "")
+	t.Setenv("TMUX_TMPDIR", t.TempDir())
+	m := newModel(UIOptions{})
+	for _, s := range sessions {
+		m.sessions = append(m.sessions, sessionItem{Name: s, nameRunes: []rune(strings.ToLower(s))})
+	}
+	m.filterValid = false
+	m.recomputeFilter()
+	return m
+}
+
+func keyRunes(s string) tea.KeyMsg {
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)}
+}
+
+func TestKillTargetFixedWhenPromptOpens(t *testing.T) {
+	m := testModel(t, "alpha", "beta", "gamma")
+	m.selected = 1 // beta
+
+	next, _ := m.Update(keyRunes("d"))
+	m = next.(model)
+	if !m.confirmKill || m.killName != "beta" {
+		t.Fatalf("confirmKill=%v killName=%q, want prompt for beta", m.confirmKill, m.killName)
+	}
+
+	// A refresh lands while the prompt is up and moves the selection.
+	m.selected = 0
+	if got := m.killTargets(); len(got) != 1 || got[0] != "beta" {
+		t.Errorf("killTargets = %v, want [beta]", got)
+	}
+	if v := m.View(); !strings.Contains(v, "Kill session beta") {
+		t.Errorf("prompt doesn't name beta:\n%s", v)
+	}
+
+	next, _ = m.Update(keyRunes("n"))
+	m = next.(model)
+	if m.confirmKill || m.killName != "" {
+		t.Errorf("cancel left confirmKill=%v killName=%q", m.confirmKill, m.killName)
+	}
+}
+
+func TestKillTargetsMarkedBatch(t *testing.T) {
+	m := testModel(t, "alpha", "beta", "gamma")
+	m.marked = map[string]bool{"alpha": true, "gamma": true}
+	m.selected = 1
+
+	next, _ := m.Update(keyRunes("d"))
+	m = next.(model)
+	m.selected = 2
+	if got := strings.Join(m.killTargets(), ","); got != "alpha,gamma" {
+		t.Errorf("killTargets = %s, want alpha,gamma", got)
+	}
+	if m.killName != "" {
+		t.Errorf("killName = %q with marks", m.killName)
+	}
+}
+
+// Space toggles the mark on the selected session; the batch is the marked sessions in list
+// order, whatever order they were marked in.
+func TestMarkToggle(t *testing.T) {
+	m := testModel(t, "alpha", "beta", "gamma")
+	m.width, m.height = 80, 20
+	press := func() {
+		t.Helper()
+		next, _ := m.Update(keyRunes(" "))
+		m = next.(model)
+	}
+
+	m.selected = 2
+	press()
+	m.selected = 0
+	press()
+	if got := strings.Join(markedSessions(m.sessions, m.marked), ","); got != "alpha,gamma" {
+		t.Fatalf("marked = %s, want alpha,gamma", got)
+	}
+	if v := ansi.Strip(m.View()); !strings.Contains(v, ">*alpha") || !strings.Contains(v, " *gamma") {
+		t.Errorf("marks not rendered with *:\n%s", v)
+	}
+
+	press()
+	if got := strings.Join(markedSessions(m.sessions, m.marked), ","); got != "gamma" {
+		t.Errorf("after unmark, marked = %s, want gamma", got)
+	}
+
+	next, _ := m.Update(keyRunes("d"))
+	m = next.(model)
+	if got := strings.Join(m.killTargets(), ","); got != "gamma" {
+		t.Errorf("killTargets = %s, want gamma", got)
+	}
+
+	m = testModel(t, "alpha")
+	m.mode = modeProjects
+	press()
+	if len(m.marked) != 0 {
+		t.Errorf("space marked %v outside sessions mode", m.marked)
+	}
+}
+
+// Each sort mode orders by its key, ties broken by name; mru puts unrecorded sessions last.
+func TestSessionLess(t *testing.T) {
+	items := []sessionItem{
+		{Name: "delta", Windows: 2, LastActivity: 300},
+		{Name: "alpha", Windows: 1, LastActivity: 100},
+		{Name: "charlie", Windows: 5, LastActivity: 300},
+		{Name: "bravo", Windows: 2, LastActivity: 200},
+	}
+	mru := map[string]int{"bravo": 0, "delta": 1}
+	for _, tc := range []struct {
+		mode string
+		want string
+	}{
+		{"name", "alpha,bravo,charlie,delta"},
+		{"activity", "charlie,delta,bravo,alpha"},
+		{"windows", "charlie,bravo,delta,alpha"},
+		{"mru", "bravo,delta,alpha,charlie"},
+		{"bogus", "alpha,bravo,charlie,delta"},
+	} {
+		got := append([]sessionItem(nil), items...)
+		less := sessionLess(tc.mode, mru)
+		sort.SliceStable(got, func(i, j int) bool { return less(got[i], got[j]) })
+		names := make([]string, len(got))
+		for i, it := range got {
+			names[i] = it.Name
+		}
+		if s := strings.Join(names, ","); s != tc.want {
+			t.Errorf("%s: %s, want %s", tc.mode, s, tc.want)
+		}
+	}
+}
+
+// Pressing t templateKindCount times visits every template once and lands back on the first.
+func TestNextTemplateCycles(t *testing.T) {
+	seen := map[templateKind]bool{}
+	tpl := tplEmpty
+	for i := 0; i < int(templateKindCount); i++ {
+		if seen[tpl] {
+			t.Fatalf("%s visited twice", tpl)
+		}
+		seen[tpl] = true
+		tpl = nextTemplate(tpl)
+	}
+	if tpl != tplEmpty {
+		t.Errorf("cycle ended on %s, want %s", tpl, tplEmpty)
+	}
+	for k := templateKind(0); k < templateKindCount; k++ {
+		if !seen[k] {
+			t.Errorf("%s never visited", k)
+		}
+		if k != tplEmpty && k.String() == "empty" {
+			t.Errorf("templateKind %d has no name", k)
+		}
+	}
+}
+
+// filterProjects fills m with n synthetic projects, many with identical scores for typical queries.
+func filterProjects(m *model, n int) {
+	m.projects = m.projects[:0]
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("svc-%d-api", i%97)
+		m.projects = append(m.projects, newProjectItem(name, fmt.Sprintf("/src/team%d/%s", i%13, name)))
+	}
+	m.filterValid = false
+}
+
+func projectNames(ps []projectItem) []string {
+	out := make([]string, len(ps))
+	for i, p := range ps {
+		out[i] = p.Path
+	}
+	return out
+}
+
+// Typing a query one rune at a time narrows the previous results; the list must be the same,
+// ties included, as filtering the full set for the final query at once.
+func TestFilterNarrowingMatchesRescan(t *testing.T) {
+	m := testModel(t)
+	filterProjects(&m, 2000)
+	for _, query := range []string{"svcapi", "team1 a", "S-1", "/src/team12/svc-9"} {
+		for i := range query {
+			m.input.SetValue(query[:i+1])
+			m.recomputeFilter()
+		}
+		narrowed := projectNames(m.filteredProjects)
+
+		m.filterValid = false
+		m.recomputeFilter()
+		if got, want := strings.Join(narrowed, "\n"), strings.Join(projectNames(m.filteredProjects), "\n"); got != want {
+			t.Errorf("query %q: narrowed result differs from a full rescan", query)
+		}
+		m.input.SetValue("")
+		m.recomputeFilter()
+	}
+}
+
+func TestFuzzyScore(t *testing.T) {
+	tests := []struct {
+		hay, needle string
+		ok          bool
+	}{
+		{"tmux-session-manager", "tsm", true},
+		{"tmux-session-manager", "", true},
+		{"api", "apix", false},
+		{"café-app", "éa", true},
+		{"abc", "cba", false},
+	}
+	for _, tt := range tests {
+		if _, ok := fuzzyScore([]rune(tt.hay), []rune(tt.needle)); ok != tt.ok {
+			t.Errorf("fuzzyScore(%q, %q) ok = %v, want %v", tt.hay, tt.needle, ok, tt.ok)
+		}
+	}
+	prefix, _ := fuzzyScore([]rune("api-gateway"), []rune("api"))
+	scattered, _ := fuzzyScore([]rune("a-p-i"), []rune("api"))
+	if prefix <= scattered {
+		t.Errorf("prefix score %d <= scattered score %d", prefix, scattered)
+	}
+}
+
+// BenchmarkFilterTyping types a query into a 5k-project list, one keystroke per recompute.
+func BenchmarkFilterTyping(b *testing.B) {
+	m := newModel(UIOptions{})
+	filterProjects(&m, 5000)
+	const query = "team3 svc api"
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := range query {
+			m.input.SetValue(query[:j+1])
+			m.recomputeFilter()
+		}
+		m.input.SetValue("")
+		m.recomputeFilter()
+	}
+}
+
+// The projects view only renders the visible window, however long the list.
+func TestViewRendersVisibleWindowOnly(t *testing.T) {
+	m := testModel(t)
+	filterProjects(&m, 5000)
+	m.mode = modeProjects
+	m.width, m.height = 120, 40
+	m.recomputeFilter()
+	m.move(4000)
+	v := m.View()
+	if n := strings.Count(v, "\n"); n > m.height+5 {
+		t.Errorf("view has %d lines for a %d-line terminal", n, m.height)
+	}
+	if !strings.Contains(v, m.filteredProjects[4000].Name) {
+		t.Error("selected project not rendered")
+	}
+}
+
+// BenchmarkProjectsRescan5k is a full filter pass over 5k projects (new query, no narrowing)
+// plus a render, the cost of the first keystroke or of a reload.
+func BenchmarkProjectsRescan5k(b *testing.B) {
+	m := newModel(UIOptions{})
+	filterProjects(&m, 5000)
+	m.mode = modeProjects
+	m.width, m.height = 120, 40
+	m.input.SetValue("svc api")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.filterValid = false
+		m.recomputeFilter()
+		_ = m.View()
+	}
+}
+
+// The TUI takes safety toggles and startup settings from UIOptions alone; the environment was
+// already folded in by config.Resolve, so it must not override what the caller resolved.
+func TestNewModelIgnoresEnv(t *testing.T) {
+	t.Setenv("TMUX", "")
+	t.Setenv("TMUX_TMPDIR", t.TempDir())
+	t.Setenv("TMUX_SESSION_MANAGER_ALLOW_SHELL", "1")
+	t.Setenv("TMUX_SESSION_MANAGER_ALLOW_TMUX_PASSTHROUGH", "1")
+	t.Setenv("TMUX_SESSION_MANAGER_CONFIRM_KILL", "yes")
+	t.Setenv("TMUX_SESSION_MANAGER_SESSION_SORT", "activity")
+	t.Setenv("TMUX_SESSION_MANAGER_PREVIEW_LINES", "30")
+
+	m := newModel(UIOptions{})
+	if m.opts.AllowShell || m.opts.AllowTmuxPassthrough {
+		t.Errorf("AllowShell = %v, AllowTmuxPassthrough = %v; want both off", m.opts.AllowShell, m.opts.AllowTmuxPassthrough)
+	}
+	if m.opts.ConfirmKill != "y" || m.opts.SessionSort != "name" || m.opts.PreviewLines != defaultPreviewLines {
+		t.Errorf("ConfirmKill = %q, SessionSort = %q, PreviewLines = %d", m.opts.ConfirmKill, m.opts.SessionSort, m.opts.PreviewLines)
+	}
+
+	m = newModel(UIOptions{AllowShell: true, ConfirmKill: "name", SessionSort: "windows", PreviewLines: 8})
+	if !m.opts.AllowShell || m.opts.ConfirmKill != "name" || m.opts.SessionSort != "windows" || m.opts.PreviewLines != 8 {
+		t.Errorf("resolved options not kept: %+v", m.opts)
+	}
+}
+
+// mkProject creates root/rel with a marker file in it.
+func mkProject(t *testing.T, root, rel, marker string) {
+	t.Helper()
+	dir := filepath.Join(root, rel)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, marker), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func scannedNames(items []projectItem) string {
+	names := make([]string, len(items))
+	for i, p := range items {
+		names[i] = p.Name
+	}
+	return strings.Join(names, ",")
+}
+
+// A configured IgnoreDirNames list replaces the default one.
+func TestScanProjectsIgnoreDirNames(t *testing.T) {
+	root := t.TempDir()
+	mkProject(t, root, "app", "go.mod")
+	mkProject(t, root, "build/gen", "go.mod")
+	mkProject(t, root, "node_modules/pkg", "package.json")
+
+	tests := []struct {
+		ignore []string
+		want   string
+	}{
+		{nil, "app,gen"},
+		{[]string{"build", " "}, "app,pkg"},
+		{[]string{"build", "node_modules"}, "app"},
+	}
+	for _, tt := range tests {
+		got := scannedNames(scanProjects(context.Background(), []string{root}, 3, ignoreDirSet(tt.ignore), nil))
+		if got != tt.want {
+			t.Errorf("ignore %q: projects = %s, want %s", tt.ignore, got, tt.want)
+		}
+	}
+}
+
+// Configured markers add to the built-in ones: a WORKSPACE dir becomes a project, go.mod still is.
+func TestScanProjectsMarkers(t *testing.T) {
+	root := t.TempDir()
+	mkProject(t, root, "app", "go.mod")
+	mkProject(t, root, "mono", "WORKSPACE")
+	mkProject(t, root, "notes", "README.md")
+	if err := os.MkdirAll(filepath.Join(root, "elisp", ".projectile"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		markers []string
+		want    string
+	}{
+		{nil, "app"},
+		{[]string{"WORKSPACE", " "}, "app,mono"},
+		{[]string{"WORKSPACE", ".projectile"}, "app,elisp,mono"},
+	}
+	for _, tt := range tests {
+		got := scannedNames(scanProjects(context.Background(), []string{root}, 3, nil, markerSet(tt.markers)))
+		if got != tt.want {
+			t.Errorf("markers %q: projects = %s, want %s", tt.markers, got, tt.want)
+		}
+	}
+}
+
+func TestCtrlDUPage(t *testing.T) {
+	var names []string
+	for i := 0; i < 100; i++ {
+		names = append(names, fmt.Sprintf("s%03d", i))
+	}
+	m := testModel(t, names...)
+	m.width, m.height = 80, 30
+	visible := m.visibleListHeight()
+	half := visible / 2
+	if half < 2 {
+		t.Fatalf("visibleListHeight = %d", visible)
+	}
+
+	// Each key moves the selection half a page; the window scrolls only to keep it visible.
+	steps := []struct {
+		key      tea.KeyType
+		selected int
+		scroll   int
+	}{
+		{tea.KeyCtrlD, half, 0},
+		{tea.KeyCtrlD, 2 * half, 2*half - visible + 1},
+		{tea.KeyCtrlD, 3 * half, 3*half - visible + 1},
+		{tea.KeyCtrlU, 2 * half, 3*half - visible + 1},
+		{tea.KeyCtrlU, half, minIntTUI(half, 3*half-visible+1)},
+		{tea.KeyCtrlU, 0, 0},
+		{tea.KeyCtrlU, 0, 0},
+	}
+	for i, st := range steps {
+		next, _ := m.Update(tea.KeyMsg{Type: st.key})
+		m = next.(model)
+		if m.selected != st.selected || m.scroll != maxIntTUI(st.scroll, 0) {
+			t.Fatalf("step %d (%v): selected=%d scroll=%d, want %d/%d", i, st.key, m.selected, m.scroll, st.selected, maxIntTUI(st.scroll, 0))
+		}
+	}
+}
+
+func TestFuzzyScoreUnicode(t *testing.T) {
+	tests := []struct {
+		hay, needle string
+		ok          bool
+	}{
+		{"tmux-session-manager", "tmx", true},
+		{"tmux-session-manager", "mgrs", false},
+		{"répertoire-café", "rpcf", true},
+		{"répertoire-café", "éé", true},
+		{"répertoire-café", "fa", false},
+		{"プロジェクト/東京", "プ東", true},
+		{"プロジェクト/東京", "京東", false},
+		{"北京-api", "京api", true},
+	}
+	for _, tt := range tests {
+		if _, ok := fuzzyScore([]rune(tt.hay), []rune(tt.needle)); ok != tt.ok {
+			t.Errorf("fuzzyScore(%q, %q) ok = %v, want %v", tt.hay, tt.needle, ok, tt.ok)
+		}
+	}
+}
+
+// Prefix and contiguous matches outrank scattered ones, equal scores keep list order, and names
+// are case-folded like the query.
+func TestFilterRanksBestMatch(t *testing.T) {
+	m := testModel(t, "tools-a-p-i", "legacy-api-v1", "API", "Café-Api")
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"api", "API,legacy-api-v1,Café-Api,tools-a-p-i"},
+		{"CAFÉ", "Café-Api"},
+		{"éa", "Café-Api"},
+	}
+	for _, tt := range tests {
+		m.input.SetValue(tt.query)
+		m.recomputeFilter()
+		var got []string
+		for _, s := range m.filteredSessions {
+			got = append(got, s.Name)
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("query %q: %s, want %s", tt.query, strings.Join(got, ","), tt.want)
+		}
+	}
+}
+
+// The scan never enters an ignored (or hidden) directory, so nothing below it is found, at any
+// depth, while the same layout elsewhere is.
+func TestScanProjectsSkipsIgnoredDirs(t *testing.T) {
+	root := t.TempDir()
+	mkProject(t, root, "group/lib", "go.mod")
+	mkProject(t, root, "vendor/group/lib2", "go.mod")
+	mkProject(t, root, "vendor/top", "Cargo.toml")
+	mkProject(t, root, "node_modules/pkg", "package.json")
+	mkProject(t, root, ".cache/proj", "go.mod")
+
+	got := scannedNames(scanProjects(context.Background(), []string{root}, 5, ignoreDirSet(nil), nil))
+	if got != "lib" {
+		t.Errorf("projects = %s, want lib", got)
+	}
+}
+
+func TestFitWidthWideGlyphs(t *testing.T) {
+	tests := []struct {
+		in   string
+		w    int
+		want string
+	}{
+		{"東京プロジェクト", 20, "東京プロジェクト"},
+		{"東京プロジェクト", 9, "東京プロ…"},
+		{"東京プロジェクト", 8, "東京プ…"},
+		{"🚀-rocket-app", 6, "🚀-ro…"},
+		{"🚀🚀🚀", 4, "🚀…"},
+		{"plain", 0, "plain"},
+	}
+	for _, tt := range tests {
+		got := fitWidth(tt.in, tt.w)
+		if got != tt.want {
+			t.Errorf("fitWidth(%q, %d) = %q, want %q", tt.in, tt.w, got, tt.want)
+		}
+		if tt.w > 0 && ansi.StringWidth(got) > tt.w {
+			t.Errorf("fitWidth(%q, %d) is %d cells wide", tt.in, tt.w, ansi.StringWidth(got))
+		}
+	}
+}
+
+// No list or preview line is wider than the terminal with CJK/emoji project names.
+func TestViewFitsWideProjectNames(t *testing.T) {
+	m := testModel(t)
+	m.projects = []projectItem{
+		newProjectItem("東京-データ分析-プロジェクト-ダッシュボード", "/src/東京/データ分析/プロジェクト/ダッシュボード"),
+		newProjectItem("🚀🚀-launch-控制台-🚀🚀-service", "/src/🚀/launch/控制台"),
+	}
+	m.filterValid = false
+	m.mode = modeProjects
+	m.recomputeFilter()
+	m.showPreview = true
+	for _, w := range []int{20, 33, 60} {
+		m.width, m.height = w, 30
+		for i, ln := range strings.Split(m.View(), "\n") {
+			if !strings.ContainsAny(ln, "東🚀") {
+				continue // header, help and status lines
+			}
+			if n := ansi.StringWidth(ln); n > w {
+				t.Errorf("width %d: line %d is %d cells: %q", w, i, n, ln)
+			}
+		}
+	}
+}
+
+func TestLooksLikeGitRepo(t *testing.T) {
+	tests := []struct {
+		name  string
+		dirs  []string
+		files []string
+		want  bool
+	}{
+		{"repo", []string{".git"}, nil, true},
+		{"worktree", nil, []string{".git"}, true},
+		{"bare", []string{"objects", "refs"}, []string{"HEAD"}, true},
+		{"HEAD only", nil, []string{"HEAD"}, false},
+		{"HEAD dir", []string{"HEAD", "objects", "refs"}, nil, false},
+		{"plain", []string{"src"}, []string{"README.md"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, d := range tt.dirs {
+				if err := os.Mkdir(filepath.Join(dir, d), 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, f := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, f), []byte("gitdir: /elsewhere\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			ents, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := looksLikeGitRepo(ents); got != tt.want {
+				t.Errorf("looksLikeGitRepo = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindProjectRoot(t *testing.T) {
+	root := t.TempDir()
+	mkProject(t, root, "api", "go.mod")
+	mkProject(t, root, "api/tools/gen", "package.json")
+	mkProject(t, root, "notes", ".project")
+	for _, d := range []string{"api/internal/pkg", "api/tools/gen/src", "plain/sub"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	markers := map[string]bool{".project": true}
+
+	tests := []struct {
+		start string
+		want  string
+	}{
+		{"api", "api"},
+		{"api/internal/pkg", "api"},
+		{"api/tools/gen/src", "api/tools/gen"}, // nearest marker wins
+		{"notes", "notes"},
+	}
+	for _, tt := range tests {
+		got, ok := findProjectRoot(filepath.Join(root, tt.start), markers)
+		if want := filepath.Join(root, tt.want); !ok || got != want {
+			t.Errorf("findProjectRoot(%s) = %q, %v, want %q", tt.start, got, ok, want)
+		}
+	}
+
+	// No marker under root: the walk must not stop inside it (it may still find one above the
+	// temp dir on an unusual machine).
+	if got, ok := findProjectRoot(filepath.Join(root, "plain/sub"), markers); ok && strings.HasPrefix(got, root) {
+		t.Errorf("non-project path resolved to %q", got)
+	}
+	if got, ok := findProjectRoot("api/internal", markers); ok {
+		t.Errorf("relative path resolved to %q", got)
+	}
+}
+
+// fakeTuiTmux points tuiTmux at a shell script whose body answers the tmux commands under test
+// ("$1" is the subcommand).
+func fakeTuiTmux(t *testing.T, body string) {
+	t.Helper()
+	bin := filepath.Join(t.TempDir(), "tmux")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	saved := tuiTmux
+	t.Cleanup(func() { tuiTmux = saved })
+	tuiTmux = &Tmux{Bin: bin}
+}
+
+// A session snapshot marshals to YAML that loads back as the same spec, awkward names included.
+func TestSnapshotSpecRoundTrip(t *testing.T) {
+	t.Setenv("SHELL", "/bin/zsh")
+	t.Setenv("TMUX_SESSION_MANAGER_SNAPSHOT_PANE_MODE", "1")
+	fakeTuiTmux(t, `case "$1" in
+list-windows) printf '0|edit|main-vertical\n1|logs: "x" # y|tiled\n' ;;
+list-panes)
+	case "$3" in
+	*:0) printf '0|editor|/src/app|zsh|999999999|0|\n1|{title}|/src/my app|htop|999999999|1|12\n' ;;
+	*) printf "0|it's|/var/log|tail|999999999|0|\n" ;;
+	esac ;;
+esac
+`)
+	snap, err := tmuxSnapshotSpec("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Windows) != 2 || len(snap.Windows[0].Panes) != 2 || snap.Windows[0].Panes[1].Restore == nil {
+		t.Fatalf("snapshot = %+v", snap)
+	}
+
+	b, err := spec.MarshalYAML(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "dev.yaml")
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := spec.LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile: %v\n%s", err, b)
+	}
+	// Compared as JSON: loading normalizes nil slices and maps to empty ones.
+	gotJSON, _ := spec.MarshalJSON(got)
+	wantJSON, _ := spec.MarshalJSON(snap)
+	if string(gotJSON) != string(wantJSON) {
+		t.Errorf("round trip differs:\n got %s\nwant %s", gotJSON, wantJSON)
+	}
+}
+
+func TestClampPreviewScroll(t *testing.T) {
+	tests := []struct{ offset, total, height, want int }{
+		{-3, 50, 10, 0},
+		{5, 50, 10, 5},
+		{40, 50, 10, 40}, // last full page
+		{41, 50, 10, 40},
+		{1000, 50, 10, 40},
+		{3, 5, 10, 0}, // everything fits
+		{3, 10, 10, 0},
+	}
+	for _, tt := range tests {
+		if got := clampPreviewScroll(tt.offset, tt.total, tt.height); got != tt.want {
+			t.Errorf("clampPreviewScroll(%d, %d, %d) = %d, want %d", tt.offset, tt.total, tt.height, got, tt.want)
+		}
+	}
+}
+
+// The focused preview pages through the pane tail, clamped at both ends, and a new selection
+// starts its preview at the top.
+func TestPreviewScrollKeys(t *testing.T) {
+	m := testModel(t, "alpha", "beta")
+	fakeTuiTmux(t, `case "$1" in
+list-windows) echo '0:edit * [1 panes] (tiled)' ;;
+display-message) echo 'active: alpha:0.0' ;;
+capture-pane) seq 1 60 ;;
+esac
+`)
+	m.width, m.height = 80, 40
+	m.showPreview, m.previewFocus = true, true
+	m.opts.PreviewLines = 10
+	total := len(strings.Split(m.previewText(), "\n"))
+	if total < 60 {
+		t.Fatalf("preview has %d lines, want the 60-line tail", total)
+	}
+
+	steps := []struct {
+		key  tea.KeyMsg
+		want int
+	}{
+		{keyRunes("k"), 0},
+		{keyRunes("j"), 1},
+		{tea.KeyMsg{Type: tea.KeyCtrlF}, 11},
+		{tea.KeyMsg{Type: tea.KeyCtrlD}, 16},
+		{keyRunes("G"), total - 10},
+		{keyRunes("j"), total - 10},
+		{tea.KeyMsg{Type: tea.KeyCtrlB}, total - 20},
+		{keyRunes("g"), 0},
+		{tea.KeyMsg{Type: tea.KeyCtrlU}, 0},
+	}
+	for _, s := range steps {
+		if !m.handlePreviewKeys(s.key) {
+			t.Fatalf("%s not handled by the focused preview", s.key)
+		}
+		if got := m.previewOffset(); got != s.want {
+			t.Errorf("after %s: offset %d, want %d", s.key, got, s.want)
+		}
+	}
+
+	m.handlePreviewKeys(keyRunes("G"))
+	m.selected = 1
+	if got := m.previewOffset(); got != 0 {
+		t.Errorf("offset %d after changing selection, want 0", got)
+	}
+}
+
+// The TUI, --open and apply name a project's session the same way, prefix or not.
+func TestProjectSessionNamePrefix(t *testing.T) {
+	root := t.TempDir()
+	names := []string{".tmux-session.yaml"}
+	mkProject(t, root, "api", names[0])
+	writeSpec(t, filepath.Join(root, "api"), "version: 1\nsession: {prefix: dev}\nwindows:\n  - name: edit\n")
+	mkProject(t, root, "My Svc", names[0])
+	writeSpec(t, filepath.Join(root, "My Svc"), "version: 1\nsession: {prefix: Dev Env}\nwindows:\n  - name: edit\n")
+	mkProject(t, root, "web", "go.mod")
+
+	m := testModel(t)
+	m.opts.PreferProjectSpec, m.opts.ProjectSpecNames = true, names
+	for _, tt := range []struct{ dir, want string }{
+		{"api", "dev-api"},
+		{"My Svc", "dev_env-my_svc"},
+		{"web", "web"},
+	} {
+		path := filepath.Join(root, tt.dir)
+		if got := m.projectSessionName(newProjectItem(tt.dir, path)); got != tt.want {
+			t.Errorf("%s: TUI name = %q, want %q", tt.dir, got, tt.want)
+		}
+		sel, specPath, err := OpenDirSelection(path, m.opts)
+		if err != nil || sel.SessionName != tt.want {
+			t.Errorf("%s: --open name = %q (%v), want %q", tt.dir, sel.SessionName, err, tt.want)
+		}
+		if specPath == "" {
+			continue
+		}
+		res, err := ApplySpecFile(specPath, ApplySpecOptions{DryRun: true})
+		if err != nil || res.SessionName != tt.want {
+			t.Errorf("%s: apply name = %q (%v), want %q", tt.dir, res.SessionName, err, tt.want)
+		}
+	}
+}
+
+// A project whose session is in the loaded session list previews it live; otherwise the preview
+// is the plan. The fake tmux claims every session exists: only the list decides.
+func TestProjectPreviewLiveSession(t *testing.T) {
+	root := t.TempDir()
+	mkProject(t, root, "api", "go.mod")
+	mkProject(t, root, "web", "go.mod")
+	fakeTuiTmux(t, `case "$1" in
+has-session) exit 0 ;;
+list-windows) echo "1:edit * [2 panes] (layout)" ;;
+display-message) echo "active: api:1.0  path=/src/api  cmd=nvim" ;;
+capture-pane) echo "go test ./..." ;;
+esac
+`)
+
+	m := testModel(t, "api", "docs")
+	m.mode = modeProjects
+	for _, tt := range []struct {
+		dir       string
+		want, not []string
+	}{
+		{"api", []string{"session api is running", "1:edit * [2 panes]", "pane tail:\ngo test ./..."}, []string{"planned operations:"}},
+		{"web", []string{"planned operations:"}, []string{"is running", "windows:"}},
+	} {
+		m.filteredProjects, m.selected = []projectItem{newProjectItem(tt.dir, filepath.Join(root, tt.dir))}, 0
+		got := m.previewText()
+		for _, s := range tt.want {
+			if !strings.Contains(got, s) {
+				t.Errorf("%s: preview lacks %q:\n%s", tt.dir, s, got)
+			}
+		}
+		for _, s := range tt.not {
+			if strings.Contains(got, s) {
+				t.Errorf("%s: preview has %q:\n%s", tt.dir, s, got)
+			}
+		}
+	}
+}
+
+// runRefresh runs m.startRefresh's loads and feeds their results back through Update.
+func runRefresh(t *testing.T, m model) model {
+	t.Helper()
+	batch, ok := m.startRefresh(false)().(tea.BatchMsg)
+	if !ok {
+		t.Fatal("startRefresh did not return a batch")
+	}
+	for _, cmd := range batch {
+		next, _ := m.Update(cmd())
+		m = next.(model)
+	}
+	return m
+}
+
+// Project rows show the session name resolved when projects load; rendering doesn't re-read specs.
+func TestProjectRowsUseLoadedSessionName(t *testing.T) {
+	root := t.TempDir()
+	mkProject(t, root, "api", ".tmux-session.yaml")
+	writeSpec(t, filepath.Join(root, "api"), "version: 1\nsession: {prefix: dev}\nwindows:\n  - name: edit\n")
+	fakeTuiTmux(t, "exit 1\n")
+
+	m := testModel(t)
+	m.opts.ProjectsPaths, m.opts.ProjectScanDepth, m.opts.ProjectCacheTTL = []string{root}, 1, -1
+	m.opts.PreferProjectSpec, m.opts.ProjectSpecNames = true, []string{".tmux-session.yaml"}
+	m.mode = modeProjects
+	m = runRefresh(t, m)
+	if len(m.projects) != 1 || m.projects[0].sessionName != "dev-api" {
+		t.Fatalf("projects = %+v, want api resolved to dev-api", m.projects)
+	}
+
+	// A spec change shows up at the next load, not on render.
+	writeSpec(t, filepath.Join(root, "api"), "version: 1\nsession: {prefix: ops}\nwindows:\n  - name: edit\n")
+	if v := m.View(); !strings.Contains(v, "→ dev-api") {
+		t.Errorf("view after spec edit lacks the loaded name:\n%s", v)
+	}
+	if v := runRefresh(t, m).View(); !strings.Contains(v, "→ ops-api") {
+		t.Errorf("view after reload lacks the new name:\n%s", v)
+	}
+}
+
+// Killing drops the session from the list at once and reloads sessions in the background, not
+// inside Update.
+func TestKillRefreshesAsync(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "tmux.log")
+	fakeTuiTmux(t, `echo "$1" >> '`+log+"'\n")
+	m := testModel(t, "alpha", "beta", "gamma")
+	m.selected = 1
+
+	next, _ := m.Update(keyRunes("d"))
+	next, cmd := next.(model).Update(keyRunes("y"))
+	m = next.(model)
+	if got := listedSessionNames(m.sessions); got != "alpha,gamma" {
+		t.Errorf("sessions after kill = %s, want alpha,gamma", got)
+	}
+	b, _ := os.ReadFile(log)
+	if strings.Contains(string(b), "list-sessions") || !strings.Contains(string(b), "kill-session") {
+		t.Errorf("tmux calls during Update:\n%s", b)
+	}
+	if cmd == nil {
+		t.Fatal("no refresh command")
+	}
+	if msg, ok := cmd().(sessionsLoadedMsg); !ok || msg.gen != m.sessionsGen {
+		t.Errorf("refresh = %#v, want sessionsLoadedMsg for gen %d", msg, m.sessionsGen)
+	}
+}
+
+// A finished project scan releases its context.
+func TestProjectsLoadedCancelsScanContext(t *testing.T) {
+	m := testModel(t)
+	cancelled := false
+	m.projectsGen, m.scanning = 3, true
+	m.projectsCancel = func() { cancelled = true }
+	next, _ := m.Update(projectsLoadedMsg{gen: 3})
+	m = next.(model)
+	if !cancelled || m.projectsCancel != nil || m.scanning {
+		t.Errorf("cancelled=%v projectsCancel nil=%v scanning=%v", cancelled, m.projectsCancel == nil, m.scanning)
+	}
+}
+
+func listedSessionNames(items []sessionItem) string {
+	names := make([]string, len(items))
+	for i, s := range items {
+		names[i] = s.Name
+	}
+	return strings.Join(names, ",")
+}
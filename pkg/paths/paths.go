@@ -0,0 +1,55 @@
+// Package paths resolves the per-user directories tmux-session-manager writes to, following the
+// XDG base directory spec: $XDG_CONFIG_HOME and $XDG_CACHE_HOME when set to absolute paths,
+// ~/.config and ~/.cache otherwise.
+package paths
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AppName is the subdirectory used under the config and cache base directories.
+const AppName = "tmux-session-manager"
+
+// ConfigDir is $XDG_CONFIG_HOME/tmux-session-manager (~/.config/tmux-session-manager by default).
+func ConfigDir() (string, error) {
+	return appDir("XDG_CONFIG_HOME", ".config")
+}
+
+// CacheDir is $XDG_CACHE_HOME/tmux-session-manager (~/.cache/tmux-session-manager by default).
+func CacheDir() (string, error) {
+	return appDir("XDG_CACHE_HOME", ".cache")
+}
+
+// ConfigPath joins elem onto ConfigDir.
+func ConfigPath(elem ...string) (string, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(append([]string{dir}, elem...)...), nil
+}
+
+// CachePath joins elem onto CacheDir.
+func CachePath(elem ...string) (string, error) {
+	dir, err := CacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(append([]string{dir}, elem...)...), nil
+}
+
+// appDir returns $env/AppName, or ~/fallback/AppName when env is unset, empty or relative (the
+// spec says relative values are invalid and must be ignored).
+func appDir(env, fallback string) (string, error) {
+	if dir := strings.TrimSpace(os.Getenv(env)); dir != "" && filepath.IsAbs(dir) {
+		return filepath.Join(dir, AppName), nil
+	}
+	home, _ := os.UserHomeDir()
+	if strings.TrimSpace(home) == "" {
+		return "", errors.New("no home directory (and no $" + env + ")")
+	}
+	return filepath.Join(home, fallback, AppName), nil
+}
@@ -0,0 +1,39 @@
+package paths
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDirs(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	tests := []struct {
+		name          string
+		config, cache string
+		wantConfig    string
+		wantCache     string
+	}{
+		{"unset", "", "", filepath.Join(home, ".config", AppName), filepath.Join(home, ".cache", AppName)},
+		{"set", "/xdg/config", "/xdg/cache", filepath.Join("/xdg/config", AppName), filepath.Join("/xdg/cache", AppName)},
+		{"relative is ignored", "rel/config", "rel/cache", filepath.Join(home, ".config", AppName), filepath.Join(home, ".cache", AppName)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("XDG_CONFIG_HOME", tt.config)
+			t.Setenv("XDG_CACHE_HOME", tt.cache)
+			if got, err := ConfigDir(); err != nil || got != tt.wantConfig {
+				t.Errorf("ConfigDir = %q, %v, want %q", got, err, tt.wantConfig)
+			}
+			if got, err := CacheDir(); err != nil || got != tt.wantCache {
+				t.Errorf("CacheDir = %q, %v, want %q", got, err, tt.wantCache)
+			}
+			if got, err := CachePath("mru.json"); err != nil || got != filepath.Join(tt.wantCache, "mru.json") {
+				t.Errorf("CachePath = %q, %v", got, err)
+			}
+			if got, err := ConfigPath("snapshots", "a.yaml"); err != nil || got != filepath.Join(tt.wantConfig, "snapshots", "a.yaml") {
+				t.Errorf("ConfigPath = %q, %v", got, err)
+			}
+		})
+	}
+}
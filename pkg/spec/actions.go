@@ -0,0 +1,117 @@
+package spec
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// ActionField describes one field of an action's payload object.
+type ActionField struct {
+	Name     string // yaml/json key
+	Type     string // Go-ish type name (string, int, bool, []string)
+	Required bool   // no omitempty on the tag
+}
+
+// ActionTypeInfo describes a supported action type.
+type ActionTypeInfo struct {
+	Type   string
+	Fields []ActionField
+
+	// Policy is the runtime allowance the action needs: "" (safe), "allow_shell", or
+	// "allow_tmux_passthrough for non-allowlisted commands".
+	Policy string
+}
+
+// actionPolicies records which action types are gated by Policy (see ValidatePolicy).
+var actionPolicies = map[string]string{
+	"shell": "allow_shell",
+	"tmux":  "allow_tmux_passthrough for non-allowlisted commands",
+}
+
+// actionModifiers are Action's pointer-to-struct fields that qualify any action instead of being
+// an action type's payload (ActionTypes skips them).
+var actionModifiers = map[string]bool{
+	"when": true,
+}
+
+// ActionTypes returns every action type accepted by Validate, derived from the Action struct:
+// each pointer field whose key names an action type contributes its payload fields. Order follows
+// the struct declaration.
+func ActionTypes() []ActionTypeInfo {
+	t := reflect.TypeOf(Action{})
+	out := make([]ActionTypeInfo, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Type.Kind() != reflect.Ptr || f.Type.Elem().Kind() != reflect.Struct {
+			continue
+		}
+		name, _ := tagKey(f)
+		if name == "" || actionModifiers[name] {
+			continue
+		}
+		info := ActionTypeInfo{Type: name, Policy: actionPolicies[name]}
+		pt := f.Type.Elem()
+		for j := 0; j < pt.NumField(); j++ {
+			pf := pt.Field(j)
+			key, omitempty := tagKey(pf)
+			if key == "" {
+				continue
+			}
+			info.Fields = append(info.Fields, ActionField{
+				Name:     key,
+				Type:     fieldTypeName(pf.Type),
+				Required: !omitempty,
+			})
+		}
+		out = append(out, info)
+	}
+	return out
+}
+
+// PrintActionTypes writes a human-readable listing of ActionTypes.
+func PrintActionTypes(w io.Writer) error {
+	for _, a := range ActionTypes() {
+		policy := "safe"
+		if a.Policy != "" {
+			policy = "unsafe: requires " + a.Policy
+		}
+		if _, err := fmt.Fprintf(w, "%s (%s)\n", a.Type, policy); err != nil {
+			return err
+		}
+		for _, f := range a.Fields {
+			req := ""
+			if f.Required {
+				req = " (required)"
+			}
+			if _, err := fmt.Fprintf(w, "  %s.%s: %s%s\n", a.Type, f.Name, f.Type, req); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func tagKey(f reflect.StructField) (string, bool) {
+	tag := f.Tag.Get("yaml")
+	if tag == "" {
+		tag = f.Tag.Get("json")
+	}
+	name, opts, _ := strings.Cut(tag, ",")
+	if name == "-" {
+		return "", false
+	}
+	return name, strings.Contains(opts, "omitempty")
+}
+
+func fieldTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return fieldTypeName(t.Elem())
+	case reflect.Slice:
+		return "[]" + fieldTypeName(t.Elem())
+	default:
+		return t.Kind().String()
+	}
+}
@@ -0,0 +1,59 @@
+package spec
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// Every listed action type must be one validateAction knows (an empty payload fails for other
+// reasons, never as an unknown type), and modifiers like `when` must not be listed.
+func TestActionTypesMatchValidator(t *testing.T) {
+	for _, info := range ActionTypes() {
+		if actionModifiers[info.Type] {
+			t.Errorf("modifier %q listed as an action type", info.Type)
+		}
+		err := validateAction(&Action{Type: info.Type})
+		if err != nil && strings.Contains(err.Error(), "unknown action type") {
+			t.Errorf("listed type %q rejected by the validator: %v", info.Type, err)
+		}
+	}
+	if err := validateAction(&Action{Type: "when"}); err == nil || !strings.Contains(err.Error(), "unknown action type") {
+		t.Errorf(`type "when": got %v, want unknown action type`, err)
+	}
+}
+
+func TestPrintActionTypesOmitsModifiers(t *testing.T) {
+	var b bytes.Buffer
+	if err := PrintActionTypes(&b); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(b.String(), "when (") {
+		t.Errorf("--list-actions lists `when`:\n%s", b.String())
+	}
+}
+
+func TestSchemaTypeEnumMatchesActionTypes(t *testing.T) {
+	var s struct {
+		Definitions map[string]struct {
+			Properties map[string]struct {
+				Enum []string `json:"enum"`
+			} `json:"properties"`
+		} `json:"definitions"`
+	}
+	if err := json.Unmarshal(JSONSchema(), &s); err != nil {
+		t.Fatal(err)
+	}
+	got := s.Definitions["Action"].Properties["type"].Enum
+	var want []string
+	for _, a := range ActionTypes() {
+		want = append(want, a.Type)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("schema type enum = %v, want %v", got, want)
+	}
+	if _, ok := s.Definitions["Action"].Properties["when"]; !ok {
+		t.Error("schema lost the Action.when property")
+	}
+}
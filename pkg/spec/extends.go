@@ -0,0 +1,141 @@
+package spec
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// maxExtendsDepth bounds how many base specs an `extends:` chain may load.
+const maxExtendsDepth = 8
+
+// resolveExtends replaces s with s merged over its `extends:` base (recursively). dir resolves
+// a relative base path; chain holds the absolute paths already loaded, to reject cycles.
+func resolveExtends(s *Spec, dir string, chain []string) error {
+	ref := strings.TrimSpace(s.Extends)
+	if ref == "" {
+		return nil
+	}
+	if len(chain) > maxExtendsDepth {
+		return fmt.Errorf("extends: more than %d levels (%s)", maxExtendsDepth, strings.Join(chain, " -> "))
+	}
+
+	p, err := extendsPath(ref, dir)
+	if err != nil {
+		return fmt.Errorf("extends %q: %w", ref, err)
+	}
+	for _, c := range chain {
+		if c == p {
+			return fmt.Errorf("extends: cycle: %s -> %s", strings.Join(chain, " -> "), p)
+		}
+	}
+
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return fmt.Errorf("extends %q: %w", ref, err)
+	}
+	base, err := parseSpec(b, strings.ToLower(filepath.Ext(p)))
+	if err != nil {
+		return fmt.Errorf("extends %q: %w", ref, err)
+	}
+	if err := resolveExtends(base, filepath.Dir(p), append(chain, p)); err != nil {
+		return err
+	}
+
+	*s = mergeSpec(*base, *s)
+	return nil
+}
+
+// extendsPath makes an `extends:` reference absolute: `~/` is the home directory and relative
+// paths are relative to dir (the extending file's directory, or the working directory).
+func extendsPath(ref, dir string) (string, error) {
+	if ref == "~" || strings.HasPrefix(ref, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		ref = filepath.Join(home, strings.TrimPrefix(ref, "~"))
+	}
+	if !filepath.IsAbs(ref) && dir != "" {
+		ref = filepath.Join(dir, ref)
+	}
+	return filepath.Abs(ref)
+}
+
+// mergeSpec returns over layered on base: set scalars and session fields override, env and meta
+// merge (over wins), actions and hooks append, and windows replace the base window of the same
+// name in place or are appended.
+func mergeSpec(base, over Spec) Spec {
+	out := base
+	out.Extends = ""
+	if over.Version != 0 {
+		out.Version = over.Version
+	}
+	if over.Name != "" {
+		out.Name = over.Name
+	}
+	if over.Description != "" {
+		out.Description = over.Description
+	}
+	overrideSetFields(reflect.ValueOf(&out.Session).Elem(), reflect.ValueOf(over.Session))
+	out.Env = mergeStringMaps(base.Env, over.Env)
+	out.Meta = mergeStringMaps(base.Meta, over.Meta)
+	if over.ExportEnv != nil {
+		out.ExportEnv = over.ExportEnv
+	}
+	if over.Limits != nil {
+		out.Limits = over.Limits
+	}
+
+	out.Windows = append([]Window(nil), base.Windows...)
+	for _, w := range over.Windows {
+		replaced := false
+		for i := range out.Windows {
+			if w.Name != "" && out.Windows[i].Name == w.Name {
+				out.Windows[i] = w
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			out.Windows = append(out.Windows, w)
+		}
+	}
+	out.Actions = append(append([]Action(nil), base.Actions...), over.Actions...)
+	if base.Hooks != nil || over.Hooks != nil {
+		var h Hooks
+		for _, src := range []*Hooks{base.Hooks, over.Hooks} {
+			if src != nil {
+				h.Pre = append(h.Pre, src.Pre...)
+				h.Post = append(h.Post, src.Post...)
+			}
+		}
+		out.Hooks = &h
+	}
+	return out
+}
+
+// overrideSetFields copies every non-zero field of src into dst (same struct type).
+func overrideSetFields(dst, src reflect.Value) {
+	for i := 0; i < src.NumField(); i++ {
+		if f := src.Field(i); !f.IsZero() {
+			dst.Field(i).Set(f)
+		}
+	}
+}
+
+func mergeStringMaps(base, over map[string]string) map[string]string {
+	if len(base) == 0 && len(over) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(base)+len(over))
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range over {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,70 @@
+package spec
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, body string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadFileExtends(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "base.yaml"), `version: 1
+name: base
+session: {root: /base, focus_window: edit}
+env: {A: base, B: base}
+windows:
+  - name: edit
+    layout: tiled
+  - name: logs
+`)
+	writeFile(t, filepath.Join(dir, "app.yaml"), `extends: base.yaml
+name: app
+session: {root: /app}
+env: {B: app}
+windows:
+  - name: logs
+    layout: even-vertical
+  - name: server
+`)
+	s, err := LoadFile(filepath.Join(dir, "app.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "app" || s.Version != 1 || s.Extends != "" {
+		t.Errorf("name, version, extends = %q, %d, %q", s.Name, s.Version, s.Extends)
+	}
+	if s.Session.Root != "/app" || s.Session.FocusWindow != "edit" {
+		t.Errorf("session = %+v, want root overridden and focus_window kept", s.Session)
+	}
+	if s.Env["A"] != "base" || s.Env["B"] != "app" {
+		t.Errorf("env = %v", s.Env)
+	}
+	var windows []string
+	for _, w := range s.Windows {
+		windows = append(windows, w.Name+":"+w.Layout)
+	}
+	// logs is replaced in place, server appended.
+	if got := strings.Join(windows, ","); got != "edit:tiled,logs:even-vertical,server:" {
+		t.Errorf("windows = %s", got)
+	}
+}
+
+func TestLoadFileExtendsCycle(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.yaml"), "version: 1\nextends: b.yaml\n")
+	writeFile(t, filepath.Join(dir, "b.yaml"), "version: 1\nextends: a.yaml\n")
+	writeFile(t, filepath.Join(dir, "self.yaml"), "version: 1\nextends: ./self.yaml\n")
+	for _, name := range []string{"a.yaml", "self.yaml"} {
+		if _, err := LoadFile(filepath.Join(dir, name)); err == nil || !strings.Contains(err.Error(), "cycle") {
+			t.Errorf("%s: err = %v, want a cycle error", name, err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package spec
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// schemaOptional lists keys the tags mark required but Validate defaults, keyed Type.key.
+var schemaOptional = map[string]bool{
+	"Spec.version": true, // Validate defaults it to CurrentVersion
+	"Window.name":  true, // not set on for_each_dir entries (the template names the windows)
+}
+
+// JSONSchema returns a draft-07 JSON Schema for spec files (.tmux-session.yaml/.json), for editor
+// completion and validation. It is derived from the Go structs by reflection, like ActionTypes,
+// so new fields show up without a separate table: every struct becomes a definition, keys without
+// omitempty are required, and unknown keys are rejected (the loader would silently ignore them).
+// Action.type is an enum of ActionTypes, and each type requires its payload object.
+func JSONSchema() []byte {
+	defs := map[string]any{}
+	root := schemaFor(reflect.TypeOf(Spec{}), defs)
+
+	doc := map[string]any{
+		"$schema":     "http://json-schema.org/draft-07/schema#",
+		"$id":         "https://github.com/mpecarina/tmux-session-manager/spec.schema.json",
+		"title":       "tmux-session-manager session spec",
+		"definitions": defs,
+	}
+	for k, v := range root {
+		doc[k] = v
+	}
+
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		// Only plain maps/slices/strings go in; a failure here is a programming error.
+		panic("spec: marshal schema: " + err.Error())
+	}
+	return append(b, '\n')
+}
+
+// schemaFor returns the schema for t, adding struct definitions to defs (by Go type name).
+func schemaFor(t reflect.Type, defs map[string]any) map[string]any {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaFor(t.Elem(), defs)
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem(), defs)}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Struct:
+	default:
+		return map[string]any{}
+	}
+
+	ref := map[string]any{"$ref": "#/definitions/" + t.Name()}
+	if t == reflect.TypeOf(Spec{}) {
+		ref = nil // the root is inlined, not referenced
+	} else if _, ok := defs[t.Name()]; ok {
+		return ref
+	} else {
+		defs[t.Name()] = map[string]any{} // placeholder: breaks recursion
+	}
+
+	props := map[string]any{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key, omitempty := tagKey(f)
+		if key == "" || !f.IsExported() {
+			continue
+		}
+		props[key] = schemaFor(f.Type, defs)
+		if !omitempty && !schemaOptional[t.Name()+"."+key] {
+			required = append(required, key)
+		}
+	}
+
+	def := map[string]any{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		def["required"] = required
+	}
+	if t == reflect.TypeOf(Action{}) {
+		addActionTypeSchema(def, props)
+	}
+
+	if ref == nil {
+		return def
+	}
+	defs[t.Name()] = def
+	return ref
+}
+
+// addActionTypeSchema narrows Action.type to the known action types and requires the matching
+// payload object (type: run needs run: {...}).
+func addActionTypeSchema(def map[string]any, props map[string]any) {
+	var types []string
+	var rules []any
+	for _, a := range ActionTypes() {
+		types = append(types, a.Type)
+		rules = append(rules, map[string]any{
+			"if":   map[string]any{"properties": map[string]any{"type": map[string]any{"const": a.Type}}},
+			"then": map[string]any{"required": []string{a.Type}},
+		})
+	}
+	props["type"] = map[string]any{"type": "string", "enum": types}
+	def["allOf"] = rules
+}
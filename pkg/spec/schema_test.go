@@ -0,0 +1,64 @@
+package spec
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Every exported field of Spec and the structs below it must have a schema property. A field
+// added without a yaml/json key would be loaded from neither format and be missing from the
+// schema, so editors would reject it as an unknown key.
+func TestSchemaCoversSpecFields(t *testing.T) {
+	var doc struct {
+		Properties  map[string]any `json:"properties"`
+		Definitions map[string]struct {
+			Properties map[string]any `json:"properties"`
+		} `json:"definitions"`
+	}
+	if err := json.Unmarshal(JSONSchema(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[reflect.Type]bool{}
+	var walk func(reflect.Type)
+	walk = func(typ reflect.Type) {
+		for typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice || typ.Kind() == reflect.Map {
+			typ = typ.Elem()
+		}
+		if typ.Kind() != reflect.Struct || seen[typ] {
+			return
+		}
+		seen[typ] = true
+
+		props := doc.Properties
+		if typ != reflect.TypeOf(Spec{}) {
+			def, ok := doc.Definitions[typ.Name()]
+			if !ok {
+				t.Errorf("schema has no definition for %s", typ.Name())
+				return
+			}
+			props = def.Properties
+		}
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			key, _ := tagKey(f)
+			if key == "" {
+				t.Errorf("%s.%s has no yaml/json key", typ.Name(), f.Name)
+				continue
+			}
+			if jsonKey, _, _ := strings.Cut(f.Tag.Get("json"), ","); jsonKey != key {
+				t.Errorf("%s.%s: json key %q != yaml key %q", typ.Name(), f.Name, jsonKey, key)
+			}
+			if _, ok := props[key]; !ok {
+				t.Errorf("schema for %s has no property %q (%s)", typ.Name(), key, f.Name)
+			}
+			walk(f.Type)
+		}
+	}
+	walk(reflect.TypeOf(Spec{}))
+}
@@ -1,12 +1,17 @@
 package spec
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -30,17 +35,31 @@ import (
 // Security model:
 //   - By default, only whitelisted actions are allowed (no arbitrary shell).
 //   - If AllowShell is enabled in runtime policy, Shell actions may run.
-//   - Even with AllowShell, implementers should consider "confirm on first use" UX.
+//   - Even with AllowShell, the TUI confirms on first use of a spec that needs it (see
+//     manager/trust.go); CLI applies rely on the explicit flags.
 
 const (
 	// CurrentVersion is the current schema version for project-local specs.
 	CurrentVersion = 1
+
+	// MaxBannerLen bounds banner messages so a breadcrumb stays a breadcrumb.
+	MaxBannerLen = 200
+
+	// DefaultForEachDirMax is the for_each_dir window cap when max is unset, and
+	// MaxForEachDirWindows the highest max a spec may ask for.
+	DefaultForEachDirMax = 20
+	MaxForEachDirWindows = 64
 )
 
 // Spec is the root document.
 type Spec struct {
 	Version int `json:"version" yaml:"version"`
 
+	// Extends names a base spec file (`~/` and paths relative to this file work) that this one is
+	// layered over: set fields override, env merges, and windows replace the base window of the
+	// same name or are appended. Bases may extend further bases; cycles are rejected.
+	Extends string `json:"extends,omitempty" yaml:"extends,omitempty"`
+
 	// Name is optional display name; session name defaults to derived project name unless Session.Name is set.
 	Name string `json:"name,omitempty" yaml:"name,omitempty"`
 
@@ -50,10 +69,14 @@ type Spec struct {
 	// Session settings.
 	Session Session `json:"session,omitempty" yaml:"session,omitempty"`
 
-	// Env are environment variables applied to all shell commands or actions that run processes.
-	// NOTE: env is only meaningful if the executor supports it. Whitelisted tmux actions typically don't need it.
+	// Env are variables for ${VAR} substitution in the spec. With ExportEnv they are also exported
+	// into the session (tmux set-environment), so shells in the spec's windows and panes see them.
 	Env map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
 
+	// ExportEnv opts in to exporting Env (and window/pane env) into the session environment.
+	// Default false: Env is for substitution only.
+	ExportEnv *bool `json:"export_env,omitempty" yaml:"export_env,omitempty"`
+
 	// Windows list.
 	Windows []Window `json:"windows,omitempty" yaml:"windows,omitempty"`
 
@@ -61,8 +84,38 @@ type Spec struct {
 	// If Actions is provided and non-empty, executors may choose it as the primary plan.
 	Actions []Action `json:"actions,omitempty" yaml:"actions,omitempty"`
 
+	// Hooks run once per apply around building the windows/actions.
+	Hooks *Hooks `json:"hooks,omitempty" yaml:"hooks,omitempty"`
+
 	// Meta provides non-functional info.
 	Meta map[string]string `json:"meta,omitempty" yaml:"meta,omitempty"`
+
+	// Limits optionally requests higher (or lower) compile guardrails for big-but-intentional specs
+	// (e.g. dashboards with many ssh panes). Requests are honored only up to the executor's hard
+	// ceilings (see templates.Policy); exceeding a ceiling is an error, not a silent clamp.
+	Limits *Limits `json:"limits,omitempty" yaml:"limits,omitempty"`
+}
+
+// Hooks are session-level actions around the build (e.g. `direnv allow` before, a notification
+// after). They are validated and policy-checked like any other action, and target the session
+// unless they name a window or pane.
+type Hooks struct {
+	// Pre runs once the session exists and Env is exported, before the first window is created.
+	// Without a target window it acts on the session's initial window.
+	Pre []Action `json:"pre,omitempty" yaml:"pre,omitempty"`
+
+	// Post runs after the last window is built and session.focus_window is applied, so an
+	// untargeted action lands in the window the session ends on.
+	Post []Action `json:"post,omitempty" yaml:"post,omitempty"`
+}
+
+// Limits are per-spec guardrail overrides. Zero means "use the executor default".
+type Limits struct {
+	// MaxActions overrides the maximum number of compiled plan actions.
+	MaxActions int `json:"max_actions,omitempty" yaml:"max_actions,omitempty"`
+
+	// MaxCommandLen overrides the maximum length of a single generated tmux command.
+	MaxCommandLen int `json:"max_command_len,omitempty" yaml:"max_command_len,omitempty"`
 }
 
 // Session describes how to create/attach/switch.
@@ -77,6 +130,12 @@ type Session struct {
 	// Root is the working directory for the session. If empty, executor should use project root.
 	Root string `json:"root,omitempty" yaml:"root,omitempty"`
 
+	// Group makes the session part of a tmux session group (`new-session -t <group>`): sessions in
+	// a group share their windows but keep their own current window, so each is an independent
+	// view of one workspace. Group names the group's base session; the windows are built there
+	// when it doesn't exist yet, and later applies only add another view.
+	Group string `json:"group,omitempty" yaml:"group,omitempty"`
+
 	// Attach controls whether to switch/attach automatically after creation. Default true.
 	Attach *bool `json:"attach,omitempty" yaml:"attach,omitempty"`
 
@@ -111,6 +170,10 @@ type Window struct {
 	// Layout is a tmux layout name (e.g. "even-horizontal", "main-vertical", etc.).
 	Layout string `json:"layout,omitempty" yaml:"layout,omitempty"`
 
+	// Env layers over the top-level env for this window: ${VAR} in its actions (and its panes')
+	// sees these values first, and with export_env they are exported into its panes (new-window -e).
+	Env map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+
 	// Focus indicates this window should be selected after creation.
 	Focus bool `json:"focus,omitempty" yaml:"focus,omitempty"`
 
@@ -129,7 +192,43 @@ type Window struct {
 	PanePlan []PanePlanStep `json:"pane_plan,omitempty" yaml:"pane_plan,omitempty"`
 
 	// Actions optionally provides window-scoped actions (for advanced usage).
+	// They run right after the window is created, BEFORE any panes are split.
 	Actions []Action `json:"actions,omitempty" yaml:"actions,omitempty"`
+
+	// OnCreate provides window-scoped setup actions that run AFTER the window's panes are built
+	// (pane_plan/panes and layout), but BEFORE window focus / focus_pane are applied.
+	//
+	// Ordering within a window:
+	//  1. new-window + select-window
+	//  2. actions
+	//  3. pane_plan / panes (including pane actions)
+	//  4. layout
+	//  5. on_create
+	//  6. focus / focus_pane
+	//
+	// on_create actions go through the same policy checks as any other action.
+	OnCreate []Action `json:"on_create,omitempty" yaml:"on_create,omitempty"`
+
+	// ForEachDir turns this entry into one window per matching directory; it must be the entry's
+	// only field.
+	ForEachDir *ForEachDir `json:"for_each_dir,omitempty" yaml:"for_each_dir,omitempty"`
+}
+
+// ForEachDir expands a windows[] entry at apply time: WindowTemplate is instantiated once per
+// directory matching Glob, in sorted order. Matching is done by the executor (no shell).
+type ForEachDir struct {
+	// Glob selects directories relative to the project root (path.Match syntax, e.g.
+	// "services/*"). It may not be absolute or use "..".
+	Glob string `json:"glob" yaml:"glob"`
+
+	// Max caps the number of windows (default DefaultForEachDirMax, at most
+	// MaxForEachDirWindows); more matches fail the apply rather than being cut off.
+	Max int `json:"max,omitempty" yaml:"max,omitempty"`
+
+	// WindowTemplate is the window created per directory. ${DIR} (absolute path) and ${DIR_NAME}
+	// (base name) expand in any of its fields; name defaults to the directory name and root to
+	// the directory.
+	WindowTemplate Window `json:"window_template" yaml:"window_template"`
 }
 
 // PanePlanStep is a tagged union: exactly one of Pane or Split must be set.
@@ -149,19 +248,30 @@ type PanePlanPane struct {
 
 	Actions []Action `json:"actions,omitempty" yaml:"actions,omitempty"`
 	Command string   `json:"command,omitempty" yaml:"command,omitempty"`
+
+	// Prefill types a command without executing it (see Pane.Prefill).
+	Prefill string `json:"prefill,omitempty" yaml:"prefill,omitempty"`
+
+	// Restore optionally re-enters copy-mode / scrollback after the pane is built (see PaneRestore).
+	Restore *PaneRestore `json:"restore,omitempty" yaml:"restore,omitempty"`
+
+	// Env layers over the window's env for this pane (substitution and, with export_env, the
+	// pane's environment via split-window / new-window -e).
+	Env map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
 }
 
 // PanePlanSplit describes how to split from the currently active pane.
 type PanePlanSplit struct {
 	// Direction: "h" (side-by-side) or "v" (stacked)
 	Direction string `json:"direction" yaml:"direction"`
-	// Size: optional, e.g. "30%" or "20"
+	// Size: optional; "30%" (percent of the pane being split, 1-99) or "30" (absolute columns for
+	// h, rows for v). See ParseSplitSize.
 	Size string `json:"size,omitempty" yaml:"size,omitempty"`
 }
 
 // Pane describes a tmux pane within a window.
 type Pane struct {
-	// Name is optional metadata; tmux pane titles may be set by executor.
+	// Name is optional; when set, apply sets the pane title to it (select-pane -T).
 	Name string `json:"name,omitempty" yaml:"name,omitempty"`
 
 	// Root sets working directory for this pane. If empty, uses Window.Root / Session.Root / project root.
@@ -178,6 +288,51 @@ type Pane struct {
 	//   - if it looks like a program + args array is desired, prefer Actions with Run.
 	//   - if it is a shell snippet, it maps to Shell action (subject to policy).
 	Command string `json:"command,omitempty" yaml:"command,omitempty"`
+
+	// Prefill is a command typed into the pane after its actions but NOT executed (no Enter), so
+	// the pane opens with a ready-to-run command line. Safe: it only sends keys. Single line.
+	Prefill string `json:"prefill,omitempty" yaml:"prefill,omitempty"`
+
+	// Restore optionally re-enters copy-mode / scrollback after the pane is built (see PaneRestore).
+	Restore *PaneRestore `json:"restore,omitempty" yaml:"restore,omitempty"`
+
+	// Env layers over the window's env for this pane (see PanePlanPane.Env).
+	Env map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+}
+
+// PaneRestore describes best-effort view state restored into a pane after its actions ran.
+// Snapshots only record it when TMUX_SESSION_MANAGER_SNAPSHOT_PANE_MODE is enabled (off by default).
+//
+// NOTE: This restores the *view* only (copy-mode + how far up it was scrolled). Scrollback content
+// itself is not snapshotted, so the position is relative to whatever the pane has printed by then.
+type PaneRestore struct {
+	// CopyMode enters copy-mode in the pane.
+	CopyMode bool `json:"copy_mode,omitempty" yaml:"copy_mode,omitempty"`
+
+	// ScrollPosition scrolls up this many lines in copy-mode (implies copy_mode). 0 = bottom.
+	ScrollPosition int `json:"scroll_position,omitempty" yaml:"scroll_position,omitempty"`
+}
+
+func validatePaneRestore(r *PaneRestore) error {
+	if r == nil {
+		return nil
+	}
+	if r.ScrollPosition < 0 {
+		return fmt.Errorf("scroll_position must be >= 0 (got %d)", r.ScrollPosition)
+	}
+	if r.ScrollPosition > 0 {
+		r.CopyMode = true
+	}
+	return nil
+}
+
+// validatePrefill trims a pane prefill and rejects multi-line values (a newline would execute it).
+func validatePrefill(v *string) error {
+	*v = strings.TrimSpace(*v)
+	if strings.ContainsAny(*v, "\r\n") {
+		return errors.New("must be a single line")
+	}
+	return nil
 }
 
 // Action is a safe, whitelisted set of operations.
@@ -190,9 +345,11 @@ type Action struct {
 	//   - "send_keys": send literal keys/strings to a pane (structured)
 	//   - "shell": run arbitrary shell snippet (unsafe; requires AllowShell)
 	//   - "sleep": pause for milliseconds (useful for timing)
-	//   - "watch": SAFE builtin repeat helper (compiled to send-keys of a watch command)
+	//   - "watch": SAFE builtin repeat helper (the executor types watch(1) when it's on PATH, else a
+	//     shell loop with AllowShell, else skips it with a warning; see WatchAction)
 	//   - "wait_for_prompt": SAFE best-effort "expect-like" readiness gate (polls pane output until prompt/quiet)
 	//   - "ssh_manager_connect": SAFE structured SSH connect action (optional askpass using Keychain)
+	//   - "banner": SAFE "what is this window" breadcrumb (display-message, or echo when shell is allowed)
 	Type string `json:"type" yaml:"type"`
 
 	// Target describes the tmux target this action applies to.
@@ -223,13 +380,31 @@ type Action struct {
 	// For "ssh_manager_connect" action: safe structured SSH connection (askpass can reuse tmux-ssh-manager Keychain service).
 	SshManagerConnect *SshManagerConnectAction `json:"ssh_manager_connect,omitempty" yaml:"ssh_manager_connect,omitempty"`
 
-	// If true, failure should not abort the whole plan (best-effort).
+	// For "banner" action: short, non-destructive message describing the window/pane (safe).
+	Banner *BannerAction `json:"banner,omitempty" yaml:"banner,omitempty"`
+
+	// If true, a failure while executing this action is reported as a warning and the rest of the
+	// plan still runs (best-effort, e.g. an optional `git fetch`).
 	IgnoreError bool `json:"ignore_error,omitempty" yaml:"ignore_error,omitempty"`
 
+	// When, if set, makes the action conditional on the machine applying the spec: it is skipped
+	// (noted in dry-run output) unless every condition holds. Evaluated when the plan is built.
+	When *Condition `json:"when,omitempty" yaml:"when,omitempty"`
+
 	// If set, executor may show this in UI preview.
 	Comment string `json:"comment,omitempty" yaml:"comment,omitempty"`
 }
 
+// Condition gates an action (see Action.When). Every non-empty field must hold.
+type Condition struct {
+	// PathExists is a file or directory that must exist. ${VAR} and ~ are expanded; relative paths
+	// are resolved against the project root.
+	PathExists string `json:"path_exists,omitempty" yaml:"path_exists,omitempty"`
+
+	// CommandAvailable is a program that must be found in PATH (or an executable path).
+	CommandAvailable string `json:"command_available,omitempty" yaml:"command_available,omitempty"`
+}
+
 // Target describes where an action should apply.
 // Fields are optional; executor may apply defaults based on current creation context.
 type Target struct {
@@ -251,9 +426,20 @@ type RunAction struct {
 	Args    []string `json:"args,omitempty" yaml:"args,omitempty"`
 	// Enter indicates whether executor should send Enter after the command when using send-keys model.
 	Enter *bool `json:"enter,omitempty" yaml:"enter,omitempty"`
+
+	// Exec, when true, runs the program as the pane's own command (new-window/split-window --
+	// program args) instead of typing it into the pane's shell, so there is no shell quoting and no
+	// typing into a shell that isn't ready yet. It only applies to the first action of a pane the
+	// apply creates (and without a target override); elsewhere the run falls back to send-keys.
+	// There is no shell behind the program: the pane closes when it exits (unless remain-on-exit),
+	// Enter is ignored, and wait_for_prompt in that pane waits on the program's output, not a prompt.
+	Exec *bool `json:"exec,omitempty" yaml:"exec,omitempty"`
 }
 
 // SendKeysAction describes sending keystrokes/text to a pane.
+//
+// Keys containing newlines are typed line by line, each followed by Enter (a short pasted script);
+// a trailing newline counts as that line's Enter, so Enter does not add another.
 type SendKeysAction struct {
 	Keys  []string `json:"keys" yaml:"keys"`
 	Enter bool     `json:"enter,omitempty" yaml:"enter,omitempty"`
@@ -273,10 +459,13 @@ type SleepAction struct {
 
 // WatchAction is a SAFE, declarative helper that expresses "repeat this command" without requiring shell passthrough.
 //
-// Executors should compile this into a send-keys of:
+// The engine types this into the pane at apply time:
 //
 //	watch -n <interval_s> -t -- <command>
 //
+// when watch(1) is on PATH. Otherwise it falls back to a `while :; do ...; sleep N; done` shell
+// loop if allow_shell is set, and skips the action with a warning if not.
+//
 // Notes:
 // - interval_s is optional; if unset/<=0, treat as 2 seconds.
 // - command must be non-empty.
@@ -294,22 +483,23 @@ type WatchAction struct {
 //  2. the output has remained unchanged for at least MinQuietMS (to allow MOTD/banner output to settle), AND
 //  3. an optional SettleMS elapses to ensure no trailing output arrives before subsequent actions.
 //
-// Defaulting guidance for executors (when fields are zero/unset):
+// Defaulting guidance for executors (when fields are zero/unset; the timings can be changed
+// globally via TMUX_SESSION_MANAGER_WAIT_TIMEOUT_MS / _WAIT_MIN_QUIET_MS / _WAIT_SETTLE_MS):
 // - TimeoutMS: 15000
 // - MinQuietMS: 500
 // - SettleMS: 250
 // - PromptRegex: executor default (suggestion: (?m)(^.*[#>$] ?$))
 // - MaxLines: 200
 type WaitForPromptAction struct {
-	// TimeoutMS bounds total wait time. If <=0, treat as 15000.
+	// TimeoutMS bounds total wait time. If <=0, use the configured default (15000).
 	TimeoutMS int `json:"timeout_ms,omitempty" yaml:"timeout_ms,omitempty"`
 
 	// MinQuietMS requires the captured pane output to be unchanged for this long before considering it ready.
-	// If <=0, treat as 500.
+	// If <=0, use the configured default (500).
 	MinQuietMS int `json:"min_quiet_ms,omitempty" yaml:"min_quiet_ms,omitempty"`
 
 	// SettleMS is an extra delay after readiness is detected, before allowing subsequent actions to proceed.
-	// If <=0, treat as 250.
+	// If <=0, use the configured default (250).
 	SettleMS int `json:"settle_ms,omitempty" yaml:"settle_ms,omitempty"`
 
 	// PromptRegex is an optional regex used to detect a prompt-like last line.
@@ -353,6 +543,21 @@ type SshManagerConnectAction struct {
 	ConnectTimeoutMS int `json:"connect_timeout_ms,omitempty" yaml:"connect_timeout_ms,omitempty"`
 }
 
+// BannerAction is a SAFE breadcrumb message for a window/pane.
+//
+// Executors should:
+//   - use `tmux display-message` when shell is not allowed (nothing is typed into the pane), or
+//   - send-keys an `echo '<message>'` into the target pane when shell is allowed, so the message
+//     stays visible in the pane's scrollback.
+//
+// Notes:
+// - message must be non-empty, single-line, and at most MaxBannerLen characters.
+// - duration_ms only applies to display-message; if <=0, executor default.
+type BannerAction struct {
+	Message    string `json:"message" yaml:"message"`
+	DurationMS int    `json:"duration_ms,omitempty" yaml:"duration_ms,omitempty"`
+}
+
 // Policy defines runtime execution allowances. This is NOT serialized in the spec.
 // It is provided by the executor based on user configuration (tmux options/env).
 type Policy struct {
@@ -367,6 +572,111 @@ type Policy struct {
 	AllowedTmuxCommands map[string]bool
 }
 
+// hardDeniedTmuxCommands run arbitrary programs (set-hook: later, whenever the hook fires). No
+// allowlist, denylist override, or passthrough setting can enable them; use a "shell" action (gated
+// by AllowShell) instead.
+var hardDeniedTmuxCommands = []string{
+	"run-shell",
+	"if-shell",
+	"pipe-pane",
+	"respawn-pane",
+	"respawn-window",
+	"set-hook",
+}
+
+// hardDeniedTmuxAliases are tmux's short aliases for the hard-denied commands.
+var hardDeniedTmuxAliases = map[string]string{
+	"run":      "run-shell",
+	"if":       "if-shell",
+	"pipep":    "pipe-pane",
+	"respawnp": "respawn-pane",
+	"respawnw": "respawn-window",
+}
+
+// HardDeniedTmuxCommand reports whether the tmux command name resolves to a hard-denied command,
+// and which one. tmux also accepts aliases and unambiguous prefixes ("run", "if-sh"), so those
+// match too.
+func HardDeniedTmuxCommand(name string) (string, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return "", false
+	}
+	if c, ok := hardDeniedTmuxAliases[name]; ok {
+		return c, true
+	}
+	if name == "set" {
+		return "", false // set-option's alias, not a prefix of set-hook
+	}
+	for _, c := range hardDeniedTmuxCommands {
+		if strings.HasPrefix(c, name) {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// tmuxHookNames are tmux's hooks other than the after-<command> ones. tmux stores hooks as options,
+// so set-option can set them as well as set-hook.
+var tmuxHookNames = map[string]bool{
+	"alert-activity": true, "alert-bell": true, "alert-silence": true,
+	"client-active": true, "client-attached": true, "client-detached": true,
+	"client-focus-in": true, "client-focus-out": true, "client-resized": true,
+	"client-session-changed": true, "client-light-theme": true, "client-dark-theme": true,
+	"command-error": true,
+	"pane-died":     true, "pane-exited": true, "pane-focus-in": true, "pane-focus-out": true,
+	"pane-mode-changed": true, "pane-set-clipboard": true, "pane-title-changed": true,
+	"session-created": true, "session-closed": true, "session-renamed": true,
+	"session-window-changed": true,
+	"window-layout-changed":  true, "window-linked": true, "window-renamed": true,
+	"window-resized": true, "window-unlinked": true,
+}
+
+// isTmuxOptionSetter reports whether the tmux command name is set-option or set-window-option
+// (by name, alias or prefix).
+func isTmuxOptionSetter(name string) bool {
+	if name == "set" || name == "setw" {
+		return true
+	}
+	return strings.HasPrefix(name, "set-") && (strings.HasPrefix("set-option", name) || strings.HasPrefix("set-window-option", name))
+}
+
+// HardDeniedTmuxArgs is HardDeniedTmuxCommand for a whole tmux invocation (args[0] is the
+// command). It also catches option setters that set a hook, such as
+// `set-option -g after-new-window 'run-shell ...'`.
+func HardDeniedTmuxArgs(args []string) (string, bool) {
+	if len(args) == 0 {
+		return "", false
+	}
+	if c, denied := HardDeniedTmuxCommand(args[0]); denied {
+		return c, true
+	}
+	if !isTmuxOptionSetter(strings.ToLower(strings.TrimSpace(args[0]))) {
+		return "", false
+	}
+	for _, a := range args[1:] {
+		name := strings.ToLower(strings.TrimSpace(a))
+		if i := strings.IndexByte(name, '['); i >= 0 {
+			name = name[:i] // array index, e.g. after-new-window[1]
+		}
+		if strings.HasPrefix(name, "after-") || tmuxHookNames[name] {
+			return "hook " + name, true
+		}
+	}
+	return "", false
+}
+
+// DeniedInAllowlist returns the allowlisted names that are hard-denied anyway (see
+// HardDeniedTmuxCommand), so callers can warn that the allowlist entry has no effect.
+func DeniedInAllowlist(allowed []string) []string {
+	var out []string
+	for _, a := range allowed {
+		if _, denied := HardDeniedTmuxCommand(a); denied {
+			out = append(out, strings.TrimSpace(a))
+		}
+	}
+	return out
+}
+
 // DefaultPolicy returns a conservative allowlist.
 func DefaultPolicy() Policy {
 	allowed := map[string]bool{
@@ -416,27 +726,51 @@ func (s *Spec) Validate() error {
 		return errors.New("spec must define either windows[] or actions[]")
 	}
 
+	if s.Limits != nil {
+		if s.Limits.MaxActions < 0 {
+			return fmt.Errorf("limits.max_actions must be >= 0 (got %d)", s.Limits.MaxActions)
+		}
+		if s.Limits.MaxCommandLen < 0 {
+			return fmt.Errorf("limits.max_command_len must be >= 0 (got %d)", s.Limits.MaxCommandLen)
+		}
+	}
+
+	// Only exported names must be valid shell identifiers; substitution takes any key.
+	if s.ExportsEnv() {
+		for k := range s.Env {
+			if !envNameRe.MatchString(k) {
+				return fmt.Errorf("env: invalid variable name %q (remove export_env to use it for substitution only)", k)
+			}
+		}
+	}
+
 	for i := range s.Windows {
 		w := &s.Windows[i]
+		if w.ForEachDir != nil {
+			if err := validateForEachDir(w); err != nil {
+				return fmt.Errorf("windows[%d].%w", i, err)
+			}
+			continue
+		}
 		if strings.TrimSpace(w.Name) == "" {
 			return fmt.Errorf("windows[%d].name is required", i)
 		}
 
 		// Validate focus_pane (optional)
-		w.FocusPane = strings.TrimSpace(strings.ToLower(w.FocusPane))
-		if w.FocusPane != "" && w.FocusPane != "active" {
-			// must be an integer string >= 0 (actual meaning is executor-defined relative to pane-base-index)
-			for _, r := range w.FocusPane {
-				if r < '0' || r > '9' {
-					return fmt.Errorf("windows[%d](%s).focus_pane must be \"active\" or a numeric string (got %q)", i, w.Name, w.FocusPane)
-				}
-			}
+		fp, err := NormalizeFocusPane(w.FocusPane)
+		if err != nil {
+			return fmt.Errorf("windows[%d](%s).focus_pane: %w", i, w.Name, err)
+		}
+		w.FocusPane = fp
+
+		if err := validateEnvNames(w.Env); err != nil {
+			return fmt.Errorf("windows[%d](%s).env: %w", i, w.Name, err)
 		}
 
 		// pane_plan validation (preferred when present)
 		if len(w.PanePlan) > 0 {
 			if err := validatePanePlan(w.PanePlan); err != nil {
-				return fmt.Errorf("windows[%d](%s).pane_plan: %w", i, w.Name, err)
+				return fmt.Errorf("windows[%d](%s).%w", i, w.Name, err)
 			}
 
 			for si := range w.PanePlan {
@@ -460,6 +794,15 @@ func (s *Spec) Validate() error {
 						return fmt.Errorf("windows[%d](%s).pane_plan[%d].pane.actions[%d]: %w", i, w.Name, si, ak, err)
 					}
 				}
+				if err := validatePrefill(&step.Pane.Prefill); err != nil {
+					return fmt.Errorf("windows[%d](%s).pane_plan[%d].pane.prefill: %w", i, w.Name, si, err)
+				}
+				if err := validatePaneRestore(step.Pane.Restore); err != nil {
+					return fmt.Errorf("windows[%d](%s).pane_plan[%d].pane.restore: %w", i, w.Name, si, err)
+				}
+				if err := validateEnvNames(step.Pane.Env); err != nil {
+					return fmt.Errorf("windows[%d](%s).pane_plan[%d].pane.env: %w", i, w.Name, si, err)
+				}
 			}
 		}
 
@@ -480,6 +823,15 @@ func (s *Spec) Validate() error {
 					return fmt.Errorf("windows[%d](%s).panes[%d].actions[%d]: %w", i, w.Name, j, k, err)
 				}
 			}
+			if err := validatePrefill(&p.Prefill); err != nil {
+				return fmt.Errorf("windows[%d](%s).panes[%d].prefill: %w", i, w.Name, j, err)
+			}
+			if err := validatePaneRestore(p.Restore); err != nil {
+				return fmt.Errorf("windows[%d](%s).panes[%d].restore: %w", i, w.Name, j, err)
+			}
+			if err := validateEnvNames(p.Env); err != nil {
+				return fmt.Errorf("windows[%d](%s).panes[%d].env: %w", i, w.Name, j, err)
+			}
 		}
 
 		for k := range w.Actions {
@@ -487,6 +839,12 @@ func (s *Spec) Validate() error {
 				return fmt.Errorf("windows[%d](%s).actions[%d]: %w", i, w.Name, k, err)
 			}
 		}
+
+		for k := range w.OnCreate {
+			if err := validateAction(&w.OnCreate[k]); err != nil {
+				return fmt.Errorf("windows[%d](%s).on_create[%d]: %w", i, w.Name, k, err)
+			}
+		}
 	}
 
 	for i := range s.Actions {
@@ -495,6 +853,19 @@ func (s *Spec) Validate() error {
 		}
 	}
 
+	if s.Hooks != nil {
+		for i := range s.Hooks.Pre {
+			if err := validateAction(&s.Hooks.Pre[i]); err != nil {
+				return fmt.Errorf("hooks.pre[%d]: %w", i, err)
+			}
+		}
+		for i := range s.Hooks.Post {
+			if err := validateAction(&s.Hooks.Post[i]); err != nil {
+				return fmt.Errorf("hooks.post[%d]: %w", i, err)
+			}
+		}
+	}
+
 	// Session name constraints are validated later by executor (it may derive).
 	if s.Session.Name != "" {
 		if err := ValidateTmuxName(s.Session.Name); err != nil {
@@ -502,32 +873,113 @@ func (s *Spec) Validate() error {
 		}
 	}
 
+	if s.Session.Group != "" {
+		if err := ValidateTmuxName(s.Session.Group); err != nil {
+			return fmt.Errorf("session.group: %w", err)
+		}
+	}
+
 	// Validate session.focus_window (optional)
-	s.Session.FocusWindow = strings.TrimSpace(s.Session.FocusWindow)
-	if s.Session.FocusWindow != "" {
-		lw := strings.ToLower(s.Session.FocusWindow)
-		if lw == "active" {
-			s.Session.FocusWindow = "active"
-		} else {
-			// Either numeric (window index) or a non-empty name.
-			isNum := true
-			for _, r := range s.Session.FocusWindow {
-				if r < '0' || r > '9' {
-					isNum = false
-					break
-				}
-			}
-			if !isNum {
-				if err := ValidateTmuxName(s.Session.FocusWindow); err != nil {
-					return fmt.Errorf("session.focus_window: %w", err)
-				}
+	fw, err := NormalizeFocusWindow(s.Session.FocusWindow)
+	if err != nil {
+		return fmt.Errorf("session.focus_window: %w", err)
+	}
+	s.Session.FocusWindow = fw
+
+	return nil
+}
+
+// validateForEachDir checks a for_each_dir entry and validates (and normalizes) its window
+// template like any other window.
+func validateForEachDir(w *Window) error {
+	fe := w.ForEachDir
+	rest := *w
+	rest.ForEachDir = nil
+	if !reflect.ValueOf(rest).IsZero() {
+		return errors.New("for_each_dir: the entry's other fields belong under window_template")
+	}
+
+	glob := strings.TrimSpace(fe.Glob)
+	switch {
+	case glob == "":
+		return errors.New("for_each_dir.glob is required")
+	case filepath.IsAbs(glob) || strings.HasPrefix(glob, "~"):
+		return fmt.Errorf("for_each_dir.glob %q must be relative to the project root", glob)
+	case slices.Contains(strings.Split(filepath.ToSlash(glob), "/"), ".."):
+		return fmt.Errorf("for_each_dir.glob %q may not use ..", glob)
+	}
+	if _, err := filepath.Match(glob, ""); err != nil {
+		return fmt.Errorf("for_each_dir.glob %q: %w", glob, err)
+	}
+	fe.Glob = glob
+	if fe.Max < 0 || fe.Max > MaxForEachDirWindows {
+		return fmt.Errorf("for_each_dir.max must be between 1 and %d (got %d)", MaxForEachDirWindows, fe.Max)
+	}
+	if fe.WindowTemplate.ForEachDir != nil {
+		return errors.New("for_each_dir.window_template may not nest for_each_dir")
+	}
+
+	// Validate the template as a one-window spec; the directory name stands in for a missing name.
+	tmp := Spec{Windows: []Window{fe.WindowTemplate}}
+	if strings.TrimSpace(tmp.Windows[0].Name) == "" {
+		tmp.Windows[0].Name = "${DIR_NAME}"
+	}
+	if err := tmp.Validate(); err != nil {
+		// Re-root "windows[0](name).panes[1]: ..." under the template.
+		msg := strings.TrimPrefix(err.Error(), "windows[0]")
+		if strings.HasPrefix(msg, "(") {
+			if i := strings.Index(msg, ")"); i >= 0 {
+				msg = msg[i+1:]
 			}
 		}
+		return errors.New("for_each_dir.window_template" + msg)
 	}
-
+	tmp.Windows[0].Name = fe.WindowTemplate.Name
+	fe.WindowTemplate = tmp.Windows[0]
 	return nil
 }
 
+// NormalizeFocusWindow validates a focus_window value: "" (unset), "active", a window index, or a
+// tmux-safe window name. It returns the trimmed value ("active" lowercased).
+func NormalizeFocusWindow(v string) (string, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return "", nil
+	}
+	if strings.ToLower(v) == "active" {
+		return "active", nil
+	}
+	if isDigits(v) {
+		return v, nil
+	}
+	if err := ValidateTmuxName(v); err != nil {
+		return "", err
+	}
+	return v, nil
+}
+
+// NormalizeFocusPane validates a focus_pane value: "" (unset), "active", or a pane index (meaning is
+// relative to the user's pane-base-index). It returns the trimmed, lowercased value.
+func NormalizeFocusPane(v string) (string, error) {
+	v = strings.TrimSpace(strings.ToLower(v))
+	if v == "" || v == "active" || isDigits(v) {
+		return v, nil
+	}
+	return "", fmt.Errorf("must be \"active\" or a numeric string (got %q)", v)
+}
+
+func isDigits(v string) bool {
+	if v == "" {
+		return false
+	}
+	for _, r := range v {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 func validateAction(a *Action) error {
 	a.Type = strings.TrimSpace(strings.ToLower(a.Type))
 	if a.Type == "" {
@@ -627,9 +1079,34 @@ func validateAction(a *Action) error {
 			return errors.New("ssh_manager_connect.connect_timeout_ms must be >= 0")
 		}
 
+	case "banner":
+		if a.Banner == nil {
+			return errors.New("banner action missing banner{}")
+		}
+		a.Banner.Message = strings.TrimSpace(a.Banner.Message)
+		if a.Banner.Message == "" {
+			return errors.New("banner.message is required")
+		}
+		if strings.ContainsAny(a.Banner.Message, "\r\n") {
+			return errors.New("banner.message must be a single line")
+		}
+		if n := len([]rune(a.Banner.Message)); n > MaxBannerLen {
+			return fmt.Errorf("banner.message too long (%d > %d characters)", n, MaxBannerLen)
+		}
+		if a.Banner.DurationMS < 0 {
+			return errors.New("banner.duration_ms must be >= 0")
+		}
+
 	default:
 		return fmt.Errorf("unknown action type %q", a.Type)
 	}
+	if a.When != nil {
+		a.When.PathExists = strings.TrimSpace(a.When.PathExists)
+		a.When.CommandAvailable = strings.TrimSpace(a.When.CommandAvailable)
+		if a.When.PathExists == "" && a.When.CommandAvailable == "" {
+			return errors.New("when: needs path_exists and/or command_available")
+		}
+	}
 	return nil
 }
 
@@ -640,7 +1117,7 @@ func validatePanePlan(steps []PanePlanStep) error {
 
 	// First step should be a pane.
 	if steps[0].Pane == nil || steps[0].Split != nil {
-		return errors.New("first step must be pane")
+		return errors.New("pane_plan[0]: first step must be pane")
 	}
 
 	for i := range steps {
@@ -650,15 +1127,17 @@ func validatePanePlan(steps []PanePlanStep) error {
 		hasSplit := step.Split != nil
 
 		if hasPane == hasSplit {
-			return fmt.Errorf("step[%d] must have exactly one of pane or split", i)
+			return fmt.Errorf("pane_plan[%d]: must have exactly one of pane or split", i)
 		}
 
 		if hasSplit {
 			dir := strings.ToLower(strings.TrimSpace(step.Split.Direction))
 			if dir != "h" && dir != "v" {
-				return fmt.Errorf("step[%d].split.direction must be 'h' or 'v'", i)
+				return fmt.Errorf("pane_plan[%d].split.direction: must be 'h' or 'v' (got %q)", i, step.Split.Direction)
+			}
+			if _, _, err := ParseSplitSize(step.Split.Size); err != nil {
+				return fmt.Errorf("pane_plan[%d].split.size: %w", i, err)
 			}
-			// Size is optional; no further validation here (executor decides -p vs -l).
 			continue
 		}
 
@@ -672,12 +1151,16 @@ func validatePanePlan(steps []PanePlanStep) error {
 	// Ensure pane/split alternation isn't strictly required, but split must be followed by a pane
 	// for a meaningful plan. Enforce "no trailing split".
 	if steps[len(steps)-1].Split != nil {
-		return errors.New("last step must be pane (cannot end with split)")
+		return fmt.Errorf("pane_plan[%d]: last step must be pane (cannot end with split)", len(steps)-1)
 	}
 
 	return nil
 }
 
+// ErrDisabledByPolicy is wrapped by ValidatePolicy errors for actions that need a capability the
+// policy doesn't grant (shell, or tmux passthrough beyond the allowlist).
+var ErrDisabledByPolicy = errors.New("disabled by policy")
+
 // ValidatePolicy enforces execution policy rules (shell allow, tmux allowlist).
 func (s *Spec) ValidatePolicy(pol Policy) error {
 	// Normalize allowlist presence.
@@ -690,7 +1173,7 @@ func (s *Spec) ValidatePolicy(pol Policy) error {
 		switch a.Type {
 		case "shell":
 			if !pol.AllowShell {
-				return errors.New("shell actions are disabled by policy")
+				return fmt.Errorf("shell actions are %w", ErrDisabledByPolicy)
 			}
 		case "tmux":
 			if a.Tmux == nil {
@@ -700,31 +1183,78 @@ func (s *Spec) ValidatePolicy(pol Policy) error {
 			if cmd == "" {
 				return errors.New("tmux.name is required")
 			}
+			if c, denied := HardDeniedTmuxArgs(append([]string{cmd}, a.Tmux.Args...)); denied {
+				return fmt.Errorf("tmux command %q is never allowed (%s runs arbitrary commands; use a shell action)", cmd, c)
+			}
 			if !pol.AllowTmuxPassthrough && !pol.AllowedTmuxCommands[cmd] {
-				return fmt.Errorf("tmux command %q not allowed by policy", cmd)
+				return fmt.Errorf("tmux command %q is not allowlisted: passthrough %w", cmd, ErrDisabledByPolicy)
 			}
 		}
 		return nil
 	}
 
-	for _, a := range s.Actions {
+	return s.WalkActions(func(path string, a Action) error {
 		if err := check(a); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// WalkActions calls fn for every action in the spec with its field path, using the same form as
+// Validate errors (e.g. `windows[1](editor).pane_plan[2].pane.actions[0]`). It stops at the first
+// error fn returns. Shorthand `command:` fields are only seen as actions after Validate.
+func (s *Spec) WalkActions(fn func(path string, a Action) error) error {
+	for i, a := range s.Actions {
+		if err := fn(fmt.Sprintf("actions[%d]", i), a); err != nil {
 			return err
 		}
 	}
-	for _, w := range s.Windows {
-		for _, a := range w.Actions {
-			if err := check(a); err != nil {
-				return fmt.Errorf("window %q: %w", w.Name, err)
+	if s.Hooks != nil {
+		for i, a := range s.Hooks.Pre {
+			if err := fn(fmt.Sprintf("hooks.pre[%d]", i), a); err != nil {
+				return err
 			}
 		}
-		for _, p := range w.Panes {
-			for _, a := range p.Actions {
-				if err := check(a); err != nil {
-					return fmt.Errorf("window %q pane %q: %w", w.Name, p.Name, err)
+		for i, a := range s.Hooks.Post {
+			if err := fn(fmt.Sprintf("hooks.post[%d]", i), a); err != nil {
+				return err
+			}
+		}
+	}
+	for i, w := range s.Windows {
+		win := fmt.Sprintf("windows[%d](%s)", i, w.Name)
+		if w.ForEachDir != nil {
+			win = fmt.Sprintf("windows[%d].for_each_dir.window_template", i)
+			w = w.ForEachDir.WindowTemplate
+		}
+		for si, step := range w.PanePlan {
+			if step.Pane == nil {
+				continue
+			}
+			for k, a := range step.Pane.Actions {
+				if err := fn(fmt.Sprintf("%s.pane_plan[%d].pane.actions[%d]", win, si, k), a); err != nil {
+					return err
+				}
+			}
+		}
+		for j, p := range w.Panes {
+			for k, a := range p.Actions {
+				if err := fn(fmt.Sprintf("%s.panes[%d].actions[%d]", win, j, k), a); err != nil {
+					return err
 				}
 			}
 		}
+		for k, a := range w.Actions {
+			if err := fn(fmt.Sprintf("%s.actions[%d]", win, k), a); err != nil {
+				return err
+			}
+		}
+		for k, a := range w.OnCreate {
+			if err := fn(fmt.Sprintf("%s.on_create[%d]", win, k), a); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
@@ -800,8 +1330,56 @@ func LoadFile(path string) (*Spec, error) {
 	if err != nil {
 		return nil, err
 	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSpec(b, strings.ToLower(filepath.Ext(path)), abs)
+}
+
+// LoadReader loads a spec from r (e.g. stdin for `--spec -`). hintExt is the format as a file
+// extension (".yaml", ".yml" or ".json"); when empty, the first non-space byte decides: `{` is
+// JSON, anything else YAML. A relative `extends:` is relative to the working directory.
+func LoadReader(r io.Reader, hintExt string) (*Spec, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	ext := strings.ToLower(strings.TrimSpace(hintExt))
+	if ext == "" {
+		ext = ".yaml"
+		if trimmed := bytes.TrimLeft(b, " \t\r\n"); len(trimmed) > 0 && trimmed[0] == '{' {
+			ext = ".json"
+		}
+	}
+	return decodeSpec(b, ext, "")
+}
+
+// decodeSpec parses spec bytes in the format named by ext (as a file extension), resolves
+// `extends:` and validates the merged result. path is the file the bytes came from ("" for a
+// stream).
+func decodeSpec(b []byte, ext, path string) (*Spec, error) {
+	s, err := parseSpec(b, ext)
+	if err != nil {
+		return nil, err
+	}
+	var chain []string
+	dir := ""
+	if path != "" {
+		chain = []string{path}
+		dir = filepath.Dir(path)
+	}
+	if err := resolveExtends(s, dir, chain); err != nil {
+		return nil, err
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
 
-	ext := strings.ToLower(filepath.Ext(path))
+// parseSpec only decodes: a base spec may be incomplete on its own.
+func parseSpec(b []byte, ext string) (*Spec, error) {
 	var s Spec
 	switch ext {
 	case ".yaml", ".yml":
@@ -820,11 +1398,76 @@ func LoadFile(path string) (*Spec, error) {
 			}
 		}
 	}
+	return &s, nil
+}
 
-	if err := s.Validate(); err != nil {
+// MarshalYAML serializes s as a spec file (2-space indented YAML) that LoadFile reads back.
+func MarshalYAML(s *Spec) ([]byte, error) {
+	if s == nil {
+		return nil, errors.New("spec is nil")
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(s); err != nil {
 		return nil, err
 	}
-	return &s, nil
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MarshalJSON serializes s as an indented JSON spec file that LoadFile reads back.
+func MarshalJSON(s *Spec) ([]byte, error) {
+	if s == nil {
+		return nil, errors.New("spec is nil")
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// ParseSplitSize parses a pane_plan split size: "NN%" yields a percent (1-99), a bare "NN" an
+// absolute length in cells (> 0), and "" neither. At most one of percent and length is non-zero.
+func ParseSplitSize(size string) (percent, length int, err error) {
+	size = strings.TrimSpace(size)
+	if size == "" {
+		return 0, 0, nil
+	}
+	if num, ok := strings.CutSuffix(size, "%"); ok {
+		n, err := strconv.Atoi(strings.TrimSpace(num))
+		if err != nil || n < 1 || n > 99 {
+			return 0, 0, fmt.Errorf("percent must be 1-99%% (got %q)", size)
+		}
+		return n, 0, nil
+	}
+	n, err := strconv.Atoi(size)
+	if err != nil || n < 1 {
+		return 0, 0, fmt.Errorf("must be \"NN%%\" or a positive number of columns/rows (got %q)", size)
+	}
+	return 0, n, nil
+}
+
+// envNameRe matches portable environment variable names.
+var envNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateEnvNames rejects window/pane env keys that aren't portable variable names. Unlike the
+// top-level env (see Validate), they are checked whether or not export_env is set.
+func validateEnvNames(env map[string]string) error {
+	for k := range env {
+		if !envNameRe.MatchString(k) {
+			return fmt.Errorf("invalid variable name %q", k)
+		}
+	}
+	return nil
+}
+
+// ExportsEnv reports whether Env is exported into the session environment (ExportEnv, default false).
+func (s Spec) ExportsEnv() bool {
+	return len(s.Env) > 0 && s.ExportEnv != nil && *s.ExportEnv
 }
 
 // ValidateTmuxName validates a tmux session/window name (best-effort).
@@ -844,59 +1487,50 @@ func ValidateTmuxName(name string) error {
 	return nil
 }
 
-// DeriveSessionName returns a tmux-safe derived name from:
-//   - optional prefix
-//   - project basename
+// DeriveSessionName returns the session name for a spec without session.name: the project
+// basename's SessionNameSlug, joined to the prefix's slug with "-" when the prefix has one
+// ("dev" + "~/code/my-app" gives "dev-my_app"). "" when the basename has nothing usable.
 //
 // This does not check for collisions (executor should handle that).
 func DeriveSessionName(prefix, projectPath string) string {
-	base := filepath.Base(strings.TrimRight(projectPath, string(filepath.Separator)))
-	base = sanitizeName(base)
-	if prefix != "" {
-		prefix = sanitizeName(prefix)
-		if prefix != "" {
-			return prefix + "-" + base
-		}
+	base := SessionNameSlug(filepath.Base(strings.TrimRight(projectPath, string(filepath.Separator))))
+	if base == "" {
+		return ""
+	}
+	if prefix = SessionNameSlug(prefix); prefix != "" {
+		return prefix + "-" + base
 	}
 	return base
 }
 
-func sanitizeName(s string) string {
-	s = strings.TrimSpace(s)
-	s = strings.ToLower(s)
-	if s == "" {
+// SessionNameSlug is the tmux-safe form of name: lowercase ASCII letters and digits, with every run
+// of other characters (spaces, dashes, dots, non-ASCII) folded into one "_". It returns "" when
+// nothing usable remains (e.g. "!!!"). The TUI, the apply path and the spec compiler all name
+// sessions through it, so a project maps to the same session everywhere.
+func SessionNameSlug(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
 		return ""
 	}
-	// Replace spaces and path-ish separators.
-	s = strings.ReplaceAll(s, " ", "-")
-	s = strings.ReplaceAll(s, string(filepath.Separator), "-")
-	// Keep only safe chars.
-	re := regexp.MustCompile(`[^a-z0-9_-]+`)
-	s = re.ReplaceAllString(s, "-")
-	s = strings.Trim(s, "-_")
-	s = collapseRepeats(s, '-')
-	s = collapseRepeats(s, '_')
-	if s == "" {
-		s = "session"
-	}
-	return s
-}
-
-func collapseRepeats(s string, ch rune) string {
 	var b strings.Builder
-	b.Grow(len(s))
-	var prev rune
-	for i, r := range s {
-		if i == 0 {
+	lastUnderscore := false
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z':
 			b.WriteRune(r)
-			prev = r
-			continue
-		}
-		if r == ch && prev == ch {
-			continue
+			lastUnderscore = false
+		case r >= 'A' && r <= 'Z':
+			b.WriteRune(r + ('a' - 'A'))
+			lastUnderscore = false
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+			lastUnderscore = false
+		default:
+			if !lastUnderscore {
+				b.WriteRune('_')
+				lastUnderscore = true
+			}
 		}
-		b.WriteRune(r)
-		prev = r
 	}
-	return b.String()
+	return strings.Trim(b.String(), "_")
 }
@@ -0,0 +1,134 @@
+package spec
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHardDeniedTmuxCommand(t *testing.T) {
+	tests := []struct {
+		name   string
+		denied string
+	}{
+		{"run-shell", "run-shell"},
+		{"Run-Shell", "run-shell"},
+		{"run", "run-shell"},
+		{"if", "if-shell"},
+		{"if-sh", "if-shell"},
+		{"pipe-pane", "pipe-pane"},
+		{"pipep", "pipe-pane"},
+		{"respawnw", "respawn-window"},
+		{"set-hook", "set-hook"},
+		{"set-h", "set-hook"},
+		{"set", ""},
+		{"set-option", ""},
+		{"send-keys", ""},
+		{"select-pane", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		c, denied := HardDeniedTmuxCommand(tt.name)
+		if denied != (tt.denied != "") || (denied && c != tt.denied) {
+			t.Errorf("HardDeniedTmuxCommand(%q) = %q, %v; want %q", tt.name, c, denied, tt.denied)
+		}
+	}
+}
+
+// Hooks run commands whenever they fire, so setting one through set-option is denied like set-hook.
+func TestHardDeniedTmuxArgs(t *testing.T) {
+	tests := []struct {
+		args   []string
+		denied string
+	}{
+		{[]string{"set-hook", "-g", "session-created", "run-shell x"}, "set-hook"},
+		{[]string{"set-option", "-g", "after-new-window", "run-shell x"}, "hook after-new-window"},
+		{[]string{"set", "-ga", "pane-exited[3]", "run-shell x"}, "hook pane-exited"},
+		{[]string{"setw", "-t", "s:1", "window-renamed", "x"}, "hook window-renamed"},
+		{[]string{"set-o", "-g", "Client-Attached", "x"}, "hook client-attached"},
+		{[]string{"set-option", "-g", "base-index", "1"}, ""},
+		{[]string{"set-window-option", "-g", "window-status-style", "fg=red"}, ""},
+		{[]string{"send-keys", "-t", "s", "after-new-window"}, ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		c, denied := HardDeniedTmuxArgs(tt.args)
+		if denied != (tt.denied != "") || c != tt.denied {
+			t.Errorf("HardDeniedTmuxArgs(%q) = %q, %v; want %q", tt.args, c, denied, tt.denied)
+		}
+	}
+}
+
+// run-shell & co. are rejected even with passthrough on and an allowlist that names them.
+func TestValidatePolicyRejectsShellTmuxCommands(t *testing.T) {
+	pol := DefaultPolicy()
+	pol.AllowTmuxPassthrough = true
+	pol.AllowedTmuxCommands = map[string]bool{"run-shell": true, "if-shell": true, "run": true}
+	for _, name := range []string{"run-shell", "if-shell", "run"} {
+		s := &Spec{Version: 1, Actions: []Action{{Type: "tmux", Tmux: &TmuxAction{Name: name, Args: []string{"id"}}}}}
+		if err := s.ValidatePolicy(pol); err == nil || !strings.Contains(err.Error(), "never allowed") {
+			t.Errorf("%s: err = %v, want never allowed", name, err)
+		}
+	}
+	ok := &Spec{Version: 1, Actions: []Action{{Type: "tmux", Tmux: &TmuxAction{Name: "send-keys"}}}}
+	pol.AllowedTmuxCommands = nil
+	if err := ok.ValidatePolicy(pol); err != nil {
+		t.Errorf("send-keys: %v", err)
+	}
+}
+
+func TestDeriveSessionName(t *testing.T) {
+	tests := []struct {
+		prefix, path, want string
+	}{
+		{"", "/code/api", "api"},
+		{"", "/code/my-app", "my_app"},
+		{"dev", "/code/api", "dev-api"},
+		{"dev", "/code/my-app/", "dev-my_app"},
+		{"Dev Env", "/code/api", "dev_env-api"},
+		{"!!", "/code/api", "api"},
+		{"dev", "/code/@@@", ""},
+	}
+	for _, tt := range tests {
+		if got := DeriveSessionName(tt.prefix, tt.path); got != tt.want {
+			t.Errorf("DeriveSessionName(%q, %q) = %q, want %q", tt.prefix, tt.path, got, tt.want)
+		}
+	}
+	// Hyphens fold like any other separator, so existing my_app sessions keep their name.
+	for in, want := range map[string]string{"my-app": "my_app", "My API": "my_api", "a__b": "a_b", "-x-": "x", "café": "caf", "@@@": ""} {
+		if got := SessionNameSlug(in); got != want {
+			t.Errorf("SessionNameSlug(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// Without an extension hint, LoadReader sniffs the format: a leading '{' is JSON, anything else YAML.
+func TestLoadReader(t *testing.T) {
+	tests := []struct {
+		name, hint, in string
+		wantErr        bool
+	}{
+		{"yaml", "", "version: 1\nname: piped\nwindows:\n  - name: edit\n", false},
+		{"json", "", "\n  {\"version\": 1, \"name\": \"piped\", \"windows\": [{\"name\": \"edit\"}]}", false},
+		{"json hint", ".json", `{"version": 1, "name": "piped", "windows": [{"name": "edit"}]}`, false},
+		{"yaml flow mapping with yaml hint", ".yaml", "{version: 1, name: piped, windows: [{name: edit}]}", false},
+		{"invalid", "", "version: 1\nwindows:\n  - name: [\n", true},
+		{"fails validation", "", `{"version": 99}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := LoadReader(strings.NewReader(tt.in), tt.hint)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("LoadReader = %+v, want an error", s)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.Name != "piped" || len(s.Windows) != 1 || s.Windows[0].Name != "edit" {
+				t.Errorf("LoadReader = %+v", s)
+			}
+		})
+	}
+}
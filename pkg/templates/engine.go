@@ -6,11 +6,15 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+
+	"tmux-session-manager/pkg/spec"
 )
 
 // Engine compiles a session spec (actions) into tmux commands and can optionally execute them.
@@ -28,13 +32,52 @@ type Engine struct {
 	Policy Policy
 	Runner Runner
 	Clock  func() time.Time
+
+	// Sleep pauses for sleep actions and wait_for_prompt polling; nil means time.Sleep. Tests can
+	// swap it (with Clock, which wait_for_prompt reads) to avoid real delays.
+	Sleep func(time.Duration)
+
+	// LookPath resolves programs for execution-time checks (watch actions); nil means
+	// exec.LookPath.
+	LookPath func(string) (string, error)
+
+	// StrictWaitForPrompt makes wait_for_prompt fail the apply when capture-pane is unavailable
+	// (denied by policy or rejected by tmux). When false (default), readiness gating degrades to a
+	// fixed settle delay and Execute reports a "WARN:" line instead.
+	StrictWaitForPrompt bool
+
+	// WaitDefaults replace the built-in wait_for_prompt timings for actions that leave them unset
+	// (e.g. a team-wide longer timeout for slow jump hosts).
+	WaitDefaults WaitDefaults
+}
+
+// WaitDefaults are the wait_for_prompt timings used when an action leaves a field <=0. A field
+// <=0 here falls back to the built-in default (15000 / 500 / 250 ms).
+type WaitDefaults struct {
+	TimeoutMS  int
+	MinQuietMS int
+	SettleMS   int
 }
 
+// Built-in wait_for_prompt timings (see WaitDefaults).
+const (
+	defaultWaitTimeoutMS  = 15000
+	defaultWaitMinQuietMS = 500
+	defaultWaitSettleMS   = 250
+)
+
+// ignoredErrorsNote marks ignore_error commands in dry-run explanations.
+const ignoredErrorsNote = " (errors ignored)"
+
+// waitForPromptFallbackMS is the minimum settle delay used when capture-pane is unavailable.
+const waitForPromptFallbackMS = 1500
+
 func NewEngine() *Engine {
 	return &Engine{
 		Policy: DefaultPolicy(),
 		Runner: &NoopRunner{},
 		Clock:  time.Now,
+		Sleep:  time.Sleep,
 	}
 }
 
@@ -43,6 +86,11 @@ type Policy struct {
 	// AllowShell permits ActionShell. Disabled by default (safer).
 	AllowShell bool
 
+	// AllowedShellPrefixes, when non-empty, additionally requires every (expanded) shell command to
+	// start with one of these prefixes, e.g. "npm ", "go ", and to contain no shell metacharacters
+	// (see ShellPrefixAllowed). Empty allows any command.
+	AllowedShellPrefixes []string
+
 	// AllowTmuxPassthrough permits ActionTmux. Disabled by default (safer).
 	AllowTmuxPassthrough bool
 
@@ -58,8 +106,20 @@ type Policy struct {
 
 	// MaxCommandLen bounds generated command strings (shell and tmux args).
 	MaxCommandLen int
+
+	// MaxActionsCeiling and MaxCommandLenCeiling are the hard upper bounds a spec may request via
+	// Spec.MaxActions / Spec.MaxCommandLen. The defaults (MaxActions/MaxCommandLen) stay in force
+	// unless a spec explicitly asks for more.
+	MaxActionsCeiling    int
+	MaxCommandLenCeiling int
 }
 
+// Hard ceilings for per-spec limit requests (overridable via Policy).
+const (
+	DefaultMaxActionsCeiling    = 2000
+	DefaultMaxCommandLenCeiling = 32768
+)
+
 func DefaultPolicy() Policy {
 	return Policy{
 		AllowShell:           false,
@@ -72,8 +132,10 @@ func DefaultPolicy() Policy {
 			"pipe-pane":    true,
 			"respawn-pane": true,
 		},
-		MaxActions:    200,
-		MaxCommandLen: 4096,
+		MaxActions:           200,
+		MaxCommandLen:        4096,
+		MaxActionsCeiling:    DefaultMaxActionsCeiling,
+		MaxCommandLenCeiling: DefaultMaxCommandLenCeiling,
 	}
 }
 
@@ -161,6 +223,15 @@ type Spec struct {
 
 	// Optional: metadata for UX
 	Unsafe bool // if true, spec declares it needs unsafe features (shell/passthrough)
+
+	// MaxActions / MaxCommandLen request per-spec guardrails (0 = Policy default).
+	// Compile rejects requests above the Policy ceilings.
+	MaxActions    int
+	MaxCommandLen int
+
+	// Warnings are non-fatal build-time lint findings (e.g. layout/pane-count mismatches).
+	// Compile carries them into Compiled.Warnings.
+	Warnings []string
 }
 
 // ActionKind identifies the action type.
@@ -175,6 +246,7 @@ const (
 	ActionSelectLayout  ActionKind = "select_layout"
 	ActionSendKeys      ActionKind = "send_keys"
 	ActionSetOption     ActionKind = "set_option"
+	ActionSetEnv        ActionKind = "set_environment" // session env (Option=Value) for panes created later
 	ActionDisplay       ActionKind = "display_message"
 
 	// Safe: window/session construction primitives
@@ -182,6 +254,20 @@ const (
 
 	// Safe: readiness / gating primitives (no shell required)
 	ActionWaitForPrompt ActionKind = "wait_for_prompt"
+	ActionSleep         ActionKind = "sleep" // executor pauses SleepMS between commands
+
+	// Safe: repeat Command every WatchIntervalS in a pane. Executed with watch(1) when it's on
+	// PATH; otherwise a shell loop is typed only if AllowShell, else Execute warns and skips it.
+	ActionWatch ActionKind = "watch"
+
+	// Safe: enter copy-mode in a pane and optionally scroll up (view restore; no shell required)
+	ActionCopyMode ActionKind = "copy_mode"
+
+	// Safe: set a pane's title (select-pane -T Name), e.g. from spec pane names
+	ActionSetPaneTitle ActionKind = "set_pane_title"
+
+	// Note: no tmux command, only a dry-run line (Message), e.g. an action skipped by its `when`
+	ActionNote ActionKind = "note"
 
 	// Safe: structured SSH connect (no shell required).
 	//
@@ -208,9 +294,16 @@ type Action struct {
 	// Cwd to use for new window/splits (tmux -c)
 	Cwd string
 
+	// Argv runs a program directly as the new window/split's pane command (-- prog args), with no
+	// shell in between. Takes precedence over Command for new_window/split_window.
+	Argv []string
+
 	// Name for new window (or new name for rename-window)
 	Name string
 
+	// Group joins an ensure_session to this session group (new-session -t <group>).
+	Group string
+
 	// For rename-window
 	// If From is empty, the caller may encode the target in Window (e.g. "0" or "editor"),
 	// otherwise From is treated as the source window identifier.
@@ -219,6 +312,7 @@ type Action struct {
 	// For split
 	Direction string // "h" or "v"
 	Percent   int    // 1-99 optional
+	Length    int    // absolute size in cells (-l); optional, exclusive with Percent
 
 	// For layout
 	Layout string // "tiled", "even-horizontal", etc.
@@ -228,13 +322,23 @@ type Action struct {
 	Keys    []string // raw key tokens; if set, used instead of Command
 	Enter   bool     // append Enter/C-m
 
+	// Echo marks a banner's `echo <Message>` send-keys. It is a shell command line, so when
+	// Policy.AllowedShellPrefixes doesn't admit it, the banner is shown with display-message instead.
+	Echo bool
+
 	// For wait_for_prompt (safe polling gate; executor performs tmux capture-pane polling)
-	TimeoutMS  int    // total timeout; if <=0, executor should default (e.g. 15000)
-	MinQuietMS int    // require unchanged output for at least this long; if <=0 default (e.g. 500)
-	SettleMS   int    // extra delay after ready; if <=0 default (e.g. 250)
+	TimeoutMS  int    // total timeout; if <=0, Engine.WaitDefaults (else 15000)
+	MinQuietMS int    // require unchanged output for at least this long; if <=0 Engine.WaitDefaults (else 500)
+	SettleMS   int    // extra delay after ready; if <=0 Engine.WaitDefaults (else 250)
 	PromptRe   string // optional prompt regex; if empty executor default (e.g. (?m)(^.*[#>$] ?$))
 	MaxLines   int    // max lines of pane output to inspect; if <=0 default (e.g. 200)
 
+	// For sleep
+	SleepMS int
+
+	// For watch (Command is the repeated command)
+	WatchIntervalS int
+
 	// For ssh_manager_connect (safe structured SSH connect).
 	//
 	// NOTE:
@@ -246,7 +350,7 @@ type Action struct {
 	LoginMode        string // askpass|manual|key (executor default: askpass)
 	ConnectTimeoutMS int    // optional; if <=0, executor default
 
-	// For set-option
+	// For set-option (and set-environment: Option is the variable name)
 	Option string
 	Value  string
 	Global bool // set -g
@@ -255,9 +359,20 @@ type Action struct {
 	Message    string
 	DurationMS int
 
+	// For copy_mode: lines to scroll up after entering copy-mode (0 = stay at bottom)
+	ScrollLines int
+
 	// Unsafe: shell and tmux passthrough
 	Shell    string   // shell snippet for ActionShell (expanded)
 	TmuxArgs []string // tmux args (expanded) for ActionTmux, excluding leading "tmux"
+
+	// IgnoreError marks the action best-effort: Execute warns about its failures and continues.
+	IgnoreError bool
+
+	// Env overlays Context.Env for this action's ${VAR} substitution (spec window/pane env). With
+	// ExportEnv, new_window/split_window also set it in the new pane (-e KEY=VALUE).
+	Env       map[string]string
+	ExportEnv bool
 }
 
 // Compiled is the result of compiling a spec into tmux commands.
@@ -272,10 +387,14 @@ type Compiled struct {
 }
 
 // Command is a single tmux invocation.
+// A Command without Args is a note: it only shows up in dry-run output (see ActionNote).
 type Command struct {
 	Args        []string
 	Explanation string // for dry-run / UI preview
 	Unsafe      bool
+
+	// IgnoreError makes Execute report a failure as a "WARN:" line and continue (spec ignore_error).
+	IgnoreError bool
 }
 
 // Compile validates and compiles the spec to tmux commands without executing.
@@ -287,6 +406,26 @@ func (e *Engine) Compile(ctx Context, spec Spec) (Compiled, error) {
 	if p.MaxCommandLen <= 0 {
 		p.MaxCommandLen = 4096
 	}
+	if p.MaxActionsCeiling <= 0 {
+		p.MaxActionsCeiling = DefaultMaxActionsCeiling
+	}
+	if p.MaxCommandLenCeiling <= 0 {
+		p.MaxCommandLenCeiling = DefaultMaxCommandLenCeiling
+	}
+
+	// Per-spec limit requests (bounded by the ceilings).
+	if spec.MaxActions > 0 {
+		if spec.MaxActions > p.MaxActionsCeiling {
+			return Compiled{}, fmt.Errorf("spec: limits.max_actions %d exceeds ceiling %d", spec.MaxActions, p.MaxActionsCeiling)
+		}
+		p.MaxActions = spec.MaxActions
+	}
+	if spec.MaxCommandLen > 0 {
+		if spec.MaxCommandLen > p.MaxCommandLenCeiling {
+			return Compiled{}, fmt.Errorf("spec: limits.max_command_len %d exceeds ceiling %d", spec.MaxCommandLen, p.MaxCommandLenCeiling)
+		}
+		p.MaxCommandLen = spec.MaxCommandLen
+	}
 
 	if ctx.SessionName == "" {
 		return Compiled{}, errors.New("context: missing SessionName")
@@ -314,12 +453,20 @@ func (e *Engine) Compile(ctx Context, spec Spec) (Compiled, error) {
 	}
 
 	var out Compiled
+	out.Warnings = append(out.Warnings, spec.Warnings...)
+	out.Warnings = append(out.Warnings, allowlistWarnings(p)...)
 
 	for i, a := range spec.Actions {
 		cmds, unsafeUsed, warns, err := e.compileAction(ctx, a)
 		if err != nil {
 			return Compiled{}, fmt.Errorf("spec action[%d] (%s): %w", i, a.Kind, err)
 		}
+		if a.IgnoreError {
+			for j := range cmds {
+				cmds[j].IgnoreError = true
+				cmds[j].Explanation += ignoredErrorsNote
+			}
+		}
 		out.Commands = append(out.Commands, cmds...)
 		out.UnsafeUsed = out.UnsafeUsed || unsafeUsed
 		out.Warnings = append(out.Warnings, warns...)
@@ -339,6 +486,60 @@ func (e *Engine) Compile(ctx Context, spec Spec) (Compiled, error) {
 	return out, nil
 }
 
+// allowlistWarnings flags Policy allowlist entries that the hard denylist overrides, so a custom
+// allowlist that names e.g. run-shell doesn't silently look like it took effect.
+func allowlistWarnings(p Policy) []string {
+	var names []string
+	for n, ok := range p.AllowedTmuxCommands {
+		if ok {
+			names = append(names, n)
+		}
+	}
+	denied := spec.DeniedInAllowlist(names)
+	sort.Strings(denied)
+	out := make([]string, 0, len(denied))
+	for _, n := range denied {
+		out = append(out, fmt.Sprintf("policy allowlist includes %q, which is always denied (runs arbitrary commands); ignoring", n))
+	}
+	return out
+}
+
+// GroupedSessionCommand creates session as a member of group (sharing the group's windows).
+func GroupedSessionCommand(session, group, cwd string) Command {
+	args := []string{"new-session", "-d", "-s", session, "-t", group}
+	if strings.TrimSpace(cwd) != "" {
+		args = append(args, "-c", cwd)
+	}
+	return Command{
+		Args:        args,
+		Explanation: "create session " + session + " in group " + group + " (shares its windows)",
+	}
+}
+
+// shellMetachars chain, substitute or redirect commands; with a prefix allowlist, any of them
+// would let "make ; curl ... | sh" through on its "make " prefix.
+const shellMetachars = ";&|$`<>\n\r"
+
+// ShellPrefixAllowed reports whether cmd starts with one of prefixes (leading blanks ignored on
+// both) and contains no shell metacharacters (shellMetachars). An empty prefix list allows
+// everything; an empty command never matches a non-empty list.
+func ShellPrefixAllowed(prefixes []string, cmd string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	cmd = strings.TrimLeft(cmd, " \t")
+	if cmd == "" || strings.ContainsAny(cmd, shellMetachars) {
+		return false
+	}
+	for _, p := range prefixes {
+		p = strings.TrimLeft(p, " \t")
+		if p != "" && strings.HasPrefix(cmd, p) {
+			return true
+		}
+	}
+	return false
+}
+
 // Execute runs compiled commands via the Engine's Runner.
 // If dryRun is true, it does not execute and returns the dry-run lines.
 func (e *Engine) Execute(compiled Compiled, dryRun bool) ([]string, error) {
@@ -351,41 +552,137 @@ func (e *Engine) Execute(compiled Compiled, dryRun bool) ([]string, error) {
 	}
 
 	for _, c := range compiled.Commands {
-		// Special-case: execution-time polling gate (safe).
-		if len(c.Args) > 0 && c.Args[0] == "__wait_for_prompt__" {
-			if err := e.execWaitForPrompt(c); err != nil {
-				return lines, err
-			}
-			continue
-		}
-
-		// Special-case: structured SSH connect (safe).
-		if len(c.Args) > 0 && c.Args[0] == "__ssh_manager_connect__" {
-			if err := e.execSshManagerConnect(c); err != nil {
+		warn, err := e.execCommand(c)
+		if err != nil {
+			if !c.IgnoreError {
 				return lines, err
 			}
+			// Best-effort action (ignore_error): report and keep going.
+			lines = append(lines, fmt.Sprintf("WARN: ignored error (%s): %v", strings.TrimSuffix(c.Explanation, ignoredErrorsNote), err))
 			continue
 		}
-
-		if err := e.Runner.Run(c.Args); err != nil {
-			return lines, err
+		if warn != "" {
+			lines = append(lines, "WARN: "+warn)
 		}
 	}
 	return lines, nil
 }
 
-// DryRunLines returns a user-friendly list of commands and explanations.
-func (e *Engine) execWaitForPrompt(c Command) error {
+// execCommand runs one compiled command, dispatching the execution-time sentinels. It returns a
+// non-empty warning when the command degraded instead of failing.
+func (e *Engine) execCommand(c Command) (string, error) {
+	if len(c.Args) == 0 {
+		return "", nil // note (ActionNote)
+	}
+	switch c.Args[0] {
+	case "__wait_for_prompt__":
+		// Execution-time polling gate (safe).
+		return e.execWaitForPrompt(c)
+	case "__sleep__":
+		// Native pause (safe).
+		return "", e.execSleep(c)
+	case "__watch__":
+		// Repeat a command (watch(1), or a shell loop when allowed).
+		return e.execWatch(c)
+	case "__ssh_manager_connect__":
+		// Structured SSH connect (safe).
+		return "", e.execSshManagerConnect(c)
+	}
+	return "", e.Runner.Run(c.Args)
+}
+
+// execSleep pauses for a "__sleep__" sentinel: ["__sleep__", <ms>].
+func (e *Engine) execSleep(c Command) error {
+	if len(c.Args) < 2 {
+		return fmt.Errorf("sleep: invalid sentinel args: %v", c.Args)
+	}
+	ms, err := strconv.Atoi(strings.TrimSpace(c.Args[1]))
+	if err != nil || ms < 0 {
+		return fmt.Errorf("sleep: invalid duration %q", c.Args[1])
+	}
+	if ms == 0 {
+		return nil
+	}
+	e.sleep(time.Duration(ms) * time.Millisecond)
+	return nil
+}
+
+// sleep pauses via e.Sleep (time.Sleep when unset).
+func (e *Engine) sleep(d time.Duration) {
+	if e.Sleep != nil {
+		e.Sleep(d)
+		return
+	}
+	time.Sleep(d)
+}
+
+// now reads e.Clock (time.Now when unset).
+func (e *Engine) now() time.Time {
+	if e.Clock != nil {
+		return e.Clock()
+	}
+	return time.Now()
+}
+
+// execWatch types the repeat for a "__watch__" sentinel: ["__watch__", <target>, <interval_s>, <cmd>].
+// It uses watch(1) when found on PATH. Without it, the portable fallback is a shell loop, which is
+// only typed when AllowShell is set; otherwise the action is skipped and a warning returned.
+func (e *Engine) execWatch(c Command) (string, error) {
 	if e == nil || e.Runner == nil {
-		return errors.New("wait_for_prompt: missing runner")
+		return "", errors.New("watch: missing runner")
+	}
+	if len(c.Args) < 4 {
+		return "", fmt.Errorf("watch: invalid sentinel args: %v", c.Args)
+	}
+	target := strings.TrimSpace(c.Args[1])
+	interval, err := strconv.Atoi(strings.TrimSpace(c.Args[2]))
+	if err != nil || interval <= 0 {
+		return "", fmt.Errorf("watch: invalid interval %q", c.Args[2])
+	}
+	cmd := strings.TrimSpace(c.Args[3])
+	if target == "" || cmd == "" {
+		return "", errors.New("watch: empty target or command")
+	}
+
+	lookPath := e.LookPath
+	if lookPath == nil {
+		lookPath = exec.LookPath
+	}
+	if _, err := lookPath("watch"); err == nil {
+		return "", e.Runner.Run([]string{"send-keys", "-t", target, watchCommand(interval, cmd), "C-m"})
+	}
+	if !e.Policy.AllowShell {
+		return fmt.Sprintf("watch: `watch` not found in PATH; not repeating %q in %s (enable allow_shell for a shell-loop fallback)", cmd, target), nil
+	}
+	if !ShellPrefixAllowed(e.Policy.AllowedShellPrefixes, cmd) {
+		return fmt.Sprintf("watch: `watch` not found in PATH; not repeating %q in %s (the shell-loop fallback needs an allowed shell prefix: %s)", cmd, target, strings.Join(e.Policy.AllowedShellPrefixes, ", ")), nil
+	}
+	return "", e.Runner.Run([]string{"send-keys", "-t", target, watchLoopCommand(interval, cmd), "C-m"})
+}
+
+// watchCommand is the watch(1) form of a watch action.
+func watchCommand(interval int, cmd string) string {
+	return fmt.Sprintf("watch -n %d -t -- %s", interval, cmd)
+}
+
+// watchLoopCommand is the portable shell fallback for a watch action (Ctrl-C stops it).
+func watchLoopCommand(interval int, cmd string) string {
+	return fmt.Sprintf("while :; do clear; %s; sleep %d; done", cmd, interval)
+}
+
+// execWaitForPrompt polls the target pane until it looks ready.
+// It returns a non-empty warning when readiness gating had to degrade (see StrictWaitForPrompt).
+func (e *Engine) execWaitForPrompt(c Command) (string, error) {
+	if e == nil || e.Runner == nil {
+		return "", errors.New("wait_for_prompt: missing runner")
 	}
 	if len(c.Args) < 6 {
-		return fmt.Errorf("wait_for_prompt: invalid sentinel args: %v", c.Args)
+		return "", fmt.Errorf("wait_for_prompt: invalid sentinel args: %v", c.Args)
 	}
 	// c.Args[0] == "__wait_for_prompt__"
 	target := strings.TrimSpace(c.Args[1])
 	if target == "" {
-		return errors.New("wait_for_prompt: empty target")
+		return "", errors.New("wait_for_prompt: empty target")
 	}
 
 	parseInt := func(s string, def int) int {
@@ -416,7 +713,7 @@ func (e *Engine) execWaitForPrompt(c Command) error {
 
 	compiled, err := regexp.Compile(promptRe)
 	if err != nil {
-		return fmt.Errorf("wait_for_prompt: invalid prompt_regex %q: %w", promptRe, err)
+		return "", fmt.Errorf("wait_for_prompt: invalid prompt_regex %q: %w", promptRe, err)
 	}
 
 	// Polling parameters.
@@ -434,10 +731,10 @@ func (e *Engine) execWaitForPrompt(c Command) error {
 	}
 	pollEvery := 100 * time.Millisecond
 
-	deadline := time.Now().Add(time.Duration(timeoutMS) * time.Millisecond)
+	deadline := e.now().Add(time.Duration(timeoutMS) * time.Millisecond)
 
 	lastSnap := ""
-	lastChange := time.Now()
+	lastChange := e.now()
 
 	// Helper: capture last N lines from pane (best-effort).
 	capture := func() (string, error) {
@@ -467,43 +764,84 @@ func (e *Engine) execWaitForPrompt(c Command) error {
 		return ""
 	}
 
-	for time.Now().Before(deadline) {
+	// Degrade to a fixed settle delay when capture-pane can't be used at all.
+	fallback := func(reason string) (string, error) {
+		if e.StrictWaitForPrompt {
+			return "", fmt.Errorf("wait_for_prompt: capture-pane unavailable for %s: %s", target, reason)
+		}
+		delayMS := minQuietMS + settleMS
+		if delayMS < waitForPromptFallbackMS {
+			delayMS = waitForPromptFallbackMS
+		}
+		if delayMS > timeoutMS {
+			delayMS = timeoutMS
+		}
+		e.sleep(time.Duration(delayMS) * time.Millisecond)
+		return fmt.Sprintf("wait_for_prompt: capture-pane unavailable for %s (%s); waited %dms instead", target, reason, delayMS), nil
+	}
+
+	if e.Policy.DisallowTmuxCommands["capture-pane"] {
+		return fallback("denied by policy")
+	}
+
+	captured := false
+	for e.now().Before(deadline) {
 		snap, err := capture()
 		if err != nil {
+			// A capture that has never succeeded and is rejected outright won't start working.
+			if !captured && captureUnavailable(err) {
+				return fallback(err.Error())
+			}
 			// If capture-pane fails transiently, keep trying until timeout.
-			time.Sleep(pollEvery)
+			e.sleep(pollEvery)
 			continue
 		}
+		captured = true
 
 		if snap != lastSnap {
 			lastSnap = snap
-			lastChange = time.Now()
+			lastChange = e.now()
 		}
 
-		quietFor := time.Since(lastChange)
+		quietFor := e.now().Sub(lastChange)
 		if quietFor < time.Duration(minQuietMS)*time.Millisecond {
-			time.Sleep(pollEvery)
+			e.sleep(pollEvery)
 			continue
 		}
 
 		lastLine := lastNonEmptyLine(snap)
 		if lastLine == "" {
-			time.Sleep(pollEvery)
+			e.sleep(pollEvery)
 			continue
 		}
 
 		// Ready if prompt-like last line matches.
 		if compiled.MatchString(lastLine) {
 			if settleMS > 0 {
-				time.Sleep(time.Duration(settleMS) * time.Millisecond)
+				e.sleep(time.Duration(settleMS) * time.Millisecond)
 			}
-			return nil
+			return "", nil
 		}
 
-		time.Sleep(pollEvery)
+		e.sleep(pollEvery)
 	}
 
-	return fmt.Errorf("wait_for_prompt: timed out after %dms waiting for readiness in %s", timeoutMS, target)
+	return "", fmt.Errorf("wait_for_prompt: timed out after %dms waiting for readiness in %s", timeoutMS, target)
+}
+
+// captureUnavailableRe matches tmux rejecting capture-pane ("unknown command: capture-pane") and
+// wrappers/policies refusing it ("capture-pane: denied", "capture-pane is not allowed").
+var captureUnavailableRe = regexp.MustCompile(`unknown command:? capture-pane|capture-pane:? (is )?(denied|not permitted|not allowed)`)
+
+// captureUnavailable reports whether a capture-pane error means the command is not usable
+// (unknown to this tmux, or rejected by a wrapper/policy), as opposed to a transient failure.
+// The runner's errors quote the whole command line, so a bare "denied" (e.g. "Permission denied"
+// on the socket) must not count.
+func captureUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	return captureUnavailableRe.MatchString(strings.ToLower(err.Error()))
 }
 
 func (e *Engine) execSshManagerConnect(c Command) error {
@@ -590,28 +928,105 @@ func (e *Engine) execSshManagerConnect(c Command) error {
 // ssh_manager_connect no longer implements Keychain/PTY/askpass logic internally.
 // Password automation is delegated to tmux-ssh-manager __connect to avoid duplication and secret leakage.
 
+// sendKeysLines compiles send-keys whose keys (or command) contain newlines into one send-keys per
+// line, each ending in C-m, so a short script can be typed into a pane line by line (still just
+// keystrokes, never shell evaluation). Keys before/after a newline stay on that line's command, in order.
+// A trailing newline already presses Enter, so enter does not add a second one.
+func sendKeysLines(target string, keys []string, enter bool) []Command {
+	var cmds []Command
+	pending := []string{}
+	flush := func(withEnter bool) {
+		args := append([]string{"send-keys", "-t", target}, pending...)
+		if withEnter {
+			args = append(args, "C-m")
+		}
+		cmds = append(cmds, Command{Args: args})
+		pending = []string{}
+	}
+
+	for _, k := range keys {
+		if !strings.Contains(k, "\n") {
+			pending = append(pending, k)
+			continue
+		}
+		lines := strings.Split(strings.ReplaceAll(k, "\r\n", "\n"), "\n")
+		for i, ln := range lines {
+			if ln != "" {
+				pending = append(pending, ln)
+			}
+			if i < len(lines)-1 {
+				flush(true)
+			}
+		}
+	}
+	if len(pending) > 0 {
+		flush(enter)
+	}
+
+	for i := range cmds {
+		cmds[i].Explanation = fmt.Sprintf("send line %d/%d to %s", i+1, len(cmds), target)
+	}
+	return cmds
+}
+
+// DryRunLines returns a user-friendly list of commands and explanations.
 func DryRunLines(compiled Compiled) []string {
-	var lines []string
+	// Preallocate: header + warnings + (explanation + command) per command.
+	n := len(compiled.Warnings)
+	if compiled.UnsafeUsed {
+		n++
+	}
+	for _, c := range compiled.Commands {
+		if c.Explanation != "" {
+			n++
+		}
+		n++
+	}
+	lines := make([]string, 0, n)
+
 	if compiled.UnsafeUsed {
 		lines = append(lines, "WARNING: unsafe actions present (shell and/or tmux passthrough)")
 	}
 	for _, w := range compiled.Warnings {
 		lines = append(lines, "WARN: "+w)
 	}
+
+	// One buffer reused across lines (truncated, not freed); large ssh dashboards produce hundreds
+	// of commands and this is rebuilt on every TUI preview.
+	var buf []byte
 	for _, c := range compiled.Commands {
 		prefix := "tmux "
 		if c.Unsafe {
 			prefix = "tmux (unsafe) "
 		}
 		if c.Explanation != "" {
-			lines = append(lines, fmt.Sprintf("%s# %s", prefix, c.Explanation))
+			buf = append(buf[:0], prefix...)
+			buf = append(buf, "# "...)
+			buf = append(buf, c.Explanation...)
+			lines = append(lines, string(buf))
+		}
+		if len(c.Args) == 0 {
+			continue // note: explanation only
 		}
-		lines = append(lines, prefix+shellJoin(c.Args))
+		buf = append(buf[:0], prefix...)
+		buf = appendShellJoin(buf, c.Args)
+		lines = append(lines, string(buf))
 	}
 	return lines
 }
 
 func (e *Engine) compileAction(ctx Context, a Action) ([]Command, bool, []string, error) {
+	if len(a.Env) > 0 {
+		merged := make(map[string]string, len(ctx.Env)+len(a.Env))
+		for k, v := range ctx.Env {
+			merged[k] = v
+		}
+		for k, v := range a.Env {
+			merged[k] = v
+		}
+		ctx.Env = merged
+	}
+
 	// Default session and cwd
 	session := strings.TrimSpace(a.Session)
 	if session == "" {
@@ -643,6 +1058,9 @@ func (e *Engine) compileAction(ctx Context, a Action) ([]Command, bool, []string
 		//
 		// For now we compile a create-only and warn.
 		warnings = append(warnings, "ensure_session is non-atomic in pure tmux command lists; consider pre-checking in code")
+		if group := strings.TrimSpace(a.Group); group != "" && group != session {
+			return []Command{GroupedSessionCommand(session, group, cwd)}, false, warnings, nil
+		}
 		return []Command{
 			{
 				Args:        []string{"new-session", "-d", "-s", session, "-c", cwd},
@@ -655,13 +1073,21 @@ func (e *Engine) compileAction(ctx Context, a Action) ([]Command, bool, []string
 		if name == "" {
 			return nil, false, nil, errors.New("new_window: missing Name")
 		}
-		// Use tmux new-window -t session -n name -c cwd [command]
-		args := []string{"new-window", "-t", session, "-n", name, "-c", cwd}
-		if strings.TrimSpace(a.Command) != "" {
+		// Use tmux new-window -t session: -n name -c cwd [command]. The trailing colon makes the
+		// target a session (next free index): a bare "svc" is matched against window names first,
+		// so a window named "svc-api" would be picked and its index reported as in use.
+		args := []string{"new-window", "-t", session + ":", "-n", name, "-c", cwd}
+		args = append(args, paneEnvArgs(ctx, a)...)
+		explain := "create window " + name
+		if len(a.Argv) > 0 {
+			args = append(args, "--")
+			args = append(args, substArgv(ctx, a.Argv)...)
+			explain += " running " + subst(ctx, a.Argv[0])
+		} else if strings.TrimSpace(a.Command) != "" {
 			cmd := subst(ctx, a.Command)
 			args = append(args, "--", "bash", "-lc", cmd)
 		}
-		return []Command{{Args: args, Explanation: "create window " + name}}, false, nil, nil
+		return []Command{{Args: args, Explanation: explain}}, false, nil, nil
 
 	case ActionSplitWindow:
 		dir := strings.ToLower(strings.TrimSpace(a.Direction))
@@ -677,17 +1103,38 @@ func (e *Engine) compileAction(ctx Context, a Action) ([]Command, bool, []string
 			target = session + ":" + strings.TrimSpace(a.Window)
 		}
 		args := []string{"split-window", flag, "-t", target, "-c", cwd}
+		args = append(args, paneEnvArgs(ctx, a)...)
+		explain := "split window (" + dir + ")"
+		if a.Percent > 0 && a.Length > 0 {
+			return nil, false, nil, errors.New("split_window: Percent and Length are mutually exclusive")
+		}
 		if a.Percent > 0 {
 			if a.Percent < 1 || a.Percent > 99 {
 				return nil, false, nil, errors.New("split_window: Percent must be 1-99")
 			}
 			args = append(args, "-p", fmt.Sprintf("%d", a.Percent))
+			explain = fmt.Sprintf("split window (%s, %d%%)", dir, a.Percent)
+		}
+		if a.Length < 0 {
+			return nil, false, nil, errors.New("split_window: Length must be > 0")
 		}
-		if strings.TrimSpace(a.Command) != "" {
+		if a.Length > 0 {
+			args = append(args, "-l", strconv.Itoa(a.Length))
+			unit := "rows"
+			if dir == "h" {
+				unit = "columns"
+			}
+			explain = fmt.Sprintf("split window (%s, %d %s)", dir, a.Length, unit)
+		}
+		if len(a.Argv) > 0 {
+			args = append(args, "--")
+			args = append(args, substArgv(ctx, a.Argv)...)
+			explain += " running " + subst(ctx, a.Argv[0])
+		} else if strings.TrimSpace(a.Command) != "" {
 			cmd := subst(ctx, a.Command)
 			args = append(args, "--", "bash", "-lc", cmd)
 		}
-		return []Command{{Args: args, Explanation: "split window (" + dir + ")"}}, false, nil, nil
+		return []Command{{Args: args, Explanation: explain}}, false, nil, nil
 
 	case ActionRenameWindow:
 		// Safe wrapper around: tmux rename-window -t <session>:<fromOrWindow> <newName>
@@ -749,6 +1196,11 @@ func (e *Engine) compileAction(ctx Context, a Action) ([]Command, bool, []string
 		return []Command{{Args: []string{"select-layout", "-t", target, layout}, Explanation: "select layout " + layout}}, false, nil, nil
 
 	case ActionSendKeys:
+		if a.Echo && !ShellPrefixAllowed(e.Policy.AllowedShellPrefixes, subst(ctx, a.Command)) {
+			a.Kind, a.Echo = ActionDisplay, false
+			cmds, _, warnings, err := e.compileAction(ctx, a)
+			return cmds, false, append(warnings, fmt.Sprintf("banner: %q is not an allowed shell command; showing it as a status message", a.Command)), err
+		}
 		target := session
 		if strings.TrimSpace(a.Window) != "" {
 			target = session + ":" + strings.TrimSpace(a.Window)
@@ -765,25 +1217,42 @@ func (e *Engine) compileAction(ctx Context, a Action) ([]Command, bool, []string
 
 		var keys []string
 		if len(a.Keys) > 0 {
+			multiline := false
 			for _, k := range a.Keys {
-				ks := strings.TrimSpace(subst(ctx, k))
-				if ks == "" {
+				ks := subst(ctx, k)
+				if strings.Contains(ks, "\n") {
+					multiline = true
+				} else {
+					ks = strings.TrimSpace(ks)
+				}
+				if strings.TrimSpace(ks) == "" {
 					continue
 				}
 				keys = append(keys, ks)
 			}
+			if multiline {
+				return sendKeysLines(target, keys, a.Enter), false, nil, nil
+			}
 		} else if strings.TrimSpace(a.Command) != "" {
-			keys = append(keys, subst(ctx, a.Command))
+			cmd := subst(ctx, a.Command)
+			if strings.Contains(cmd, "\n") {
+				return sendKeysLines(target, []string{cmd}, a.Enter), false, nil, nil
+			}
+			keys = append(keys, cmd)
 		} else {
 			return nil, false, nil, errors.New("send_keys: missing Keys or Command")
 		}
 
 		args := []string{"send-keys", "-t", target}
 		args = append(args, keys...)
+		explanation := "send keys to " + target
 		if a.Enter {
 			args = append(args, "C-m")
+		} else if strings.TrimSpace(a.Command) != "" {
+			// Typed but not executed (run enter:false / pane prefill): make that visible in previews.
+			explanation = "type into " + target + " (not executed)"
 		}
-		return []Command{{Args: args, Explanation: "send keys to " + target}}, false, nil, nil
+		return []Command{{Args: args, Explanation: explanation}}, false, nil, nil
 
 	case ActionWaitForPrompt:
 		// Execution-time polling action. We encode it as a sentinel command so Engine.Execute
@@ -805,18 +1274,17 @@ func (e *Engine) compileAction(ctx Context, a Action) ([]Command, bool, []string
 			}
 		}
 
-		timeoutMS := a.TimeoutMS
-		if timeoutMS <= 0 {
-			timeoutMS = 15000
-		}
-		minQuietMS := a.MinQuietMS
-		if minQuietMS <= 0 {
-			minQuietMS = 500
-		}
-		settleMS := a.SettleMS
-		if settleMS <= 0 {
-			settleMS = 250
+		firstPositive := func(vals ...int) int {
+			for _, v := range vals {
+				if v > 0 {
+					return v
+				}
+			}
+			return 0
 		}
+		timeoutMS := firstPositive(a.TimeoutMS, e.WaitDefaults.TimeoutMS, defaultWaitTimeoutMS)
+		minQuietMS := firstPositive(a.MinQuietMS, e.WaitDefaults.MinQuietMS, defaultWaitMinQuietMS)
+		settleMS := firstPositive(a.SettleMS, e.WaitDefaults.SettleMS, defaultWaitSettleMS)
 		maxLines := a.MaxLines
 		if maxLines <= 0 {
 			maxLines = 200
@@ -836,6 +1304,46 @@ func (e *Engine) compileAction(ctx Context, a Action) ([]Command, bool, []string
 			Explanation: "wait for prompt (best-effort) in " + target,
 		}}, false, nil, nil
 
+	case ActionSleep:
+		// Execution-time pause, encoded as a sentinel like wait_for_prompt:
+		//   ["__sleep__", <ms>]
+		if a.SleepMS < 0 {
+			return nil, false, nil, errors.New("sleep: negative duration")
+		}
+		return []Command{{
+			Args:        []string{"__sleep__", strconv.Itoa(a.SleepMS)},
+			Explanation: fmt.Sprintf("sleep %dms", a.SleepMS),
+		}}, false, nil, nil
+
+	case ActionWatch:
+		// Execution-time repeat, encoded as a sentinel so Execute can pick watch(1) or the
+		// shell-loop fallback on the machine it runs on:
+		//   ["__watch__", <target>, <interval_s>, <cmd>]
+		target := session
+		if strings.TrimSpace(a.Window) != "" {
+			target = session + ":" + strings.TrimSpace(a.Window)
+		}
+		if strings.TrimSpace(a.Pane) != "" {
+			if strings.HasPrefix(strings.TrimSpace(a.Pane), "%") {
+				target = strings.TrimSpace(a.Pane)
+			} else {
+				target = target + "." + strings.TrimSpace(a.Pane)
+			}
+		}
+		cmd := strings.TrimSpace(subst(ctx, a.Command))
+		if cmd == "" {
+			return nil, false, nil, errors.New("watch: missing Command")
+		}
+		interval := a.WatchIntervalS
+		if interval <= 0 {
+			interval = 2
+		}
+		return []Command{{
+			Args: []string{"__watch__", target, strconv.Itoa(interval), cmd},
+			Explanation: fmt.Sprintf("repeat every %ds in %s: %s (watch(1) if on PATH; else a shell loop with allow_shell, or skipped with a warning)",
+				interval, target, cmd),
+		}}, false, nil, nil
+
 	case ActionSshManagerConnect:
 		// Execution-time connect action. We encode it as a sentinel command so Engine.Execute
 		// can safely send a fixed ssh+askpass wrapper into the target pane.
@@ -903,6 +1411,56 @@ func (e *Engine) compileAction(ctx Context, a Action) ([]Command, bool, []string
 		args = append(args, opt, val)
 		return []Command{{Args: args, Explanation: "set option " + opt}}, false, nil, nil
 
+	case ActionSetEnv:
+		name := strings.TrimSpace(a.Option)
+		if name == "" {
+			return nil, false, nil, errors.New("set_environment: missing Option")
+		}
+		args := []string{"set-environment", "-t", session, name, subst(ctx, a.Value)}
+		return []Command{{Args: args, Explanation: "set environment " + name + " for session " + session}}, false, nil, nil
+
+	case ActionCopyMode:
+		target := session
+		if strings.TrimSpace(a.Window) != "" {
+			target = session + ":" + strings.TrimSpace(a.Window)
+		}
+		if p := strings.TrimSpace(a.Pane); p != "" {
+			if strings.HasPrefix(p, "%") {
+				target = p
+			} else {
+				target = target + "." + p
+			}
+		}
+		cmds := []Command{{Args: []string{"copy-mode", "-t", target}, Explanation: "enter copy-mode"}}
+		if a.ScrollLines > 0 {
+			cmds = append(cmds, Command{
+				Args:        []string{"send-keys", "-t", target, "-X", "-N", strconv.Itoa(a.ScrollLines), "scroll-up"},
+				Explanation: fmt.Sprintf("scroll up %d lines", a.ScrollLines),
+			})
+		}
+		return cmds, false, nil, nil
+
+	case ActionSetPaneTitle:
+		title := subst(ctx, a.Name)
+		if strings.TrimSpace(title) == "" {
+			return nil, false, nil, errors.New("set_pane_title: missing Name")
+		}
+		target := session
+		if strings.TrimSpace(a.Window) != "" {
+			target = session + ":" + strings.TrimSpace(a.Window)
+		}
+		if p := strings.TrimSpace(a.Pane); p != "" {
+			if strings.HasPrefix(p, "%") {
+				target = p
+			} else {
+				target = target + "." + p
+			}
+		}
+		return []Command{{Args: []string{"select-pane", "-t", target, "-T", title}, Explanation: "set pane title " + title}}, false, nil, nil
+
+	case ActionNote:
+		return []Command{{Explanation: a.Message}}, false, nil, nil
+
 	case ActionDisplay:
 		msg := subst(ctx, a.Message)
 		if strings.TrimSpace(msg) == "" {
@@ -912,7 +1470,12 @@ func (e *Engine) compileAction(ctx Context, a Action) ([]Command, bool, []string
 		if d <= 0 {
 			d = 1500
 		}
-		args := []string{"display-message", "-d", fmt.Sprintf("%d", d), msg}
+		args := []string{"display-message", "-d", fmt.Sprintf("%d", d)}
+		if strings.TrimSpace(a.Window) != "" {
+			// Target only affects format expansion; the message is shown on the attached client.
+			args = append(args, "-t", session+":"+strings.TrimSpace(a.Window))
+		}
+		args = append(args, msg)
 		return []Command{{Args: args, Explanation: "display message"}}, false, nil, nil
 
 	case ActionShell:
@@ -934,7 +1497,10 @@ func (e *Engine) compileAction(ctx Context, a Action) ([]Command, bool, []string
 			name = "shell"
 		}
 		sh = subst(ctx, sh)
-		args := []string{"new-window", "-t", session, "-n", name, "-c", cwd, "--", "bash", "-lc", sh}
+		if !ShellPrefixAllowed(e.Policy.AllowedShellPrefixes, sh) {
+			return nil, unsafe, nil, fmt.Errorf("shell command %q does not start with an allowed prefix (%s)", sh, strings.Join(e.Policy.AllowedShellPrefixes, ", "))
+		}
+		args := []string{"new-window", "-t", session + ":", "-n", name, "-c", cwd, "--", "bash", "-lc", sh}
 		return []Command{{Args: args, Explanation: "unsafe shell window " + name, Unsafe: true}}, true, warnings, nil
 
 	case ActionTmux:
@@ -958,6 +1524,10 @@ func (e *Engine) compileAction(ctx Context, a Action) ([]Command, bool, []string
 		if sub == "" {
 			return nil, unsafe, nil, errors.New("tmux: empty subcommand")
 		}
+		// The hard denylist applies even when a caller-supplied Policy replaced DisallowTmuxCommands.
+		if c, denied := spec.HardDeniedTmuxArgs(append([]string{sub}, args[1:]...)); denied {
+			return nil, unsafe, nil, fmt.Errorf("tmux: subcommand %q is disallowed (%s is never allowed)", sub, c)
+		}
 		if e.Policy.DisallowTmuxCommands != nil && e.Policy.DisallowTmuxCommands[sub] {
 			return nil, unsafe, nil, fmt.Errorf("tmux: subcommand %q is disallowed", sub)
 		}
@@ -976,6 +1546,11 @@ func (e *Engine) compileAction(ctx Context, a Action) ([]Command, bool, []string
 	}
 }
 
+// Expand substitutes ${VARS} in s the same way compiled actions do (see subst).
+func Expand(ctx Context, s string) string {
+	return subst(ctx, s)
+}
+
 // subst replaces ${VARS} in a string using Context + environment.
 // Supports ${VAR} and ${VAR:-default}.
 // Known builtins: PROJECT_NAME, PROJECT_PATH, SESSION_NAME, TMUX_SOCK.
@@ -1011,6 +1586,33 @@ func subst(ctx Context, s string) string {
 	})
 }
 
+// paneEnvArgs returns -e KEY=VALUE flags (sorted by key) exporting a.Env into the pane a creates,
+// or nil without ExportEnv.
+func paneEnvArgs(ctx Context, a Action) []string {
+	if !a.ExportEnv || len(a.Env) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(a.Env))
+	for k := range a.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var out []string
+	for _, k := range keys {
+		out = append(out, "-e", k+"="+subst(ctx, a.Env[k]))
+	}
+	return out
+}
+
+// substArgv applies subst to each argument (no word splitting: one arg stays one arg).
+func substArgv(ctx Context, argv []string) []string {
+	out := make([]string, 0, len(argv))
+	for _, a := range argv {
+		out = append(out, subst(ctx, a))
+	}
+	return out
+}
+
 var reVar = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-(.*?))?\}`)
 
 func expandVars(s string, lookup func(key, def string) string) string {
@@ -1050,11 +1652,18 @@ func expandUser(p string) string {
 }
 
 func shellJoin(args []string) string {
-	parts := make([]string, 0, len(args))
-	for _, a := range args {
-		parts = append(parts, shellQuote(a))
+	return string(appendShellJoin(nil, args))
+}
+
+// appendShellJoin appends the shellJoin rendering of args to b (no intermediate slice).
+func appendShellJoin(b []byte, args []string) []byte {
+	for i, a := range args {
+		if i > 0 {
+			b = append(b, ' ')
+		}
+		b = append(b, shellQuote(a)...)
 	}
-	return strings.Join(parts, " ")
+	return b
 }
 
 func shellQuote(s string) string {
@@ -1074,7 +1683,7 @@ func shellQuote(s string) string {
 	if !needs {
 		return s
 	}
-	return "'" + strings.ReplaceAll(s, "'", `'\"'\"'`) + "'"
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
 }
 
 // HashPath returns a short stable hash for a project path. Useful for name collision avoidance.
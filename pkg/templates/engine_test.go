@@ -0,0 +1,486 @@
+package templates
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"tmux-session-manager/pkg/spec"
+)
+
+// recordRunner records every tmux command it is asked to run.
+type recordRunner struct {
+	runs [][]string
+}
+
+func (r *recordRunner) Run(args []string) error {
+	r.runs = append(r.runs, append([]string(nil), args...))
+	return nil
+}
+
+func (r *recordRunner) RunOutput(args []string) (string, error) {
+	r.runs = append(r.runs, append([]string(nil), args...))
+	return "", nil
+}
+
+func TestShellPrefixAllowed(t *testing.T) {
+	prefixes := []string{"make ", "npm run "}
+	tests := []struct {
+		prefixes []string
+		cmd      string
+		want     bool
+	}{
+		{nil, "anything; goes | here", true},
+		{prefixes, "make dev", true},
+		{prefixes, "  npm run build", true},
+		{prefixes, "go test ./...", false},
+		{prefixes, "", false},
+		{prefixes, "make dev; curl x", false},
+		{prefixes, "make dev && rm -rf /", false},
+		{prefixes, "make dev | sh", false},
+		{prefixes, "make $(curl x)", false},
+		{prefixes, "make `id`", false},
+		{prefixes, "make dev > /etc/passwd", false},
+		{prefixes, "make < x", false},
+		{prefixes, "make dev\nrm -rf /", false},
+		{prefixes, "make dev &", false},
+	}
+	for _, tt := range tests {
+		if got := ShellPrefixAllowed(tt.prefixes, tt.cmd); got != tt.want {
+			t.Errorf("ShellPrefixAllowed(%q, %q) = %v, want %v", tt.prefixes, tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestExecWatchFallbackChecksPrefixes(t *testing.T) {
+	noWatch := func(string) (string, error) { return "", errors.New("not found") }
+	tests := []struct {
+		name     string
+		cmd      string
+		sent     bool
+		prefixes []string
+	}{
+		{"no allowlist", "make test; true", true, nil},
+		{"allowed prefix", "make test", true, []string{"make "}},
+		{"other command", "go test ./...", false, []string{"make "}},
+		{"chained onto a prefix", "make test; rm -rf /tmp/x", false, []string{"make "}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &recordRunner{}
+			e := &Engine{Runner: r, LookPath: noWatch}
+			e.Policy.AllowShell = true
+			e.Policy.AllowedShellPrefixes = tt.prefixes
+			warn, err := e.execWatch(Command{Args: []string{"__watch__", "s:0", "2", tt.cmd}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sent := len(r.runs) > 0; sent != tt.sent {
+				t.Fatalf("sent = %v (%v), want %v; warning %q", sent, r.runs, tt.sent, warn)
+			}
+			if tt.sent && !strings.Contains(r.runs[0][3], "while :; do clear; "+tt.cmd) {
+				t.Errorf("fallback = %q", r.runs[0][3])
+			}
+			if !tt.sent && warn == "" {
+				t.Error("skipped without a warning")
+			}
+		})
+	}
+}
+
+func TestBannerEchoChecksPrefixes(t *testing.T) {
+	s := spec.Spec{
+		Version: 1,
+		Actions: []spec.Action{{Type: "banner", Banner: &spec.BannerAction{Message: "hello; world"}}},
+	}
+	ctx := Context{ProjectName: "p", ProjectPath: t.TempDir(), SessionName: "p"}
+	tpl, err := FromSpec(ctx, s, true, false, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		prefixes []string
+		want     string
+	}{
+		{"no allowlist echoes", nil, "send-keys"},
+		{"echo allowed but quoted metacharacter", []string{"echo "}, "display-message"},
+		{"echo not allowed", []string{"make "}, "display-message"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEngine()
+			e.Policy.AllowShell = true
+			e.Policy.AllowedShellPrefixes = tt.prefixes
+			c, err := e.Compile(ctx, tpl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(c.Commands) == 0 || c.Commands[0].Args[0] != tt.want {
+				t.Fatalf("commands = %v, want %s", c.Commands, tt.want)
+			}
+			if tt.want == "display-message" && !strings.Contains(strings.Join(c.Commands[0].Args, " "), "hello; world") {
+				t.Errorf("display-message lost the banner: %v", c.Commands[0].Args)
+			}
+		})
+	}
+}
+
+// dryRunPlan is a large ssh-dashboard-like plan: explained commands, some unsafe, some notes.
+func dryRunPlan(n int) Compiled {
+	c := Compiled{UnsafeUsed: true, Warnings: []string{"w1", "w2"}}
+	for i := 0; i < n; i++ {
+		c.Commands = append(c.Commands,
+			Command{Args: []string{"new-window", "-t", "s:", "-n", fmt.Sprintf("host-%d", i)}, Explanation: "new window"},
+			Command{Args: []string{"send-keys", "-t", fmt.Sprintf("s:host-%d", i), "ssh 'admin'@host && echo $HOME", "C-m"}},
+			Command{Args: []string{"new-window", "--", "bash", "-lc", "tail -f x"}, Unsafe: true, Explanation: "unsafe shell window"},
+			Command{Explanation: "note only"},
+		)
+	}
+	return c
+}
+
+// DryRunLines must render exactly what a plain per-line string concatenation gives.
+func TestDryRunLinesByteIdentical(t *testing.T) {
+	c := dryRunPlan(50)
+	want := []string{"WARNING: unsafe actions present (shell and/or tmux passthrough)", "WARN: w1", "WARN: w2"}
+	for _, cmd := range c.Commands {
+		prefix := "tmux "
+		if cmd.Unsafe {
+			prefix = "tmux (unsafe) "
+		}
+		if cmd.Explanation != "" {
+			want = append(want, prefix+"# "+cmd.Explanation)
+		}
+		if len(cmd.Args) > 0 {
+			quoted := make([]string, len(cmd.Args))
+			for i, a := range cmd.Args {
+				quoted[i] = shellQuote(a)
+			}
+			want = append(want, prefix+strings.Join(quoted, " "))
+		}
+	}
+	got := DryRunLines(c)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") || len(got) != len(want) {
+		t.Fatalf("DryRunLines differs:\n got %q\nwant %q", got[:5], want[:5])
+	}
+	// Lines must not alias the reused buffer.
+	if got[4] != "tmux new-window -t s: -n host-0" {
+		t.Errorf("line 4 = %q", got[4])
+	}
+}
+
+func BenchmarkDryRunLines(b *testing.B) {
+	c := dryRunPlan(250)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = DryRunLines(c)
+	}
+}
+
+// fakeClock is an Engine Clock/Sleep pair: sleeping advances the clock instead of blocking.
+type fakeClock struct {
+	t     time.Time
+	slept []time.Duration
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) sleep(d time.Duration) {
+	c.slept = append(c.slept, d)
+	c.t = c.t.Add(d)
+}
+
+func fakeEngine(r Runner) (*Engine, *fakeClock) {
+	clk := &fakeClock{t: time.Unix(1700000000, 0)}
+	e := NewEngine()
+	e.Runner = r
+	e.Clock, e.Sleep = clk.now, clk.sleep
+	return e, clk
+}
+
+func TestSleepActionNeedsNoShell(t *testing.T) {
+	r := &recordRunner{}
+	e, clk := fakeEngine(r)
+	c, err := e.Compile(Context{ProjectPath: "/p", SessionName: "s"}, Spec{Actions: []Action{{Kind: ActionSleep, SleepMS: 500}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.UnsafeUsed {
+		t.Error("sleep marked unsafe")
+	}
+	if got := strings.Join(DryRunLines(c), "\n"); !strings.Contains(got, "# sleep 500ms") {
+		t.Errorf("dry-run doesn't show the pause:\n%s", got)
+	}
+	if _, err := e.Execute(c, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.runs) != 0 || len(clk.slept) != 1 || clk.slept[0] != 500*time.Millisecond {
+		t.Errorf("runs = %v, slept = %v", r.runs, clk.slept)
+	}
+}
+
+// paneRunner answers capture-pane with outputs[i] on the i-th call (the last one repeats), or err.
+type paneRunner struct {
+	recordRunner
+	outputs []string
+	err     error
+	calls   int
+}
+
+func (r *paneRunner) RunOutput(args []string) (string, error) {
+	r.runs = append(r.runs, args)
+	if r.err != nil {
+		return "", r.err
+	}
+	out := r.outputs[min(r.calls, len(r.outputs)-1)]
+	r.calls++
+	return out, nil
+}
+
+func waitCommand(timeoutMS, quietMS, settleMS int) Command {
+	return Command{Args: []string{"__wait_for_prompt__", "s:0", fmt.Sprint(timeoutMS), fmt.Sprint(quietMS), fmt.Sprint(settleMS), "200", ""}}
+}
+
+func TestWaitForPromptUsesEngineClock(t *testing.T) {
+	t.Run("ready", func(t *testing.T) {
+		e, clk := fakeEngine(&paneRunner{outputs: []string{"building...", "done\n$ "}})
+		warn, err := e.execWaitForPrompt(waitCommand(5000, 300, 50))
+		if err != nil || warn != "" {
+			t.Fatalf("warn %q, err %v", warn, err)
+		}
+		if last := clk.slept[len(clk.slept)-1]; last != 50*time.Millisecond {
+			t.Errorf("settle = %v, want 50ms (slept %v)", last, clk.slept)
+		}
+	})
+	t.Run("timeout", func(t *testing.T) {
+		e, clk := fakeEngine(&paneRunner{outputs: []string{"still compiling"}})
+		start := clk.t
+		if _, err := e.execWaitForPrompt(waitCommand(2000, 0, 0)); err == nil || !strings.Contains(err.Error(), "timed out") {
+			t.Fatalf("err = %v, want timeout", err)
+		}
+		if waited := clk.t.Sub(start); waited < 2*time.Second || waited > 3*time.Second {
+			t.Errorf("waited %v of fake time for a 2s timeout", waited)
+		}
+	})
+	t.Run("fallback", func(t *testing.T) {
+		e, clk := fakeEngine(&paneRunner{err: errors.New("unknown command: capture-pane")})
+		warn, err := e.execWaitForPrompt(waitCommand(5000, 500, 250))
+		if err != nil || !strings.Contains(warn, "waited 1500ms") {
+			t.Fatalf("warn %q, err %v", warn, err)
+		}
+		if len(clk.slept) != 1 || clk.slept[0] != 1500*time.Millisecond {
+			t.Errorf("slept %v, want one 1.5s fallback delay", clk.slept)
+		}
+	})
+}
+
+func TestWatchPresentAndAbsent(t *testing.T) {
+	found := func(string) (string, error) { return "/usr/bin/watch", nil }
+	missing := func(string) (string, error) { return "", errors.New("not found") }
+	ctx := Context{ProjectPath: "/p", SessionName: "s"}
+	tpl := Spec{Actions: []Action{{Kind: ActionWatch, Window: "logs", Command: "kubectl get pods"}}}
+
+	tests := []struct {
+		name       string
+		lookPath   func(string) (string, error)
+		allowShell bool
+		typed      string // "" = nothing sent
+	}{
+		{"watch on PATH", found, false, "watch -n 2 -t -- kubectl get pods"},
+		{"absent, shell off", missing, false, ""},
+		{"absent, shell on", missing, true, "while :; do clear; kubectl get pods; sleep 2; done"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &recordRunner{}
+			e, _ := fakeEngine(r)
+			e.LookPath = tt.lookPath
+			e.Policy.AllowShell = tt.allowShell
+			c, err := e.Compile(ctx, tpl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.UnsafeUsed {
+				t.Error("watch marked unsafe")
+			}
+			if dry := strings.Join(DryRunLines(c), "\n"); !strings.Contains(dry, "repeat every 2s in s:logs") {
+				t.Errorf("dry-run doesn't describe the watch:\n%s", dry)
+			}
+			lines, err := e.Execute(c, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.typed == "" {
+				if len(r.runs) != 0 || !strings.Contains(strings.Join(lines, "\n"), "not repeating") {
+					t.Errorf("runs = %v, lines = %q; want a skip warning", r.runs, lines)
+				}
+				return
+			}
+			if len(r.runs) != 1 || r.runs[0][0] != "send-keys" || r.runs[0][3] != tt.typed {
+				t.Errorf("runs = %q, want send-keys of %q", r.runs, tt.typed)
+			}
+		})
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", "''"},
+		{"plain", "plain"},
+		{"two words", "'two words'"},
+		{"it's", `'it'"'"'s'`},
+		{"'", `''"'"''`},
+		{"$HOME", "'$HOME'"},
+		{"a $b 'c'", `'a $b '"'"'c'"'"''`},
+		{"$(id)", "'$(id)'"},
+	}
+	for _, tt := range tests {
+		got := shellQuote(tt.in)
+		if got != tt.want {
+			t.Errorf("shellQuote(%q) = %s, want %s", tt.in, got, tt.want)
+			continue
+		}
+		// The shell must read it back as the original word.
+		out, err := exec.Command("sh", "-c", "printf %s "+got).Output()
+		if err != nil {
+			t.Fatalf("sh: %v", err)
+		}
+		if string(out) != tt.in {
+			t.Errorf("sh read %s back as %q, want %q", got, out, tt.in)
+		}
+	}
+}
+
+func TestSendKeysMultiline(t *testing.T) {
+	tests := []struct {
+		name   string
+		action Action
+		want   []string
+	}{
+		{
+			"keys in order",
+			Action{Kind: ActionSendKeys, Window: "w", Keys: []string{"cd src", "make\nmake test"}, Enter: true},
+			[]string{"send-keys -t s:w cd src make C-m", "send-keys -t s:w make test C-m"},
+		},
+		{
+			"command split",
+			Action{Kind: ActionSendKeys, Window: "w", Command: "export A=1\necho $A", Enter: true},
+			[]string{"send-keys -t s:w export A=1 C-m", "send-keys -t s:w echo $A C-m"},
+		},
+		{
+			"trailing newline presses enter once",
+			Action{Kind: ActionSendKeys, Window: "w", Keys: []string{"one\ntwo\n"}, Enter: true},
+			[]string{"send-keys -t s:w one C-m", "send-keys -t s:w two C-m"},
+		},
+		{
+			"trailing newline on a command",
+			Action{Kind: ActionSendKeys, Window: "w", Command: "ls\n", Enter: true},
+			[]string{"send-keys -t s:w ls C-m"},
+		},
+		{
+			"last line typed only without enter",
+			Action{Kind: ActionSendKeys, Window: "w", Command: "ls\npwd"},
+			[]string{"send-keys -t s:w ls C-m", "send-keys -t s:w pwd"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewEngine().Compile(Context{ProjectPath: "/p", SessionName: "s"}, Spec{Actions: []Action{tt.action}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, cmd := range c.Commands {
+				got = append(got, strings.Join(cmd.Args, " "))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("commands:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestCaptureUnavailable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("unknown command: capture-pane"), true},
+		{errors.New("tmux-wrapper: capture-pane denied by policy"), true},
+		{errors.New("tmux-wrapper: send-keys denied by policy"), false},
+		{errors.New("command capture-pane is not allowed"), true},
+		{errors.New(`tmux runner: tmux capture-pane -p -t s:0: exit status 1 (stderr="capture-pane: denied")`), true},
+		{errors.New(`tmux runner: tmux capture-pane -p -t s:0: exit status 1 (stderr="error connecting to /tmp/tmux-1000/default (Permission denied)")`), false},
+		{errors.New("access denied"), false},
+		{errors.New("can't find pane: %3"), false},
+	}
+	for _, tt := range tests {
+		if got := captureUnavailable(tt.err); got != tt.want {
+			t.Errorf("captureUnavailable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+// failRunner records commands like recordRunner and fails every `fail` command (e.g. send-keys).
+type failRunner struct {
+	recordRunner
+	fail string
+}
+
+func (r *failRunner) Run(args []string) error {
+	r.runs = append(r.runs, append([]string(nil), args...))
+	if len(args) > 0 && args[0] == r.fail {
+		return errors.New("tmux: boom")
+	}
+	return nil
+}
+
+// A failing ignore_error action is reported and the plan goes on; without it the plan aborts.
+func TestExecuteIgnoreError(t *testing.T) {
+	for _, ignore := range []bool{true, false} {
+		t.Run(fmt.Sprint(ignore), func(t *testing.T) {
+			s := spec.Spec{Version: 1, Windows: []spec.Window{
+				{Name: "fetch", Panes: []spec.Pane{{Actions: []spec.Action{
+					{Type: "run", Run: &spec.RunAction{Program: "git", Args: []string{"fetch"}}, IgnoreError: ignore},
+				}}}},
+				{Name: "edit"},
+			}}
+			ctx := Context{ProjectPath: "/p", SessionName: "s"}
+			tpl, err := FromSpec(ctx, s, false, false, nil, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := &failRunner{fail: "send-keys"}
+			e, _ := fakeEngine(r)
+			c, err := e.Compile(ctx, tpl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines, err := e.Execute(c, false)
+			ranEdit := strings.Contains(fmt.Sprint(r.runs), "edit")
+			if !ignore {
+				if err == nil || ranEdit {
+					t.Errorf("err = %v, ran edit window = %v; want an abort at the failing send-keys", err, ranEdit)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ranEdit {
+				t.Errorf("plan stopped after the ignored error: %v", r.runs)
+			}
+			if got := strings.Join(lines, "\n"); !strings.Contains(got, "WARN: ignored error") || !strings.Contains(got, "boom") {
+				t.Errorf("lines don't report the ignored error:\n%s", got)
+			}
+		})
+	}
+}
@@ -3,7 +3,12 @@ package templates
 import (
 	"errors"
 	"fmt"
+	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 
 	"tmux-session-manager/pkg/spec"
@@ -31,8 +36,9 @@ import (
 // It is a convenience wrapper around BuildFromSpec that keeps call sites (TUI/CLI) thin.
 //
 // Safety / policy:
-// - Shell actions remain opt-in (allowShell must be true, and the spec must pass ValidatePolicy).
-// - Raw tmux passthrough remains opt-in (allowTmuxPassthrough must be true, and allowlist rules apply).
+//   - Shell actions remain opt-in (allowShell must be true, and the spec must pass ValidatePolicy).
+//   - Raw tmux passthrough remains opt-in (allowTmuxPassthrough must be true, and allowlist rules
+//     apply: allowedTmuxCommands, or the default allowlist when it is empty).
 //
 // includeEnsureSession controls whether the plan begins with an ActionEnsureSession.
 // If false, the caller is expected to create the session before executing the compiled plan.
@@ -43,6 +49,7 @@ func FromSpec(
 	s spec.Spec,
 	allowShell bool,
 	allowTmuxPassthrough bool,
+	allowedTmuxCommands map[string]bool,
 	includeEnsureSession bool,
 ) (Spec, error) {
 	projectPath := strings.TrimSpace(ctx.ProjectPath)
@@ -56,16 +63,6 @@ func FromSpec(
 		projectName = filepath.Base(strings.TrimRight(projectPath, string(filepath.Separator)))
 	}
 
-	// If caller didn't provide a session name, derive from spec + project path.
-	sessionName := strings.TrimSpace(ctx.SessionName)
-	if sessionName == "" {
-		if strings.TrimSpace(s.Session.Name) != "" {
-			sessionName = s.Session.Name
-		} else {
-			sessionName = spec.DeriveSessionName(strings.TrimSpace(s.Session.Prefix), projectPath)
-		}
-	}
-
 	// Ensure env exists for substitution (Engine.subst uses ctx.Env plus process env).
 	// We do not mutate the caller's context; BuildFromSpec clones the map from spec.Env.
 	if ctx.Env == nil && s.Env != nil {
@@ -75,13 +72,16 @@ func FromSpec(
 	opt := BuildOptions{
 		ProjectRoot: projectPath,
 		ProjectName: projectName,
-		SessionName: sessionName,
+		// BuildFromSpec derives the name from the spec when the caller didn't provide one.
+		SessionName: strings.TrimSpace(ctx.SessionName),
+		Env:         ctx.Env,
 
 		PreferWindows:        true,
 		IncludeEnsureSession: includeEnsureSession,
 
 		AllowShell:           allowShell,
 		AllowTmuxPassthrough: allowTmuxPassthrough,
+		AllowedTmuxCommands:  allowedTmuxCommands,
 	}
 
 	_, tpl, _, err := BuildFromSpec(&s, opt)
@@ -103,6 +103,10 @@ type BuildOptions struct {
 	// If empty, we derive from spec.Session (Prefix/Name) and project root.
 	SessionName string
 
+	// Env layers over spec.Env (e.g. --spec-env values): the substitution context and the values
+	// exported with export_env come from the merged map.
+	Env map[string]string
+
 	// PreferWindows, when true, will prefer Spec.Windows representation over Spec.Actions if both exist.
 	// If false, Actions takes precedence when provided.
 	PreferWindows bool
@@ -152,20 +156,21 @@ func BuildFromSpec(s *spec.Spec, opt BuildOptions) (ctx Context, tpl Spec, unsaf
 	}
 
 	// Decide final session name:
-	// - explicit BuildOptions.SessionName wins
-	// - else spec.Session.Name wins
+	// - explicit BuildOptions.SessionName wins; callers pass the name they resolved, so it is only
+	//   sanitized when it isn't a valid tmux name already (a derived "dev-api" must stay as is)
+	// - else spec.Session.Name, sanitized
 	// - else derived from spec.Session.Prefix + project basename
 	sessionName := strings.TrimSpace(opt.SessionName)
-	if sessionName == "" {
-		if strings.TrimSpace(s.Session.Name) != "" {
-			// Spec session name may contain variables; allow resolution at compile-time.
-			// We still sanitize it to be tmux-safe-ish.
-			sessionName = strings.TrimSpace(s.Session.Name)
-		} else {
-			sessionName = spec.DeriveSessionName(strings.TrimSpace(s.Session.Prefix), projectRoot)
+	switch {
+	case sessionName != "":
+		if spec.ValidateTmuxName(sessionName) != nil {
+			sessionName = spec.SessionNameSlug(sessionName)
 		}
+	case strings.TrimSpace(s.Session.Name) != "":
+		sessionName = spec.SessionNameSlug(s.Session.Name)
+	default:
+		sessionName = spec.DeriveSessionName(s.Session.Prefix, projectRoot)
 	}
-	sessionName = sanitizeSessionName(sessionName)
 	if sessionName == "" {
 		return Context{}, Spec{}, false, errors.New("resolved session name is empty")
 	}
@@ -208,6 +213,12 @@ func BuildFromSpec(s *spec.Spec, opt BuildOptions) (ctx Context, tpl Spec, unsaf
 		Env:         cloneStringMap(s.Env),
 		TmuxSocket:  "",
 	}
+	for k, v := range opt.Env {
+		if ctx.Env == nil {
+			ctx.Env = make(map[string]string, len(opt.Env))
+		}
+		ctx.Env[k] = v
+	}
 
 	// Convert spec -> templates.Spec
 	tpl = Spec{
@@ -216,6 +227,16 @@ func BuildFromSpec(s *spec.Spec, opt BuildOptions) (ctx Context, tpl Spec, unsaf
 		Name:    firstNonEmpty(s.Name, projectName),
 		Unsafe:  false,
 	}
+	if s.Limits != nil {
+		tpl.MaxActions = s.Limits.MaxActions
+		tpl.MaxCommandLen = s.Limits.MaxCommandLen
+	}
+	windows, warns, err := expandForEachDir(projectRoot, s.Windows)
+	if err != nil {
+		return Context{}, Spec{}, false, err
+	}
+	tpl.Warnings = append(warns, lintWindowLayouts(windows)...)
+	tpl.Warnings = append(tpl.Warnings, lintPanePlanSizes(windows)...)
 
 	// Track whether spec uses unsafe actions.
 	unsafeRequired = false
@@ -227,6 +248,7 @@ func BuildFromSpec(s *spec.Spec, opt BuildOptions) (ctx Context, tpl Spec, unsaf
 			Kind:    ActionEnsureSession,
 			Session: sessionName,
 			Cwd:     root,
+			Group:   strings.TrimSpace(s.Session.Group),
 		})
 	}
 
@@ -248,9 +270,39 @@ func BuildFromSpec(s *spec.Spec, opt BuildOptions) (ctx Context, tpl Spec, unsaf
 		})
 	}
 
+	// Export spec env into the session before any window exists, so every pane the plan creates
+	// inherits it. set-environment is a plain tmux command: no AllowShell needed. Values come from
+	// ctx.Env so a --spec-env override reaches the panes as well as ${VAR} expansion.
+	if s.ExportsEnv() {
+		keys := make([]string, 0, len(s.Env))
+		for k := range s.Env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			tpl.Actions = append(tpl.Actions, Action{
+				Kind:    ActionSetEnv,
+				Session: sessionName,
+				Option:  k,
+				Value:   ctx.Env[k],
+			})
+		}
+	}
+
+	var hooks spec.Hooks
+	if s.Hooks != nil {
+		hooks = *s.Hooks
+	}
+	pre, usedUnsafe, err := convertActions(ctx, sessionName, hooks.Pre, pol, disallowed)
+	if err != nil {
+		return Context{}, Spec{}, false, fmt.Errorf("hooks.pre: %w", err)
+	}
+	unsafeRequired = unsafeRequired || usedUnsafe
+	tpl.Actions = append(tpl.Actions, pre...)
+
 	// Choose representation: Actions (script-like) or Windows (declarative).
 	useActions := len(s.Actions) > 0
-	if opt.PreferWindows && len(s.Windows) > 0 {
+	if opt.PreferWindows && len(windows) > 0 {
 		useActions = false
 	}
 
@@ -262,7 +314,8 @@ func BuildFromSpec(s *spec.Spec, opt BuildOptions) (ctx Context, tpl Spec, unsaf
 		unsafeRequired = unsafeRequired || usedUnsafe
 		tpl.Actions = append(tpl.Actions, acts...)
 	} else {
-		acts, usedUnsafe, err := convertWindows(ctx, sessionName, root, s.Windows, pol, disallowed)
+		exportEnv := s.ExportEnv != nil && *s.ExportEnv
+		acts, usedUnsafe, err := convertWindows(ctx, sessionName, root, windows, exportEnv, pol, disallowed)
 		if err != nil {
 			return Context{}, Spec{}, false, err
 		}
@@ -288,6 +341,14 @@ func BuildFromSpec(s *spec.Spec, opt BuildOptions) (ctx Context, tpl Spec, unsaf
 		}
 	}
 
+	// Post hooks come last, after focus_window, so they see the finished session.
+	post, usedUnsafe, err := convertActions(ctx, sessionName, hooks.Post, pol, disallowed)
+	if err != nil {
+		return Context{}, Spec{}, false, fmt.Errorf("hooks.post: %w", err)
+	}
+	unsafeRequired = unsafeRequired || usedUnsafe
+	tpl.Actions = append(tpl.Actions, post...)
+
 	tpl.Unsafe = unsafeRequired
 	return ctx, tpl, unsafeRequired, nil
 }
@@ -316,17 +377,50 @@ func convertActions(ctx Context, sessionName string, actions []spec.Action, pol
 	unsafeUsed := false
 
 	for i, a := range actions {
+		if reason := WhenUnmet(ctx, a.When); reason != "" {
+			out = append(out, Action{Kind: ActionNote, Message: "skipped (" + reason + ")"})
+			continue
+		}
 		kind, act, usedUnsafe, err := convertSingleAction(ctx, sessionName, a, pol, disallowed)
 		if err != nil {
 			return nil, false, fmt.Errorf("actions[%d] (%s): %w", i, kind, err)
 		}
 		unsafeUsed = unsafeUsed || usedUnsafe
+		if a.IgnoreError {
+			for j := range act {
+				act[j].IgnoreError = true
+			}
+		}
 		out = append(out, act...)
 	}
 
 	return out, unsafeUsed, nil
 }
 
+// WhenUnmet evaluates an action's `when` on this machine: "" when c is nil or holds, otherwise why
+// the action is skipped (e.g. "package.json not found"). Relative paths are resolved against
+// ctx.ProjectPath.
+func WhenUnmet(ctx Context, c *spec.Condition) string {
+	if c == nil {
+		return ""
+	}
+	if p := strings.TrimSpace(c.PathExists); p != "" {
+		path := expandUser(subst(ctx, p))
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(ctx.ProjectPath, path)
+		}
+		if _, err := os.Stat(path); err != nil {
+			return p + " not found"
+		}
+	}
+	if prog := strings.TrimSpace(c.CommandAvailable); prog != "" {
+		if _, err := exec.LookPath(expandUser(subst(ctx, prog))); err != nil {
+			return prog + " not available"
+		}
+	}
+	return ""
+}
+
 func convertSingleAction(ctx Context, defaultSession string, a spec.Action, pol spec.Policy, disallowed map[string]bool) (string, []Action, bool, error) {
 	// Determine target session/window/pane (best-effort)
 	sess := strings.TrimSpace(a.Target.Session)
@@ -356,7 +450,9 @@ func convertSingleAction(ctx Context, defaultSession string, a spec.Action, pol
 		if a.Run == nil {
 			return "run", nil, false, errors.New("missing run{}")
 		}
-		// We model run as send-keys of a shell command line for MVP.
+		// We model run as send-keys of a shell command line for MVP. exec: true runs are folded into
+		// pane creation by convertWindows/convertPanePlan (takeExecRun); any that reach here were not
+		// the first action of a fresh pane and are typed like the rest.
 		argv := append([]string{a.Run.Program}, a.Run.Args...)
 		cmdLine := shellJoin(argv)
 		enter := true
@@ -390,6 +486,40 @@ func convertSingleAction(ctx Context, defaultSession string, a spec.Action, pol
 		}
 		return "ssh_manager_connect", []Action{act}, false, nil
 
+	case "banner":
+		if a.Banner == nil {
+			return "banner", nil, false, errors.New("missing banner{}")
+		}
+		msg := strings.TrimSpace(a.Banner.Message)
+		if msg == "" {
+			return "banner", nil, false, errors.New("banner.message empty")
+		}
+		// Prefer a non-destructive status-line message. Only type into the pane when the user has
+		// opted into shell, since an echo is still a command line executed by the pane's shell (and
+		// the engine checks it against AllowedShellPrefixes, falling back to Message).
+		if pol.AllowShell {
+			act := Action{
+				Kind:       ActionSendKeys,
+				Session:    sess,
+				Window:     strings.TrimSpace(a.Target.Window),
+				Pane:       strings.TrimSpace(a.Target.Pane),
+				Command:    "echo " + shellQuote(msg),
+				Enter:      true,
+				Echo:       true,
+				Message:    msg,
+				DurationMS: a.Banner.DurationMS,
+			}
+			return "banner", []Action{act}, false, nil
+		}
+		act := Action{
+			Kind:       ActionDisplay,
+			Session:    sess,
+			Window:     strings.TrimSpace(a.Target.Window),
+			Message:    msg,
+			DurationMS: a.Banner.DurationMS,
+		}
+		return "banner", []Action{act}, false, nil
+
 	case "wait_for_prompt":
 		if a.WaitForPrompt == nil {
 			return "wait_for_prompt", nil, false, errors.New("missing wait_for_prompt{}")
@@ -419,15 +549,13 @@ func convertSingleAction(ctx Context, defaultSession string, a spec.Action, pol
 		if cmd == "" {
 			return "watch", nil, false, errors.New("watch.command empty")
 		}
-		wrapped := fmt.Sprintf("watch -n %d -t -- %s", interval, cmd)
-
 		act := Action{
-			Kind:    ActionSendKeys,
-			Session: sess,
-			Window:  strings.TrimSpace(a.Target.Window),
-			Pane:    strings.TrimSpace(a.Target.Pane),
-			Command: wrapped,
-			Enter:   true,
+			Kind:           ActionWatch,
+			Session:        sess,
+			Window:         strings.TrimSpace(a.Target.Window),
+			Pane:           strings.TrimSpace(a.Target.Pane),
+			Command:        cmd,
+			WatchIntervalS: interval,
 		}
 		return "watch", []Action{act}, false, nil
 
@@ -463,6 +591,9 @@ func convertSingleAction(ctx Context, defaultSession string, a spec.Action, pol
 		if cmd == "" {
 			return "tmux", nil, false, errors.New("tmux.name empty")
 		}
+		if c, denied := spec.HardDeniedTmuxArgs(append([]string{cmd}, a.Tmux.Args...)); denied {
+			return "tmux", nil, false, fmt.Errorf("tmux subcommand %q is disallowed (%s is never allowed)", cmd, c)
+		}
 		if disallowed != nil && disallowed[cmd] {
 			return "tmux", nil, false, fmt.Errorf("tmux subcommand %q is disallowed", cmd)
 		}
@@ -484,24 +615,17 @@ func convertSingleAction(ctx Context, defaultSession string, a spec.Action, pol
 		if a.Sleep == nil {
 			return "sleep", nil, false, errors.New("missing sleep{}")
 		}
-		// Engine does not have a native sleep action; encode as a shell sleep (still unsafe).
-		// We treat it as safe-ish if AllowShell=true, otherwise reject.
-		if !pol.AllowShell {
-			return "sleep", nil, false, errors.New("sleep requires shell enabled (policy)")
-		}
+		// Native engine pause: no shell involved, so it's allowed regardless of AllowShell.
 		ms := a.Sleep.Milliseconds
 		if ms < 0 {
 			return "sleep", nil, false, errors.New("sleep.ms must be >= 0")
 		}
-		cmd := fmt.Sprintf("sleep %.3f", float64(ms)/1000.0)
 		act := Action{
-			Kind:    ActionShell,
+			Kind:    ActionSleep,
 			Session: sess,
-			Window:  strings.TrimSpace(a.Target.Window),
-			Pane:    strings.TrimSpace(a.Target.Pane),
-			Shell:   cmd,
+			SleepMS: ms,
 		}
-		return "sleep", []Action{act}, true, nil
+		return "sleep", []Action{act}, false, nil
 
 	default:
 		return a.Type, nil, false, fmt.Errorf("unknown action type %q", a.Type)
@@ -512,7 +636,11 @@ func convertSingleAction(ctx Context, defaultSession string, a spec.Action, pol
 // Conversion: Spec.Windows[]
 // --------------------------
 
-func convertWindows(ctx Context, sessionName string, sessionRoot string, windows []spec.Window, pol spec.Policy, disallowed map[string]bool) ([]Action, bool, error) {
+// convertWindows compiles the declarative windows into new-window/split/pane actions.
+//
+// Window and pane env (spec.Window.Env / Pane.Env) are attached to the actions they scope (see
+// Action.Env), pane over window; exportEnv also sets them in the panes the plan creates.
+func convertWindows(ctx Context, sessionName string, sessionRoot string, windows []spec.Window, exportEnv bool, pol spec.Policy, disallowed map[string]bool) ([]Action, bool, error) {
 	if len(windows) == 0 {
 		return nil, false, errors.New("no windows in spec")
 	}
@@ -541,6 +669,18 @@ func convertWindows(ctx Context, sessionName string, sessionRoot string, windows
 		}
 		winRoot = expandUser(subst(ctx, winRoot))
 
+		// An exec run in the first pane becomes the new-window command (the window's first pane is
+		// created with the window), started in that pane's root.
+		firstArgv, firstCwd, w := takeFirstPaneExec(ctx, w, winRoot)
+
+		start := len(out)
+		var firstPaneEnv map[string]string
+		if len(w.PanePlan) > 0 && w.PanePlan[0].Pane != nil {
+			firstPaneEnv = w.PanePlan[0].Pane.Env
+		} else if len(w.PanePlan) == 0 && len(w.Panes) > 0 {
+			firstPaneEnv = w.Panes[0].Env
+		}
+
 		// Window creation strategy:
 		// - Always create spec windows explicitly via new-window -n <name>.
 		//   This avoids relying on an initial session window index (base-index can be 0 or 1),
@@ -549,7 +689,11 @@ func convertWindows(ctx Context, sessionName string, sessionRoot string, windows
 			Kind:    ActionNewWindow,
 			Session: sessionName,
 			Name:    w.Name,
-			Cwd:     winRoot,
+			Cwd:     firstCwd,
+			Argv:    firstArgv,
+			// The window's first pane is created here, so it gets that pane's env too.
+			Env:       mergeEnv(w.Env, firstPaneEnv),
+			ExportEnv: exportEnv,
 		})
 
 		// Ensure the newly created window is selected before any subsequent pane actions.
@@ -583,7 +727,7 @@ func convertWindows(ctx Context, sessionName string, sessionRoot string, windows
 		// - Prefer PanePlan when present (encodes split geometry safely).
 		// - Otherwise fall back to the simple sequential panes[] behavior.
 		if len(w.PanePlan) > 0 {
-			planActs, usedUnsafe, err := convertPanePlan(ctx, sessionName, w, winRoot, pol, disallowed)
+			planActs, usedUnsafe, err := convertPanePlan(ctx, sessionName, w, winRoot, exportEnv, pol, disallowed)
 			if err != nil {
 				return nil, false, err
 			}
@@ -592,6 +736,7 @@ func convertWindows(ctx Context, sessionName string, sessionRoot string, windows
 		} else if len(w.Panes) > 0 {
 			// Panes: simple sequential split model (legacy).
 			for pi, p := range w.Panes {
+				paneStart := len(out)
 				paneRoot := strings.TrimSpace(p.Root)
 				if paneRoot == "" {
 					paneRoot = winRoot
@@ -611,12 +756,15 @@ func convertWindows(ctx Context, sessionName string, sessionRoot string, windows
 					}
 				} else {
 					// Split from active pane; default direction is horizontal for legacy list.
+					var argv []string
+					argv, p.Actions = takeExecRun(ctx, p.Actions)
 					out = append(out, Action{
 						Kind:      ActionSplitWindow,
 						Session:   sessionName,
 						Window:    w.Name,
 						Direction: "h",
 						Cwd:       paneRoot,
+						Argv:      argv,
 					})
 				}
 
@@ -643,6 +791,12 @@ func convertWindows(ctx Context, sessionName string, sessionRoot string, windows
 					out = append(out, acts...)
 				}
 
+				out = append(out, paneTitleActions(sessionName, w.Name, p.Name)...)
+				out = append(out, panePrefillActions(sessionName, w.Name, p.Prefill)...)
+
+				// View restore (copy-mode / scroll) targets the active pane, which is this pane.
+				out = append(out, paneRestoreActions(sessionName, w.Name, p.Restore)...)
+
 				// Pane focus:
 				// Do NOT assume pane index 0. Users commonly set `pane-base-index` to 1, and tmux
 				// also varies indices depending on options. Since our specs already set the active
@@ -655,6 +809,8 @@ func convertWindows(ctx Context, sessionName string, sessionRoot string, windows
 						Window:  w.Name,
 					})
 				}
+
+				scopeEnv(out[paneStart:], mergeEnv(w.Env, p.Env), exportEnv)
 			}
 		}
 
@@ -668,6 +824,24 @@ func convertWindows(ctx Context, sessionName string, sessionRoot string, windows
 			})
 		}
 
+		// Window on_create actions run after panes/layout are in place, but before focus.
+		if len(w.OnCreate) > 0 {
+			acts, usedUnsafe, err := convertActions(ctx, sessionName, w.OnCreate, pol, disallowed)
+			if err != nil {
+				return nil, false, fmt.Errorf("window %q on_create: %w", w.Name, err)
+			}
+			unsafeUsed = unsafeUsed || usedUnsafe
+			for i := range acts {
+				if acts[i].Window == "" {
+					acts[i].Window = w.Name
+				}
+				if acts[i].Session == "" {
+					acts[i].Session = sessionName
+				}
+			}
+			out = append(out, acts...)
+		}
+
 		// Window focus
 		if w.Focus {
 			out = append(out, Action{
@@ -698,6 +872,8 @@ func convertWindows(ctx Context, sessionName string, sessionRoot string, windows
 				Pane:    fp,
 			})
 		}
+
+		scopeEnv(out[start:], w.Env, exportEnv)
 	}
 
 	// Unsafe usage is determined by presence of escape hatches.
@@ -729,12 +905,18 @@ func convertPanePlan(
 	sessionName string,
 	w spec.Window,
 	winRoot string,
+	exportEnv bool,
 	pol spec.Policy,
 	disallowed map[string]bool,
 ) ([]Action, bool, error) {
 	var out []Action
 	unsafeUsed := false
 
+	// An exec run in the pane after a split becomes that split's command; copy the plan so the
+	// caller's spec keeps its actions.
+	plan := append([]spec.PanePlanStep(nil), w.PanePlan...)
+	w.PanePlan = plan
+
 	// Active pane context starts at first pane. We interpret "split" steps as splitting the active pane,
 	// and the following "pane" step describes the newly created pane content.
 	//
@@ -742,6 +924,7 @@ func convertPanePlan(
 	for i, step := range w.PanePlan {
 		if step.Pane != nil {
 			p := step.Pane
+			stepStart := len(out)
 			paneRoot := strings.TrimSpace(p.Root)
 			if paneRoot == "" {
 				paneRoot = winRoot
@@ -782,6 +965,10 @@ func convertPanePlan(
 				out = append(out, acts...)
 			}
 
+			out = append(out, paneTitleActions(sessionName, w.Name, p.Name)...)
+			out = append(out, panePrefillActions(sessionName, w.Name, p.Prefill)...)
+			out = append(out, paneRestoreActions(sessionName, w.Name, p.Restore)...)
+
 			if p.Focus {
 				// See note above: avoid selecting a hardcoded pane index (0) because it breaks with
 				// `pane-base-index 1`. Selecting the window is sufficient for most workflows, and
@@ -793,6 +980,7 @@ func convertPanePlan(
 				})
 			}
 
+			scopeEnv(out[stepStart:], mergeEnv(w.Env, p.Env), exportEnv)
 			continue
 		}
 
@@ -803,23 +991,19 @@ func convertPanePlan(
 				return nil, false, fmt.Errorf("window %q pane_plan[%d].split.direction must be 'h' or 'v'", w.Name, i)
 			}
 
-			// Size: support "NN%" (percent) and "NN" (absolute) by mapping to Percent when possible.
-			percent := 0
-			size := strings.TrimSpace(s.Size)
-			if size != "" && strings.HasSuffix(size, "%") {
-				num := strings.TrimSuffix(size, "%")
-				// best-effort parse
-				pv := 0
-				for _, r := range num {
-					if r < '0' || r > '9' {
-						pv = 0
-						break
-					}
-					pv = pv*10 + int(r-'0')
-				}
-				if pv > 0 && pv < 100 {
-					percent = pv
-				}
+			// Size: "NN%" maps to split-window -p, a bare "NN" to -l (columns for h, rows for v).
+			percent, length, err := spec.ParseSplitSize(s.Size)
+			if err != nil {
+				return nil, false, fmt.Errorf("window %q pane_plan[%d].split.size: %w", w.Name, i, err)
+			}
+
+			var argv []string
+			var env map[string]string
+			if i+1 < len(plan) && plan[i+1].Pane != nil {
+				next := *plan[i+1].Pane
+				argv, next.Actions = takeExecRun(ctx, next.Actions)
+				plan[i+1].Pane = &next
+				env = next.Env
 			}
 
 			out = append(out, Action{
@@ -829,6 +1013,10 @@ func convertPanePlan(
 				Direction: dir,
 				Cwd:       winRoot,
 				Percent:   percent,
+				Length:    length,
+				Argv:      argv,
+				Env:       mergeEnv(w.Env, env),
+				ExportEnv: exportEnv,
 			})
 			continue
 		}
@@ -840,51 +1028,139 @@ func convertPanePlan(
 	return out, unsafeUsed, nil
 }
 
-// -------------------------
-// Helpers
-// -------------------------
+// layoutPaneHints is the pane count above which a named layout stops producing usable panes on a
+// typical (~80x24) terminal. "" covers windows without a layout (tmux default splits).
+var layoutPaneHints = map[string]int{
+	"":                         9,
+	"even-horizontal":          6,
+	"even-vertical":            6,
+	"main-horizontal":          8,
+	"main-horizontal-mirrored": 8,
+	"main-vertical":            8,
+	"main-vertical-mirrored":   8,
+	"tiled":                    16,
+}
 
-func cloneStringMap(m map[string]string) map[string]string {
-	if len(m) == 0 {
-		return nil
+// expandForEachDir replaces each for_each_dir entry with one window per directory matching its
+// glob under projectRoot (sorted; files are skipped), instantiated from the window template with
+// ${DIR} / ${DIR_NAME} substituted. Window names are sanitized and made unique. More matches than
+// the entry's max is an error; no match at all is a warning.
+func expandForEachDir(projectRoot string, windows []spec.Window) ([]spec.Window, []string, error) {
+	var out []spec.Window
+	var warns []string
+	used := map[string]bool{}
+	for _, w := range windows {
+		if w.ForEachDir == nil {
+			used[w.Name] = true
+		}
 	}
-	out := make(map[string]string, len(m))
-	for k, v := range m {
-		out[k] = v
+
+	for wi, w := range windows {
+		fe := w.ForEachDir
+		if fe == nil {
+			out = append(out, w)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(projectRoot, fe.Glob))
+		if err != nil {
+			return nil, nil, fmt.Errorf("windows[%d].for_each_dir.glob %q: %w", wi, fe.Glob, err)
+		}
+		var dirs []string
+		for _, m := range matches {
+			if st, err := os.Stat(m); err == nil && st.IsDir() {
+				dirs = append(dirs, m)
+			}
+		}
+		limit := fe.Max
+		if limit <= 0 {
+			limit = spec.DefaultForEachDirMax
+		}
+		if len(dirs) > limit {
+			return nil, nil, fmt.Errorf("windows[%d].for_each_dir: glob %q matches %d directories, more than max %d (raise for_each_dir.max, up to %d, or narrow the glob)", wi, fe.Glob, len(dirs), limit, spec.MaxForEachDirWindows)
+		}
+		if len(dirs) == 0 {
+			warns = append(warns, fmt.Sprintf("windows[%d].for_each_dir: glob %q matches no directories", wi, fe.Glob))
+		}
+
+		for _, d := range dirs {
+			r := strings.NewReplacer("${DIR}", d, "${DIR_NAME}", filepath.Base(d))
+			nw := replaceStrings(reflect.ValueOf(fe.WindowTemplate), r).Interface().(spec.Window)
+			name := sanitizeWindowName(firstNonEmpty(nw.Name, filepath.Base(d)))
+			if name == "" {
+				name = "dir"
+			}
+			nw.Name = name
+			for n := 2; used[nw.Name]; n++ {
+				nw.Name = fmt.Sprintf("%s-%d", name, n)
+			}
+			used[nw.Name] = true
+			if strings.TrimSpace(nw.Root) == "" {
+				nw.Root = d
+			}
+			out = append(out, nw)
+		}
 	}
-	return out
+	return out, warns, nil
 }
 
-func firstNonEmpty(a, b string) string {
-	a = strings.TrimSpace(a)
-	if a != "" {
-		return a
+// replaceStrings returns a deep copy of v with r applied to every string in it (struct fields,
+// slice elements, pointers, map values; map keys are kept).
+func replaceStrings(v reflect.Value, r *strings.Replacer) reflect.Value {
+	switch v.Kind() {
+	case reflect.String:
+		out := reflect.New(v.Type()).Elem()
+		out.SetString(r.Replace(v.String()))
+		return out
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(v.Type().Elem())
+		out.Elem().Set(replaceStrings(v.Elem(), r))
+		return out
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(replaceStrings(v.Index(i), r))
+		}
+		return out
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			out.SetMapIndex(iter.Key(), replaceStrings(iter.Value(), r))
+		}
+		return out
+	case reflect.Struct:
+		out := reflect.New(v.Type()).Elem()
+		out.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if out.Field(i).CanSet() {
+				out.Field(i).Set(replaceStrings(v.Field(i), r))
+			}
+		}
+		return out
+	default:
+		return v
 	}
-	return strings.TrimSpace(b)
 }
 
-func sanitizeSessionName(name string) string {
-	name = strings.TrimSpace(name)
-	if name == "" {
-		return ""
-	}
-	// mirror spec.ValidateTmuxName but allow variables already substituted; keep it conservative.
+// sanitizeWindowName keeps [A-Za-z0-9_-] (what spec.ValidateTmuxName accepts) and turns runs of
+// anything else ('.' and ':' would break tmux targets) into '_'.
+func sanitizeWindowName(name string) string {
 	var b strings.Builder
 	lastUnderscore := false
-	for _, r := range name {
+	for _, r := range strings.TrimSpace(name) {
 		switch {
-		case r >= 'a' && r <= 'z':
-			b.WriteRune(r)
-			lastUnderscore = false
-		case r >= 'A' && r <= 'Z':
-			b.WriteRune(r + ('a' - 'A'))
-			lastUnderscore = false
-		case r >= '0' && r <= '9':
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-':
 			b.WriteRune(r)
 			lastUnderscore = false
-		case r == '-' || r == '_':
-			b.WriteRune('_')
-			lastUnderscore = true
 		default:
 			if !lastUnderscore {
 				b.WriteRune('_')
@@ -892,9 +1168,263 @@ func sanitizeSessionName(name string) string {
 			}
 		}
 	}
-	out := strings.Trim(b.String(), "_")
-	if out == "" {
-		return ""
+	return strings.Trim(b.String(), "_")
+}
+
+// lintWindowLayouts reports layout choices that are unlikely to do what the author intended.
+// Warnings only: tmux accepts all of these, the results are just odd or cramped.
+func lintWindowLayouts(windows []spec.Window) []string {
+	var warns []string
+	for _, w := range windows {
+		panes := len(w.Panes)
+		if len(w.PanePlan) > 0 {
+			panes = 0
+			for _, step := range w.PanePlan {
+				if step.Pane != nil {
+					panes++
+				}
+			}
+		}
+		if panes == 0 {
+			panes = 1
+		}
+
+		layout := strings.TrimSpace(w.Layout)
+		limit, known := layoutPaneHints[layout]
+		if !known {
+			// Custom layout strings (from list-windows #{window_layout}) contain commas.
+			if !strings.Contains(layout, ",") {
+				warns = append(warns, fmt.Sprintf("window %q: unknown layout %q", w.Name, layout))
+			}
+			continue
+		}
+		if layout != "" && panes == 1 {
+			warns = append(warns, fmt.Sprintf("window %q: layout %q has no effect with a single pane", w.Name, layout))
+			continue
+		}
+		if panes > limit {
+			desc := "without a layout"
+			if layout != "" {
+				desc = "with layout " + strconv.Quote(layout)
+			}
+			warns = append(warns, fmt.Sprintf("window %q: %d panes %s will be too small to use on a typical terminal (hint: <= %d)", w.Name, panes, desc, limit))
+		}
+	}
+	return warns
+}
+
+// Pane plan size lint: splits are simulated on a typical terminal (the same ~80x24 assumption as
+// layoutPaneHints) and panes smaller than this are reported.
+const (
+	lintTermCols    = 80
+	lintTermRows    = 24
+	minLintPaneCols = 20
+	minLintPaneRows = 3
+)
+
+// lintPanePlanSizes reports pane_plan splits that leave panes too small to use. Each split divides
+// the active pane, giving the new (now active) pane its declared size ("NN%" of the pane, NN cells,
+// or half by default) and the rest to the pane it split. Windows with a layout are skipped: the
+// layout re-sizes every pane afterwards (see lintWindowLayouts). Warnings only.
+func lintPanePlanSizes(windows []spec.Window) []string {
+	var warns []string
+	for _, w := range windows {
+		if len(w.PanePlan) == 0 || strings.TrimSpace(w.Layout) != "" {
+			continue
+		}
+		cols, rows := float64(lintTermCols), float64(lintTermRows)
+		minCols, minRows := cols, rows
+		for _, step := range w.PanePlan {
+			if step.Split == nil {
+				continue
+			}
+			percent, length, err := spec.ParseSplitSize(step.Split.Size)
+			if err != nil {
+				continue // Validate reports it
+			}
+			total := rows
+			if strings.EqualFold(strings.TrimSpace(step.Split.Direction), "h") {
+				total = cols
+			}
+			created := total / 2
+			switch {
+			case percent > 0:
+				created = total * float64(percent) / 100
+			case length > 0:
+				created = min(float64(length), total)
+			}
+			left := total - created
+			if strings.EqualFold(strings.TrimSpace(step.Split.Direction), "h") {
+				cols = created
+				minCols = min(minCols, created, left)
+			} else {
+				rows = created
+				minRows = min(minRows, created, left)
+			}
+		}
+		if minRows < minLintPaneRows {
+			warns = append(warns, fmt.Sprintf("window %q: pane_plan vertical splits leave a pane about %d rows tall on a typical terminal (hint: >= %d)", w.Name, int(minRows), minLintPaneRows))
+		}
+		if minCols < minLintPaneCols {
+			warns = append(warns, fmt.Sprintf("window %q: pane_plan horizontal splits leave a pane about %d columns wide on a typical terminal (hint: >= %d)", w.Name, int(minCols), minLintPaneCols))
+		}
+	}
+	return warns
+}
+
+// mergeEnv layers inner over outer (nil when both are empty).
+func mergeEnv(outer, inner map[string]string) map[string]string {
+	if len(inner) == 0 {
+		return outer
+	}
+	if len(outer) == 0 {
+		return inner
+	}
+	out := make(map[string]string, len(outer)+len(inner))
+	for k, v := range outer {
+		out[k] = v
+	}
+	for k, v := range inner {
+		out[k] = v
+	}
+	return out
+}
+
+// scopeEnv gives env to the actions that don't have a (more specific) env yet. With export, the
+// pane-creating ones among them also set it in the new pane.
+func scopeEnv(acts []Action, env map[string]string, export bool) {
+	if len(env) == 0 {
+		return
+	}
+	for i := range acts {
+		if acts[i].Env != nil {
+			continue
+		}
+		acts[i].Env = env
+		if acts[i].Kind == ActionNewWindow || acts[i].Kind == ActionSplitWindow {
+			acts[i].ExportEnv = export
+		}
+	}
+}
+
+// takeExecRun splits a leading `run` with exec: true (and no target override or unmet `when`) off a
+// fresh pane's actions, returning its argv for the pane-creating command. Otherwise argv is nil and
+// acts is returned unchanged.
+func takeExecRun(ctx Context, acts []spec.Action) ([]string, []spec.Action) {
+	if len(acts) == 0 {
+		return nil, acts
+	}
+	a := acts[0]
+	if a.Type != "run" || a.Run == nil || a.Run.Exec == nil || !*a.Run.Exec || strings.TrimSpace(a.Run.Program) == "" {
+		return nil, acts
+	}
+	if WhenUnmet(ctx, a.When) != "" {
+		return nil, acts // convertActions notes the skip
+	}
+	if strings.TrimSpace(a.Target.Session) != "" || strings.TrimSpace(a.Target.Window) != "" || strings.TrimSpace(a.Target.Pane) != "" {
+		return nil, acts
+	}
+	return append([]string{a.Run.Program}, a.Run.Args...), acts[1:]
+}
+
+// takeFirstPaneExec applies takeExecRun to w's first pane (pane_plan or panes), which new-window
+// creates. It returns the argv and the cwd for new-window (the pane's root when it has an exec
+// run, since there is no shell to cd in), and a copy of w without that run.
+func takeFirstPaneExec(ctx Context, w spec.Window, winRoot string) ([]string, string, spec.Window) {
+	var acts []spec.Action
+	var root string
+	switch {
+	case len(w.PanePlan) > 0 && w.PanePlan[0].Pane != nil:
+		acts, root = w.PanePlan[0].Pane.Actions, w.PanePlan[0].Pane.Root
+	case len(w.PanePlan) == 0 && len(w.Panes) > 0:
+		acts, root = w.Panes[0].Actions, w.Panes[0].Root
+	default:
+		return nil, winRoot, w
+	}
+	argv, rest := takeExecRun(ctx, acts)
+	if argv == nil {
+		return nil, winRoot, w
+	}
+
+	cwd := winRoot
+	if strings.TrimSpace(root) != "" {
+		cwd = expandUser(subst(ctx, strings.TrimSpace(root)))
+	}
+	if len(w.PanePlan) > 0 {
+		first := *w.PanePlan[0].Pane
+		first.Actions, first.Root = rest, "" // root is new-window's -c now: no cd
+		w.PanePlan = append([]spec.PanePlanStep{{Pane: &first}}, w.PanePlan[1:]...)
+	} else {
+		first := w.Panes[0]
+		first.Actions, first.Root = rest, ""
+		w.Panes = append([]spec.Pane{first}, w.Panes[1:]...)
+	}
+	return argv, cwd, w
+}
+
+// paneTitleActions sets the active pane's title to the pane's spec name, so snapshots
+// (pane_title) read the name back. Unnamed panes keep tmux's default title.
+func paneTitleActions(sessionName, window, name string) []Action {
+	if strings.TrimSpace(name) == "" {
+		return nil
+	}
+	return []Action{{
+		Kind:    ActionSetPaneTitle,
+		Session: sessionName,
+		Window:  window,
+		Name:    strings.TrimSpace(name),
+	}}
+}
+
+// panePrefillActions types a pane's prefill command into the active pane without Enter.
+// It runs after the pane's actions and before restore (copy-mode would swallow the keys).
+func panePrefillActions(sessionName, window, prefill string) []Action {
+	if strings.TrimSpace(prefill) == "" {
+		return nil
+	}
+	return []Action{{
+		Kind:    ActionSendKeys,
+		Session: sessionName,
+		Window:  window,
+		Command: prefill,
+		Enter:   false,
+	}}
+}
+
+// paneRestoreActions compiles a pane's restore hints against the window's active pane.
+// Best-effort: copy-mode only applies to the pane that is active when the action runs, which is
+// the pane just created/configured by the pane loop.
+func paneRestoreActions(sessionName, window string, r *spec.PaneRestore) []Action {
+	if r == nil || (!r.CopyMode && r.ScrollPosition <= 0) {
+		return nil
+	}
+	return []Action{{
+		Kind:        ActionCopyMode,
+		Session:     sessionName,
+		Window:      window,
+		ScrollLines: r.ScrollPosition,
+	}}
+}
+
+// -------------------------
+// Helpers
+// -------------------------
+
+func cloneStringMap(m map[string]string) map[string]string {
+	if len(m) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
 	}
 	return out
 }
+
+func firstNonEmpty(a, b string) string {
+	a = strings.TrimSpace(a)
+	if a != "" {
+		return a
+	}
+	return strings.TrimSpace(b)
+}
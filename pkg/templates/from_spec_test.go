@@ -0,0 +1,116 @@
+package templates
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tmux-session-manager/pkg/spec"
+)
+
+func TestExpandForEachDir(t *testing.T) {
+	root := t.TempDir()
+	for _, d := range []string{"services/web", "services/api", "services/my svc", "docs"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "services", "README.md"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	windows := []spec.Window{
+		{Name: "edit"},
+		{ForEachDir: &spec.ForEachDir{
+			Glob: "services/*",
+			WindowTemplate: spec.Window{Panes: []spec.Pane{{Actions: []spec.Action{
+				{Type: "run", Run: &spec.RunAction{Program: "make", Args: []string{"-C", "${DIR}", "${DIR_NAME}"}}},
+			}}}},
+		}},
+		{Name: "api"},
+	}
+	got, warns, err := expandForEachDir(root, windows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warns) != 0 {
+		t.Errorf("warnings: %v", warns)
+	}
+
+	// Sorted matches, files skipped, names sanitized and kept unique against fixed windows.
+	var names []string
+	for _, w := range got {
+		names = append(names, w.Name)
+	}
+	if s := strings.Join(names, ","); s != "edit,api-2,my_svc,web,api" {
+		t.Fatalf("windows = %s, want edit,api-2,my_svc,web,api", s)
+	}
+	web := got[3]
+	if web.Root != filepath.Join(root, "services", "web") {
+		t.Errorf("web root = %q", web.Root)
+	}
+	if args := web.Panes[0].Actions[0].Run.Args; strings.Join(args, " ") != "-C "+web.Root+" web" {
+		t.Errorf("web args = %q", args)
+	}
+	if args := windows[1].ForEachDir.WindowTemplate.Panes[0].Actions[0].Run.Args; args[1] != "${DIR}" {
+		t.Errorf("template modified: %q", args)
+	}
+
+	_, warns, err = expandForEachDir(root, []spec.Window{{ForEachDir: &spec.ForEachDir{Glob: "missing/*"}}})
+	if err != nil || len(warns) != 1 {
+		t.Errorf("no match: warns = %v, err = %v; want one warning", warns, err)
+	}
+	_, _, err = expandForEachDir(root, []spec.Window{{ForEachDir: &spec.ForEachDir{Glob: "services/*", Max: 2}}})
+	if err == nil || !strings.Contains(err.Error(), "more than max 2") {
+		t.Errorf("over max: err = %v", err)
+	}
+}
+
+// A leading exec run becomes the argv of the command creating its pane; otherwise (exec unset or
+// false, or not the pane's first action) the run is typed with send-keys.
+func TestRunExec(t *testing.T) {
+	yes, no := true, false
+	run := func(exec *bool, prog string, args ...string) spec.Action {
+		return spec.Action{Type: "run", Run: &spec.RunAction{Program: prog, Args: args, Exec: exec}}
+	}
+	s := spec.Spec{Version: 1, Windows: []spec.Window{
+		{Name: "logs", Panes: []spec.Pane{
+			{Root: "/var/log", Actions: []spec.Action{run(&yes, "tail", "-F", "app log"), run(&yes, "echo", "later")}},
+			{Actions: []spec.Action{run(&yes, "htop")}},
+		}},
+		{Name: "shell", Panes: []spec.Pane{{Actions: []spec.Action{run(&no, "make", "dev"), run(nil, "ls")}}}},
+	}}
+	ctx := Context{ProjectPath: "/p", SessionName: "s"}
+	tpl, err := FromSpec(ctx, s, false, false, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, _ := fakeEngine(&recordRunner{})
+	c, err := e.Compile(ctx, tpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, cmd := range c.Commands {
+		lines = append(lines, strings.Join(cmd.Args, " "))
+	}
+	plan := strings.Join(lines, "\n")
+
+	for _, want := range []string{
+		"new-window -t s: -n logs -c /var/log -- tail -F app log",
+		"split-window -h -t s:logs -c /p -- htop",
+		"send-keys -t s:logs echo later C-m",
+		"send-keys -t s:shell make dev C-m",
+		"send-keys -t s:shell ls C-m",
+	} {
+		if !strings.Contains(plan, want) {
+			t.Errorf("plan lacks %q:\n%s", want, plan)
+		}
+	}
+	for _, bad := range []string{"tail -F 'app log'", "send-keys -t s:logs htop"} {
+		if strings.Contains(plan, bad) {
+			t.Errorf("exec run typed (%q):\n%s", bad, plan)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
 	"strings"
@@ -32,6 +33,37 @@ type TmuxExecRunner struct {
 
 	// Debug prints executed commands and outputs to stderr when true.
 	Debug bool
+
+	// Socket, when set, targets a specific tmux server: a socket path (tmux -S) or a socket name
+	// (tmux -L). It takes precedence over the client socket derived from $TMUX.
+	Socket string
+
+	// Log, when set, receives one debug line per command (args, duration, error). Unlike Debug it
+	// never writes to the terminal, so it is safe under the TUI.
+	Log *slog.Logger
+}
+
+// TmuxSocketArgs returns the tmux global flags selecting socket: "-S <path>" when it looks like a
+// path, otherwise "-L <name>". Empty socket yields nil.
+func TmuxSocketArgs(socket string) []string {
+	socket = strings.TrimSpace(socket)
+	if socket == "" {
+		return nil
+	}
+	if strings.ContainsRune(socket, '/') {
+		return []string{"-S", expandUser(socket)}
+	}
+	return []string{"-L", socket}
+}
+
+// WithSocketArgs prepends TmuxSocketArgs(socket) to args, unless socket is empty or args already
+// select a server (leading -S/-L), so the flags are never passed twice.
+func WithSocketArgs(socket string, args []string) []string {
+	sockArgs := TmuxSocketArgs(socket)
+	if len(sockArgs) == 0 || argsContainSocketOrServerOverride(args) {
+		return args
+	}
+	return append(sockArgs, args...)
 }
 
 func (r *TmuxExecRunner) Run(args []string) error {
@@ -73,7 +105,9 @@ func (r *TmuxExecRunner) RunOutput(args []string) (string, error) {
 	env = append(env, r.ExtraEnv...)
 
 	tmuxEnv := strings.TrimSpace(os.Getenv("TMUX"))
-	if tmuxEnv != "" && !argsContainSocketOrServerOverride(args) {
+	if strings.TrimSpace(r.Socket) != "" {
+		args = WithSocketArgs(r.Socket, args)
+	} else if tmuxEnv != "" && !argsContainSocketOrServerOverride(args) {
 		sock := parseTmuxSockPathFromEnv(tmuxEnv)
 		if sock != "" {
 			// Force tmux to use the active client's socket. This is more reliable than relying
@@ -94,7 +128,11 @@ func (r *TmuxExecRunner) RunOutput(args []string) (string, error) {
 		fmt.Fprintf(os.Stderr, "tmux-runner: exec: %s %s\n", bin, shellJoin(args))
 	}
 
+	start := time.Now()
 	err := cmd.Run()
+	if r.Log != nil {
+		r.Log.Debug("tmux", "runner", "exec", "args", shellJoin(args), "dur", time.Since(start), "err", err, "stderr", strings.TrimSpace(stderr.String()))
+	}
 
 	// Handle context timeout explicitly.
 	if ctx.Err() == context.DeadlineExceeded {
@@ -135,11 +173,29 @@ func (r *TmuxExecRunner) RunOutput(args []string) (string, error) {
 	return serr, nil
 }
 
+// tmuxGlobalValueFlags are the tmux global options that take a value (`tmux -f file -L name ...`).
+const tmuxGlobalValueFlags = "cfLST"
+
+// argsContainSocketOrServerOverride reports whether args already start with a server selection flag
+// (-L / -S, alone, with an attached value, or grouped like -2L). Only leading (global) flags count,
+// parsed like tmux's getopt so option values are skipped: subcommand flags such as
+// `capture-pane -S -200` are unrelated.
 func argsContainSocketOrServerOverride(args []string) bool {
 	for i := 0; i < len(args); i++ {
-		switch args[i] {
-		case "-S", "-L":
-			return true
+		a := args[i]
+		if a == "--" || len(a) < 2 || a[0] != '-' {
+			return false
+		}
+		for j := 1; j < len(a); j++ {
+			if a[j] == 'L' || a[j] == 'S' {
+				return true
+			}
+			if strings.IndexByte(tmuxGlobalValueFlags, a[j]) >= 0 {
+				if j == len(a)-1 {
+					i++ // the value is the next argument
+				}
+				break
+			}
 		}
 	}
 	return false
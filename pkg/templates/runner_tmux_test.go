@@ -0,0 +1,54 @@
+package templates
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestArgsContainSocketOrServerOverride(t *testing.T) {
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{nil, false},
+		{[]string{"list-sessions"}, false},
+		{[]string{"-L", "x", "list-sessions"}, true},
+		{[]string{"-S", "/tmp/s", "list-sessions"}, true},
+		{[]string{"-Lx", "list-sessions"}, true},
+		{[]string{"-2L", "x", "list-sessions"}, true},
+		{[]string{"-f", "file", "-L", "x", "list-sessions"}, true},
+		{[]string{"-ffile", "-S", "/tmp/s", "ls"}, true},
+		{[]string{"-u", "-f", "file", "ls"}, false},
+		{[]string{"-f", "-L", "ls"}, false}, // "-L" is -f's value
+		{[]string{"-c", "cmd", "-T", "RGB", "-S", "s"}, true},
+		{[]string{"capture-pane", "-S", "-200"}, false},
+		{[]string{"--", "-L", "x"}, false},
+	}
+	for _, tt := range tests {
+		if got := argsContainSocketOrServerOverride(tt.args); got != tt.want {
+			t.Errorf("argsContainSocketOrServerOverride(%q) = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
+
+// WithSocketArgs prepends the socket once, never on top of one already given.
+func TestWithSocketArgs(t *testing.T) {
+	tests := []struct {
+		socket string
+		args   []string
+		want   string
+	}{
+		{"", []string{"ls"}, "ls"},
+		{"work", []string{"ls"}, "-L work ls"},
+		{"/tmp/s", []string{"ls"}, "-S /tmp/s ls"},
+		{"work", []string{"-L", "other", "ls"}, "-L other ls"},
+		{"work", []string{"-f", "conf", "-S", "/x", "ls"}, "-f conf -S /x ls"},
+		{"work", []string{"-f", "conf", "ls"}, "-L work -f conf ls"},
+		{"work", WithSocketArgs("work", []string{"ls"}), "-L work ls"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(WithSocketArgs(tt.socket, tt.args), " "); got != tt.want {
+			t.Errorf("WithSocketArgs(%q, %q) = %q, want %q", tt.socket, tt.args, got, tt.want)
+		}
+	}
+}